@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/fleet"
+)
+
+// mux routes the fleet api
+func (c *controller) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/heartbeat", c.handleHeartbeat)
+	mux.HandleFunc("/v1/summary", c.handleSummary)
+	mux.HandleFunc("/v1/nodes", c.handleNodes)
+	mux.HandleFunc("/v1/nodes/", c.handleNode)
+	mux.HandleFunc("/v1/drain", c.handleDrain)
+	mux.HandleFunc("/v1/config", c.handleConfig)
+	mux.HandleFunc("/v1/operations", c.handleOperations)
+	mux.HandleFunc("/v1/operations/", c.handleOperation)
+	return mux
+}
+
+// authorize rejects requests without the fleet token, when one is set.
+// Heartbeats may pass it as the token query parameter since the nodes only
+// know the -heartbeat url.
+func (c *controller) authorize(h http.Handler) http.Handler {
+	if len(c.token) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if len(given) == 0 && r.URL.Path == "/v1/heartbeat" {
+			given = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(c.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid fleet token"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (c *controller) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return
+	}
+	var hb fleet.Heartbeat
+	if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(hb.Node) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("heartbeat has no node"))
+		return
+	}
+	// Over mutual TLS a node may only report itself
+	if r.TLS != nil && len(r.TLS.PeerCertificates) != 0 {
+		if err := r.TLS.PeerCertificates[0].VerifyHostname(hb.Node); err != nil {
+			writeError(w, http.StatusForbidden, fmt.Errorf("client certificate isn't valid for node %s", hb.Node))
+			return
+		}
+	}
+	if c.registry.Get(hb.Node) == nil {
+		c.logger.Infof("node joined the fleet (node=%s;version=%s)", hb.Node, hb.Version)
+	}
+	c.registry.Update(hb)
+	writeJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+}
+
+func (c *controller) handleSummary(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, c.registry.Summary())
+}
+
+func (c *controller) handleNodes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, c.registry.List())
+}
+
+// handleNode returns the node, or forgets a decommissioned one with DELETE
+func (c *controller) handleNode(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/nodes/")
+	switch r.Method {
+	case http.MethodGet:
+		node := c.registry.Get(name)
+		if node == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown node %s", name))
+			return
+		}
+		writeJSON(w, http.StatusOK, node)
+	case http.MethodDelete:
+		if !c.registry.Remove(name) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown node %s", name))
+			return
+		}
+		c.audit.Record("forget_node", initiator(r), nil, name)
+		writeJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use GET or DELETE"))
+	}
+}
+
+func (c *controller) handleOperations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, c.ops.list())
+}
+
+func (c *controller) handleOperation(w http.ResponseWriter, r *http.Request) {
+	op := c.ops.get(strings.TrimPrefix(r.URL.Path, "/v1/operations/"))
+	if op == nil {
+		writeError(w, http.StatusNotFound, errors.New("unknown operation"))
+		return
+	}
+	writeJSON(w, http.StatusOK, op)
+}
+
+// selectNodes returns the named nodes, or every fresh node when names is
+// empty
+func (c *controller) selectNodes(names []string) ([]*fleet.Node, error) {
+	if len(names) == 0 {
+		nodes := make([]*fleet.Node, 0)
+		for _, n := range c.registry.List() {
+			if !n.Stale {
+				nodes = append(nodes, n)
+			}
+		}
+		return nodes, nil
+	}
+	nodes := make([]*fleet.Node, 0, len(names))
+	for _, name := range names {
+		n := c.registry.Get(name)
+		if n == nil {
+			return nil, fmt.Errorf("unknown node %s", name)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// initiator names who made a fleet api request in the audit log, using the
+// client certificate's common name over mutual TLS
+func initiator(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) != 0 {
+		return "fleet-api:" + r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return "fleet-api:" + r.RemoteAddr
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// configRequest is the body of a config push
+type configRequest struct {
+	// Nodes receive the values; the whole fleet when empty
+	Nodes []string `json:"nodes"`
+	// Values are the reloadable options to set, keyed by flag name
+	Values map[string]string `json:"values"`
+	// Remove are the options to unset, falling back to the node's config
+	Remove []string `json:"remove"`
+	// Features are the fleet-wide feature flags to set, on or off
+	Features map[string]string `json:"features"`
+}
+
+// unpushable are the options config pushes may not set, since they change
+// what the nodes run rather than how
+var unpushable = map[string]bool{
+	"launch": true,
+}
+
+// handleConfig writes the pushed options to the consul kv prefix the nodes
+// watch with -config-kv. Nodes apply the reloadable options and ignore the
+// others.
+func (c *controller) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return
+	}
+	if len(c.configKV) == 0 {
+		writeError(w, http.StatusNotImplemented, errors.New("config push is disabled; start with -config-kv"))
+		return
+	}
+	var req configRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Features) != 0 && len(req.Nodes) != 0 {
+		writeError(w, http.StatusBadRequest, errors.New("features are pushed to the whole fleet; omit nodes"))
+		return
+	}
+	keys, err := c.pushConfig(req)
+	c.audit.Record("config_push", initiator(r), err, strings.Join(keys, ","))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	c.logger.Infof("config pushed (keys=%s)", strings.Join(keys, ","))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"result": "ok", "keys": keys})
+}
+
+// pushConfig writes and deletes the kv keys of req, returning those changed
+func (c *controller) pushConfig(req configRequest) ([]string, error) {
+	prefixes := []string{c.configKV}
+	if len(req.Nodes) != 0 {
+		prefixes = prefixes[:0]
+		for _, node := range req.Nodes {
+			prefixes = append(prefixes, c.configKV+"/nodes/"+node)
+		}
+	}
+	for _, name := range append(sortedNames(req.Values), req.Remove...) {
+		if len(name) == 0 || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid option name %q", name)
+		}
+		if unpushable[name] {
+			return nil, fmt.Errorf("%s can't be pushed; change it in the nodes' config", name)
+		}
+	}
+	keys := make([]string, 0)
+	for _, prefix := range prefixes {
+		for _, name := range sortedNames(req.Values) {
+			key := prefix + "/" + name
+			if err := c.consul.Put(key, []byte(req.Values[name])); err != nil {
+				return keys, err
+			}
+			keys = append(keys, key)
+		}
+		for _, name := range req.Remove {
+			key := prefix + "/" + name
+			if err := c.consul.Delete(key); err != nil {
+				return keys, err
+			}
+			keys = append(keys, key)
+		}
+	}
+	for _, name := range sortedNames(req.Features) {
+		if len(name) == 0 || strings.Contains(name, "/") {
+			return keys, fmt.Errorf("invalid feature name %q", name)
+		}
+		key := c.configKV + "/features/" + name
+		if err := c.consul.Put(key, []byte(req.Features[name])); err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func sortedNames(values map[string]string) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/fleet"
+)
+
+// drainRequest is the body of a rolling drain
+type drainRequest struct {
+	// Nodes are drained in order; every fresh node when empty
+	Nodes []string `json:"nodes"`
+	// Deadline overrides the nodes' -drain-deadline
+	Deadline string `json:"deadline"`
+	// Undrain makes each node eligible again once drained, before the next
+	// one is drained
+	Undrain *bool `json:"undrain"`
+	// Timeout is how long a node may take to drain
+	Timeout string `json:"timeout"`
+}
+
+// drainPollInterval is how often a draining node's status is checked
+const drainPollInterval = 10 * time.Second
+
+// handleDrain starts a rolling drain, draining one node at a time
+func (c *controller) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return
+	}
+	var req drainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var deadline time.Duration
+	timeout := time.Hour
+	var err error
+	if len(req.Deadline) != 0 {
+		deadline, err = time.ParseDuration(req.Deadline)
+	}
+	if len(req.Timeout) != 0 && err == nil {
+		timeout, err = time.ParseDuration(req.Timeout)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	undrain := req.Undrain == nil || *req.Undrain
+	nodes, err := c.selectNodes(req.Nodes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(nodes) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("no nodes to drain"))
+		return
+	}
+	if c.ops.running("rolling_drain") {
+		writeError(w, http.StatusConflict, errors.New("a rolling drain is already running"))
+		return
+	}
+	names := make([]string, len(nodes))
+	for i, n := range nodes {
+		names[i] = n.Node
+	}
+	op := c.ops.start("rolling_drain", names)
+	who := initiator(r)
+	c.crash.Go(func() {
+		err := c.rollingDrain(op, nodes, deadline, timeout, undrain)
+		c.ops.finish(op, err)
+		c.audit.Record("rolling_drain", who, err, fmt.Sprintf("%d nodes (op=%s)", len(nodes), op.ID))
+		if err != nil {
+			c.logger.Errorf("rolling drain failed (op=%s): %v", op.ID, err)
+			return
+		}
+		c.logger.Infof("rolling drain finished (op=%s;nodes=%d)", op.ID, len(nodes))
+	})
+	writeJSON(w, http.StatusAccepted, c.ops.get(op.ID))
+}
+
+// rollingDrain drains the nodes one at a time, waiting for each drain to
+// complete and optionally undraining the node before moving on. It stops at
+// the first node that fails.
+func (c *controller) rollingDrain(op *operation, nodes []*fleet.Node, deadline time.Duration, timeout time.Duration, undrain bool) error {
+	for _, node := range nodes {
+		c.ops.update(op, func(op *operation) {
+			op.Current = node.Node
+		})
+		c.logger.Infof("draining node (op=%s;node=%s)", op.ID, node.Node)
+		if err := c.nodes.Drain(node, deadline); err != nil {
+			return err
+		}
+		if err := c.awaitDrained(node, timeout); err != nil {
+			return err
+		}
+		if undrain {
+			if err := c.nodes.Undrain(node); err != nil {
+				return err
+			}
+		}
+		c.ops.update(op, func(op *operation) {
+			op.Done = append(op.Done, node.Node)
+		})
+	}
+	return nil
+}
+
+// awaitDrained waits for nomad to finish migrating the node's allocations,
+// which clears its drain flag
+func (c *controller) awaitDrained(node *fleet.Node, timeout time.Duration) error {
+	expired := time.After(timeout)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		hb, err := c.nodes.Status(node)
+		if err != nil {
+			return err
+		}
+		if !hb.Drain {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-expired:
+			return fmt.Errorf("%s still draining after %v", node.Node, timeout)
+		case <-c.exit:
+			return errors.New("fleet controller stopped")
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/audit"
+	"github.com/pgombola/clarify-svc/internal/buildinfo"
+	"github.com/pgombola/clarify-svc/internal/consul"
+	"github.com/pgombola/clarify-svc/internal/crash"
+	"github.com/pgombola/clarify-svc/internal/dedup"
+	"github.com/pgombola/clarify-svc/internal/fleet"
+	"github.com/pgombola/clarify-svc/internal/journald"
+	"github.com/pgombola/clarify-svc/internal/pidfile"
+	"github.com/pgombola/clarify-svc/internal/redact"
+	"github.com/pgombola/clarify-svc/internal/scm"
+)
+
+// controller aggregates the heartbeats of the clarify wrappers and
+// coordinates commands across them
+type controller struct {
+	name     string
+	listen   string
+	token    string
+	tls      *tls.Config
+	registry *fleet.Registry
+	nodes    *fleet.Client
+	consul   *consul.Client
+	configKV string
+	ops      *operations
+	audit    *audit.Log
+	logger   service.Logger
+	crash    *crash.Reporter
+	server   *http.Server
+	exit     chan struct{}
+}
+
+func (c *controller) Start(s service.Service) error {
+	defer c.crash.Recover()
+	l, err := net.Listen("tcp", c.listen)
+	if err != nil {
+		c.logger.Errorf("unable to listen on %s: %v", c.listen, err)
+		return err
+	}
+	if c.tls != nil {
+		l = tls.NewListener(l, c.tls)
+	}
+	c.server = &http.Server{Handler: c.authorize(c.mux())}
+	c.logger.Infof("Starting %s (address=%s;token=%t;tls=%t;config-kv=%s)", c.name, c.listen, len(c.token) != 0, c.tls != nil, c.configKV)
+	c.audit.Record("start", "service-manager", nil, "")
+	c.crash.Go(func() {
+		if err := c.server.Serve(l); err != http.ErrServerClosed {
+			c.logger.Error(err)
+		}
+	})
+	return nil
+}
+
+func (c *controller) Stop(s service.Service) error {
+	defer c.crash.Recover()
+	c.logger.Infof("Stopping %s", c.name)
+	close(c.exit)
+	c.audit.Record("stop", "service-manager", nil, "")
+	if c.server != nil {
+		return c.server.Close()
+	}
+	return nil
+}
+
+// crashState is the program state written to crash reports
+func (c *controller) crashState() interface{} {
+	return map[string]interface{}{
+		"listen":     c.listen,
+		"nodes":      len(c.registry.List()),
+		"operations": c.ops.list(),
+	}
+}
+
+// nodeTLS returns the client certificate presented to the nodes' admin
+// apis, or nil when they don't use mutual TLS
+func nodeTLS(cert string, key string, ca string) (*tls.Config, error) {
+	if len(cert) == 0 && len(key) == 0 && len(ca) == 0 {
+		return nil, nil
+	}
+	if len(cert) == 0 || len(key) == 0 || len(ca) == 0 {
+		return nil, errors.New("-node-tls-cert, -node-tls-key and -node-tls-ca must be given together")
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load node tls certificate: %v", err)
+	}
+	pool, err := readCA(ca)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read node tls ca: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{pair}, RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+// apiTLS returns the config the fleet api serves mutual TLS with, or nil
+// when it serves plain http
+func apiTLS(cert string, key string, ca string) (*tls.Config, error) {
+	if len(cert) == 0 && len(key) == 0 && len(ca) == 0 {
+		return nil, nil
+	}
+	if len(cert) == 0 || len(key) == 0 || len(ca) == 0 {
+		return nil, errors.New("-tls-cert, -tls-key and -tls-ca must be given together")
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load tls certificate: %v", err)
+	}
+	pool, err := readCA(ca)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tls ca: %v", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func readCA(ca string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", ca)
+	}
+	return pool, nil
+}
+
+// checkListen refuses to expose the fleet api beyond loopback without a
+// token or mutual TLS, since it drains nodes and pushes their config
+func checkListen(addr string, token string, tlsConfig *tls.Config) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid -listen %q: %v", addr, err)
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+	if len(token) == 0 && tlsConfig == nil {
+		return fmt.Errorf("-listen %s isn't a loopback address; set -token or -tls-cert to expose the fleet api", addr)
+	}
+	return nil
+}
+
+// parseAdmins parses the comma separated node=url list of -node-admin
+func parseAdmins(value string) (map[string]string, error) {
+	admins := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid -node-admin entry %q; use node=url", entry)
+		}
+		u, err := url.Parse(parts[1])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return nil, fmt.Errorf("invalid -node-admin url %q", parts[1])
+		}
+		admins[parts[0]] = parts[1]
+	}
+	return admins, nil
+}
+
+// readToken returns the flag value, the content of file or the environment
+// variable, in that order
+func readToken(value string, file string, env string) (string, error) {
+	if len(value) != 0 {
+		return value, nil
+	}
+	if len(file) != 0 {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(buf)), nil
+	}
+	return os.Getenv(env), nil
+}
+
+// secretFlags map the flags holding secrets, which aren't stored in the
+// service definition, to the file flags the service is installed with
+var secretFlags = map[string]string{
+	"token":        "token-file",
+	"node-token":   "node-token-file",
+	"consul-token": "consul-token-file",
+}
+
+func serviceArgs() []string {
+	args := make([]string, 0)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "control" && len(secretFlags[f.Name]) == 0 {
+			args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		}
+	})
+	return args
+}
+
+func main() {
+	control := flag.String("control", "", fmt.Sprintf("Service control command [%q].", service.ControlAction))
+	listen := flag.String("listen", ":8700", "TCP address of the fleet api, which also receives the nodes' -heartbeat posts; addresses beyond loopback need -token or -tls-cert.")
+	token := flag.String("token", "", "Bearer token required by the fleet api, also accepted as a token query parameter in heartbeat urls (defaults to CLARIFY_FLEET_TOKEN).")
+	tokenFile := flag.String("token-file", "", "File containing the fleet api token.")
+	tlsCert := flag.String("tls-cert", "", "Certificate the fleet api serves mutual TLS with.")
+	tlsKey := flag.String("tls-key", "", "Private key of -tls-cert.")
+	tlsCA := flag.String("tls-ca", "", "CA fleet api client certificates must be signed by; heartbeat certificates must also name the node.")
+	nodeToken := flag.String("node-token", "", "Admin api token of the nodes (defaults to CLARIFY_ADMIN_TOKEN).")
+	nodeTokenFile := flag.String("node-token-file", "", "File containing the nodes' admin api token.")
+	nodeTLSCert := flag.String("node-tls-cert", "", "Client certificate presented to the nodes' admin apis over mutual TLS.")
+	nodeTLSKey := flag.String("node-tls-key", "", "Private key of -node-tls-cert.")
+	nodeTLSCA := flag.String("node-tls-ca", "", "CA the nodes' admin api certificates are signed by.")
+	nodeAdmin := flag.String("node-admin", "", "Comma separated node=url admin api urls; other nodes' advertised urls are only used over -node-tls-* with the certificate checked against the node name.")
+	stale := flag.Duration("stale-after", 3*time.Minute, "How long a node may go without a heartbeat before it's reported stale.")
+	consulAddr := flag.String("consul", "127.0.0.1:8500", "host:port of the consul agent config pushes are written to.")
+	consulToken := flag.String("consul-token", "", "Consul ACL token (defaults to CONSUL_HTTP_TOKEN).")
+	consulTokenFile := flag.String("consul-token-file", "", "File containing the Consul ACL token (defaults to CONSUL_HTTP_TOKEN_FILE).")
+	configKV := flag.String("config-kv", "", "Consul kv prefix the nodes watch with -config-kv; config pushes are disabled when empty.")
+	name := flag.String("service-name", "clarify-fleet", "Name of this service.")
+	auditLog := flag.String("audit", "", "Path of the append-only audit log of fleet commands.")
+	crashDir := flag.String("crash-dir", "", "Directory crash reports are written to when the service panics (defaults to crashes beside the executable).")
+	pidFile := flag.String("pid-file", "", "Pid file locked while the service runs so only one instance listens (defaults to <name>.pid beside the executable).")
+	startTimeout := flag.Duration("start-timeout", time.Minute, "How long the service may stay start pending, its TimeoutStartSec under systemd, before failing (0 waits forever).")
+	logDedup := flag.Duration("log-dedup-window", time.Minute, "Repeated warning and info messages are logged once per window (0 disables it).")
+	flag.Parse()
+
+	// Program
+	var prg *controller
+	{
+		wd, err := filepath.Abs(filepath.Dir(os.Args[0]))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(*pidFile) == 0 {
+			*pidFile = filepath.Join(wd, *name+".pid")
+		}
+		if len(*crashDir) == 0 {
+			*crashDir = filepath.Join(wd, "crashes")
+		}
+		fleetToken, err := readToken(*token, *tokenFile, "CLARIFY_FLEET_TOKEN")
+		if err != nil {
+			log.Fatalf("unable to read fleet token: %v", err)
+		}
+		adminToken, err := readToken(*nodeToken, *nodeTokenFile, "CLARIFY_ADMIN_TOKEN")
+		if err != nil {
+			log.Fatalf("unable to read node token: %v", err)
+		}
+		tlsConfig, err := nodeTLS(*nodeTLSCert, *nodeTLSKey, *nodeTLSCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		admins, err := parseAdmins(*nodeAdmin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listenTLS, err := apiTLS(*tlsCert, *tlsKey, *tlsCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := checkListen(*listen, fleetToken, listenTLS); err != nil {
+			log.Fatal(err)
+		}
+		host, portValue, err := net.SplitHostPort(*consulAddr)
+		if err != nil {
+			log.Fatalf("invalid -consul %q: %v", *consulAddr, err)
+		}
+		port, err := strconv.Atoi(portValue)
+		if err != nil {
+			log.Fatalf("invalid -consul %q: %v", *consulAddr, err)
+		}
+		redact.Add(fleetToken, adminToken)
+		prg = &controller{
+			name:     *name,
+			listen:   *listen,
+			token:    fleetToken,
+			tls:      listenTLS,
+			registry: &fleet.Registry{Stale: *stale},
+			nodes:    fleet.NewClient(adminToken, tlsConfig, admins),
+			consul:   consul.NewClient(host, port),
+			configKV: strings.TrimSuffix(*configKV, "/"),
+			ops:      &operations{},
+			audit:    audit.Open(*auditLog, *name),
+			crash: &crash.Reporter{
+				Dir:         *crashDir,
+				Name:        *name,
+				Fingerprint: crash.Fingerprint(os.Args[1:]),
+			},
+			exit: make(chan struct{}),
+		}
+		if len(prg.configKV) != 0 {
+			if err := prg.consul.SetToken(*consulToken, *consulTokenFile); err != nil {
+				log.Fatal(err)
+			}
+			redact.Add(prg.consul.Token)
+		}
+	}
+
+	// Service
+	var s service.Service
+	{
+		svcConfig := &service.Config{
+			Name:        *name,
+			DisplayName: *name,
+			Description: *name + " service",
+			Arguments:   serviceArgs(),
+		}
+		s, _ = service.New(prg, svcConfig)
+	}
+
+	// Logging
+	var logger service.Logger
+	{
+		var err error
+		logger, err = s.Logger(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if flag.NArg() == 0 && len(*control) == 0 && !service.Interactive() && journald.Available() {
+			journal, err := journald.New(*name, map[string]string{"UNIT": *name + ".service"})
+			if err != nil {
+				log.Fatal(err)
+			}
+			logger = journal
+		}
+		logger = dedup.New(redact.Logger(logger), *logDedup)
+		prg.logger = logger
+		prg.crash.Logger = logger
+		prg.crash.State = prg.crashState
+	}
+
+	// Run subcommand, control command or start program
+	if flag.NArg() != 0 {
+		switch flag.Arg(0) {
+		case "version":
+			if err := buildinfo.Command("clarify-fleet", flag.Args()[1:], os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatalf("unknown command %q", flag.Arg(0))
+		}
+		return
+	}
+	if len(*control) != 0 {
+		if *control == "install" {
+			if err := scm.CheckSecrets(secretFlags); err != nil {
+				log.Fatal(err)
+			}
+		}
+		err := scm.Control(s, *control, *name, *startTimeout)
+		prg.audit.Record(*control, audit.User(), err, "")
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	pid, err := pidfile.Acquire(*pidFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer prg.crash.Recover()
+	err = scm.Run(s, prg, *name, *startTimeout)
+	pid.Release()
+	if err != nil {
+		logger.Error(err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/opid"
+)
+
+// operation is a command running across several nodes
+type operation struct {
+	ID       string     `json:"id"`
+	Kind     string     `json:"kind"`
+	Status   string     `json:"status"`
+	Nodes    []string   `json:"nodes"`
+	Done     []string   `json:"done"`
+	Current  string     `json:"current,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Operation statuses
+const (
+	opRunning   = "running"
+	opSucceeded = "succeeded"
+	opFailed    = "failed"
+)
+
+// operations tracks the fleet operations since the controller started
+type operations struct {
+	mu  sync.RWMutex
+	ops map[string]*operation
+}
+
+// start records a running operation of kind over nodes
+func (o *operations) start(kind string, nodes []string) *operation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.ops == nil {
+		o.ops = make(map[string]*operation)
+	}
+	op := &operation{ID: opid.New(), Kind: kind, Status: opRunning, Nodes: nodes, Done: []string{}, Started: time.Now().UTC()}
+	o.ops[op.ID] = op
+	return op
+}
+
+// update changes op under the lock so readers see a consistent copy
+func (o *operations) update(op *operation, f func(op *operation)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f(op)
+}
+
+// finish marks op succeeded, or failed with err
+func (o *operations) finish(op *operation, err error) {
+	o.update(op, func(op *operation) {
+		now := time.Now().UTC()
+		op.Finished = &now
+		op.Current = ""
+		op.Status = opSucceeded
+		if err != nil {
+			op.Status = opFailed
+			op.Error = err.Error()
+		}
+	})
+}
+
+// running reports whether an operation of kind is running
+func (o *operations) running(kind string) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	for _, op := range o.ops {
+		if op.Kind == kind && op.Status == opRunning {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *operations) get(id string) *operation {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	op, ok := o.ops[id]
+	if !ok {
+		return nil
+	}
+	return o.copy(op)
+}
+
+// list returns the operations, most recent first
+func (o *operations) list() []*operation {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	ops := make([]*operation, 0, len(o.ops))
+	for _, op := range o.ops {
+		ops = append(ops, o.copy(op))
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Started.After(ops[j].Started)
+	})
+	return ops
+}
+
+func (o *operations) copy(op *operation) *operation {
+	c := *op
+	c.Nodes = append([]string{}, op.Nodes...)
+	c.Done = append([]string{}, op.Done...)
+	return &c
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// parseAddress parses an address flag given as host, host:port,
+// [ipv6]:port or an http(s) url, returning its scheme, host and port. The
+// scheme defaults to http, the host to localhost and the port to
+// defaultPort. IPv6 hosts are returned bracketed so they can be used in urls.
+func parseAddress(value string, defaultPort int) (string, string, int, error) {
+	scheme, hostPort := "http", value
+	if strings.Contains(value, "://") {
+		u, err := url.Parse(value)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("invalid address %q: %v", value, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return "", "", 0, fmt.Errorf("invalid address %q: %s isn't supported, use http or https", value, u.Scheme)
+		}
+		scheme, hostPort = u.Scheme, u.Host
+	}
+	host, portValue := hostPort, ""
+	if h, p, err := net.SplitHostPort(hostPort); err == nil {
+		host, portValue = h, p
+	} else if ip := net.ParseIP(strings.Trim(hostPort, "[]")); ip != nil {
+		host = ip.String()
+	} else if strings.Contains(hostPort, ":") {
+		return "", "", 0, fmt.Errorf("invalid address %q: %v", value, err)
+	}
+	port := defaultPort
+	if len(portValue) != 0 {
+		p, err := strconv.Atoi(portValue)
+		if err != nil || p < 1 || p > 65535 {
+			return "", "", 0, fmt.Errorf("invalid address %q: port must be a number between 1 and 65535", value)
+		}
+		port = p
+	}
+	if len(host) == 0 {
+		host = "localhost"
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return scheme, host, port, nil
+}
+
+// agentTLS returns the tls config an https agent is verified with: the
+// system roots unless ca is given, presenting the cert and key pair when the
+// agent requires client certificates. It's nil when none is given.
+func agentTLS(ca string, cert string, key string) (*tls.Config, error) {
+	if len(ca) == 0 && len(cert) == 0 && len(key) == 0 {
+		return nil, nil
+	}
+	if (len(cert) == 0) != (len(key) == 0) {
+		return nil, errors.New("client certificate and key must be given together")
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(ca) != 0 {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read ca: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", ca)
+		}
+	}
+	if len(cert) != 0 {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/rpc"
+)
+
+// serveAdmin exposes the control api on a unix socket, or a named pipe on
+// windows, until the program exits. The socket serves the gRPC service of
+// admin.proto over HTTP/2 alongside the json api over HTTP/1.
+func (p *program) serveAdmin() {
+	if len(p.admin) == 0 {
+		return
+	}
+	l, err := listenAdmin(p.admin)
+	if err != nil {
+		p.logger.Errorf("unable to listen on admin socket (%s): %v", p.admin, err)
+		return
+	}
+	go func() {
+		<-p.exit
+		l.Close()
+	}()
+	p.logger.Infof("admin api listening (socket=%s;token=%t)", p.admin, len(p.adminAuth.token) != 0)
+	rpc.NewServer(p.adminAuth.wrap(p.adminMux())).Serve(l)
+}
+
+// serveAdminTCP exposes the control api on the -admin-listen tcp address,
+// over mutual TLS when configured
+func (p *program) serveAdminTCP() {
+	if len(p.adminListen) == 0 {
+		return
+	}
+	l, err := net.Listen("tcp", p.adminListen)
+	if err != nil {
+		p.logger.Errorf("unable to listen on admin address (%s): %v", p.adminListen, err)
+		return
+	}
+	if p.adminAuth.tls != nil {
+		l = tls.NewListener(l, p.adminAuth.tls)
+	}
+	go func() {
+		<-p.exit
+		l.Close()
+	}()
+	p.logger.Infof("admin api listening (address=%s;token=%t;mtls=%t)", p.adminListen, len(p.adminAuth.token) != 0, p.adminAuth.tls != nil)
+	http.Serve(l, p.adminAuth.wrap(p.adminMux()))
+}
+
+func (p *program) adminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range p.adminRoutes() {
+		mux.HandleFunc(route.path, route.handler)
+	}
+	svc := p.adminService()
+	mux.Handle(svc.Prefix(), svc)
+	return mux
+}
+
+// handleDrain drains the node, overriding the configured drain spec with the
+// deadline, force and ignore-system-jobs query parameters
+func (p *program) handleDrain(w http.ResponseWriter, r *http.Request) {
+	spec := p.drainOptions()
+	q := r.URL.Query()
+	var err error
+	if v := q.Get("deadline"); len(v) != 0 {
+		spec.Deadline, err = time.ParseDuration(v)
+	}
+	if v := q.Get("force"); len(v) != 0 && err == nil {
+		spec.Force, err = strconv.ParseBool(v)
+	}
+	if v := q.Get("ignore-system-jobs"); len(v) != 0 && err == nil {
+		spec.IgnoreSystemJobs, err = strconv.ParseBool(v)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	p.handleAction("drain", func() error {
+		return p.drainWith(spec)
+	})(w, r)
+}
+
+func (p *program) undrain() error {
+	node, err := p.hostID(p.hostname)
+	if err != nil {
+		return err
+	}
+	if err := p.disableDrain(node.ID); err != nil {
+		return err
+	}
+	p.releaseDrainLock()
+	return nil
+}
+
+func (p *program) relaunch() error {
+	_, err := p.launchClarify()
+	return err
+}
+
+func (p *program) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.status())
+}
+
+func (p *program) handleAction(name string, action func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+			return
+		}
+		err := action()
+		p.audit.Record(name, adminInitiator(r), err, "")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+	}
+}
+
+func (p *program) handleWatch(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+	events := p.events.subscribe()
+	defer p.events.unsubscribe(events)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	enc.Encode(&event{Event: "status", Detail: p.status().JobStatus})
+	flusher.Flush()
+	for {
+		select {
+		case e := <-events:
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-p.exit:
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// adminSocket resolves the -admin flag to the socket path
+func adminSocket(flagValue string, name string) string {
+	switch flagValue {
+	case "off":
+		return ""
+	case "":
+		return defaultAdminSocket(name)
+	}
+	return flagValue
+}
@@ -0,0 +1,84 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// listenAdmin listens on the unix socket at path, replacing a stale socket
+// left by a previous run. The socket is bound in a private directory and made
+// owner-only before it's renamed to path, so other users can't connect to it
+// in between.
+func listenAdmin(path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".admin")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	err = os.Chmod(tmp, 0600)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &socketListener{Listener: l, path: path}, nil
+}
+
+// socketListener removes its socket once closed
+type socketListener struct {
+	net.Listener
+	path string
+}
+
+func (l *socketListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// removeStaleSocket removes the socket a previous run left at path, refusing
+// to remove anything else
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and isn't a socket", path)
+	}
+	return os.Remove(path)
+}
+
+// dialAdmin connects to the admin socket at path
+func dialAdmin(path string) (net.Conn, error) {
+	return net.Dial("unix", path)
+}
+
+// defaultAdminSocket is the admin socket of the service name, next to the
+// executable
+func defaultAdminSocket(name string) string {
+	wd, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(wd, name+".sock")
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/pipe"
+)
+
+// listenAdmin listens on the named pipe at path, which only the local system,
+// administrators and the service's account may open
+func listenAdmin(path string) (net.Listener, error) {
+	return pipe.Listen(path)
+}
+
+// dialAdmin connects to the admin named pipe at path, waiting briefly while
+// its instances are busy
+func dialAdmin(path string) (net.Conn, error) {
+	return pipe.Dial(path, 5*time.Second)
+}
+
+// defaultAdminSocket is the admin named pipe of the service name
+func defaultAdminSocket(name string) string {
+	return `\\.\pipe\` + name
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// adminAuth protects the admin api with a bearer token and, on the tcp
+// listener, mutual TLS
+type adminAuth struct {
+	token string
+	tls   *tls.Config
+}
+
+// newAdminAuth reads the admin token from the flag, token file or
+// CLARIFY_ADMIN_TOKEN, in that order, and loads the mutual TLS config when
+// a certificate is given
+func newAdminAuth(token string, tokenFile string, cert string, key string, ca string) (*adminAuth, error) {
+	a := &adminAuth{token: token}
+	if len(a.token) == 0 && len(tokenFile) != 0 {
+		buf, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read admin token: %v", err)
+		}
+		a.token = strings.TrimSpace(string(buf))
+	}
+	if len(a.token) == 0 {
+		a.token = os.Getenv("CLARIFY_ADMIN_TOKEN")
+	}
+	if len(cert) == 0 && len(key) == 0 && len(ca) == 0 {
+		return a, nil
+	}
+	if len(cert) == 0 || len(key) == 0 || len(ca) == 0 {
+		return nil, errors.New("-admin-tls-cert, -admin-tls-key and -admin-tls-ca must be given together")
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load admin tls certificate: %v", err)
+	}
+	pem, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read admin tls ca: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", ca)
+	}
+	a.tls = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+	return a, nil
+}
+
+// wrap rejects requests without the admin token, when one is set. The
+// health endpoints stay open for probes.
+func (a *adminAuth) wrap(h http.Handler) http.Handler {
+	if len(a.token) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(a.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid admin token"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// adminListenAddr binds a bare port or :port to the loopback address so the
+// admin api is only exposed beyond the host when a host is given
+func adminListenAddr(value string) string {
+	if len(value) == 0 {
+		return ""
+	}
+	if !strings.Contains(value, ":") {
+		value = ":" + value
+	}
+	if strings.HasPrefix(value, ":") {
+		return "127.0.0.1" + value
+	}
+	return value
+}
+
+// checkListen refuses to expose the admin api beyond loopback without a
+// token or mutual TLS
+func (a *adminAuth) checkListen(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid -admin-listen %q: %v", addr, err)
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+	if len(a.token) == 0 && a.tls == nil {
+		return fmt.Errorf("-admin-listen %s isn't a loopback address; set -admin-token or -admin-tls-cert to expose the admin api", addr)
+	}
+	return nil
+}
+
+// adminInitiator names who made an admin api request in the audit log,
+// using the client certificate's common name over mutual TLS
+func adminInitiator(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) != 0 {
+		return "admin-api:" + r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return "admin-api"
+}
+
+// adminURL is the url the fleet controller reaches the admin api at, empty
+// when -admin-listen only binds to loopback
+func (p *program) adminURL() string {
+	if len(p.adminListen) == 0 {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(p.adminListen)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return ""
+	} else if len(host) == 0 || (ip != nil && ip.IsUnspecified()) {
+		host = p.hostname
+	}
+	scheme := "http"
+	if p.adminAuth.tls != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/pgombola/clarify-svc/internal/rpc"
+)
+
+// adminService is the clarify.admin.v1.Admin gRPC service of admin.proto
+func (p *program) adminService() *rpc.Service {
+	return &rpc.Service{
+		Name: "clarify.admin.v1.Admin",
+		Methods: map[string]rpc.Method{
+			"Status":      p.rpcStatus,
+			"Drain":       p.rpcDrain,
+			"Undrain":     p.rpcAction("undrain", p.undrain),
+			"RelaunchJob": p.rpcAction("relaunch", p.relaunch),
+			"Watch":       p.rpcWatch,
+		},
+	}
+}
+
+func (p *program) rpcStatus(r *http.Request, req []byte, send func([]byte) error) error {
+	hb := p.status()
+	msg := &rpc.Encoder{}
+	msg.String(1, hb.Node)
+	msg.String(2, hb.NodeID)
+	msg.String(3, hb.Service)
+	msg.String(4, hb.Version)
+	msg.String(5, hb.NomadVersion)
+	msg.String(6, hb.ConsulVersion)
+	msg.String(7, hb.State)
+	msg.Time(8, hb.StateSince)
+	msg.String(9, hb.JobStatus)
+	msg.Map(10, hb.Jobs)
+	msg.Bool(11, hb.Drain)
+	msg.Bool(12, hb.LameDuck)
+	msg.String(13, hb.Quarantined)
+	return send(msg.Message())
+}
+
+// rpcDrain drains the node, overriding the configured drain spec with the
+// fields of the request that are set
+func (p *program) rpcDrain(r *http.Request, req []byte, send func([]byte) error) error {
+	spec := p.drainOptions()
+	err := rpc.Decode(req, func(f rpc.Field) (err error) {
+		switch f.Number {
+		case 1:
+			spec.Deadline, err = f.Duration()
+			if err == nil && spec.Deadline <= 0 {
+				err = rpc.Errorf(rpc.InvalidArgument, "deadline must be positive")
+			}
+		case 2:
+			spec.Force = f.Bool()
+		case 3:
+			spec.IgnoreSystemJobs = f.Bool()
+		}
+		return err
+	})
+	if err != nil {
+		return rpc.Errorf(rpc.InvalidArgument, "invalid DrainRequest: %v", err)
+	}
+	return p.rpcAction("drain", func() error {
+		return p.drainWith(spec)
+	})(r, req, send)
+}
+
+// rpcAction runs an action taking no arguments, recording it in the audit log
+func (p *program) rpcAction(name string, action func() error) rpc.Method {
+	return func(r *http.Request, req []byte, send func([]byte) error) error {
+		err := action()
+		p.audit.Record(name, adminInitiator(r), err, "")
+		if err != nil {
+			return err
+		}
+		return send(nil)
+	}
+}
+
+// rpcWatch streams events until the client goes away or the program exits
+func (p *program) rpcWatch(r *http.Request, req []byte, send func([]byte) error) error {
+	events := p.events.subscribe()
+	defer p.events.unsubscribe(events)
+	if err := send(encodeEvent(&event{Event: "status", Detail: p.status().JobStatus})); err != nil {
+		return err
+	}
+	for {
+		select {
+		case e := <-events:
+			if err := send(encodeEvent(e)); err != nil {
+				return err
+			}
+		case <-r.Context().Done():
+			return nil
+		case <-p.exit:
+			return nil
+		}
+	}
+}
+
+func encodeEvent(e *event) []byte {
+	msg := &rpc.Encoder{}
+	msg.String(1, e.Event)
+	msg.String(2, e.Detail)
+	msg.Time(3, e.Time)
+	return msg.Message()
+}
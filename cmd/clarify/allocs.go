@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/gomad/client"
+)
+
+// Actions taken when clarify allocations on this node keep failing
+const (
+	allocActionNone     = "none"
+	allocActionRestart  = "restart"
+	allocActionEvaluate = "evaluate"
+)
+
+func validAllocAction(action string) error {
+	switch action {
+	case allocActionNone, allocActionRestart, allocActionEvaluate:
+		return nil
+	}
+	return fmt.Errorf("invalid alloc action %q; expected %s, %s or %s", action, allocActionNone, allocActionRestart, allocActionEvaluate)
+}
+
+// allocWatch counts failed or lost clarify allocations seen on this node
+// within the last window
+type allocWatch struct {
+	threshold int
+	action    string
+	window    time.Duration
+	seen      map[string]bool
+	failures  []time.Time
+}
+
+// record adds a failure at now and drops those older than the window,
+// returning the failures left
+func (w *allocWatch) record(now time.Time) int {
+	w.failures = append(w.failures, now)
+	recent := w.failures[:0]
+	for _, t := range w.failures {
+		if w.window <= 0 || now.Sub(t) < w.window {
+			recent = append(recent, t)
+		}
+	}
+	w.failures = recent
+	return len(recent)
+}
+
+// watchAllocs inspects the clarify allocations on the node and, once
+// threshold failures were observed within the window, alerts and applies the
+// configured action
+func (p *program) watchAllocs(host *client.Host) {
+	allocs, err := nomad.NodeAllocations(p.nomad, host.ID)
+	if err != nil {
+		p.logger.Warning("error retrieving node allocations")
+		return
+	}
+	var running *client.Alloc
+	failures := 0
+	listed := make(map[string]bool)
+	now := time.Now()
+	jobID := p.clarifyJob()
+	for i := range allocs {
+		alloc := &allocs[i]
+		if alloc.JobID != jobID {
+			continue
+		}
+		listed[alloc.ID] = true
+		if alloc.ClientStatus == "running" && running == nil {
+			running = alloc
+		}
+		if p.allocs.seen[alloc.ID] {
+			continue
+		}
+		if alloc.ClientStatus == "failed" || alloc.ClientStatus == "lost" {
+			p.logger.Warningf("clarify allocation %s (id=%s)", alloc.ClientStatus, alloc.ID)
+			p.allocs.seen[alloc.ID] = true
+			failures = p.allocs.record(now)
+			p.publish("alloc_"+alloc.ClientStatus, alloc.ID)
+		}
+	}
+	// Nomad garbage collects terminal allocations, which never come back
+	for id := range p.allocs.seen {
+		if !listed[id] {
+			delete(p.allocs.seen, id)
+		}
+	}
+	if failures < p.allocs.threshold {
+		return
+	}
+	msg := fmt.Sprintf("%d clarify allocations failed on %s within %v", failures, host.Name, p.allocs.window)
+	p.logger.Error(msg)
+	if err := p.notifier.Notify("alloc_failures", msg); err != nil {
+		p.logger.Warningf("error sending notification: %v", err)
+	}
+	p.allocs.failures = nil
+	action := p.allocs.action
+	if action == allocActionRestart && running == nil {
+		// A failed or lost allocation can't be restarted; have nomad
+		// reschedule the job instead
+		p.logger.Info("no running clarify allocation to restart; evaluating instead")
+		action = allocActionEvaluate
+	}
+	switch action {
+	case allocActionRestart:
+		if !p.features.Enabled(featureAllocRestart) {
+			p.logger.Infof("feature %s is off; not restarting allocation (id=%s)", featureAllocRestart, running.ID)
+			return
+		}
+		p.logger.Infof("restarting allocation (id=%s)", running.ID)
+		err = nomad.RestartAlloc(p.nomad, running.ID)
+		if err == nil {
+			p.publish("alloc_restarted", running.ID)
+		}
+	case allocActionEvaluate:
+		p.logger.Info("forcing clarify job evaluation")
+		err = nomad.EvaluateJob(p.nomad, jobID)
+	}
+	if err != nil {
+		p.logger.Errorf("error applying %s action", action)
+		p.logger.Error(err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/fleet"
+)
+
+// adminRoute is an admin api endpoint, registered on the mux and described
+// in the OpenAPI document served at /api/spec
+type adminRoute struct {
+	path    string
+	method  string
+	summary string
+	params  []apiParam
+	// response is a value of the type of the json response body
+	response    interface{}
+	contentType string
+	handler     http.HandlerFunc
+}
+
+// apiParam is a query parameter of an admin api endpoint
+type apiParam struct {
+	name        string
+	kind        string
+	description string
+}
+
+type actionResult struct {
+	Result string `json:"result"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// adminRoutes returns the admin api endpoints
+func (p *program) adminRoutes() []adminRoute {
+	return []adminRoute{
+		{path: "/status", method: http.MethodGet, summary: "Node and clarify job status.", response: fleet.Heartbeat{}, handler: p.handleStatus},
+		{path: "/status/events", method: http.MethodGet, summary: "Recent events such as state transitions, failed operations and allocation restarts, oldest first.", params: []apiParam{
+			{"limit", "integer", "Returns only the most recent events."},
+			{"event", "string", "Returns only events whose name starts with this prefix (e.g. state_)."},
+		}, response: []event{}, handler: p.handleEvents},
+		{path: "/drain", method: http.MethodPost, summary: "Drains the node, overriding the configured drain spec with the query parameters.", params: []apiParam{
+			{"deadline", "string", "How long allocations may migrate before they're forced off, as a duration (e.g. 10m)."},
+			{"force", "boolean", "Stops allocations immediately."},
+			{"ignore-system-jobs", "boolean", "Leaves system job allocations running."},
+		}, response: actionResult{}, handler: p.handleDrain},
+		{path: "/undrain", method: http.MethodPost, summary: "Disables the node drain and releases the drain lock.", response: actionResult{}, handler: p.handleAction("undrain", p.undrain)},
+		{path: "/lame-duck", method: http.MethodPost, summary: "Marks the node ineligible for new allocations while running ones finish.", params: []apiParam{
+			{"off", "boolean", "Makes the node eligible for new allocations again."},
+		}, response: actionResult{}, handler: p.handleLameDuck},
+		{path: "/relaunch", method: http.MethodPost, summary: "Submits the clarify job again.", response: actionResult{}, handler: p.handleAction("relaunch", p.relaunch)},
+		{path: "/promote", method: http.MethodPost, summary: "Promotes the canaries of the clarify job deployment.", response: actionResult{}, handler: p.handleAction("promote", p.promote)},
+		{path: "/rollback", method: http.MethodPost, summary: "Reverts the clarify job to a previous version.", params: []apiParam{
+			{"version", "integer", "Version to revert to; defaults to the last stable version before the current one."},
+		}, response: actionResult{}, handler: p.handleRollback},
+		{path: "/versions", method: http.MethodGet, summary: "Versions of the clarify job nomad keeps, most recent first.", response: []jobVersion{}, handler: p.handleVersions},
+		{path: "/reload", method: http.MethodPost, summary: "Reloads the reloadable options from the -config file.", response: actionResult{}, handler: p.handleAction("reload", p.reload)},
+		{path: "/dump", method: http.MethodPost, summary: "Writes a diagnostic dump and returns its path.", response: struct {
+			File string `json:"file"`
+		}{}, handler: p.handleDump},
+		{path: "/watch", method: http.MethodGet, summary: "Streams events, one json object per line, starting with the current job status.", response: event{}, contentType: "application/x-ndjson", handler: p.handleWatch},
+		{path: "/healthz", method: http.MethodGet, summary: "Reports the process is alive; doesn't require the admin token.", response: struct {
+			Status string `json:"status"`
+		}{}, handler: p.handleHealthz},
+		{path: "/readyz", method: http.MethodGet, summary: "Readiness checks, answered with 503 when one fails; doesn't require the admin token.", response: []check{}, handler: p.handleReadyz},
+		{path: "/api/spec", method: http.MethodGet, summary: "This OpenAPI document.", response: map[string]interface{}{}, handler: p.handleAPISpec},
+	}
+}
+
+func (p *program) handleAPISpec(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.apiSpec())
+}
+
+// apiSpec returns the OpenAPI document of the admin api
+func (p *program) apiSpec() map[string]interface{} {
+	paths := make(map[string]interface{})
+	for _, route := range p.adminRoutes() {
+		contentType := route.contentType
+		if len(contentType) == 0 {
+			contentType = "application/json"
+		}
+		responses := map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "OK",
+				"content":     map[string]interface{}{contentType: map[string]interface{}{"schema": schemaOf(reflect.TypeOf(route.response))}},
+			},
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(apiError{}))}},
+			},
+		}
+		op := map[string]interface{}{
+			"summary":     route.summary,
+			"operationId": operationID(route.path),
+			"responses":   responses,
+		}
+		if len(route.params) != 0 {
+			params := make([]interface{}, 0, len(route.params))
+			for _, param := range route.params {
+				params = append(params, map[string]interface{}{
+					"name":        param.name,
+					"in":          "query",
+					"description": param.description,
+					"schema":      map[string]interface{}{"type": param.kind},
+				})
+			}
+			op["parameters"] = params
+		}
+		if route.path == "/healthz" || route.path == "/readyz" {
+			op["security"] = []interface{}{}
+		}
+		paths[route.path] = map[string]interface{}{strings.ToLower(route.method): op}
+	}
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       p.name + " admin api",
+			"description": "Control api of the clarify service wrapper, served on its unix socket and -admin-listen.",
+			"version":     version,
+		},
+		"paths": paths,
+	}
+	if len(p.adminAuth.token) != 0 {
+		spec["components"] = map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		}
+		spec["security"] = []interface{}{map[string]interface{}{"token": []string{}}}
+	}
+	return spec
+}
+
+// operationID turns a path like /lame-duck into lameDuck
+func operationID(path string) string {
+	parts := strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '-'
+	})
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the json schema of values of t as encoded by
+// encoding/json
+func schemaOf(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return map[string]interface{}{"type": "object"}
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		required := make([]string, 0)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			name := strings.Split(tag, ",")[0]
+			if f.PkgPath != "" || name == "-" {
+				continue
+			}
+			if len(name) == 0 {
+				name = f.Name
+			}
+			properties[name] = schemaOf(f.Type)
+			if !strings.Contains(tag, ",omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) != 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
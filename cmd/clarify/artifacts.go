@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// artifactClient downloads artifacts, which may be large installers
+var artifactClient = &http.Client{Timeout: time.Hour}
+
+// artifactChecksums are the go-getter checksum types artifacts are verified
+// with
+var artifactChecksums = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// cachedArtifact is an http artifact of the job specification with a
+// checksum, which is pre-staged
+type cachedArtifact struct {
+	artifact map[string]interface{}
+	source   string
+	kind     string
+	sum      string
+	// path is the artifact's location beneath the cache and the mirror,
+	// <kind>-<sum>/<name>
+	path string
+}
+
+// cachedArtifacts returns the artifacts of the job specification that are
+// pre-staged
+func cachedArtifacts(wrapped map[string]interface{}) ([]*cachedArtifact, error) {
+	job, ok := wrapped["Job"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("job specification has no Job object")
+	}
+	var cached []*cachedArtifact
+	groups, _ := job["TaskGroups"].([]interface{})
+	for _, g := range groups {
+		group, _ := g.(map[string]interface{})
+		tasks, _ := group["Tasks"].([]interface{})
+		for _, t := range tasks {
+			task, _ := t.(map[string]interface{})
+			artifacts, _ := task["Artifacts"].([]interface{})
+			for _, a := range artifacts {
+				artifact, _ := a.(map[string]interface{})
+				if artifact == nil {
+					continue
+				}
+				c, err := newCachedArtifact(artifact)
+				if err != nil {
+					return nil, err
+				}
+				if c != nil {
+					cached = append(cached, c)
+				}
+			}
+		}
+	}
+	return cached, nil
+}
+
+// newCachedArtifact returns the artifact when it's pre-staged, or nil
+func newCachedArtifact(artifact map[string]interface{}) (*cachedArtifact, error) {
+	source, _ := artifact["GetterSource"].(string)
+	options, _ := artifact["GetterOptions"].(map[string]interface{})
+	checksum, _ := options["checksum"].(string)
+	u, err := url.Parse(source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.RawQuery) != 0 || len(checksum) == 0 {
+		// go-getter query parameters can't be carried over to the mirror
+		return nil, nil
+	}
+	parts := strings.SplitN(checksum, ":", 2)
+	newHash, ok := artifactChecksums[parts[0]]
+	if len(parts) != 2 || !ok {
+		return nil, fmt.Errorf("unsupported artifact checksum %q (source=%s)", checksum, source)
+	}
+	// The sum and name become cache paths, so they mustn't escape the cache
+	sum := strings.ToLower(parts[1])
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != 2*newHash().Size() {
+		return nil, fmt.Errorf("invalid %s artifact checksum %q (source=%s)", parts[0], parts[1], source)
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" || name == ".." {
+		return nil, fmt.Errorf("artifact source has no file name (source=%s)", source)
+	}
+	c := &cachedArtifact{artifact: artifact, source: source, kind: parts[0], sum: sum}
+	c.path = c.kind + "-" + c.sum + "/" + name
+	return c, nil
+}
+
+// prestageArtifacts downloads the http artifacts of the job specification
+// that have a checksum into the -artifact-cache directory, verifying them
+// before the job is submitted. With -artifact-mirror serving the cache to
+// every client the submission then fails when an artifact can't be staged;
+// otherwise nomad downloads it.
+func (p *program) prestageArtifacts(spec []byte) error {
+	if len(p.artifactCache) == 0 {
+		return nil
+	}
+	var wrapped map[string]interface{}
+	if err := json.Unmarshal(spec, &wrapped); err != nil {
+		return err
+	}
+	cached, err := cachedArtifacts(wrapped)
+	if err != nil {
+		return err
+	}
+	for _, c := range cached {
+		if err := p.stageArtifact(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stageArtifact downloads the artifact into the cache unless it's there with
+// the expected checksum
+func (p *program) stageArtifact(c *cachedArtifact) error {
+	dest := filepath.Join(p.artifactCache, filepath.FromSlash(c.path))
+	if actual, err := fileChecksum(dest, artifactChecksums[c.kind]()); err == nil {
+		if actual == c.sum {
+			return nil
+		}
+		p.logger.Warningf("cached artifact checksum mismatch; downloading it again (path=%s;expected=%s;actual=%s)", dest, c.sum, actual)
+	}
+	start := time.Now()
+	actual, err := downloadArtifact(c.source, dest, artifactChecksums[c.kind](), c.sum)
+	if err != nil {
+		if len(p.artifactMirror) != 0 {
+			return fmt.Errorf("unable to pre-stage artifact for the mirror (source=%s): %v", c.source, err)
+		}
+		p.logger.Warningf("unable to pre-stage artifact; nomad will download it (source=%s): %v", c.source, err)
+		return nil
+	}
+	if actual != c.sum {
+		return fmt.Errorf("artifact checksum mismatch (source=%s;expected=%s;actual=%s)", c.source, c.sum, actual)
+	}
+	p.logger.Infof("artifact pre-staged (source=%s;path=%s;duration=%v)", c.source, dest, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// fileChecksum returns the hex encoded checksum of the file at path
+func fileChecksum(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// mirrorArtifacts points the pre-staged artifacts of the job specification
+// at -artifact-mirror. The rewrite only depends on the specification, so
+// every node prepares the same job whether or not it staged the artifacts.
+func (p *program) mirrorArtifacts(spec []byte) ([]byte, error) {
+	if len(p.artifactMirror) == 0 {
+		return spec, nil
+	}
+	var wrapped map[string]interface{}
+	if err := json.Unmarshal(spec, &wrapped); err != nil {
+		return nil, err
+	}
+	cached, err := cachedArtifacts(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(cached) == 0 {
+		return spec, nil
+	}
+	for _, c := range cached {
+		c.artifact["GetterSource"] = strings.TrimSuffix(p.artifactMirror, "/") + "/" + c.path
+	}
+	return json.Marshal(wrapped)
+}
+
+// downloadArtifact downloads source to dest, returning its hex encoded
+// checksum. dest only appears once the download completes with the checksum
+// sum.
+func downloadArtifact(source string, dest string, h hash.Hash, sum string) (string, error) {
+	resp, err := artifactClient.Get(source)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("http status: %v", resp.StatusCode)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	tmp := dest + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	actual := hex.EncodeToString(h.Sum(nil))
+	if actual != sum {
+		return actual, nil
+	}
+	return actual, os.Rename(tmp, dest)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// watchDeployment follows the deployment created by resubmitting the clarify
+// job. Healthy canaries are promoted when auto promotion is enabled,
+// otherwise they wait for the promote command.
+func (p *program) watchDeployment() {
+	deadline := time.Now().Add(p.canaryTimeout)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	waiting := false
+	for {
+		select {
+		case <-ticker.C:
+			d, err := nomad.LatestDeployment(p.nomad, p.clarifyJob())
+			if err != nil {
+				p.logger.Warning("error retrieving clarify deployment")
+				continue
+			}
+			if d.Status != "running" {
+				p.logger.Infof("clarify deployment %s (id=%s): %s", d.Status, d.ID, d.StatusDescription)
+				return
+			}
+			if d.CanariesHealthy() {
+				if !p.autoPromote {
+					if !waiting {
+						p.logger.Infof("clarify canaries healthy; awaiting promotion (id=%s)", d.ID)
+						waiting = true
+					}
+					continue
+				}
+				p.logger.Infof("promoting clarify canaries (id=%s)", d.ID)
+				if err := nomad.PromoteDeployment(p.nomad, d.ID); err != nil {
+					p.logger.Error(err)
+				}
+				return
+			}
+			if time.Now().After(deadline) {
+				p.logger.Errorf("timed out waiting for clarify canaries (id=%s)", d.ID)
+				p.notifier.Notify("canary_timeout", "clarify canaries didn't become healthy")
+				return
+			}
+		case <-p.exit:
+			return
+		}
+	}
+}
+
+// promote promotes the canaries of the running clarify deployment
+func (p *program) promote() error {
+	d, err := nomad.LatestDeployment(p.nomad, p.clarifyJob())
+	if err != nil {
+		return err
+	}
+	if d.Status != "running" {
+		return errors.New("no clarify deployment in progress")
+	}
+	if !d.CanariesHealthy() {
+		return errors.New("clarify canaries aren't healthy yet")
+	}
+	if err := nomad.PromoteDeployment(p.nomad, d.ID); err != nil {
+		return err
+	}
+	p.logger.Infof("promoted clarify canaries (id=%s)", d.ID)
+	return nil
+}
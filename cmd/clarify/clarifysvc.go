@@ -6,121 +6,463 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/audit"
+	"github.com/pgombola/clarify-svc/internal/buildinfo"
+	"github.com/pgombola/clarify-svc/internal/chaos"
+	"github.com/pgombola/clarify-svc/internal/consul"
+	"github.com/pgombola/clarify-svc/internal/crash"
+	"github.com/pgombola/clarify-svc/internal/crashloop"
+	"github.com/pgombola/clarify-svc/internal/dedup"
+	"github.com/pgombola/clarify-svc/internal/errs"
+	"github.com/pgombola/clarify-svc/internal/eventid"
+	"github.com/pgombola/clarify-svc/internal/feature"
+	"github.com/pgombola/clarify-svc/internal/journald"
+	"github.com/pgombola/clarify-svc/internal/logsink"
+	"github.com/pgombola/clarify-svc/internal/metrics"
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/clarify-svc/internal/notify"
+	"github.com/pgombola/clarify-svc/internal/pidfile"
+	"github.com/pgombola/clarify-svc/internal/proxy"
+	"github.com/pgombola/clarify-svc/internal/ready"
+	"github.com/pgombola/clarify-svc/internal/redact"
+	"github.com/pgombola/clarify-svc/internal/schedule"
+	"github.com/pgombola/clarify-svc/internal/scm"
+	"github.com/pgombola/clarify-svc/internal/trace"
 	"github.com/pgombola/gomad/client"
 )
 
+// version is the clarifysvc build version, see buildinfo
+var version = buildinfo.Version
+
 type program struct {
-	clarify  string
-	hostname string
-	nomad    *client.NomadServer
-	launch   string
-	exit     chan struct{}
-	logger   service.Logger
-	svc      service.Service
+	name                 string
+	clarify              string
+	installManifest      string
+	installStrict        bool
+	hostname             string
+	nomad                *nomad.Server
+	lifecycle            lifecycle
+	newNomad             func(server *nomad.Server) nomadAPI
+	consul               *consul.Client
+	launch               string
+	lock                 *consul.Semaphore
+	redeployLock         *consul.Semaphore
+	lockWait             time.Duration
+	maintenance          string
+	drainPolicy          string
+	autoUndrain          string
+	compatPolicy         string
+	pollMaxInterval      time.Duration
+	pollJitter           float64
+	drainSpec            nomad.DrainSpec
+	allocs               *allocWatch
+	notifier             *notify.Notifier
+	logs                 *logStreams
+	remote               *remoteSpec
+	artifactCache        string
+	artifactMirror       string
+	redeploy             string
+	specInterval         time.Duration
+	autoPromote          bool
+	canaryTimeout        time.Duration
+	inject               *injection
+	nodeMeta             map[string]string
+	jobs                 []*supervisedJob
+	variants             []*jobVariant
+	local                *jobVariant
+	variantMu            sync.Mutex
+	drainMu              sync.Mutex
+	ownerClear           int32
+	journal              *journald.Logger
+	audit                *audit.Log
+	initiator            string
+	heartbeatURL         string
+	heartbeatInterval    time.Duration
+	admin                string
+	adminListen          string
+	adminAuth            *adminAuth
+	health               string
+	metrics              *metrics.Statsd
+	metricsInterval      time.Duration
+	tracer               *trace.Tracer
+	configFile           string
+	configKV             string
+	features             *feature.Set
+	datacenter           string
+	hooks                map[string]string
+	hookTimeout          time.Duration
+	windows              []*schedule.Window
+	rebootPrefix         string
+	rebootInterval       time.Duration
+	readySentinel        string
+	services             []string
+	registration         []string
+	registrationInterval time.Duration
+	registrationGrace    time.Duration
+	placement            []string
+	placementInterval    time.Duration
+	nodeEvents           bool
+	dumpDir              string
+	crash                *crash.Reporter
+	pollInterval         time.Duration
+	levels               *levelLogger
+	mu                   sync.RWMutex
+	events               *eventHub
+	crashes              *crashloop.Tracker
+	exit                 chan struct{}
+	logger               service.Logger
+	svc                  service.Service
 }
 
 func (p *program) Start(s service.Service) error {
-	p.logger.Info("Starting Clarify")
-	go p.run()
+	defer p.crash.Recover()
+	op := p.begin("startup")
+	eventid.Info(op.logger, eventid.ServiceStarted, "Starting %s", p.name)
+	p.audit.Record("start", "service-manager", nil, "")
+	p.transition(stateWaitingForInstall, "service starting")
+	p.crash.Go(p.publishHeartbeats)
+	p.crash.Go(p.serveAdmin)
+	p.crash.Go(p.serveAdminTCP)
+	p.crash.Go(p.serveHealth)
+	p.crash.Go(func() { p.publishMetrics(p.metricsInterval) })
+	p.crash.Go(func() { p.tracer.Run(5*time.Second, p.exit) })
+	p.crash.Go(p.watchReload)
+	p.crash.Go(p.watchRemoteConfig)
+	p.crash.Go(p.watchDump)
+	p.crash.Go(p.watchMaintenanceWindows)
+	p.crash.Go(p.watchReboot)
+	p.crash.Go(p.watchRegistration)
+	p.crash.Go(p.watchPlacement)
+	p.crash.Go(p.publishNodeEvents)
+	p.crash.Go(p.keepLocks)
+	// Waiting here keeps the service start pending until clarify is
+	// installed; it only gives up when the service is stopped meanwhile
+	if found := p.waitForInstall(); !found {
+		err := errs.ErrInstallMissing
+		op.logger.Warningf("service stopped while waiting for the clarify install: %v", err)
+		p.transition(stateStopped, err.Error())
+		op.end(err)
+		return err
+	}
+	p.transition(stateWaitingForNomad, "clarify installed")
+	p.crash.Go(func() { p.run(op) })
 	return nil
 }
 
 func (p *program) Stop(s service.Service) error {
+	defer p.crash.Recover()
+	p.runHook(hookPreStop, "service stopping")
 	close(p.exit)
-	if _, err := client.FindJob(p.nomad, "clarify"); err != nil {
+	if state, _ := p.state(); state == stateWaitingForInstall {
+		// Stopped while start pending; Start gives up waiting and nothing
+		// ran on the node yet
+		p.transition(stateStopped, "service stopped before clarify was installed")
+		p.audit.Record("stop", "service-manager", nil, "")
+		return nil
+	}
+	if _, err := p.findJob(p.clarifyJob()); err != nil {
 		// If we find clarify running, drain node:
-		return p.drain()
+		p.transition(stateDraining, "service stopping")
+		err = p.drain()
+		p.transition(stateStopped, "service stopped")
+		p.audit.Record("stop", "service-manager", err, "")
+		return err
 	}
-	p.logger.Info("Stopped Clarify")
+	p.transition(stateStopped, "service stopped")
+	eventid.Info(p.logger, eventid.ServiceStopped, "Stopped %s", p.name)
+	p.audit.Record("stop", "service-manager", nil, "")
 	return nil
 }
 
-func (p *program) run() {
-	if found := p.waitForInstall(); !found {
-		p.logger.Error("clarify install not available")
-		return
-	}
-	_, err := client.FindJob(p.nomad, "clarify")
-	if err == nil {
-		p.logger.Info("clarify found")
-		node := p.node()
-		if node.Drain {
-			p.logger.Info("disabling drain")
-			p.disableDrain(node.ID)
+// run drives the lifecycle from waiting for nomad until the service stops.
+// Each step returns the next state and why; draining stops the service,
+// which finishes the lifecycle.
+func (p *program) run(op *operation) {
+	next, reason := p.awaitNomad(op)
+	for len(next) != 0 {
+		if err := p.transition(next, reason); err != nil {
+			if !p.exiting() {
+				p.logger.Error(err)
+			}
+			return
 		}
-		p.logger.Infof("drain disabled (name=%s;id=%s)", node.Name, node.ID)
-	} else {
-		p.logger.Info("launching clarify")
-		_, err := p.launchClarify()
-		if err != nil {
-			p.logger.Error(err)
-			// Exit will allow the service to restart
-			os.Exit(1)
+		switch next {
+		case stateLaunching:
+			next, reason = p.launching(op)
+		case stateRunning:
+			next, reason = p.running(op)
+		case stateDraining:
+			p.svc.Stop()
+			return
+		case stateStopped:
+			op.end(errors.New(reason))
+			return
 		}
 	}
-	stopped := p.pollJob()
+}
+
+// exiting reports whether the service is stopping
+func (p *program) exiting() bool {
 	select {
-	case <-stopped:
-		p.svc.Stop()
+	case <-p.exit:
+		return true
+	default:
+		return false
 	}
 }
 
-func (p *program) pollJob() <-chan struct{} {
-	stopped := make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		for {
-			select {
-			case <-ticker.C:
-				if _, err := client.FindJob(p.nomad, "clarify"); err != nil {
-					p.logger.Error("clarify job not found")
-					ticker.Stop()
-					close(stopped)
-					return
-				}
-				n, err := client.HostID(p.nomad, &p.hostname)
-				if err != nil {
-					p.logger.Warning("error retrieving node")
-				} else if n.Drain {
-					p.logger.Info("node drained")
-					ticker.Stop()
-					close(stopped)
-				}
-			}
+// awaitNomad waits for nomad to answer with this node, then decides whether
+// clarify must be launched. A drain left from the last stop is disabled
+// unless the node is in maintenance.
+func (p *program) awaitNomad(op *operation) (lifecycleState, string) {
+	if state, err := p.crashes.Load(); err == nil && state.Quarantined {
+		err := fmt.Errorf("%s is quarantined (%s); run resume to launch clarify again", p.name, state.Reason)
+		op.logger.Error(err)
+		return stateStopped, "quarantined"
+	}
+	var node *client.Host
+	for {
+		var err error
+		node, err = p.hostID(p.hostname)
+		if err == nil {
+			break
 		}
-	}()
-	return stopped
+		op.logger.Warningf("waiting for nomad: %v", err)
+		select {
+		case <-time.After(p.duration(&p.pollInterval)):
+		case <-p.exit:
+			return "", ""
+		}
+	}
+	if err := p.checkCompat(op); err != nil {
+		op.logger.Error(err)
+		return stateStopped, "unsupported agents"
+	}
+	if err := p.publishNodeMeta(op, node.ID); err != nil {
+		op.logger.Warningf("unable to publish node metadata (id=%s): %v", node.ID, err)
+	}
+	if _, err := p.localVariant(); err != nil {
+		op.logger.Error(err)
+		return stateStopped, "no clarify job for this datacenter"
+	}
+	if _, err := p.findJob(p.clarifyJob()); err != nil {
+		if p.quarantine("clarify job missing") {
+			return stateStopped, "quarantined"
+		}
+		return stateLaunching, "clarify job missing"
+	}
+	op.logger.Info("clarify found")
+	if node.Drain && p.inMaintenance(node) {
+		op.logger.Infof("node in maintenance; leaving drain enabled (name=%s;id=%s)", node.Name, node.ID)
+		return stateRunning, "clarify found; node in maintenance"
+	}
+	if node.Drain && !p.shouldUndrain(node) {
+		op.logger.Infof("leaving drain enabled (name=%s;id=%s;auto-undrain=%s)", node.Name, node.ID, p.undrainPolicy())
+		p.releaseDrainLock()
+		return stateRunning, "clarify found; node left drained"
+	}
+	if node.Drain {
+		op.logger.Info("disabling drain")
+		p.disableDrain(node.ID)
+	}
+	op.logger.Infof("drain disabled (name=%s;id=%s)", node.Name, node.ID)
+	p.releaseDrainLock()
+	return stateRunning, "clarify found"
+}
+
+// launching submits the clarify job. A failed launch exits so the service
+// manager restarts the service.
+func (p *program) launching(op *operation) (lifecycleState, string) {
+	op.logger.Info("launching clarify")
+	if _, err := p.launchClarify(); err != nil {
+		eventid.Error(op.logger, eventid.JobLaunchFailed, "error launching clarify: %v", err)
+		p.transition(stateStopped, "launch failed")
+		op.end(err)
+		// Exit will allow the service to restart
+		os.Exit(errs.Code(err))
+	}
+	p.releaseDrainLock()
+	return stateRunning, "clarify launched"
+}
+
+// running finishes the startup sequence and supervises clarify until the
+// job is lost or the node drained
+func (p *program) running(op *operation) (lifecycleState, string) {
+	state := "running"
+	if node, err := p.hostID(p.hostname); err == nil && node.Drain {
+		state = "drained"
+	}
+	p.superviseJobs()
+	p.runHook(hookPostStart, state)
+	op.end(nil)
+	p.crash.Go(p.watchJobSpec)
+	return p.pollJob()
+}
+
+// pollJob polls the clarify job and node until the job is lost or the node
+// drained, returning the state to move to. Returns no state once the
+// service is stopping.
+func (p *program) pollJob() (lifecycleState, string) {
+	schedule := newPollSchedule(func() time.Duration {
+		return p.duration(&p.pollInterval)
+	}, p.pollMaxInterval, p.pollJitter)
+	wait := schedule.first()
+	for {
+		select {
+		case <-p.exit:
+			return "", ""
+		case <-time.After(wait):
+		}
+		observed, next, reason := p.poll()
+		if len(next) != 0 {
+			return next, reason
+		}
+		wait = schedule.next(observed)
+	}
+}
+
+// poll checks the clarify job, the supervised jobs and the node once.
+// Returns what it observed, so unchanged polls can slow down, and the state
+// to move to if polling must stop. Nothing is observed when nomad errors.
+func (p *program) poll() (string, lifecycleState, string) {
+	span := p.tracer.Start("poll")
+	find := span.Child("nomad.find_job")
+	job, err := p.findJob(p.clarifyJob())
+	find.End(err)
+	if err == errNomadTimeout {
+		p.logger.Warning(err)
+		span.End(err)
+		return "", "", ""
+	} else if err != nil {
+		eventid.Error(p.logger, eventid.JobLost, "clarify job not found")
+		p.publish("job_lost", p.clarifyJob())
+		span.End(err)
+		return "", stateDraining, "clarify job lost"
+	}
+	jobs := span.Child("supervise_jobs")
+	p.superviseJobs()
+	jobs.End(nil)
+	host := span.Child("nomad.node")
+	n, err := p.hostID(p.hostname)
+	host.End(err)
+	if err != nil {
+		p.logger.Warning("error retrieving node")
+		span.End(err)
+		return "", "", ""
+	} else if n.Drain && p.stopOnDrain(n) {
+		p.logger.Info("node drained")
+		p.publish("node_drained", n.ID)
+		span.Set("drain", true)
+		span.End(nil)
+		return "", stateDraining, "node drained"
+	} else if !n.Drain {
+		p.clearDrainOwner(n.ID)
+	}
+	p.watchAllocs(n)
+	p.streamLogs(n)
+	span.End(nil)
+	observed := fmt.Sprintf("job=%s;drain=%v", job.Status, n.Drain)
+	for _, j := range p.jobs {
+		observed += fmt.Sprintf(";%s=%v", j.name, j.healthy)
+	}
+	statuses := p.variantStatuses()
+	ids := make([]string, 0, len(statuses))
+	for id := range statuses {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		observed += fmt.Sprintf(";%s=%s", id, statuses[id])
+	}
+	return observed, "", ""
 }
 
+// drain drains the node with the configured drain spec
 func (p *program) drain() error {
-	node := p.node()
-	status, err := client.Drain(p.nomad, node.ID, true)
+	return p.drainWith(p.drainOptions())
+}
+
+func (p *program) drainWith(spec nomad.DrainSpec) (err error) {
+	op := p.begin("drain")
+	span := p.tracer.Start("drain")
+	span.Set("operation.id", op.id)
+	defer func() {
+		span.End(err)
+		op.end(err)
+	}()
+	node, err := p.hostID(p.hostname)
 	if err != nil {
-		p.logger.Error("error enabling node-drain.")
+		op.logger.Error("error retrieving node")
 		return err
 	}
-	if status != http.StatusOK {
-		p.logger.Errorf("error enable node-drain; returned %v status code.", status)
-		return errors.New("error enabling node-drain")
+	span.Set("node.id", node.ID)
+	defer func() {
+		p.audit.Record("drain", p.initiator, err, node.Name)
+	}()
+	lock := span.Child("drain_lock")
+	err = p.acquireDrainLock()
+	lock.End(err)
+	if err != nil {
+		op.logger.Error(err)
+		return err
+	}
+	call := span.Child("nomad.drain")
+	span.Set("drain.deadline", spec.Deadline.String())
+	p.drainMu.Lock()
+	p.setDrainOwner(node.ID, true)
+	status, err := p.drainNode(op, node.ID, &spec)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("returned %v status code", status)
 	}
+	if err != nil {
+		p.setDrainOwner(node.ID, false)
+	}
+	p.drainMu.Unlock()
+	call.End(err)
+	if err != nil {
+		eventid.Error(op.logger, eventid.DrainFailed, "error enabling node-drain: %v", err)
+		p.releaseDrainLock()
+		return err
+	}
+	eventid.Info(op.logger, eventid.DrainEnabled, "drain enabled (name=%s;id=%s)", node.Name, node.ID)
+	p.publish("drained", node.ID)
 	return nil
 }
 
 func (p *program) launchClarify() (bool, error) {
-	s, err := client.SubmitJob(p.nomad, strings.Join([]string{p.clarify, p.launch}, string(filepath.Separator)))
-	if err != nil {
-		return false, err
+	op := p.begin("submit_job")
+	span := p.tracer.Start("submit_job")
+	span.Set("operation.id", op.id)
+	launch := p.launchSpec()
+	span.Set("launch", launch)
+	read := span.Child("job_spec")
+	spec, err := p.stagedJobSpec()
+	read.End(err)
+	if err == nil {
+		err = p.checkDrivers(op.nomad, spec)
 	}
-	if s != http.StatusOK {
-		return false, fmt.Errorf("http status: %v", s)
+	if err == nil {
+		submit := span.Child("nomad.submit_job")
+		err = p.newNomad(op.nomad).SubmitJob(spec)
+		submit.End(err)
 	}
+	span.End(err)
+	op.end(err)
+	p.audit.Record("submit_job", p.initiator, err, launch)
+	if err != nil {
+		return false, errs.Wrap(errs.ErrJobSubmitFailed, err)
+	}
+	eventid.Info(op.logger, eventid.JobLaunched, "clarify job submitted (launch=%s)", launch)
+	p.publish("job_submitted", launch)
 	return true, nil
 }
 
@@ -130,28 +472,48 @@ func (p *program) node() *client.Host {
 		p.logger.Error("unable to retrieve hostname")
 		os.Exit(1)
 	}
-	node, err := client.HostID(p.nomad, &hostname)
+	node, err := p.hostID(hostname)
 	if err != nil {
 		p.logger.Errorf("error retrieving node")
 		p.logger.Error(err)
-		os.Exit(1)
+		os.Exit(errs.Code(err))
 	}
 	return node
 }
 
-func (p *program) disableDrain(id string) {
-	s, err := client.Drain(p.nomad, id, false)
+func (p *program) disableDrain(id string) (err error) {
+	op := p.begin("undrain")
+	defer func() {
+		op.end(err)
+	}()
+	span := p.tracer.Start("undrain")
+	span.Set("operation.id", op.id)
+	span.Set("node.id", id)
+	s, err := p.drainNode(op, id, nil)
+	if err == nil && s != http.StatusOK {
+		span.End(fmt.Errorf("http status: %v", s))
+	} else {
+		span.End(err)
+	}
 	if err != nil {
-		p.logger.Error("error disabling drain")
-		p.logger.Error(err)
+		op.logger.Error("error disabling drain")
+		op.logger.Error(err)
 	}
 	if s != http.StatusOK {
-		p.logger.Errorf("error disabling drain; returned %v status", s)
+		op.logger.Errorf("error disabling drain; returned %v status", s)
+		err = fmt.Errorf("http status: %v", s)
+		p.audit.Record("undrain", p.initiator, err, id)
+		return err
 	}
+	p.audit.Record("undrain", p.initiator, nil, id)
+	eventid.Info(op.logger, eventid.DrainDisabled, "drain disabled (id=%s)", id)
+	p.setDrainOwner(id, false)
+	p.publish("undrained", id)
+	return nil
 }
 
 func (p *program) waitForInstall() bool {
-	if _, err := os.Stat(p.clarify); !os.IsNotExist(err) {
+	if err := p.verifyInstall(); err == nil {
 		p.logger.Info("found clarify install directory")
 		return true
 	}
@@ -162,12 +524,13 @@ func (p *program) waitForInstall() bool {
 		for {
 			select {
 			case <-ticker.C:
-				if _, err := os.Stat(p.clarify); !os.IsNotExist(err) {
+				err := p.verifyInstall()
+				if err == nil {
 					ticker.Stop()
 					found <- true
 					return
 				}
-				p.logger.Warning("clarify install not available; waiting")
+				p.logger.Warningf("clarify install not available; waiting (%v)", err)
 			case <-p.exit:
 				ticker.Stop()
 				found <- false
@@ -185,18 +548,315 @@ func isInstall(control *string) bool {
 	return len(*control) != 0 && *control == "install"
 }
 
+// secretFlags map the flags holding secrets, which aren't stored in the
+// service definition, to the file flags the service is installed with
+var secretFlags = map[string]string{
+	"admin-token":  "admin-token-file",
+	"consul-token": "consul-token-file",
+}
+
+// serviceArgs returns the flags given on the command line, minus -control, so
+// the installed service runs with the same configuration
+func serviceArgs() []string {
+	args := make([]string, 0)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "control" || configFlags[f.Name] || len(secretFlags[f.Name]) != 0 {
+			return
+		}
+		if list, ok := f.Value.(*stringList); ok {
+			for _, v := range *list {
+				args = append(args, fmt.Sprintf("-%s=%s", f.Name, v))
+			}
+			return
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	})
+	return args
+}
+
 func main() {
 	control := flag.String("control", "", fmt.Sprintf("Service control command %q.", service.ControlAction))
 	clarify := flag.String("clarify", "", "The location of Clarify install directory.")
-	nomad := flag.String("nomad", ":4646", "Address:Port of Nomad instance.")
-	launch := flag.String("launch", "launch_clarify.json", "Filename of Clarify job specification.")
+	installManifest := flag.String("install-manifest", "clarify.sha256", "sha256sum style manifest in the install directory whose files must match before clarify launches.")
+	installStrict := flag.Bool("install-strict", false, "Requires the install manifest or a .complete sentinel file before clarify launches.")
+	nomadAddr := flag.String("nomad", ":4646", "Address of Nomad instance (host, host:port, [ipv6]:port or http(s) url).")
+	nomadCA := flag.String("nomad-ca-cert", "", "CA an https -nomad is verified with (defaults to the system roots).")
+	nomadCert := flag.String("nomad-client-cert", "", "Client certificate presented to an https -nomad requiring one.")
+	nomadKey := flag.String("nomad-client-key", "", "Private key of -nomad-client-cert.")
+	launch := flag.String("launch", "launch_clarify.json", "Filename of Clarify job specification, kv://<key> to read it from Consul, or an http(s) url.")
+	redeploy := flag.String("redeploy", redeployNotify, "Action taken when the job specification differs from the running job [auto notify manual].")
+	redeployLock := flag.String("redeploy-lock", "clarify/redeploy", "Consul KV prefix locked by the one node resubmitting a changed job specification under -redeploy auto.")
+	specInterval := flag.Duration("spec-interval", time.Minute, "How often file and url job specifications are checked for changes.")
+	autoPromote := flag.Bool("auto-promote", true, "Promotes healthy canaries of an updated clarify job automatically.")
+	canaryTimeout := flag.Duration("canary-timeout", 10*time.Minute, "How long to wait for canaries of an updated clarify job to become healthy.")
+	launchCache := flag.String("launch-cache", "", "Local copy of a remote job specification (defaults to the install directory).")
+	artifactCache := flag.String("artifact-cache", "", "Directory http artifacts of the job with a checksum are downloaded to and verified before submission (empty disables pre-staging).")
+	artifactMirror := flag.String("artifact-mirror", "", "URL every nomad client reaches -artifact-cache at; pre-staged artifacts are downloaded from it instead of their source when set.")
+	launchSum := flag.String("launch-sha256", "", "Pinned SHA-256 checksum of the job specification.")
+	var constraints, nodeMeta, jobMeta stringList
+	flag.Var(&constraints, "constraint", "Constraint added to the job at submit time, e.g. \"${node.class} = clarify\" (repeatable).")
+	flag.Var(&nodeMeta, "node-meta", "key=value node metadata the job is constrained to (repeatable).")
+	flag.Var(&jobMeta, "job-meta", "key=value meta added to the job at submit time (repeatable).")
+	var publishMeta stringList
+	flag.Var(&publishMeta, "publish-meta", "Comma separated key=value metadata set on this nomad node at startup, e.g. \"hardware_class=gpu,rack=r12\" (repeatable).")
+	uninstallJob := flag.String("uninstall-job", uninstallJobNone, fmt.Sprintf("With -control uninstall, also stops the clarify job across the cluster [%s %s].", uninstallJobStop, uninstallJobPurge))
+	uninstallNode := flag.Bool("uninstall-node", false, "With -control uninstall, drains this node, stops its nomad agent and purges it from the cluster.")
+	skipDeps := flag.Bool("skip-dependency-check", false, "With -control install, registers the service without checking the consul and nomad services are installed.")
+	uninstallWait := flag.Duration("uninstall-wait", 5*time.Minute, "How long -uninstall-node waits for allocations to stop.")
+	var extraJobs stringList
+	jobDatacenters := flag.String("job-datacenters", "", "Comma separated [region/]datacenter list the clarify job is submitted to as one clarify-<datacenter> job each, tracked separately; the node runs its own datacenter's job.")
+	flag.Var(&extraJobs, "job", "Job supervised next to clarify as name=spec[,running=N], spec being a file in the install directory and N the minimum running allocations (repeatable).")
+	injectFile := flag.String("job-inject", "", "JSON file of constraints, node_meta and meta added to the job at submit time.")
+	timeout := flag.Duration("nomad-timeout", 10*time.Second, "Timeout of each request to Nomad.")
+	prefix := flag.String("service-prefix", "clarify", "Prefix of the clarify service names, allowing several installs per host.")
+	name := flag.String("service-name", "", "Name of this service (defaults to <service-prefix>).")
+	heartbeatURL := flag.String("heartbeat", "", "Fleet management URL node status is periodically posted to.")
+	heartbeatInterval := flag.Duration("heartbeat-interval", time.Minute, "How often node status is posted to -heartbeat.")
+	heartbeatTLSCert := flag.String("heartbeat-tls-cert", "", "Client certificate, valid for the node's hostname, presented to a -heartbeat url served over mutual TLS.")
+	heartbeatTLSKey := flag.String("heartbeat-tls-key", "", "Private key of -heartbeat-tls-cert.")
+	heartbeatTLSCA := flag.String("heartbeat-tls-ca", "", "CA the fleet controller's certificate is signed by.")
+	health := flag.String("health", "", "TCP address serving /healthz and /readyz (e.g. :8081; disabled when empty).")
+	admin := flag.String("admin", "", "Unix socket, or named pipe on windows, of the admin api (defaults to <service-name>.sock next to the executable, \\\\.\\pipe\\<service-name> on windows; \"off\" disables it).")
+	adminListen := flag.String("admin-listen", "", "TCP address also serving the admin api; a bare port binds to loopback, other hosts need -admin-token or -admin-tls-cert (disabled when empty).")
+	adminToken := flag.String("admin-token", "", "Bearer token required by the admin api (defaults to CLARIFY_ADMIN_TOKEN).")
+	adminTokenFile := flag.String("admin-token-file", "", "File containing the admin api token.")
+	adminTLSCert := flag.String("admin-tls-cert", "", "Certificate -admin-listen serves mutual TLS with.")
+	adminTLSKey := flag.String("admin-tls-key", "", "Private key of -admin-tls-cert.")
+	adminTLSCA := flag.String("admin-tls-ca", "", "CA admin api client certificates must be signed by.")
+	eventHistory := flag.Int("event-history", recentEvents, "Number of recent events kept for /status/events and diagnostic dumps.")
+	crashMax := flag.Int("crash-max", 5, "Launches of the clarify job within -crash-window before the service is quarantined.")
+	crashWindow := flag.Duration("crash-window", 10*time.Minute, "Window launches are counted in.")
+	auditLog := flag.String("audit", "", "Path of the append-only audit log of control actions.")
+	consulAddr := flag.String("consul", ":8500", "Address of Consul instance (host, host:port, [ipv6]:port or http(s) url).")
+	consulCA := flag.String("consul-ca-cert", "", "CA an https -consul is verified with (defaults to the system roots).")
+	consulCert := flag.String("consul-client-cert", "", "Client certificate presented to an https -consul requiring one.")
+	consulKey := flag.String("consul-client-key", "", "Private key of -consul-client-cert.")
+	consulToken := flag.String("consul-token", "", "Consul ACL token (defaults to CONSUL_HTTP_TOKEN).")
+	consulTokenFile := flag.String("consul-token-file", "", "File containing the Consul ACL token (defaults to CONSUL_HTTP_TOKEN_FILE).")
+	consulServices := flag.String("consul-services", "", "Comma separated consul services clarify registers (defaults to the services of the job specification).")
+	nodeEvents := flag.Bool("node-events", false, "Records the service state and its last notable event (drain, job launch, restart) in the node's dynamic metadata (clarify.state and clarify.last-event), shown by nomad node status -verbose.")
+	placementInterval := flag.Duration("placement-interval", 30*time.Second, "How often the clarify job is checked for blocked evaluations (0 disables it).")
+	registrationInterval := flag.Duration("registration-interval", 30*time.Second, "How often the clarify services' consul registration is checked (0 disables it).")
+	registrationGrace := flag.Duration("registration-grace", 2*time.Minute, "How long services may be missing or critical before alerting.")
+	var windows stringList
+	flag.Var(&windows, "maintenance-window", "Recurring maintenance window as a cron expression and duration, e.g. \"0 2 * * sun 3h\" (repeatable).")
+	maintenancePrefix := flag.String("maintenance-prefix", "clarify/maintenance", "Consul KV prefix of the per-node maintenance flags.")
+	rebootPrefix := flag.String("reboot-prefix", "", "Consul KV prefix where nodes drained for a pending OS reboot publish their readiness to a patch orchestrator (empty disables reboot coordination).")
+	rebootInterval := flag.Duration("reboot-check-interval", 10*time.Minute, "How often a pending OS reboot is checked for when -reboot-prefix is set.")
+	readySentinel := flag.String("ready-sentinel", "", "File (registry key under HKLM on windows) present while clarify is running, for other host tooling (defaults to /var/run/<name>.ready or SOFTWARE\\<name>; none disables).")
+	drainDeadline := flag.Duration("drain-deadline", time.Hour, "How long allocations may migrate off a drained node before they're forced off.")
+	drainForce := flag.Bool("drain-force", false, "Stops allocations immediately when draining instead of migrating them.")
+	drainIgnoreSystem := flag.Bool("drain-ignore-system-jobs", false, "Leaves system job allocations running when draining.")
+	drainPolicy := flag.String("drain-policy", drainPolicyExternal, "When a drained node stops the service [external any never].")
+	autoUndrain := flag.String("auto-undrain", autoUndrainAlways, "Whether a drain found at startup is disabled [always never if-self-drained].")
+	compatPolicy := flag.String("compat-policy", compatPolicyRefuse, "Whether nomad or consul versions the compatibility table marks unsupported stop startup [refuse warn].")
+	allocFailures := flag.Int("alloc-failures", 3, "Number of failed or lost clarify allocations on this node before alerting.")
+	allocWindow := flag.Duration("alloc-window", time.Hour, "Window within which -alloc-failures failed or lost allocations trigger the alert.")
+	allocAction := flag.String("alloc-action", allocActionNone, "Action taken when allocations keep failing [none restart evaluate].")
+	streamLogs := flag.Bool("stream-logs", false, "Logs stdout and stderr of the clarify allocations on this node.")
+	notifyURL := flag.String("notify", "", "Webhook URL that receives alerts.")
+	proxyURL := flag.String("proxy", "", "Proxy nomad api calls, artifact downloads, webhooks and other outbound http requests go through, http://[user:password@]host:port (defaults to HTTP_PROXY and HTTPS_PROXY).")
+	noProxy := flag.String("no-proxy", "", "Comma separated hosts, domains and cidrs reached without -proxy.")
+	statsdAddr := flag.String("statsd", "", "host:port of a statsd or DogStatsD collector metrics are sent to.")
+	statsdPrefix := flag.String("statsd-prefix", "clarify", "Prefix of the metric names.")
+	statsdTags := flag.String("statsd-tags", "", "Comma separated key:value DogStatsD tags added to every metric.")
+	postStart := flag.String("post-start", "", "Script run once clarify is running or found on start.")
+	preStop := flag.String("pre-stop", "", "Script run before the service stops.")
+	hookTimeout := flag.Duration("hook-timeout", time.Minute, "How long hook scripts may run before they're killed.")
+	dumpDir := flag.String("dump-dir", "", "Directory diagnostic dumps are written to (defaults to the executable's directory).")
+	crashDir := flag.String("crash-dir", "", "Directory crash reports are written to when the service panics (defaults to crashes beside the executable).")
+	configFile := flag.String("config", "", "JSON file of options keyed by flag name; launch, intervals, notify, log-level and the drain policy are reloaded on SIGHUP.")
+	flag.StringVar(&configKey, "config-key", "", "Key file opening the enc: values of -config sealed with the seal command (values sealed with dpapi on windows need none).")
+	configKV := flag.String("config-kv", "", "Consul KV prefix of fleet-wide reloadable options keyed <prefix>/<flag>, overridden per node by <prefix>/nodes/<hostname>/<flag>, and of feature flags keyed <prefix>/features/[<datacenter>/]<feature>, applied as they change.")
+	features := newFeatures()
+	var featureFlags stringList
+	flag.Var(&featureFlags, "feature", "Comma separated name=on|off feature flags, overridden by -config-kv (repeatable): "+features.Usage()+".")
+	chaosSpec := chaos.Flag()
+	dryRun := flag.Bool("dry-run", false, "Log the nomad and consul calls that would change state (job submissions, drains, deletions) instead of making them.")
+	logDedup := flag.Duration("log-dedup-window", time.Minute, "Repeated warning and info messages are logged once per window (0 disables it).")
+	logLevel := flag.String("log-level", "info", "Minimum level of logged messages [info warning error].")
+	logFile := flag.String("log-file", "", "File messages are also logged to, relative to the executable's directory.")
+	logFileLevel := flag.String("log-file-level", "info", "Minimum level of messages logged to -log-file [info warning error].")
+	logFileMaxMB := flag.Int("log-file-max-mb", 10, "Size in MB at which -log-file is rotated (0 never rotates).")
+	logFileBackups := flag.Int("log-file-backups", 5, "Number of rotated -log-file files kept.")
+	logRemote := flag.String("log-remote", "", "Syslog endpoint messages are also sent to (udp://host:port or tcp://host:port).")
+	logRemoteLevel := flag.String("log-remote-level", "warning", "Minimum level of messages sent to -log-remote [info warning error].")
+	startTimeout := flag.Duration("start-timeout", 10*time.Minute, "How long the service may stay start pending, waiting for the clarify install, before failing; its TimeoutStartSec under systemd (0 waits forever).")
+	pidFile := flag.String("pid-file", "", "Pid file locked while the service runs so only one instance manages the node (defaults to <name>.pid beside the executable).")
+	pollInterval := flag.Duration("poll-interval", 5*time.Second, "How often the clarify job and node are polled.")
+	pollMaxInterval := flag.Duration("poll-max-interval", time.Minute, "Interval polls slow down to while the clarify job and node are unchanged (at most -poll-interval disables it).")
+	pollJitter := flag.Float64("poll-jitter", 0.2, "Fraction of the poll interval polls are randomly spread by (0 to 1).")
+	otlp := flag.String("otlp", "", "OTLP/HTTP endpoint of an OpenTelemetry collector spans are exported to (e.g. http://localhost:4318).")
+	statsdInterval := flag.Duration("statsd-interval", 10*time.Second, "How often node and job state gauges are sent.")
+	drainLock := flag.String("drain-lock", "", "Consul KV prefix used to coordinate drains across the cluster.")
+	drainSlots := flag.Int("drain-slots", 1, "Maximum number of nodes draining at once when -drain-lock is set.")
+	drainWait := flag.Duration("drain-lock-wait", 5*time.Minute, "How long to wait for a drain slot before giving up.")
+	lockTTL := flag.Duration("lock-ttl", time.Hour, "How long a drain or redeploy slot outlives a holder that stopped renewing it, such as a node rebooting or stopped in maintenance (10s to 24h).")
+	vaultCfg := &vaultConfig{}
+	flag.StringVar(&vaultCfg.address, "vault", "", "URL of the Vault server secrets are read from.")
+	flag.StringVar(&vaultCfg.roleID, "vault-role-id", "", "AppRole role-id used to authenticate with Vault (VAULT_TOKEN is used otherwise).")
+	flag.StringVar(&vaultCfg.secretIDFile, "vault-secret-id-file", "", "File containing the AppRole secret-id.")
+	flag.StringVar(&vaultCfg.nomadToken, "vault-nomad-token", "", "Vault path#field of the Nomad ACL token.")
+	flag.StringVar(&vaultCfg.consulToken, "vault-consul-token", "", "Vault path#field of the Consul ACL token.")
+	flag.StringVar(&vaultCfg.tls, "vault-tls", "", "Vault PKI issue path used to issue this node's TLS certificate.")
+	flag.StringVar(&vaultCfg.tlsDir, "vault-tls-dir", "tls", "Directory the issued TLS certificate is written to (relative to the executable's directory).")
 
 	flag.Parse()
+	if err := applyConfig(*configFile); err != nil {
+		log.Fatal(err)
+	}
+	if len(*name) == 0 {
+		*name = *prefix
+	}
+	level, err := parseLogLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fileLevel, err := parseLogLevel(*logFileLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	remoteLevel, err := parseLogLevel(*logRemoteLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if (isInstall(control) || len(*control) == 0) && len(*clarify) == 0 {
+	if (isInstall(control) || len(*control) == 0) && flag.NArg() == 0 && len(*clarify) == 0 {
 		log.Fatal("clarify locaton must be provided")
 	}
 
+	if err := validDrainPolicy(*drainPolicy); err != nil {
+		log.Fatal(err)
+	}
+	if *eventHistory < 1 {
+		log.Fatal("event history must be at least 1")
+	}
+	if err := validAutoUndrain(*autoUndrain); err != nil {
+		log.Fatal(err)
+	}
+	if err := validCompatPolicy(*compatPolicy); err != nil {
+		log.Fatal(err)
+	}
+	if *pollJitter < 0 || *pollJitter > 1 {
+		log.Fatal("poll jitter must be between 0 and 1")
+	}
+	if err := validAllocAction(*allocAction); err != nil {
+		log.Fatal(err)
+	}
+	for key, d := range map[string]time.Duration{
+		"spec-interval":      *specInterval,
+		"heartbeat-interval": *heartbeatInterval,
+		"poll-interval":      *pollInterval,
+		"drain-deadline":     *drainDeadline,
+		"statsd-interval":    *statsdInterval,
+		"alloc-window":       *allocWindow,
+	} {
+		if err := validDuration(key, d); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := validRedeployPolicy(*redeploy); err != nil {
+		log.Fatal(err)
+	}
+	if *lockTTL < 10*time.Second || *lockTTL > 24*time.Hour {
+		log.Fatal("-lock-ttl must be between 10s and 24h")
+	}
+	if *redeploy == redeployAuto && len(*redeployLock) == 0 {
+		log.Fatal("-redeploy auto requires -redeploy-lock")
+	}
+	if err := validUninstallJob(*uninstallJob); err != nil {
+		log.Fatal(err)
+	}
+	maintenanceWindows := make([]*schedule.Window, 0, len(windows))
+	for _, value := range windows {
+		w, err := schedule.ParseWindow(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		maintenanceWindows = append(maintenanceWindows, w)
+	}
+	if len(maintenanceWindows) != 0 && *drainPolicy == drainPolicyAny {
+		log.Fatalf("-maintenance-window needs -drain-policy %s or %s so the service keeps running to close the window", drainPolicyExternal, drainPolicyNever)
+	}
+	adminAuth, err := newAdminAuth(*adminToken, *adminTokenFile, *adminTLSCert, *adminTLSKey, *adminTLSCA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	*adminListen = adminListenAddr(*adminListen)
+	if len(*adminListen) != 0 {
+		if err := adminAuth.checkListen(*adminListen); err != nil {
+			log.Fatal(err)
+		}
+	}
+	redact.Add(adminAuth.token)
+	proxyCfg, err := proxy.Parse(*proxyURL, *noProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	proxy.Install(proxyCfg)
+	nomad.SetProxy(proxyCfg.Func())
+	redact.Add(proxyCfg.Password())
+	if err := setHeartbeatTLS(*heartbeatTLSCert, *heartbeatTLSKey, *heartbeatTLSCA); err != nil {
+		log.Fatal(err)
+	}
+	ctlToken = adminAuth.token
+	inject, err := newInjection(*injectFile, constraints, nodeMeta, jobMeta)
+	if err != nil {
+		log.Fatal(err)
+	}
+	publishedNodeMeta, err := parseNodeMeta(publishMeta)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := features.Configure(featureFlags); err != nil {
+		log.Fatal(err)
+	}
+	var jobs []*supervisedJob
+	for _, value := range extraJobs {
+		job, err := parseJob(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	nomad.SetTimeout(*timeout)
+
+	wd, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !filepath.IsAbs(vaultCfg.tlsDir) {
+		vaultCfg.tlsDir = filepath.Join(wd, vaultCfg.tlsDir)
+	}
+	if len(*artifactCache) != 0 && !filepath.IsAbs(*artifactCache) {
+		*artifactCache = filepath.Join(wd, *artifactCache)
+	}
+	if len(*artifactMirror) != 0 {
+		if len(*artifactCache) == 0 {
+			log.Fatal("-artifact-mirror needs -artifact-cache")
+		}
+		if u, err := url.Parse(*artifactMirror); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			log.Fatalf("invalid -artifact-mirror %q; expected an http or https url", *artifactMirror)
+		}
+	}
+	if len(*dumpDir) == 0 {
+		*dumpDir = wd
+	}
+	if len(*crashDir) == 0 {
+		*crashDir = filepath.Join(wd, "crashes")
+	}
+	switch *readySentinel {
+	case "":
+		*readySentinel = ready.Default(*name)
+	case ready.Disabled:
+		*readySentinel = ""
+	}
+	if len(*pidFile) == 0 {
+		*pidFile = filepath.Join(wd, *name+".pid")
+	}
+
 	// Program
 	var prg *program
 	{
@@ -204,29 +864,164 @@ func main() {
 		if err != nil {
 			log.Fatal("error retrieving hostname")
 		}
-		addressPort := strings.Split(*nomad, ":")
-		if len(addressPort[0]) == 0 {
-			addressPort[0] = "localhost"
+		nomadScheme, address, port, err := parseAddress(*nomadAddr, 4646)
+		if err != nil {
+			log.Fatal(err)
+		}
+		nomadTLS, err := agentTLS(*nomadCA, *nomadCert, *nomadKey)
+		if err != nil {
+			log.Fatalf("-nomad: %v", err)
+		}
+		if nomadTLS != nil && nomadScheme != "https" {
+			log.Fatal("-nomad-ca-cert and -nomad-client-cert need an https -nomad url")
+		}
+		nomad.Scheme = nomadScheme
+		nomad.SetTLS(nomadTLS)
+		consulScheme, consulHost, consulPort, err := parseAddress(*consulAddr, 8500)
+		if err != nil {
+			log.Fatal(err)
+		}
+		consulTLS, err := agentTLS(*consulCA, *consulCert, *consulKey)
+		if err != nil {
+			log.Fatalf("-consul: %v", err)
+		}
+		if consulTLS != nil && consulScheme != "https" {
+			log.Fatal("-consul-ca-cert and -consul-client-cert need an https -consul url")
 		}
-		port, _ := strconv.Atoi(addressPort[1])
 		prg = &program{
-			clarify:  *clarify,
-			hostname: hostname,
-			nomad:    &client.NomadServer{Address: addressPort[0], Port: port},
-			launch:   *launch,
-			exit:     make(chan struct{}),
+			clarify:         *clarify,
+			installManifest: *installManifest,
+			installStrict:   *installStrict,
+			hostname:        hostname,
+			nomad:           &nomad.Server{Address: address, Port: port},
+			newNomad:        newHTTPNomad,
+			consul:          consul.NewClient(consulHost, consulPort),
+			launch:          *launch,
+			lockWait:        *drainWait,
+			maintenance:     *maintenancePrefix,
+			rebootPrefix:    *rebootPrefix,
+			rebootInterval:  *rebootInterval,
+			readySentinel:   *readySentinel,
+			drainPolicy:     *drainPolicy,
+			autoUndrain:     *autoUndrain,
+			compatPolicy:    *compatPolicy,
+			pollMaxInterval: *pollMaxInterval,
+			pollJitter:      *pollJitter,
+			drainSpec: nomad.DrainSpec{
+				Deadline:         *drainDeadline,
+				Force:            *drainForce,
+				IgnoreSystemJobs: *drainIgnoreSystem,
+			},
+			allocs: &allocWatch{
+				threshold: *allocFailures,
+				action:    *allocAction,
+				window:    *allocWindow,
+				seen:      make(map[string]bool),
+			},
+			notifier:        notify.New(*notifyURL, hostname),
+			metricsInterval: *statsdInterval,
+			tracer:          trace.New(*otlp, *name, hostname),
+			configFile:      *configFile,
+			configKV:        *configKV,
+			features:        features,
+			dumpDir:         *dumpDir,
+			crash: &crash.Reporter{
+				Dir:         *crashDir,
+				Name:        *name,
+				Fingerprint: crash.Fingerprint(os.Args[1:], *configFile),
+			},
+			hooks:                map[string]string{hookPostStart: *postStart, hookPreStop: *preStop},
+			hookTimeout:          *hookTimeout,
+			windows:              maintenanceWindows,
+			services:             splitList(*consulServices),
+			registrationInterval: *registrationInterval,
+			placementInterval:    *placementInterval,
+			nodeEvents:           *nodeEvents,
+			registrationGrace:    *registrationGrace,
+			pollInterval:         *pollInterval,
+			remote:               &remoteSpec{cache: *launchCache, sha256: *launchSum},
+			artifactCache:        *artifactCache,
+			artifactMirror:       *artifactMirror,
+			inject:               inject,
+			nodeMeta:             publishedNodeMeta,
+			jobs:                 jobs,
+			redeploy:             *redeploy,
+			specInterval:         *specInterval,
+			autoPromote:          *autoPromote,
+			canaryTimeout:        *canaryTimeout,
+			audit:                audit.Open(*auditLog, *name),
+			name:                 *name,
+			initiator:            "clarifysvc",
+			heartbeatURL:         *heartbeatURL,
+			heartbeatInterval:    *heartbeatInterval,
+			admin:                adminSocket(*admin, *name),
+			adminListen:          *adminListen,
+			adminAuth:            adminAuth,
+			health:               *health,
+			events:               newEventHub(*eventHistory),
+			crashes: &crashloop.Tracker{
+				Path:   filepath.Join(wd, *name+".crashloop.json"),
+				Max:    *crashMax,
+				Window: *crashWindow,
+			},
+			exit: make(chan struct{}),
+		}
+		if prg.metrics, err = metrics.New(*statsdAddr, *statsdPrefix, metrics.Tags(*statsdTags, "host:"+hostname)); err != nil {
+			log.Fatal(err)
+		}
+		if err := prg.consul.SetToken(*consulToken, *consulTokenFile); err != nil {
+			log.Fatal(err)
+		}
+		redact.Add(prg.consul.Token)
+		if consulScheme == "https" {
+			prg.consul.SetTLS(consulTLS)
+		}
+		if u, err := url.Parse(*heartbeatURL); err == nil {
+			// The fleet controller's token may be passed in the url
+			redact.Add(u.Query().Get("token"))
+		}
+		if *streamLogs {
+			prg.logs = &logStreams{active: make(map[string]bool)}
+		}
+		prg.variants, err = parseJobDatacenters(*jobDatacenters, prg.nomad)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, v := range prg.variants {
+			for _, job := range jobs {
+				if job.name == v.id() {
+					log.Fatalf("-job %s clashes with the clarify job of datacenter %s", job.name, v)
+				}
+			}
+		}
+		prg.redeployLock = &consul.Semaphore{
+			Client: prg.consul,
+			Prefix: *redeployLock,
+			Slots:  1,
+			Holder: hostname,
+			TTL:    *lockTTL,
+		}
+		if len(*drainLock) != 0 {
+			prg.lock = &consul.Semaphore{
+				Client: prg.consul,
+				Prefix: *drainLock,
+				Slots:  *drainSlots,
+				Holder: hostname,
+				TTL:    *lockTTL,
+			}
 		}
 	}
 
 	// Service
 	var s service.Service
+	dependencies := []string{*prefix + "-consul", *prefix + "-nomad"}
 	{
 		svcConfig := &service.Config{
-			Name:         "clarify",
-			DisplayName:  "clarify",
-			Description:  "clarify service",
-			Arguments:    []string{fmt.Sprintf("-clarify=%v", *clarify)},
-			Dependencies: []string{"clarify-consul", "clarify-nomad"},
+			Name:         *name,
+			DisplayName:  *name,
+			Description:  *name + " service",
+			Arguments:    serviceArgs(),
+			Dependencies: dependencies,
 		}
 		s, _ = service.New(prg, svcConfig)
 		prg.svc = s
@@ -236,18 +1031,167 @@ func main() {
 	var logger service.Logger
 	{
 		logger, _ = s.Logger(nil)
+		if flag.NArg() == 0 && len(*control) == 0 && !service.Interactive() && journald.Available() {
+			journal, err := journald.New(*name, map[string]string{"UNIT": *name + ".service", "JOB": "clarify"})
+			if err != nil {
+				log.Fatal(err)
+			}
+			prg.journal = journal
+			logger = journal
+		}
+		prg.levels = &levelLogger{Logger: logger, level: level}
+		sinks := logsink.Multi{prg.levels}
+		// Only the service writes to the log file and remote endpoint
+		if flag.NArg() == 0 && len(*control) == 0 {
+			if len(*logFile) != 0 {
+				path := *logFile
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(wd, path)
+				}
+				file, err := logsink.OpenFile(path, int64(*logFileMaxMB)<<20, *logFileBackups)
+				if err != nil {
+					log.Fatal(err)
+				}
+				sinks = append(sinks, &levelLogger{Logger: file, level: fileLevel})
+			}
+			if len(*logRemote) != 0 {
+				remote, err := logsink.Dial(*logRemote, *name)
+				if err != nil {
+					log.Fatal(err)
+				}
+				sinks = append(sinks, &levelLogger{Logger: remote, level: remoteLevel})
+			}
+		}
+		logger = dedup.New(redact.Logger(sinks), *logDedup)
 		prg.logger = logger
+		prg.crash.Logger = logger
+		prg.crash.State = prg.crashState
+	}
+
+	// Dry run
+	if *dryRun {
+		record := func(call string, body []byte) {
+			if len(body) == 0 {
+				logger.Infof("dry-run: would call %s", call)
+				return
+			}
+			logger.Infof("dry-run: would call %s (body=%s)", call, body)
+		}
+		nomad.DryRun = record
+		prg.consul.DryRun = record
+		logger.Warning("dry-run enabled; nomad and consul won't be changed")
+	}
+
+	// Chaos testing
+	if flag.NArg() == 0 && len(*control) == 0 {
+		injector, err := chaos.Parse(*chaosSpec, logger)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if injector != nil {
+			logger.Warningf("chaos mode enabled (spec=%s)", *chaosSpec)
+			nomad.Fault = injector.Fault
+			newNomad := prg.newNomad
+			prg.newNomad = func(server *nomad.Server) nomadAPI {
+				return chaosNomad{nomadAPI: newNomad(server), chaos: injector}
+			}
+		}
 	}
 
-	// Run control command or start program
+	// Secrets
+	if len(vaultCfg.address) != 0 && len(*control) == 0 {
+		if err := prg.loadSecrets(vaultCfg); err != nil {
+			logger.Error(err)
+			log.Fatal(err)
+		}
+	}
+
+	// Run subcommand, control command or start program
+	if flag.NArg() != 0 {
+		prg.initiator = audit.User()
+		var err error
+		switch flag.Arg(0) {
+		case "maintenance":
+			err = maintenance(prg, s, flag.Args()[1:])
+		case "promote":
+			err = prg.promote()
+		case "preflight":
+			prg.preflight(flag.Args()[1:])
+		case "resume":
+			err = prg.resume()
+		case "decommission":
+			err = prg.decommission(flag.Args()[1:], *prefix)
+		case "support-bundle":
+			err = prg.supportBundle(flag.Args()[1:], wd)
+		case "scale":
+			err = prg.scale(flag.Args()[1:])
+		case "upgrade":
+			err = prg.upgrade(flag.Args()[1:])
+		case "dispatch":
+			err = prg.dispatch(flag.Args()[1:])
+		case "peers":
+			err = prg.peers(flag.Args()[1:])
+		case "force-leave":
+			err = prg.forceLeave(flag.Args()[1:])
+		case "reboot-status":
+			err = prg.rebootStatus()
+		case "version":
+			err = buildinfo.Command("clarifysvc", flag.Args()[1:], os.Stdout)
+		case "init-config":
+			err = initConfig(flag.Args()[1:], wd)
+		case "dev":
+			err = prg.dev(flag.Args()[1:], s)
+		case "install-bundle":
+			err = installBundle(flag.Args()[1:], wd)
+		case "install-all":
+			err = installAll(flag.Args()[1:], wd)
+		case "seal":
+			err = sealValue(flag.Args()[1:])
+		case "status", "watch", "drain", "undrain", "lame-duck", "relaunch", "reload", "dump", "rollback", "versions", "events":
+			err = ctl(prg.admin, flag.Arg(0), flag.Args()[1:])
+		default:
+			err = fmt.Errorf("unknown command %q", flag.Arg(0))
+		}
+		prg.audit.Record(strings.Join(flag.Args(), " "), prg.initiator, err, "")
+		if err != nil {
+			errs.Fatal(err)
+		}
+		return
+	}
 	if len(*control) != 0 {
-		if err := service.Control(s, *control); err != nil {
+		if isInstall(control) {
+			if err := scm.CheckSecrets(secretFlags); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if isInstall(control) && !*skipDeps {
+			if err := scm.CheckDependencies(*name, dependencies); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *control == "uninstall" {
+			prg.initiator = audit.User()
+			if err := prg.uninstall(*uninstallJob, *uninstallNode, *uninstallWait, *prefix); err != nil {
+				log.Fatal(err)
+			}
+		}
+		err := scm.Control(s, *control, *name, *startTimeout)
+		prg.audit.Record(*control, audit.User(), err, "")
+		if err != nil {
 			log.Fatal(err)
 		}
 		return
 	}
 
-	if err := s.Run(); err != nil {
+	pid, err := pidfile.Acquire(*pidFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer prg.crash.Recover()
+	err = scm.Run(s, prg, *name, *startTimeout)
+	pid.Release()
+	if err != nil {
 		logger.Error(err)
+		os.Exit(errs.Code(err))
 	}
 }
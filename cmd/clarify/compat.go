@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/compat"
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// Compatibility policies deciding whether unsupported agents stop startup
+const (
+	compatPolicyRefuse = "refuse"
+	compatPolicyWarn   = "warn"
+)
+
+func validCompatPolicy(policy string) error {
+	switch policy {
+	case compatPolicyRefuse, compatPolicyWarn:
+		return nil
+	}
+	return fmt.Errorf("invalid compatibility policy %q; expected %s or %s", policy, compatPolicyRefuse, compatPolicyWarn)
+}
+
+// checkCompat compares the local agents against the compatibility table.
+// Returns an error when an agent is unsupported and the policy refuses it;
+// untested agents only warn.
+func (p *program) checkCompat(op *operation) error {
+	nomadVersion, err := nomad.AgentVersion(op.nomad)
+	if err != nil {
+		op.logger.Warningf("unable to read nomad version: %v", err)
+	}
+	consulVersion, err := op.consul.AgentVersion()
+	if err != nil {
+		op.logger.Warningf("unable to read consul version: %v", err)
+	}
+	var unsupported []string
+	for _, f := range compat.Check(nomadVersion, consulVersion) {
+		op.logger.Warningf("agent compatibility %v (nomad=%s;consul=%s)", f, nomadVersion, consulVersion)
+		if f.Level == compat.Unsupported {
+			unsupported = append(unsupported, f.Reason)
+		}
+	}
+	if len(unsupported) == 0 {
+		return nil
+	}
+	reason := strings.Join(unsupported, "; ")
+	p.publish("incompatible", reason)
+	if err := p.notifier.Notify("incompatible", reason); err != nil {
+		op.logger.Warningf("error sending notification: %v", err)
+	}
+	if p.compatPolicy == compatPolicyWarn {
+		return nil
+	}
+	return fmt.Errorf("unsupported agents: %s; start with -compat-policy=warn to run anyway", reason)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// ctlToken is the bearer token sent to the admin api
+var ctlToken string
+
+// ctlCommands are the subcommands forwarded to the running service's admin
+// api along with the http method they use
+var ctlCommands = map[string]string{
+	"status":    http.MethodGet,
+	"watch":     http.MethodGet,
+	"drain":     http.MethodPost,
+	"undrain":   http.MethodPost,
+	"relaunch":  http.MethodPost,
+	"reload":    http.MethodPost,
+	"dump":      http.MethodPost,
+	"lame-duck": http.MethodPost,
+	"rollback":  http.MethodPost,
+	"versions":  http.MethodGet,
+	"events":    http.MethodGet,
+}
+
+// ctlPaths are the admin api paths of ctl commands not served at /<command>
+var ctlPaths = map[string]string{
+	"events": "status/events",
+}
+
+// ctl runs command against the admin api listening on socket and prints the
+// response to stdout
+func ctl(socket string, command string, args []string) error {
+	var query url.Values
+	switch command {
+	case "drain":
+		query = drainQuery(args)
+	case "lame-duck":
+		query = lameDuckQuery(args)
+	case "rollback":
+		query = rollbackQuery(args)
+	case "events":
+		query = eventsQuery(args)
+	}
+	if command == "watch" {
+		resp, err := ctlDo(socket, command, query)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	body, err := ctlRead(ctlDo(socket, command, query))
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if json.Indent(&out, body, "", "  ") != nil {
+		out.Write(body)
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+// ctlRequest runs command against the admin api listening on socket
+// Returns the response body
+func ctlRequest(socket string, command string) ([]byte, error) {
+	return ctlRead(ctlDo(socket, command, nil))
+}
+
+func ctlRead(resp *http.Response, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && len(e.Error) != 0 {
+			return nil, errors.New(e.Error)
+		}
+		return nil, fmt.Errorf("http status: %v", resp.StatusCode)
+	}
+	return body, nil
+}
+
+// drainQuery parses the drain command's flags into the admin api query
+func drainQuery(args []string) url.Values {
+	fs := flag.NewFlagSet("drain", flag.ExitOnError)
+	fs.Duration("deadline", 0, "How long allocations may migrate before they're forced off (defaults to -drain-deadline).")
+	fs.Bool("force", false, "Stops allocations immediately.")
+	fs.Bool("ignore-system-jobs", false, "Leaves system job allocations running.")
+	fs.Parse(args)
+	// Only flags given on the command line override the service's drain spec
+	query := url.Values{}
+	fs.Visit(func(f *flag.Flag) {
+		query.Set(f.Name, f.Value.String())
+	})
+	return query
+}
+
+func ctlDo(socket string, command string, query url.Values) (*http.Response, error) {
+	if len(socket) == 0 {
+		return nil, errors.New("admin api is disabled")
+	}
+	c := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return dialAdmin(socket)
+			},
+		},
+	}
+	path := command
+	if p, ok := ctlPaths[command]; ok {
+		path = p
+	}
+	u := "http://clarify/" + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(ctlCommands[command], u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(ctlToken) != 0 {
+		req.Header.Set("Authorization", "Bearer "+ctlToken)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the service's admin api (%s): %v", socket, err)
+	}
+	return resp, nil
+}
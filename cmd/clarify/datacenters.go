@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/agentconfig"
+	"github.com/pgombola/clarify-svc/internal/errs"
+	"github.com/pgombola/clarify-svc/internal/eventid"
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// jobVariant is the clarify job qualified to one datacenter, submitted with
+// -job-datacenters as its own clarify-<datacenter> job
+type jobVariant struct {
+	region     string
+	datacenter string
+	// server forwards requests to the variant's region
+	server *nomad.Server
+	status string
+}
+
+// id returns the nomad job id of the variant
+func (v *jobVariant) id() string {
+	return "clarify-" + v.datacenter
+}
+
+func (v *jobVariant) String() string {
+	if len(v.region) == 0 {
+		return v.datacenter
+	}
+	return v.region + "/" + v.datacenter
+}
+
+// parseJobDatacenters parses the comma separated [region/]datacenter list
+// of -job-datacenters
+func parseJobDatacenters(value string, server *nomad.Server) ([]*jobVariant, error) {
+	var variants []*jobVariant
+	seen := make(map[string]bool)
+	for _, dc := range splitList(value) {
+		v := &jobVariant{datacenter: dc, server: server}
+		if parts := strings.SplitN(dc, "/", 2); len(parts) == 2 {
+			v.region, v.datacenter = parts[0], parts[1]
+			if err := agentconfig.ValidName("region", v.region); err != nil {
+				return nil, err
+			}
+			v.server = nomad.WithRegion(server, v.region)
+		}
+		if err := agentconfig.ValidName("datacenter", v.datacenter); err != nil {
+			return nil, err
+		}
+		if seen[v.datacenter] {
+			return nil, fmt.Errorf("datacenter %s is listed twice in -job-datacenters", v.datacenter)
+		}
+		seen[v.datacenter] = true
+		variants = append(variants, v)
+	}
+	return variants, nil
+}
+
+// qualify rewrites the job specification as the variant's job, running in
+// its datacenter and region only
+func (v *jobVariant) qualify(spec []byte) ([]byte, error) {
+	var wrapped map[string]interface{}
+	if err := json.Unmarshal(spec, &wrapped); err != nil {
+		return nil, err
+	}
+	job, ok := wrapped["Job"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("job specification has no Job object")
+	}
+	job["ID"] = v.id()
+	job["Name"] = v.id()
+	job["Datacenters"] = []string{v.datacenter}
+	if len(v.region) != 0 {
+		job["Region"] = v.region
+	}
+	return json.Marshal(wrapped)
+}
+
+// localVariant returns the variant of this node's datacenter, looking it up
+// on the local agent the first time. It returns nil without
+// -job-datacenters.
+func (p *program) localVariant() (*jobVariant, error) {
+	if len(p.variants) == 0 {
+		return nil, nil
+	}
+	p.variantMu.Lock()
+	defer p.variantMu.Unlock()
+	if p.local != nil {
+		return p.local, nil
+	}
+	agent, err := nomad.AgentSelf(p.nomad)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range p.variants {
+		if v.datacenter == agent.Config.Datacenter && (len(v.region) == 0 || v.region == agent.Config.Region) {
+			p.local = v
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("datacenter %s of this node isn't listed in -job-datacenters", agent.Config.Datacenter)
+}
+
+// clarifyJob returns the id of the clarify job this node runs: clarify, or
+// its datacenter's variant with -job-datacenters
+func (p *program) clarifyJob() string {
+	v, err := p.localVariant()
+	if err != nil {
+		p.logger.Warningf("unable to find the clarify job variant of this node: %v", err)
+	}
+	if v == nil {
+		return "clarify"
+	}
+	return v.id()
+}
+
+// launchVariant submits the job specification qualified as the variant
+func (p *program) launchVariant(v *jobVariant) error {
+	op := p.begin("submit_job")
+	spec, err := p.readJobSpec()
+	if err == nil {
+		err = p.prestageArtifacts(spec)
+	}
+	if err == nil {
+		spec, err = p.prepareSpec(spec)
+	}
+	if err == nil {
+		spec, err = v.qualify(spec)
+	}
+	if err == nil {
+		err = nomad.SubmitJob(nomad.WithOperation(v.server, op.id), spec)
+	}
+	op.end(err)
+	p.audit.Record("submit_job", p.initiator, err, v.id())
+	if err != nil {
+		return errs.Wrap(errs.ErrJobSubmitFailed, err)
+	}
+	eventid.Info(op.logger, eventid.JobLaunched, "job submitted (name=%s;datacenter=%s)", v.id(), v)
+	p.publish("job_submitted", v.id())
+	return nil
+}
+
+// superviseVariants launches the variants of the other datacenters missing
+// from nomad. The variant of this node's datacenter follows the clarify job
+// lifecycle instead.
+func (p *program) superviseVariants() {
+	local, _ := p.localVariant()
+	for _, v := range p.variants {
+		if v == local {
+			continue
+		}
+		status, err := nomad.JobStatus(v.server, v.id())
+		if err != nil {
+			p.logger.Warningf("error retrieving job (name=%s): %v", v.id(), err)
+			continue
+		}
+		p.variantMu.Lock()
+		v.status = status
+		p.variantMu.Unlock()
+		if len(status) != 0 {
+			continue
+		}
+		eventid.Warning(p.logger, eventid.JobLost, "job not found; launching it (name=%s;datacenter=%s)", v.id(), v)
+		p.publish("job_lost", v.id())
+		if err := p.launchVariant(v); err != nil {
+			eventid.Error(p.logger, eventid.JobLaunchFailed, "error launching job (name=%s): %v", v.id(), err)
+		}
+	}
+}
+
+// variantStatuses returns the last seen status of the other datacenters'
+// variants by job id
+func (p *program) variantStatuses() map[string]string {
+	local, _ := p.localVariant()
+	p.variantMu.Lock()
+	defer p.variantMu.Unlock()
+	statuses := make(map[string]string)
+	for _, v := range p.variants {
+		if v == local {
+			continue
+		}
+		statuses[v.id()] = v.status
+		if len(v.status) == 0 {
+			statuses[v.id()] = "missing"
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// agentService controls an installed agent wrapper service
+type agentService struct{}
+
+func (agentService) Start(s service.Service) error { return nil }
+func (agentService) Stop(s service.Service) error  { return nil }
+
+// decommission drains this node, removes it from scheduling and service
+// discovery, stops the agents and disables the wrapper. With -purge-node the
+// stopped node is also purged from nomad.
+func (p *program) decommission(args []string, prefix string) error {
+	fs := flag.NewFlagSet("decommission", flag.ExitOnError)
+	deadline := fs.Duration("deadline", 10*time.Minute, "How long allocations may migrate before they're forced off the node.")
+	force := fs.Bool("force", false, "Decommissions a server even when stopping it would lose raft quorum.")
+	purge := fs.Bool("purge-node", false, "Also purges the node from nomad once its agents are stopped; this can't be undone.")
+	purgeVia := fs.String("purge-via", "", "Nomad server address the node is purged through (defaults to an alive server other than this node).")
+	fs.Parse(args)
+
+	node, err := p.hostID(p.hostname)
+	if err != nil {
+		return fmt.Errorf("unable to find node: %v", err)
+	}
+	step := func(name string, err error) error {
+		if err != nil {
+			return fmt.Errorf("decommission failed at %s: %v", name, err)
+		}
+		fmt.Printf("%s: done\n", name)
+		p.publish("decommission", name)
+		return nil
+	}
+
+	if *force {
+		p.logger.Warning("skipping quorum check (-force)")
+	} else if err := step("quorum", p.quorumSafe()); err != nil {
+		return err
+	}
+	if err := step("drain-lock", p.acquireDrainLock()); err != nil {
+		return err
+	}
+	p.drainMu.Lock()
+	p.setDrainOwner(node.ID, true)
+	err = nomad.DrainNode(p.nomad, node.ID, nomad.DrainSpec{Deadline: *deadline, IgnoreSystemJobs: p.drainOptions().IgnoreSystemJobs})
+	if err != nil {
+		p.setDrainOwner(node.ID, false)
+	}
+	p.drainMu.Unlock()
+	if err := step("drain", err); err != nil {
+		p.releaseDrainLock()
+		return err
+	}
+	err = p.waitForAllocs(node.ID, *deadline+time.Minute)
+	p.releaseDrainLock()
+	if err := step("migrate", err); err != nil {
+		return err
+	}
+	if err := step("ineligible", nomad.SetEligibility(p.nomad, node.ID, false)); err != nil {
+		return err
+	}
+	if err := step("deregister-services", p.deregisterServices()); err != nil {
+		return err
+	}
+	var server *nomad.Server
+	if *purge {
+		server, err = p.purgeServer(*purgeVia)
+		if err := step("purge-server", err); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{prefix + "-nomad", prefix + "-consul"} {
+		if err := step("stop "+name, stopService(name)); err != nil {
+			return err
+		}
+	}
+	if *purge {
+		if err := step("purge-node", p.purgeNode(server, node)); err != nil {
+			return err
+		}
+	}
+	if err := step("disable", p.crashes.Quarantine("decommissioned")); err != nil {
+		return err
+	}
+	p.logger.Infof("decommissioned node (name=%s;id=%s)", node.Name, node.ID)
+	return nil
+}
+
+// quorumSafe returns an error when this node is a consul or nomad server
+// whose cluster would lose raft quorum without it
+func (p *program) quorumSafe() error {
+	if server, err := p.consul.IsServer(); err != nil {
+		return fmt.Errorf("unable to detect consul server mode: %v", err)
+	} else if server {
+		health, err := p.consul.Autopilot()
+		if err != nil {
+			return fmt.Errorf("unable to check consul raft health: %v", err)
+		}
+		if health.FailureTolerance < 1 {
+			return fmt.Errorf("stopping consul would lose raft quorum (failure tolerance %d); use -force to override", health.FailureTolerance)
+		}
+	}
+	agent, err := nomad.AgentSelf(p.nomad)
+	if err != nil {
+		return fmt.Errorf("unable to detect nomad server mode: %v", err)
+	}
+	if !agent.Config.Server.Enabled {
+		return nil
+	}
+	health, err := nomad.Autopilot(p.nomad)
+	if err != nil {
+		return fmt.Errorf("unable to check nomad raft health: %v", err)
+	}
+	if health.FailureTolerance < 1 {
+		return fmt.Errorf("stopping nomad would lose raft quorum (failure tolerance %d); use -force to override", health.FailureTolerance)
+	}
+	return nil
+}
+
+// deregisterServices removes every service registered with the local consul
+// agent
+func (p *program) deregisterServices() error {
+	ids, err := p.consul.AgentServices()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := p.consul.DeregisterService(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopService stops the installed service with the provided name
+func stopService(name string) error {
+	s, err := service.New(agentService{}, &service.Config{Name: name})
+	if err != nil {
+		return err
+	}
+	return s.Stop()
+}
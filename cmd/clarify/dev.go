@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// devAgent is a consul or nomad agent started in dev mode
+type devAgent struct {
+	name string
+	cmd  *exec.Cmd
+	done chan error
+}
+
+// dev starts consul and nomad in dev mode and runs the service against them
+// in the console, the same supervision as production, streaming the agent
+// and clarify allocation logs until interrupted
+func (p *program) dev(args []string, s service.Service) error {
+	fs := flag.NewFlagSet("dev", flag.ExitOnError)
+	consulExe := fs.String("consul-exe", "consul", "Path of the consul executable, or its name to look up in PATH.")
+	nomadExe := fs.String("nomad-exe", "nomad", "Path of the nomad executable, or its name to look up in PATH.")
+	quiet := fs.Bool("quiet-agents", false, "Doesn't print the agents' output.")
+	fs.Parse(args)
+	if len(p.clarify) == 0 {
+		return errors.New("dev needs -clarify, the clarify install directory holding the job specification")
+	}
+
+	consul, err := startDevAgent("consul", *consulExe, *quiet)
+	if err != nil {
+		return err
+	}
+	defer consul.stop()
+	nomad, err := startDevAgent("nomad", *nomadExe, *quiet)
+	if err != nil {
+		return err
+	}
+	defer nomad.stop()
+	if p.logs == nil {
+		p.logs = &logStreams{active: make(map[string]bool)}
+	}
+	p.logger.Info("dev agents started; press Ctrl+C to stop")
+	return s.Run()
+}
+
+// startDevAgent starts the agent exe in dev mode, printing its output
+// prefixed with its name unless quiet
+func startDevAgent(name string, exe string, quiet bool) (*devAgent, error) {
+	path, err := exec.LookPath(exe)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find %s: %v; use -%s-exe", name, err, name)
+	}
+	a := &devAgent{name: name, cmd: exec.Command(path, "agent", "-dev"), done: make(chan error, 1)}
+	detach(a.cmd)
+	var out *io.PipeWriter
+	if !quiet {
+		out = prefixWriter(name)
+		a.cmd.Stdout = out
+		a.cmd.Stderr = out
+	}
+	if err := a.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start %s: %v", name, err)
+	}
+	go func() {
+		err := a.cmd.Wait()
+		if out != nil {
+			out.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] exited: %v\n", name, err)
+		}
+		a.done <- err
+	}()
+	return a, nil
+}
+
+// stop interrupts the agent, killing it if it hasn't exited within ten
+// seconds
+func (a *devAgent) stop() {
+	// https://github.com/golang/go/issues/6720
+	if runtime.GOOS == "windows" {
+		a.cmd.Process.Kill()
+	} else {
+		a.cmd.Process.Signal(os.Interrupt)
+	}
+	select {
+	case <-a.done:
+	case <-time.After(10 * time.Second):
+		a.cmd.Process.Kill()
+		<-a.done
+	}
+}
+
+// prefixWriter returns a writer printing each line to stdout prefixed with
+// [name] until it's closed
+func prefixWriter(name string) *io.PipeWriter {
+	r, w := io.Pipe()
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			fmt.Printf("[%s] %s\n", name, scanner.Text())
+		}
+	}()
+	return w
+}
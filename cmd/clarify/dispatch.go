@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// dispatch dispatches an instance of a parameterized job, e.g. a maintenance
+// batch task, and waits for its evaluation to complete
+func (p *program) dispatch(args []string) error {
+	fs := flag.NewFlagSet("dispatch", flag.ExitOnError)
+	var metaList stringList
+	fs.Var(&metaList, "meta", "key=value meta of the dispatched job (repeatable).")
+	payloadFile := fs.String("payload", "", "File whose contents are the job's payload, or - for stdin.")
+	wait := fs.Duration("wait", 2*time.Minute, "How long to wait for the evaluation to complete (0 doesn't wait).")
+	// The job may come before or after the flags
+	var job string
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		job, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+	if len(job) == 0 {
+		job = fs.Arg(0)
+	}
+	if len(job) == 0 {
+		return errors.New("usage: dispatch <job> [-meta key=value] [-payload file]")
+	}
+	meta := make(map[string]string)
+	for _, kv := range metaList {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid meta %q; expected key=value", kv)
+		}
+		meta[parts[0]] = parts[1]
+	}
+	var payload []byte
+	var err error
+	switch *payloadFile {
+	case "":
+	case "-":
+		payload, err = ioutil.ReadAll(os.Stdin)
+	default:
+		payload, err = ioutil.ReadFile(*payloadFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	id, evalID, err := nomad.DispatchJob(p.nomad, job, payload, meta)
+	if err != nil {
+		return err
+	}
+	p.publish("job_dispatched", id)
+	fmt.Printf("dispatched %s (job=%s;eval=%s)\n", job, id, evalID)
+	if len(evalID) == 0 || *wait == 0 {
+		return nil
+	}
+	return p.monitorEvaluation(evalID, *wait)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/gomad/client"
+)
+
+// drainOwnerMeta is the nomad node metadata key recording that clarifysvc
+// enabled drain itself
+const drainOwnerMeta = reservedMeta + "drain-owner"
+
+// Drain policies deciding when a drained node stops the service
+const (
+	drainPolicyExternal = "external"
+	drainPolicyAny      = "any"
+	drainPolicyNever    = "never"
+)
+
+func validDrainPolicy(policy string) error {
+	switch policy {
+	case drainPolicyExternal, drainPolicyAny, drainPolicyNever:
+		return nil
+	}
+	return fmt.Errorf("invalid drain policy %q; expected %s, %s or %s", policy, drainPolicyExternal, drainPolicyAny, drainPolicyNever)
+}
+
+// Auto undrain policies deciding whether a drain found at startup is disabled
+const (
+	autoUndrainAlways      = "always"
+	autoUndrainNever       = "never"
+	autoUndrainSelfDrained = "if-self-drained"
+)
+
+func validAutoUndrain(policy string) error {
+	switch policy {
+	case autoUndrainAlways, autoUndrainNever, autoUndrainSelfDrained:
+		return nil
+	}
+	return fmt.Errorf("invalid auto undrain policy %q; expected %s, %s or %s", policy, autoUndrainAlways, autoUndrainNever, autoUndrainSelfDrained)
+}
+
+// setDrainOwner records (or clears) that this wrapper owns the node's drain.
+// Ownership is recorded before the drain is requested, with drainMu held, so
+// a poll never sees the drain without its owner.
+func (p *program) setDrainOwner(id string, owned bool) {
+	meta := map[string]*string{drainOwnerMeta: nil}
+	if owned {
+		meta[drainOwnerMeta] = &p.hostname
+		atomic.StoreInt32(&p.ownerClear, 0)
+	}
+	if err := nomad.SetMeta(p.nomad, id, meta); err != nil {
+		p.logger.Warning("error updating drain owner metadata")
+		p.logger.Warning(err)
+	}
+}
+
+// clearDrainOwner clears the drain owner of a node that isn't draining, so
+// a drain disabled through nomad rather than the wrapper doesn't make the
+// next external drain look like its own
+func (p *program) clearDrainOwner(id string) {
+	if atomic.LoadInt32(&p.ownerClear) == 1 {
+		return
+	}
+	p.drainMu.Lock()
+	defer p.drainMu.Unlock()
+	node, err := nomad.GetNode(p.nomad, id)
+	if err != nil {
+		p.logger.Warningf("error retrieving drain owner: %v", err)
+		return
+	}
+	if node.Drain {
+		return
+	}
+	if owner := node.Meta[drainOwnerMeta]; len(owner) != 0 {
+		p.logger.Infof("clearing drain owner of undrained node (id=%s;owner=%s)", id, owner)
+		if err := nomad.SetMeta(p.nomad, id, map[string]*string{drainOwnerMeta: nil}); err != nil {
+			p.logger.Warningf("error clearing drain owner metadata: %v", err)
+			return
+		}
+	}
+	atomic.StoreInt32(&p.ownerClear, 1)
+}
+
+// stopOnDrain applies the drain policy to a drained node
+func (p *program) stopOnDrain(host *client.Host) bool {
+	switch p.stopPolicy() {
+	case drainPolicyNever:
+		return false
+	case drainPolicyAny:
+		return true
+	}
+	node, err := nomad.GetNode(p.nomad, host.ID)
+	if err != nil {
+		p.logger.Warning("error retrieving drain owner; assuming external drain")
+		return true
+	}
+	return len(node.Meta[drainOwnerMeta]) == 0
+}
+
+// shouldUndrain applies the auto undrain policy to a node found drained at
+// startup
+func (p *program) shouldUndrain(host *client.Host) bool {
+	switch p.undrainPolicy() {
+	case autoUndrainNever:
+		return false
+	case autoUndrainAlways:
+		return true
+	}
+	node, err := nomad.GetNode(p.nomad, host.ID)
+	if err != nil {
+		p.logger.Warning("error retrieving drain owner; leaving drain enabled")
+		return false
+	}
+	return len(node.Meta[drainOwnerMeta]) != 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// acquireDrainLock blocks until one of the cluster-wide drain slots is
+// available so only a bounded number of nodes drain at once.
+func (p *program) acquireDrainLock() error {
+	if p.lock == nil {
+		return nil
+	}
+	deadline := time.Now().Add(p.lockWait)
+	for {
+		key, err := p.lock.TryAcquire()
+		if err != nil {
+			p.logger.Error("error acquiring drain lock")
+			return err
+		}
+		if len(key) != 0 {
+			p.logger.Infof("acquired drain lock (key=%s)", key)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for drain lock")
+		}
+		p.logger.Warning("all drain slots held; waiting")
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (p *program) releaseDrainLock() {
+	if p.lock == nil {
+		return
+	}
+	if err := p.lock.Release(); err != nil {
+		p.logger.Error("error releasing drain lock")
+		p.logger.Error(err)
+		return
+	}
+	p.logger.Info("released drain lock")
+}
+
+func (p *program) releaseRedeployLock() {
+	if err := p.redeployLock.Release(); err != nil {
+		p.logger.Error("error releasing redeploy lock")
+		p.logger.Error(err)
+	}
+}
+
+// keepLocks renews the drain and redeploy slots this node holds until the
+// program exits. A slot expires -lock-ttl after its renewals stop.
+func (p *program) keepLocks() {
+	if p.lock != nil {
+		p.crash.Go(func() { p.lock.Keep(p.exit, p.lockRenewFailed) })
+	}
+	if p.redeployLock != nil {
+		p.redeployLock.Keep(p.exit, p.lockRenewFailed)
+	}
+}
+
+func (p *program) lockRenewFailed(err error) {
+	p.logger.Warningf("error renewing lock: %v", err)
+}
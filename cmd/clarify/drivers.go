@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// requiredDrivers returns the sorted task drivers used by the job
+// specification
+func requiredDrivers(spec []byte) ([]string, error) {
+	var wrapped struct {
+		Job struct {
+			TaskGroups []struct {
+				Tasks []struct {
+					Driver string `json:"Driver"`
+				} `json:"Tasks"`
+			} `json:"TaskGroups"`
+		} `json:"Job"`
+	}
+	if err := json.Unmarshal(spec, &wrapped); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	drivers := make([]string, 0)
+	for _, group := range wrapped.Job.TaskGroups {
+		for _, task := range group.Tasks {
+			if len(task.Driver) == 0 || seen[task.Driver] {
+				continue
+			}
+			seen[task.Driver] = true
+			drivers = append(drivers, task.Driver)
+		}
+	}
+	sort.Strings(drivers)
+	return drivers, nil
+}
+
+// checkDrivers verifies the nomad client fingerprinted every driver the job
+// specification requires so a job that can't be placed fails at submission
+// instead of sitting in a blocked evaluation. Nodes that don't report
+// driver fingerprints aren't checked.
+func (p *program) checkDrivers(server *nomad.Server, spec []byte) error {
+	drivers, err := requiredDrivers(spec)
+	if err != nil || len(drivers) == 0 {
+		return nil
+	}
+	host, err := p.hostID(p.hostname)
+	if err != nil {
+		p.logger.Warningf("unable to check task drivers: %v", err)
+		return nil
+	}
+	node, err := nomad.GetNode(server, host.ID)
+	if err != nil {
+		p.logger.Warningf("unable to check task drivers: %v", err)
+		return nil
+	}
+	if len(node.Drivers) == 0 {
+		return nil
+	}
+	missing := make([]string, 0)
+	unhealthy := make([]string, 0)
+	for _, name := range drivers {
+		d, ok := node.Drivers[name]
+		switch {
+		case !ok || !d.Detected:
+			missing = append(missing, name)
+		case !d.Healthy:
+			unhealthy = append(unhealthy, fmt.Sprintf("%s: %s", name, d.HealthDescription))
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("nomad client %s is missing the %s driver required by the job; install or enable it in the nomad client config", p.hostname, strings.Join(missing, ", "))
+	}
+	if len(unhealthy) != 0 {
+		return fmt.Errorf("nomad client %s has unhealthy drivers required by the job (%s)", p.hostname, strings.Join(unhealthy, "; "))
+	}
+	return nil
+}
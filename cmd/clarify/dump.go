@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// dump writes the goroutine stacks, node and job state, recent events and
+// recent nomad responses to a timestamped file in the dump directory
+// Returns the path of the file
+func (p *program) dump() (string, error) {
+	if err := os.MkdirAll(p.dumpDir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(p.dumpDir, fmt.Sprintf("%s-dump-%s.txt", p.name, time.Now().UTC().Format("20060102T150405Z")))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	section := func(title string, v interface{}) {
+		fmt.Fprintf(f, "== %s ==\n", title)
+		buf, _ := json.MarshalIndent(v, "", "  ")
+		f.Write(buf)
+		fmt.Fprint(f, "\n\n")
+	}
+	fmt.Fprintln(f, "== goroutines ==")
+	if err := pprof.Lookup("goroutine").WriteTo(f, 2); err != nil {
+		return "", err
+	}
+	fmt.Fprintln(f)
+	section("events", p.events.history())
+	section("nomad responses", nomad.Recent())
+	// Status queries nomad and consul, which may be what's hanging
+	section("status", p.status())
+	p.logger.Infof("wrote diagnostic dump (file=%s)", path)
+	return path, nil
+}
+
+func (p *program) handleDump(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return
+	}
+	path, err := p.dump()
+	p.audit.Record("dump", adminInitiator(r), err, path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"file": path})
+}
+
+// crashState is the program state written to crash reports. Unlike status
+// it doesn't call nomad or consul.
+func (p *program) crashState() interface{} {
+	state, since := p.state()
+	return map[string]interface{}{
+		"state":       state,
+		"state_since": since,
+		"events":      p.events.history(),
+	}
+}
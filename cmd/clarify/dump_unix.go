@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchDump writes a diagnostic dump on SIGUSR1 until the program exits
+func (p *program) watchDump() {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
+	for {
+		select {
+		case <-usr1:
+			if _, err := p.dump(); err != nil {
+				p.logger.Errorf("error writing diagnostic dump: %v", err)
+			}
+		case <-p.exit:
+			return
+		}
+	}
+}
@@ -0,0 +1,5 @@
+package main
+
+// watchDump is a no-op; windows has no SIGUSR1 so dumps are requested through
+// the admin api
+func (p *program) watchDump() {}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// event is a notable change in the program's lifecycle
+type event struct {
+	Event  string    `json:"event"`
+	Detail string    `json:"detail,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// recentEvents is how many events are kept for diagnostics by default
+const recentEvents = 100
+
+// eventHub fans events out to the admin api's watchers and keeps the size
+// most recent events
+type eventHub struct {
+	sync.Mutex
+	watchers map[chan *event]bool
+	recent   []*event
+	size     int
+}
+
+func newEventHub(size int) *eventHub {
+	return &eventHub{watchers: make(map[chan *event]bool), size: size}
+}
+
+func (h *eventHub) subscribe() chan *event {
+	h.Lock()
+	defer h.Unlock()
+	ch := make(chan *event, 16)
+	h.watchers[ch] = true
+	return ch
+}
+
+// history returns the most recent events, oldest first
+func (h *eventHub) history() []*event {
+	h.Lock()
+	defer h.Unlock()
+	return append([]*event{}, h.recent...)
+}
+
+func (h *eventHub) unsubscribe(ch chan *event) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.watchers, ch)
+}
+
+// publish sends an event to every watcher, dropping it for watchers that
+// aren't keeping up
+func (p *program) publish(name string, detail string) {
+	if p.events == nil {
+		return
+	}
+	p.metrics.Incr("events", "event:"+name)
+	e := &event{Event: name, Detail: detail, Time: time.Now().UTC()}
+	p.events.Lock()
+	defer p.events.Unlock()
+	p.events.recent = append(p.events.recent, e)
+	if len(p.events.recent) > p.events.size {
+		p.events.recent = p.events.recent[len(p.events.recent)-p.events.size:]
+	}
+	for ch := range p.events.watchers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// handleEvents returns the recent events, oldest first, optionally only the
+// last limit ones whose name starts with the event query parameter
+func (p *program) handleEvents(w http.ResponseWriter, r *http.Request) {
+	events := p.events.history()
+	if prefix := r.URL.Query().Get("event"); len(prefix) != 0 {
+		matched := make([]*event, 0, len(events))
+		for _, e := range events {
+			if strings.HasPrefix(e.Event, prefix) {
+				matched = append(matched, e)
+			}
+		}
+		events = matched
+	}
+	if value := r.URL.Query().Get("limit"); len(value) != 0 {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", value))
+			return
+		}
+		if len(events) > limit {
+			events = events[len(events)-limit:]
+		}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// eventsQuery parses the events command's flags into the admin api query
+func eventsQuery(args []string) url.Values {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	limit := fs.Int("limit", 0, "Prints only the most recent events.")
+	prefix := fs.String("event", "", "Prints only events whose name starts with this prefix.")
+	fs.Parse(args)
+	query := url.Values{}
+	if *limit > 0 {
+		query.Set("limit", strconv.Itoa(*limit))
+	}
+	if len(*prefix) != 0 {
+		query.Set("event", *prefix)
+	}
+	return query
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/consul"
+	"github.com/pgombola/clarify-svc/internal/feature"
+)
+
+// Feature flags gating behaviors that change the cluster without an operator.
+// They default to off and are turned on with -feature or -config-kv.
+const (
+	// featureAutoRedeploy gates resubmitting a changed job specification
+	// under -redeploy auto; while off the change is only notified
+	featureAutoRedeploy = "auto-redeploy"
+	// featureAllocRestart gates restarting failing allocations under
+	// -alloc-action restart; while off the failures are only alerted
+	featureAllocRestart = "alloc-restart"
+)
+
+// remoteFeatures is the path beneath the -config-kv prefix of the feature
+// flags, keyed <prefix>/features/<flag> and overridden per datacenter by
+// <prefix>/features/<datacenter>/<flag>
+const remoteFeatures = "features/"
+
+func newFeatures() *feature.Set {
+	return feature.New(
+		feature.Flag{Name: featureAutoRedeploy, Default: false, Description: "resubmit a changed job specification under -redeploy auto"},
+		feature.Flag{Name: featureAllocRestart, Default: false, Description: "restart failing allocations under -alloc-action restart"},
+	)
+}
+
+// applyRemoteFeatures overrides the feature flags with those stored beneath
+// the -config-kv prefix
+func (p *program) applyRemoteFeatures(prefix string, pairs []consul.KVPair) {
+	if len(p.datacenter) == 0 {
+		dc, err := p.consul.Datacenter()
+		if err != nil {
+			p.logger.Warningf("unable to read the consul datacenter; applying fleet feature flags only: %v", err)
+		}
+		p.datacenter = dc
+	}
+	values := make(map[string]string)
+	overrides := make(map[string]string)
+	dc := p.datacenter + "/"
+	for _, pair := range pairs {
+		key := strings.TrimPrefix(pair.Key, prefix+remoteFeatures)
+		if key == pair.Key || len(key) == 0 {
+			continue
+		}
+		dst := values
+		if len(p.datacenter) != 0 && strings.HasPrefix(key, dc) {
+			key, dst = strings.TrimPrefix(key, dc), overrides
+		} else if strings.Contains(key, "/") {
+			continue
+		}
+		dst[key] = string(pair.Value)
+	}
+	for key, value := range overrides {
+		values[key] = value
+	}
+	changed, err := p.features.SetRemote(values)
+	if err != nil {
+		p.logger.Warningf("ignoring invalid remote feature flags: %v", err)
+	}
+	if changed {
+		p.logger.Infof("feature flags changed (features=%s)", p.features)
+		p.publish("features_changed", p.features.String())
+	}
+}
@@ -0,0 +1,866 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/audit"
+	"github.com/pgombola/clarify-svc/internal/consul"
+	"github.com/pgombola/clarify-svc/internal/crashloop"
+	"github.com/pgombola/clarify-svc/internal/feature"
+	"github.com/pgombola/clarify-svc/internal/metrics"
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/clarify-svc/internal/notify"
+	"github.com/pgombola/clarify-svc/internal/reboot"
+	"github.com/pgombola/clarify-svc/internal/rpc"
+	"github.com/pgombola/clarify-svc/internal/testutil"
+	"github.com/pgombola/clarify-svc/internal/trace"
+	"github.com/pgombola/gomad/client"
+)
+
+const (
+	testNode     = "node-1"
+	testHostname = "host-1"
+	testSpec     = `{"Job":{"ID":"clarify","Name":"clarify","TaskGroups":[{"Name":"worker","Tasks":[{"Name":"worker","Driver":"raw_exec"}]}]}}`
+)
+
+// testLogger writes the program's log lines to the test log
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Error(v ...interface{}) error   { l.t.Log(v...); return nil }
+func (l testLogger) Warning(v ...interface{}) error { l.t.Log(v...); return nil }
+func (l testLogger) Info(v ...interface{}) error    { l.t.Log(v...); return nil }
+func (l testLogger) Errorf(format string, a ...interface{}) error {
+	l.t.Logf(format, a...)
+	return nil
+}
+func (l testLogger) Warningf(format string, a ...interface{}) error {
+	l.t.Logf(format, a...)
+	return nil
+}
+func (l testLogger) Infof(format string, a ...interface{}) error {
+	l.t.Logf(format, a...)
+	return nil
+}
+
+// newTestProgram returns a program talking to fake nomad and consul agents,
+// with the clarify job specification in its install directory
+func newTestProgram(t *testing.T) (*program, *testutil.Nomad) {
+	t.Helper()
+	n := testutil.NewNomad()
+	t.Cleanup(n.Close)
+	c := testutil.NewConsul()
+	t.Cleanup(c.Close)
+	n.AddNode(testNode, testHostname)
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "launch_clarify.json"), []byte(testSpec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := metrics.New("", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inject, err := newInjection("", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &program{
+		name:         "clarify",
+		clarify:      dir,
+		hostname:     testHostname,
+		nomad:        n.Client(),
+		newNomad:     newHTTPNomad,
+		consul:       c.Client(),
+		launch:       "launch_clarify.json",
+		drainPolicy:  drainPolicyExternal,
+		pollInterval: 10 * time.Millisecond,
+		drainSpec:    nomad.DrainSpec{Deadline: time.Minute},
+		allocs:       &allocWatch{seen: make(map[string]bool)},
+		notifier:     notify.New("", testHostname),
+		metrics:      stats,
+		tracer:       trace.New("", "clarify", testHostname),
+		features:     feature.New(),
+		inject:       inject,
+		audit:        audit.Open("", "clarify"),
+		initiator:    "test",
+		events:       newEventHub(recentEvents),
+		logger:       testLogger{t},
+		exit:         make(chan struct{}),
+	}
+	return p, n
+}
+
+func TestLaunchClarify(t *testing.T) {
+	p, n := newTestProgram(t)
+	launched, err := p.launchClarify()
+	if err != nil || !launched {
+		t.Fatalf("launchClarify() = %v, %v; want true, nil", launched, err)
+	}
+	if status := n.Job("clarify"); status != "running" {
+		t.Fatalf("clarify job status = %q; want running", status)
+	}
+	for _, r := range n.Requests() {
+		if r.Method == "POST" && r.Path == "/v1/jobs" && len(r.Operation) == 0 {
+			t.Fatal("job submitted without the operation id header")
+		}
+	}
+}
+
+func TestLaunchClarifyNomadDown(t *testing.T) {
+	p, n := newTestProgram(t)
+	n.SetDown(true)
+	if _, err := p.launchClarify(); err == nil {
+		t.Fatal("launchClarify() succeeded with nomad down")
+	}
+	if status := n.Job("clarify"); len(status) != 0 {
+		t.Fatalf("clarify job status = %q; want no job", status)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	p, n := newTestProgram(t)
+	n.SetJob("clarify", "running")
+
+	observed, next, _ := p.poll()
+	if len(next) != 0 {
+		t.Fatalf("poll() moved to %s with the job running", next)
+	}
+	if want := "job=running;drain=false"; observed != want {
+		t.Fatalf("poll() observed %q; want %q", observed, want)
+	}
+
+	n.SetJob("clarify", "")
+	if _, next, reason := p.poll(); next != stateDraining {
+		t.Fatalf("poll() = %q (%s) with the job lost; want %s", next, reason, stateDraining)
+	}
+}
+
+func TestPollExternalDrain(t *testing.T) {
+	p, n := newTestProgram(t)
+	n.SetJob("clarify", "running")
+	if err := nomad.DrainNode(p.nomad, testNode, nomad.DrainSpec{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, next, reason := p.poll(); next != stateDraining || reason != "node drained" {
+		t.Fatalf("poll() = %q (%s) on a drained node; want %s (node drained)", next, reason, stateDraining)
+	}
+
+	p.drainPolicy = drainPolicyNever
+	if _, next, _ := p.poll(); len(next) != 0 {
+		t.Fatalf("poll() moved to %s under -drain-policy never", next)
+	}
+}
+
+func TestPollSelfDrain(t *testing.T) {
+	p, n := newTestProgram(t)
+	n.SetJob("clarify", "running")
+	if err := p.drain(); err != nil {
+		t.Fatal(err)
+	}
+	if _, next, _ := p.poll(); len(next) != 0 {
+		t.Fatalf("poll() moved to %s on a node the wrapper drained itself", next)
+	}
+}
+
+func TestPollOperatorUndrain(t *testing.T) {
+	p, n := newTestProgram(t)
+	n.SetJob("clarify", "running")
+	if err := p.drain(); err != nil {
+		t.Fatal(err)
+	}
+	// The operator disables the wrapper's drain through nomad, then drains
+	// the node again
+	if err := nomad.DisableDrain(p.nomad, testNode); err != nil {
+		t.Fatal(err)
+	}
+	if _, next, _ := p.poll(); len(next) != 0 {
+		t.Fatalf("poll() moved to %s on an undrained node", next)
+	}
+	if node, err := nomad.GetNode(p.nomad, testNode); err != nil || len(node.Meta[drainOwnerMeta]) != 0 {
+		t.Fatalf("drain owner %q (%v) after the node was undrained; want it cleared", node.Meta[drainOwnerMeta], err)
+	}
+	if err := nomad.DrainNode(p.nomad, testNode, nomad.DrainSpec{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, next, _ := p.poll(); next != stateDraining {
+		t.Fatalf("poll() = %q after an operator drain; want %s", next, stateDraining)
+	}
+}
+
+func TestPollJob(t *testing.T) {
+	p, n := newTestProgram(t)
+	n.SetJob("clarify", "running")
+	done := make(chan lifecycleState, 1)
+	go func() {
+		next, _ := p.pollJob()
+		done <- next
+	}()
+	time.Sleep(50 * time.Millisecond)
+	n.SetJob("clarify", "")
+	select {
+	case next := <-done:
+		if next != stateDraining {
+			t.Fatalf("pollJob() = %q once the job was lost; want %s", next, stateDraining)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pollJob() didn't notice the lost job")
+	}
+
+	close(p.exit)
+	if next, _ := p.pollJob(); len(next) != 0 {
+		t.Fatalf("pollJob() = %q while stopping; want no state", next)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	p, n := newTestProgram(t)
+	if err := p.drain(); err != nil {
+		t.Fatal(err)
+	}
+	spec := n.Drain(testNode)
+	if spec == nil {
+		t.Fatal("node isn't draining")
+	}
+	if spec.Deadline != time.Minute {
+		t.Fatalf("drain deadline = %v; want %v", spec.Deadline, time.Minute)
+	}
+	if err := p.disableDrain(testNode); err != nil {
+		t.Fatal(err)
+	}
+	if n.Drain(testNode) != nil {
+		t.Fatal("node still draining after disableDrain")
+	}
+}
+
+func TestDrainUnknownNode(t *testing.T) {
+	p, n := newTestProgram(t)
+	p.hostname = "unknown"
+	if err := p.drain(); err == nil {
+		t.Fatal("drain() succeeded for a node nomad doesn't know")
+	}
+	for _, r := range n.Requests() {
+		if r.Method == "POST" {
+			t.Fatalf("drain() sent %s %s for an unknown node", r.Method, r.Path)
+		}
+	}
+}
+
+// failingNomad refuses drains, passing the other calls to nomad
+type failingNomad struct {
+	nomadAPI
+}
+
+func (failingNomad) Drain(id string, spec *nomad.DrainSpec) (int, error) {
+	return 0, fmt.Errorf("drain %s refused", id)
+}
+
+func TestDrainFailure(t *testing.T) {
+	p, n := newTestProgram(t)
+	p.newNomad = func(server *nomad.Server) nomadAPI {
+		return failingNomad{nomadAPI: newHTTPNomad(server)}
+	}
+	if err := p.drain(); err == nil {
+		t.Fatal("drain() succeeded although nomad refused it")
+	}
+	if n.Drain(testNode) != nil {
+		t.Fatal("node draining although the drain failed")
+	}
+}
+
+func TestDrainLock(t *testing.T) {
+	p1, _ := newTestProgram(t)
+	p2, n2 := newTestProgram(t)
+	p1.lock = &consul.Semaphore{Client: p1.consul, Prefix: "clarify/drain", Slots: 1, Holder: "host-1"}
+	p2.lock = &consul.Semaphore{Client: p1.consul, Prefix: "clarify/drain", Slots: 1, Holder: "host-2"}
+	if err := p1.drain(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p2.drain(); err == nil {
+		t.Fatal("drain() succeeded while the only drain slot was held")
+	}
+	if n2.Drain(testNode) != nil {
+		t.Fatal("node draining without the drain lock")
+	}
+	p1.releaseDrainLock()
+	if err := p2.drain(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPollNomadTimeout(t *testing.T) {
+	p, _ := newTestProgram(t)
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer hung.Close()
+	host, port, _ := net.SplitHostPort(hung.Listener.Addr().String())
+	p.nomad.Address = host
+	p.nomad.Port, _ = strconv.Atoi(port)
+	nomad.SetTimeout(50 * time.Millisecond)
+	defer nomad.SetTimeout(10 * time.Second)
+
+	start := time.Now()
+	if _, next, _ := p.poll(); len(next) != 0 {
+		t.Fatalf("poll() moved to %s when nomad timed out", next)
+	}
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Fatalf("poll() took %v with a 50ms nomad timeout", d)
+	}
+}
+
+func TestNomadProxy(t *testing.T) {
+	p, n := newTestProgram(t)
+	n.SetJob("clarify", "running")
+	var proxied int
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied++
+		http.Error(w, "proxy refused", http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	nomad.SetProxy(func(*http.Request) (*url.URL, error) { return proxyURL, nil })
+	defer nomad.SetProxy(http.ProxyFromEnvironment)
+
+	if _, err := p.findJob("clarify"); err == nil {
+		t.Fatal("findJob() succeeded although the proxy refused it")
+	}
+	if proxied == 0 {
+		t.Fatal("nomad call didn't go through the proxy")
+	}
+}
+
+func TestDryRunPlan(t *testing.T) {
+	p, _ := newTestProgram(t)
+	if err := nomad.SubmitJob(p.nomad, []byte(testSpec)); err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	nomad.DryRun = func(call string, body []byte) { calls = append(calls, call) }
+	defer func() { nomad.DryRun = nil }()
+
+	changed, err := nomad.PlanJob(p.nomad, []byte(testSpec))
+	if err != nil || changed {
+		t.Fatalf("PlanJob() = %v, %v; want the unchanged job planned against nomad", changed, err)
+	}
+	if err := nomad.SubmitJob(p.nomad, []byte(testSpec)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"POST /v1/jobs"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("dry run calls %v; want %v", calls, want)
+	}
+}
+
+func TestInjectionNullMeta(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "inject.json")
+	if err := ioutil.WriteFile(file, []byte(`{"meta": null, "node_meta": null}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inj, err := newInjection(file, nil, []string{"zone=b", "rack=r1", "class=gpu"}, []string{"team=ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inj.Meta["team"] != "ops" {
+		t.Fatalf("job meta %v; want team=ops", inj.Meta)
+	}
+	var targets []string
+	for _, c := range inj.Constraints {
+		targets = append(targets, c.LTarget)
+	}
+	if want := []string{"${meta.class}", "${meta.rack}", "${meta.zone}"}; !reflect.DeepEqual(targets, want) {
+		t.Fatalf("node meta constraints %v; want %v", targets, want)
+	}
+}
+
+func TestArtifactCache(t *testing.T) {
+	body := []byte("installer")
+	sum := fmt.Sprintf("%x", sha256.Sum256(body))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer srv.Close()
+	artifact := func(source string, checksum string) map[string]interface{} {
+		return map[string]interface{}{
+			"GetterSource":  source,
+			"GetterOptions": map[string]interface{}{"checksum": checksum},
+		}
+	}
+	for _, a := range []map[string]interface{}{
+		artifact(srv.URL+"/installer.msi", "sha256:../../etc"),
+		artifact(srv.URL+"/installer.msi", "sha256:"+sum[:10]),
+		artifact(srv.URL+"/", "sha256:"+sum),
+		artifact(srv.URL, "sha256:"+sum),
+	} {
+		if c, err := newCachedArtifact(a); err == nil {
+			t.Fatalf("newCachedArtifact(%v) = %s; want it rejected", a, c.path)
+		}
+	}
+
+	p, _ := newTestProgram(t)
+	p.artifactCache = t.TempDir()
+	c, err := newCachedArtifact(artifact(srv.URL+"/installer.msi", "sha256:"+sum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(p.artifactCache, filepath.FromSlash(c.path))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dest, []byte("truncated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.stageArtifact(c); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := ioutil.ReadFile(dest); string(buf) != string(body) {
+		t.Fatalf("cached artifact %q; want the corrupt copy downloaded again", buf)
+	}
+}
+
+func TestParseNodeMetaReserved(t *testing.T) {
+	for _, key := range []string{versionMeta, publishedMeta, stateMeta, eventMeta, drainOwnerMeta, maintenanceMeta} {
+		if _, err := parseNodeMeta([]string{"rack=r12," + key + "=x"}); err == nil {
+			t.Fatalf("parseNodeMeta() accepted the reserved key %s", key)
+		}
+	}
+	meta, err := parseNodeMeta([]string{"rack=r12,hardware_class=gpu"})
+	if err != nil || len(meta) != 2 {
+		t.Fatalf("parseNodeMeta() = %v, %v", meta, err)
+	}
+}
+
+func TestReconcileRedeployLock(t *testing.T) {
+	p1, n := newTestProgram(t)
+	p2, _ := newTestProgram(t)
+	p2.nomad, p2.consul = p1.nomad, p1.consul
+	for i, p := range []*program{p1, p2} {
+		p.redeploy = redeployAuto
+		p.features = feature.New(feature.Flag{Name: featureAutoRedeploy, Default: true})
+		p.redeployLock = &consul.Semaphore{Client: p1.consul, Prefix: "clarify/redeploy", Slots: 1, Holder: fmt.Sprintf("host-%d", i+1)}
+	}
+	n.SetJob("clarify", "pending")
+
+	if key, err := p1.redeployLock.TryAcquire(); err != nil || len(key) == 0 {
+		t.Fatalf("TryAcquire() = %q, %v", key, err)
+	}
+	p2.reconcileJobSpec()
+	if status := n.Job("clarify"); status != "pending" {
+		t.Fatalf("clarify job %s; resubmitted without the redeploy lock", status)
+	}
+
+	p1.releaseRedeployLock()
+	p2.reconcileJobSpec()
+	if status := n.Job("clarify"); status != "running" {
+		t.Fatalf("clarify job %s; want resubmitted by the lock holder", status)
+	}
+	if held, err := p2.redeployLock.Held(); err != nil || held != nil {
+		t.Fatalf("redeploy lock still held after the resubmit: %v, %v", held, err)
+	}
+}
+
+func TestUpgradeRedeployLock(t *testing.T) {
+	p, n := newTestProgram(t)
+	p.redeploy = redeployAuto
+	p.features = feature.New(feature.Flag{Name: featureAutoRedeploy, Default: true})
+	p.redeployLock = &consul.Semaphore{Client: p.consul, Prefix: "clarify/redeploy", Slots: 1, Holder: testHostname}
+	n.SetJob("clarify", "pending")
+
+	unlock, err := p.lockRedeploys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.reconcileJobSpec()
+	if status := n.Job("clarify"); status != "pending" {
+		t.Fatalf("clarify job %s; resubmitted during the upgrade", status)
+	}
+	other, _ := newTestProgram(t)
+	other.redeployLock = &consul.Semaphore{Client: p.consul, Prefix: "clarify/redeploy", Slots: 1, Holder: "host-2"}
+	if _, err := other.lockRedeploys(); err == nil {
+		t.Fatal("upgrade from another node took the held redeploy lock")
+	}
+	unlock()
+	p.reconcileJobSpec()
+	if status := n.Job("clarify"); status != "running" {
+		t.Fatalf("clarify job %s; want resubmitted after the upgrade", status)
+	}
+}
+
+func TestWatchAllocsRestart(t *testing.T) {
+	p, n := newTestProgram(t)
+	p.allocs = &allocWatch{threshold: 2, action: allocActionRestart, window: time.Hour, seen: make(map[string]bool)}
+	p.features = feature.New(feature.Flag{Name: featureAllocRestart, Default: true})
+	host := &client.Host{ID: testNode, Name: testHostname}
+	n.SetAllocs(testNode,
+		client.Alloc{ID: "a1", JobID: "clarify", ClientStatus: "failed"},
+		client.Alloc{ID: "a2", JobID: "clarify", ClientStatus: "lost"},
+		client.Alloc{ID: "a3", JobID: "clarify", ClientStatus: "running"},
+	)
+	p.watchAllocs(host)
+	var restarted []string
+	for _, r := range n.Requests() {
+		if r.Method == "POST" && strings.HasSuffix(r.Path, "/restart") {
+			restarted = append(restarted, r.Path)
+		}
+	}
+	if want := []string{"/v1/client/allocation/a3/restart"}; !reflect.DeepEqual(restarted, want) {
+		t.Fatalf("restarted %v; want %v", restarted, want)
+	}
+
+	n.SetAllocs(testNode, client.Alloc{ID: "a3", JobID: "clarify", ClientStatus: "running"})
+	p.watchAllocs(host)
+	if len(p.allocs.seen) != 0 {
+		t.Fatalf("seen %v; want the collected allocations forgotten", p.allocs.seen)
+	}
+}
+
+func TestWatchAllocsEvaluate(t *testing.T) {
+	p, n := newTestProgram(t)
+	p.allocs = &allocWatch{threshold: 1, action: allocActionRestart, window: time.Hour, seen: make(map[string]bool)}
+	p.features = feature.New(feature.Flag{Name: featureAllocRestart, Default: true})
+	n.SetAllocs(testNode, client.Alloc{ID: "a1", JobID: "clarify", ClientStatus: "failed"})
+	p.watchAllocs(&client.Host{ID: testNode, Name: testHostname})
+	for _, r := range n.Requests() {
+		if strings.HasSuffix(r.Path, "/restart") {
+			t.Fatalf("restarted the failed allocation (%s)", r.Path)
+		}
+		if r.Path == "/v1/job/clarify/evaluate" {
+			return
+		}
+	}
+	t.Fatal("clarify job not evaluated without a running allocation")
+}
+
+func TestAllocWatchWindow(t *testing.T) {
+	w := &allocWatch{window: time.Minute}
+	start := time.Now()
+	w.record(start)
+	w.record(start.Add(30 * time.Second))
+	if n := w.record(start.Add(80 * time.Second)); n != 2 {
+		t.Fatalf("record() = %d failures within the window; want 2", n)
+	}
+}
+
+func TestApplyReloadableAtomic(t *testing.T) {
+	p, _ := newTestProgram(t)
+	p.specInterval = time.Minute
+	source := map[string]bool{"spec-interval": true, "poll-interval": true, "drain-force": true}
+
+	for _, values := range []map[string]string{
+		{"spec-interval": "30s", "poll-interval": "0s"},
+		{"spec-interval": "30s", "poll-interval": "-1s"},
+		{"spec-interval": "30s", "drain-force": "maybe"},
+	} {
+		if err := p.applyReloadable(values, source); err == nil {
+			t.Fatalf("applyReloadable(%v) succeeded", values)
+		}
+		if p.specInterval != time.Minute || p.pollInterval != 10*time.Millisecond || p.drainSpec.Force {
+			t.Fatalf("applyReloadable(%v) applied part of an invalid config", values)
+		}
+	}
+	if err := p.applyReloadable(map[string]string{"spec-interval": "30s", "poll-interval": "1s"}, source); err != nil {
+		t.Fatal(err)
+	}
+	if p.specInterval != 30*time.Second || p.pollInterval != time.Second {
+		t.Fatalf("intervals %v, %v; want 30s, 1s", p.specInterval, p.pollInterval)
+	}
+}
+
+func TestNomadHTTPS(t *testing.T) {
+	p, n := newTestProgram(t)
+	n.SetJob("clarify", "running")
+	secure := httptest.NewTLSServer(n.Server.Config.Handler)
+	defer secure.Close()
+	scheme, host, port, err := parseAddress(secure.URL, 4646)
+	if err != nil || scheme != "https" {
+		t.Fatalf("parseAddress(%s) = %q, %v; want https", secure.URL, scheme, err)
+	}
+	p.nomad.Address, p.nomad.Port = host, port
+	nomad.Scheme = scheme
+	nomad.SetTLS(secure.Client().Transport.(*http.Transport).TLSClientConfig)
+	defer func() {
+		nomad.Scheme = "http"
+		nomad.SetTLS(nil)
+	}()
+
+	if job, err := p.findJob("clarify"); err != nil || job.Status != "running" {
+		t.Fatalf("findJob() over https = %v, %v", job, err)
+	}
+}
+
+func TestRemoteConfigBaseline(t *testing.T) {
+	if flag.Lookup("spec-interval") == nil {
+		flag.Duration("spec-interval", time.Minute, "")
+	}
+	p, _ := newTestProgram(t)
+	applied := map[string]string{"spec-interval": "5s"}
+	if err := p.applyReloadable(applied, remoteFlags(applied)); err != nil {
+		t.Fatal(err)
+	}
+	restore := withBaseline(map[string]string{}, applied)
+	if want := map[string]string{"spec-interval": "1m0s"}; !reflect.DeepEqual(restore, want) {
+		t.Fatalf("withBaseline() = %v; want %v", restore, want)
+	}
+	if err := p.applyReloadable(restore, remoteFlags(restore)); err != nil {
+		t.Fatal(err)
+	}
+	if p.specInterval != time.Minute {
+		t.Fatalf("spec interval %v once its key was deleted; want the local 1m0s", p.specInterval)
+	}
+}
+
+func TestAdminSocketMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows ignores file modes")
+	}
+	path := filepath.Join(t.TempDir(), "clarify.sock")
+	l, err := listenAdmin(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Fatalf("admin socket mode %v; want 0600", mode)
+	}
+	l.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("admin socket left behind after close: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not a socket"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenAdmin(path); err == nil {
+		t.Fatal("listenAdmin() replaced a file that isn't a socket")
+	}
+	if buf, err := ioutil.ReadFile(path); err != nil || string(buf) != "not a socket" {
+		t.Fatalf("file at the admin socket path changed: %q, %v", buf, err)
+	}
+}
+
+// grpcCall makes a call to the admin api's gRPC service on socket, returning
+// the response messages and the grpc-status trailer
+func grpcCall(t *testing.T, socket string, method string, req []byte) ([][]byte, string) {
+	t.Helper()
+	protocols := &http.Protocols{}
+	protocols.SetUnencryptedHTTP2(true)
+	c := &http.Client{Transport: &http.Transport{
+		Protocols: protocols,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialAdmin(socket)
+		},
+	}}
+	frame := append([]byte{0, 0, 0, 0, byte(len(req))}, req...)
+	httpReq, _ := http.NewRequest(http.MethodPost, "http://clarify/clarify.admin.v1.Admin/"+method, bytes.NewReader(frame))
+	httpReq.Header.Set("Content-Type", "application/grpc")
+	resp, err := c.Do(httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var msgs [][]byte
+	for {
+		var prefix [5]byte
+		if _, err := io.ReadFull(resp.Body, prefix[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		if _, err := io.ReadFull(resp.Body, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+		if method == "Watch" {
+			return msgs, ""
+		}
+	}
+	return msgs, resp.Trailer.Get("Grpc-Status")
+}
+
+func TestAdminGRPC(t *testing.T) {
+	p, n := newTestProgram(t)
+	n.SetJob("clarify", "running")
+	p.admin = filepath.Join(t.TempDir(), "clarify.sock")
+	p.adminAuth = &adminAuth{}
+	p.crashes = &crashloop.Tracker{Path: filepath.Join(t.TempDir(), "clarify.crashloop.json"), Max: 5, Window: time.Minute}
+	go p.serveAdmin()
+	defer close(p.exit)
+	for i := 0; ; i++ {
+		if _, err := os.Stat(p.admin); err == nil {
+			break
+		} else if i == 100 {
+			t.Fatal("admin socket never created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	msgs, status := grpcCall(t, p.admin, "Status", nil)
+	if status != "0" || len(msgs) != 1 {
+		t.Fatalf("Status returned %d messages, grpc-status %q", len(msgs), status)
+	}
+	fields := make(map[int]string)
+	rpc.Decode(msgs[0], func(f rpc.Field) error {
+		fields[f.Number] = f.String()
+		return nil
+	})
+	if fields[1] != testHostname || fields[9] != "running" {
+		t.Fatalf("Status node %q, job status %q; want %s, running", fields[1], fields[9], testHostname)
+	}
+
+	// DrainRequest{deadline: 2m}
+	deadline := &rpc.Encoder{}
+	deadline.Int64(1, 120)
+	req := &rpc.Encoder{}
+	req.Bytes(1, deadline.Message())
+	if _, status := grpcCall(t, p.admin, "Drain", req.Message()); status != "0" {
+		t.Fatalf("Drain grpc-status %q", status)
+	}
+	if spec := n.Drain(testNode); spec == nil || spec.Deadline != 2*time.Minute {
+		t.Fatalf("drain spec %+v; want a 2m deadline", spec)
+	}
+	if _, status := grpcCall(t, p.admin, "Reboot", nil); status != strconv.Itoa(rpc.Unimplemented) {
+		t.Fatalf("unknown method grpc-status %q; want %d", status, rpc.Unimplemented)
+	}
+	if msgs, _ := grpcCall(t, p.admin, "Watch", nil); len(msgs) != 1 {
+		t.Fatal("Watch sent no status event")
+	}
+	if _, err := ctlRequest(p.admin, "status"); err != nil {
+		t.Fatalf("json api on the admin socket: %v", err)
+	}
+}
+
+func TestRebootReadyRetried(t *testing.T) {
+	p, _ := newTestProgram(t)
+	p.maintenance = "clarify/maintenance"
+	p.rebootPrefix = "clarify/reboot"
+	pendingReboot = func() ([]string, error) { return []string{"kernel"}, nil }
+	defer func() { pendingReboot = reboot.Pending }()
+	// An earlier check drained the node for the reboot but failed to
+	// publish its readiness
+	if err := p.consul.Put(p.maintenanceKey(), []byte(maintenanceReboot+" 2026-10-16T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	p.checkReboot()
+	if state, err := p.rebootState(); err != nil || state == nil || state.State != rebootReady {
+		t.Fatalf("reboot state %+v, %v; want %s published again", state, err, rebootReady)
+	}
+}
+
+func TestExitMaintenanceUndrainFailure(t *testing.T) {
+	p, n := newTestProgram(t)
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.AddNode("node-local", hostname)
+	p.hostname = hostname
+	p.maintenance = "clarify/maintenance"
+	if err := p.consul.Put(p.maintenanceKey(), []byte("manual")); err != nil {
+		t.Fatal(err)
+	}
+	p.newNomad = func(server *nomad.Server) nomadAPI {
+		return failingNomad{nomadAPI: newHTTPNomad(server)}
+	}
+	if err := p.exitMaintenance(); err == nil {
+		t.Fatal("exitMaintenance() succeeded although the undrain failed")
+	}
+	if pair, err := p.consul.Get(p.maintenanceKey()); err != nil || pair == nil {
+		t.Fatalf("maintenance flag cleared although the node is still drained: %v, %v", pair, err)
+	}
+}
+
+func TestDrainLockExpires(t *testing.T) {
+	p1, _ := newTestProgram(t)
+	p2, _ := newTestProgram(t)
+	ttl := 100 * time.Millisecond
+	p1.lock = &consul.Semaphore{Client: p1.consul, Prefix: "clarify/drain", Slots: 1, Holder: "host-1", TTL: ttl}
+	p2.lock = &consul.Semaphore{Client: p1.consul, Prefix: "clarify/drain", Slots: 1, Holder: "host-2", TTL: ttl}
+	if err := p1.drain(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go p1.lock.Keep(done, func(err error) { t.Error(err) })
+	time.Sleep(3 * ttl)
+	if key, err := p2.lock.TryAcquire(); err != nil || len(key) != 0 {
+		t.Fatalf("TryAcquire() = %q, %v while the holder renews its slot", key, err)
+	}
+
+	close(done)
+	time.Sleep(2 * ttl)
+	if key, err := p2.lock.TryAcquire(); err != nil || len(key) == 0 {
+		t.Fatalf("TryAcquire() = %q, %v once the holder stopped renewing", key, err)
+	}
+}
+
+func TestStopWhileWaitingForInstall(t *testing.T) {
+	p, n := newTestProgram(t)
+	p.clarify = filepath.Join(t.TempDir(), "missing")
+	p.metricsInterval = time.Second
+	started := make(chan error, 1)
+	go func() {
+		started <- p.Start(nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if state, _ := p.state(); state != stateWaitingForInstall {
+		t.Fatalf("state %s; want %s", state, stateWaitingForInstall)
+	}
+	if err := p.Stop(nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start still pending after Stop")
+	}
+	if state, _ := p.state(); state != stateStopped {
+		t.Fatalf("state %s; want %s", state, stateStopped)
+	}
+	for _, r := range n.Requests() {
+		if r.Method == "POST" {
+			t.Fatalf("Stop sent %s %s before clarify was installed", r.Method, r.Path)
+		}
+	}
+}
+
+func TestInstallAllRejectsServiceName(t *testing.T) {
+	dir := t.TempDir()
+	for _, svc := range bundleServices {
+		if err := os.WriteFile(wrapperPath(dir, svc), nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"-consul-args", "-service-name=my-consul"},
+		{"-shared-args", "-proxy http://proxy:3128 --service-name x"},
+		{"-nomad-args", "-service-prefix other"},
+	} {
+		err := installAll(append([]string{"-dir", dir, "-clarify", dir}, args...), dir)
+		if err == nil || !strings.Contains(err.Error(), "-service-prefix of install-all") {
+			t.Fatalf("installAll %v: %v; want the naming argument rejected", args, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// check is the result of one readiness check
+type check struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// serveHealth exposes /healthz and /readyz on a tcp address for load
+// balancers and monitoring that can't reach the admin socket
+func (p *program) serveHealth() {
+	if len(p.health) == 0 {
+		return
+	}
+	l, err := net.Listen("tcp", p.health)
+	if err != nil {
+		p.logger.Errorf("unable to listen on health address (%s): %v", p.health, err)
+		return
+	}
+	go func() {
+		<-p.exit
+		l.Close()
+	}()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", p.handleHealthz)
+	mux.HandleFunc("/readyz", p.handleReadyz)
+	p.logger.Infof("health endpoints listening (address=%s)", p.health)
+	http.Serve(l, mux)
+}
+
+// handleHealthz reports the process is alive
+func (p *program) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "alive"})
+}
+
+// handleReadyz reports whether nomad is reachable, the node is registered
+// and not draining, and clarify is running on the node
+func (p *program) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := p.readiness()
+	status := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, checks)
+}
+
+func (p *program) readiness() []check {
+	checks := make([]check, 0, 4)
+	add := func(name string, err error) bool {
+		c := check{Name: name, OK: err == nil}
+		if err != nil {
+			c.Error = err.Error()
+		}
+		checks = append(checks, c)
+		return c.OK
+	}
+	_, err := nomad.AgentVersion(p.nomad)
+	if !add("nomad", err) {
+		return checks
+	}
+	host, err := p.hostID(p.hostname)
+	if !add("node", err) {
+		return checks
+	}
+	if host.Drain {
+		err = errors.New("node is draining")
+	}
+	add("drain", err)
+	allocs, err := nomad.NodeAllocations(p.nomad, host.ID)
+	if err == nil {
+		err = errors.New("clarify isn't running on the node")
+		jobID := p.clarifyJob()
+		for _, alloc := range allocs {
+			if alloc.JobID == jobID && alloc.ClientStatus == "running" {
+				err = nil
+				break
+			}
+		}
+	}
+	add("job", err)
+	return checks
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/fleet"
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+var heartbeatClient = &http.Client{Timeout: 10 * time.Second}
+
+// setHeartbeatTLS presents the client certificate to a fleet controller
+// serving mutual TLS. The certificate must be valid for the node's hostname.
+func setHeartbeatTLS(cert string, key string, ca string) error {
+	if len(cert) == 0 && len(key) == 0 && len(ca) == 0 {
+		return nil
+	}
+	if len(cert) == 0 || len(key) == 0 || len(ca) == 0 {
+		return errors.New("-heartbeat-tls-cert, -heartbeat-tls-key and -heartbeat-tls-ca must be given together")
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return fmt.Errorf("unable to load heartbeat tls certificate: %v", err)
+	}
+	pem, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return fmt.Errorf("unable to read heartbeat tls ca: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", ca)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{pair}, RootCAs: pool, MinVersion: tls.VersionTLS12}
+	heartbeatClient.Transport = transport
+	return nil
+}
+
+// publishHeartbeats posts the node's status to the heartbeat url every
+// interval until the program exits
+func (p *program) publishHeartbeats() {
+	if len(p.heartbeatURL) == 0 {
+		return
+	}
+	ticker := time.NewTicker(p.duration(&p.heartbeatInterval))
+	defer ticker.Stop()
+	for {
+		if err := p.sendHeartbeat(); err != nil {
+			p.logger.Warningf("error sending heartbeat: %v", err)
+		}
+		select {
+		case <-ticker.C:
+			ticker.Reset(p.duration(&p.heartbeatInterval))
+		case <-p.exit:
+			return
+		}
+	}
+}
+
+func (p *program) status() *fleet.Heartbeat {
+	hb := &fleet.Heartbeat{
+		Node:      p.hostname,
+		Service:   p.name,
+		Version:   version,
+		Features:  p.features.States(),
+		JobStatus: "missing",
+		Admin:     p.adminURL(),
+		Time:      time.Now().UTC(),
+	}
+	state, since := p.state()
+	hb.State, hb.StateSince = string(state), since
+	if host, err := p.hostID(p.hostname); err == nil {
+		hb.NodeID = host.ID
+		hb.Drain = host.Drain
+		if node, err := nomad.GetNode(p.nomad, host.ID); err == nil {
+			hb.LameDuck = node.SchedulingEligibility == "ineligible" && !node.Drain
+		}
+	}
+	if job, err := p.findJob(p.clarifyJob()); err == nil {
+		hb.JobStatus = job.Status
+	}
+	hb.Jobs = p.jobStatuses()
+	p.mu.RLock()
+	hb.Registration = p.registration
+	hb.Placement = p.placement
+	p.mu.RUnlock()
+	if state, err := p.crashes.Load(); err == nil && state.Quarantined {
+		hb.Quarantined = state.Reason
+	}
+	hb.NomadVersion, _ = nomad.AgentVersion(p.nomad)
+	hb.ConsulVersion, _ = p.consul.AgentVersion()
+	return hb
+}
+
+func (p *program) sendHeartbeat() error {
+	body, err := json.Marshal(p.status())
+	if err != nil {
+		return err
+	}
+	resp, err := heartbeatClient.Post(p.heartbeatURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("http status: %v", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/redact"
+)
+
+// Lifecycle events hooks run on
+const (
+	hookPostStart = "post-start"
+	hookPreStop   = "pre-stop"
+)
+
+// runHook runs the script configured for event with the event described in
+// its environment, killing it once the hook timeout elapses. Hook failures
+// are logged but don't stop the lifecycle transition.
+func (p *program) runHook(event string, detail string) {
+	script := p.hooks[event]
+	if len(script) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Env = append(os.Environ(),
+		"CLARIFY_EVENT="+event,
+		"CLARIFY_DETAIL="+detail,
+		"CLARIFY_SERVICE="+p.name,
+		"CLARIFY_NODE="+p.hostname,
+		"CLARIFY_INSTALL="+p.clarify,
+		"CLARIFY_NOMAD="+p.nomad.Address,
+		"CLARIFY_TIME="+time.Now().UTC().Format(time.RFC3339),
+	)
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = ctx.Err()
+	}
+	output := redact.String(strings.TrimSpace(string(out)))
+	p.audit.Record("hook "+event, p.initiator, err, script)
+	if err != nil {
+		p.logger.Warningf("%s hook failed after %v (script=%s): %v %s", event, time.Since(start), script, err, output)
+		return
+	}
+	p.logger.Infof("%s hook completed in %v (script=%s) %s", event, time.Since(start), script, output)
+}
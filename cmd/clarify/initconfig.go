@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pgombola/clarify-svc/internal/agentconfig"
+)
+
+// initConfig renders the consul and nomad agent configs for this node
+func initConfig(args []string, wd string) error {
+	fs := flag.NewFlagSet("init-config", flag.ExitOnError)
+	cfg := &agentconfig.Values{}
+	fs.StringVar(&cfg.Bind, "bind", "", "Address the agents bind to (defaults to the first non-loopback IPv4 address).")
+	datacenter := fs.String("datacenter", "dc1", "Datacenter of the agents.")
+	nodeClass := fs.String("node-class", "", "Scheduling class of the nomad client.")
+	join := fs.String("retry-join", "", "Comma separated addresses of the servers to join.")
+	fs.BoolVar(&cfg.Server, "server", false, "Runs the agents in server mode.")
+	fs.IntVar(&cfg.BootstrapExpect, "bootstrap-expect", 3, "Number of servers expected when running in server mode.")
+	consulDir := fs.String("consul-dir", wd, "Directory of the consul wrapper.")
+	nomadDir := fs.String("nomad-dir", wd, "Directory of the nomad wrapper.")
+	consulCfg := fs.String("consul-config", "config.json", "Name of the generated consul configuration.")
+	nomadCfg := fs.String("nomad-config", "config.hcl", "Name of the generated nomad configuration.")
+	rawExec := fs.Bool("raw-exec", true, "Enables the raw_exec driver the clarify job runs with.")
+	dockerPrivileged := fs.Bool("docker-privileged", false, "Allows docker tasks to run privileged containers.")
+	disableDrivers := fs.String("disable-drivers", "", "Comma separated task drivers the nomad client disables, e.g. java,qemu.")
+	force := fs.Bool("force", false, "Overwrites existing configuration files.")
+	fs.Parse(args)
+
+	if err := cfg.SetDrivers(*rawExec, *dockerPrivileged, *disableDrivers); err != nil {
+		return err
+	}
+	if err := cfg.SetScheduling(*datacenter, *nodeClass); err != nil {
+		return err
+	}
+
+	if len(cfg.Bind) == 0 {
+		bind, err := agentconfig.BindAddress()
+		if err != nil {
+			return err
+		}
+		cfg.Bind = bind
+	}
+	cfg.RetryJoin = splitList(*join)
+	cfg.ConsulData = filepath.ToSlash(filepath.Join(*consulDir, "consul-data"))
+	cfg.NomadData = filepath.ToSlash(filepath.Join(*nomadDir, "data"))
+
+	for _, c := range []struct {
+		tmpl *template.Template
+		path string
+	}{
+		{agentconfig.Consul, filepath.Join(*consulDir, *consulCfg)},
+		{agentconfig.Nomad, filepath.Join(*nomadDir, *nomadCfg)},
+	} {
+		if err := agentconfig.Render(c.tmpl, cfg, c.path, *force); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", c.path)
+	}
+	return nil
+}
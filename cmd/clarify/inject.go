@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// stringList is a flag that may be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// constraint is a nomad job constraint
+type constraint struct {
+	LTarget string `json:"LTarget"`
+	Operand string `json:"Operand"`
+	RTarget string `json:"RTarget"`
+}
+
+// injection is added to the job specification at submit time so it doesn't
+// need editing per environment
+type injection struct {
+	Constraints []constraint      `json:"constraints"`
+	NodeMeta    map[string]string `json:"node_meta"`
+	Meta        map[string]string `json:"meta"`
+}
+
+// newInjection reads the injection file, if any, and adds the values given
+// with -constraint, -node-meta and -job-meta
+func newInjection(file string, constraints []string, nodeMeta []string, meta []string) (*injection, error) {
+	inj := &injection{NodeMeta: make(map[string]string), Meta: make(map[string]string)}
+	if len(file) != 0 {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(buf, inj); err != nil {
+			return nil, fmt.Errorf("invalid job injection file: %v", err)
+		}
+		// "meta": null in the file leaves the maps nil
+		if inj.NodeMeta == nil {
+			inj.NodeMeta = make(map[string]string)
+		}
+		if inj.Meta == nil {
+			inj.Meta = make(map[string]string)
+		}
+	}
+	for _, c := range constraints {
+		fields := strings.Fields(c)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid constraint %q; expected \"<attribute> <operator> [value]\"", c)
+		}
+		inj.Constraints = append(inj.Constraints, constraint{
+			LTarget: fields[0],
+			Operand: fields[1],
+			RTarget: strings.Join(fields[2:], " "),
+		})
+	}
+	for _, values := range []struct {
+		flags []string
+		dst   map[string]string
+	}{{nodeMeta, inj.NodeMeta}, {meta, inj.Meta}} {
+		for _, kv := range values.flags {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid meta %q; expected key=value", kv)
+			}
+			values.dst[parts[0]] = parts[1]
+		}
+	}
+	// Sorted so the submitted job specification doesn't change between runs
+	keys := make([]string, 0, len(inj.NodeMeta))
+	for key := range inj.NodeMeta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		inj.Constraints = append(inj.Constraints, constraint{
+			LTarget: "${meta." + key + "}",
+			Operand: "=",
+			RTarget: inj.NodeMeta[key],
+		})
+	}
+	return inj, nil
+}
+
+// apply adds the constraints and meta to the job specification
+func (inj *injection) apply(spec []byte) ([]byte, error) {
+	if inj == nil || (len(inj.Constraints) == 0 && len(inj.Meta) == 0) {
+		return spec, nil
+	}
+	var wrapped map[string]interface{}
+	if err := json.Unmarshal(spec, &wrapped); err != nil {
+		return nil, err
+	}
+	job, ok := wrapped["Job"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("job specification has no Job object")
+	}
+	constraints, _ := job["Constraints"].([]interface{})
+	for _, c := range inj.Constraints {
+		constraints = append(constraints, c)
+	}
+	if len(constraints) != 0 {
+		job["Constraints"] = constraints
+	}
+	if len(inj.Meta) != 0 {
+		meta, _ := job["Meta"].(map[string]interface{})
+		if meta == nil {
+			meta = make(map[string]interface{})
+		}
+		for key, value := range inj.Meta {
+			meta[key] = value
+		}
+		job["Meta"] = meta
+	}
+	return json.Marshal(wrapped)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// installSentinel is the file installers create once extraction finished
+const installSentinel = ".complete"
+
+// verifyInstall checks the clarify install directory is completely extracted.
+// When the -install-manifest file (sha256sum format) is present every file it
+// lists must match its checksum, otherwise the .complete sentinel must exist.
+// Without either the directory existing is enough unless -install-strict.
+func (p *program) verifyInstall() error {
+	if _, err := os.Stat(p.clarify); err != nil {
+		return err
+	}
+	if len(p.installManifest) != 0 {
+		manifest := filepath.Join(p.clarify, p.installManifest)
+		if _, err := os.Stat(manifest); err == nil {
+			return verifyManifest(p.clarify, manifest)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(p.clarify, installSentinel)); err == nil {
+		return nil
+	}
+	if p.installStrict {
+		return fmt.Errorf("%s has neither %s nor %s", p.clarify, p.installManifest, installSentinel)
+	}
+	return nil
+}
+
+// verifyManifest checks the checksums of the files listed in manifest,
+// relative to dir
+func verifyManifest(dir string, manifest string) error {
+	f, err := os.Open(manifest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	files := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid manifest line %q", line)
+		}
+		name := strings.TrimPrefix(strings.TrimSpace(fields[1]), "*")
+		sum, err := fileSum(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(sum, fields[0]) {
+			return fmt.Errorf("checksum mismatch (file=%s)", name)
+		}
+		files++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if files == 0 {
+		return errors.New("install manifest lists no files")
+	}
+	return nil
+}
+
+func fileSum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
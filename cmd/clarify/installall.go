@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/scm"
+)
+
+// bundleServiceName returns the default service name the wrapper svc
+// registers under with prefix
+func bundleServiceName(svc string, prefix string) string {
+	switch svc {
+	case "consulsvc":
+		return prefix + "-consul"
+	case "nomadsvc":
+		return prefix + "-nomad"
+	}
+	return prefix
+}
+
+// installAll installs and starts the consul, nomad and clarify services of
+// -dir in dependency order with shared configuration. Services already
+// installed are left registered as they are, so provisioning can rerun it.
+func installAll(args []string, wd string) error {
+	fs := flag.NewFlagSet("install-all", flag.ExitOnError)
+	dir := fs.String("dir", wd, "Directory the wrappers, agents and configs are installed in.")
+	clarify := fs.String("clarify", "", "Clarify install directory.")
+	prefix := fs.String("service-prefix", "clarify", "Prefix of the registered service names.")
+	shared := fs.String("shared-args", "", "Space separated arguments every service is installed with, such as -proxy.")
+	extra := map[string]*string{
+		"consulsvc":  fs.String("consul-args", "", "Space separated arguments the consul service is also installed with."),
+		"nomadsvc":   fs.String("nomad-args", "", "Space separated arguments the nomad service is also installed with."),
+		"clarifysvc": fs.String("clarify-args", "", "Space separated arguments the clarify service is also installed with."),
+	}
+	start := fs.Bool("start", true, "Starts each service once installed, before installing the next.")
+	fs.Parse(args)
+
+	if len(*clarify) == 0 {
+		return errors.New("install-all needs -clarify")
+	}
+	if arg := namingArg(strings.Fields(*shared)); len(arg) > 0 {
+		return fmt.Errorf("%s names the services; use -service-prefix of install-all instead", arg)
+	}
+	for svc, args := range extra {
+		if arg := namingArg(strings.Fields(*args)); len(arg) > 0 {
+			return fmt.Errorf("%s names the %s service; use -service-prefix of install-all instead", arg, svc)
+		}
+	}
+	for _, svc := range bundleServices {
+		if _, err := os.Stat(wrapperPath(*dir, svc)); err != nil {
+			return fmt.Errorf("%s has no %s: %v", *dir, svc, err)
+		}
+	}
+	for _, svc := range bundleServices {
+		name := bundleServiceName(svc, *prefix)
+		installed, err := scm.Installed(name)
+		if err != nil {
+			return fmt.Errorf("unable to check the %s service is installed: %v", name, err)
+		}
+		if installed {
+			fmt.Printf("%s already installed\n", name)
+		} else {
+			svcArgs := append(strings.Fields(*shared), strings.Fields(*extra[svc])...)
+			if err := registerService(*dir, svc, *prefix, *clarify, svcArgs); err != nil {
+				return err
+			}
+		}
+		if !*start {
+			continue
+		}
+		if err := runWrapper(*dir, svc, []string{"-control", "start", "-service-prefix", *prefix}); err != nil {
+			return fmt.Errorf("unable to start %s: %v", name, err)
+		}
+		fmt.Printf("started %s\n", name)
+	}
+	return nil
+}
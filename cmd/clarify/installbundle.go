@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/airgap"
+)
+
+// bundleJobDir is the bundle directory written to the clarify install
+// directory, holding the job specification
+const bundleJobDir = "job/"
+
+// bundleServices are the wrappers install-bundle registers, in dependency
+// order
+var bundleServices = []string{"consulsvc", "nomadsvc", "clarifysvc"}
+
+// installBundle verifies a signed bundle, lays it out and registers the
+// services. Bundle entries beneath job/ go to the clarify install
+// directory, the others to -dir, so a bundle looks like
+//
+//	consulsvc  consul  config.json
+//	nomadsvc   nomad   config.hcl
+//	clarifysvc job/launch_clarify.json
+func installBundle(args []string, wd string) error {
+	fs := flag.NewFlagSet("install-bundle", flag.ExitOnError)
+	file := fs.String("bundle", "", "Signed bundle (.tar, .tar.gz, .tgz or .zip) to install.")
+	sigFile := fs.String("signature", "", "Ed25519 signature of the bundle (defaults to <bundle>.sig).")
+	keyFile := fs.String("public-key", "", "PEM or base64 ed25519 public key the bundle is signed with.")
+	dir := fs.String("dir", wd, "Directory the wrappers, agents and configs are installed to.")
+	clarify := fs.String("clarify", "", "Clarify install directory the job specification is written to.")
+	prefix := fs.String("service-prefix", "clarify", "Prefix of the registered service names.")
+	extra := map[string]*string{
+		"consulsvc":  fs.String("consul-args", "", "Space separated arguments the consul service is also installed with."),
+		"nomadsvc":   fs.String("nomad-args", "", "Space separated arguments the nomad service is also installed with."),
+		"clarifysvc": fs.String("clarify-args", "", "Space separated arguments the clarify service is also installed with."),
+	}
+	register := fs.Bool("register", true, "Registers the services once the bundle is laid out.")
+	fs.Parse(args)
+
+	if len(*file) == 0 || len(*keyFile) == 0 || len(*clarify) == 0 {
+		return errors.New("install-bundle needs -bundle, -public-key and -clarify")
+	}
+	for svc, args := range extra {
+		if arg := namingArg(strings.Fields(*args)); len(arg) > 0 {
+			return fmt.Errorf("%s names the %s service; use -service-prefix of install-bundle instead", arg, svc)
+		}
+	}
+	if len(*sigFile) == 0 {
+		*sigFile = *file + ".sig"
+	}
+	key, err := airgap.ReadKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	sig, err := airgap.ReadSignature(*sigFile)
+	if err != nil {
+		return err
+	}
+	if err := airgap.Verify(*file, sig, key); err != nil {
+		return err
+	}
+	fmt.Printf("verified %s\n", *file)
+
+	written, err := airgap.Extract(*file, func(name string) string {
+		if strings.HasPrefix(name, bundleJobDir) {
+			return filepath.Join(*clarify, filepath.FromSlash(strings.TrimPrefix(name, bundleJobDir)))
+		}
+		return filepath.Join(*dir, filepath.FromSlash(name))
+	})
+	for _, path := range written {
+		fmt.Printf("wrote %s\n", path)
+	}
+	if err != nil {
+		return err
+	}
+	if !*register {
+		return nil
+	}
+
+	for _, svc := range bundleServices {
+		if _, err := os.Stat(wrapperPath(*dir, svc)); err != nil {
+			return fmt.Errorf("bundle has no %s: %v", svc, err)
+		}
+	}
+	for _, svc := range bundleServices {
+		if err := registerService(*dir, svc, *prefix, *clarify, strings.Fields(*extra[svc])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wrapperPath returns the path of the wrapper executable svc in dir
+func wrapperPath(dir string, svc string) string {
+	if runtime.GOOS == "windows" {
+		svc += ".exe"
+	}
+	return filepath.Join(dir, svc)
+}
+
+// registerService installs the wrapper svc of dir as a service with the
+// extra arguments
+func registerService(dir string, svc string, prefix string, clarify string, extra []string) error {
+	args := []string{"-control", "install", "-service-prefix", prefix}
+	if svc == "clarifysvc" {
+		args = append(args, "-clarify", clarify)
+	}
+	args = append(args, extra...)
+	if err := runWrapper(dir, svc, args); err != nil {
+		return fmt.Errorf("unable to register %s: %v", svc, err)
+	}
+	fmt.Printf("registered %s\n", svc)
+	return nil
+}
+
+// namingArg returns the first of args naming the service, which the bundle
+// commands set from -service-prefix so they can start the services and chain
+// their dependencies
+func namingArg(args []string) string {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if name == "service-name" || name == "service-prefix" {
+			return arg
+		}
+	}
+	return ""
+}
+
+// runWrapper runs the wrapper svc of dir with args
+func runWrapper(dir string, svc string, args []string) error {
+	cmd := exec.Command(wrapperPath(dir, svc), args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/errs"
+	"github.com/pgombola/clarify-svc/internal/eventid"
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// supervisedJob is a job kept running next to clarify, e.g. a clarify-ingest
+// system job. Unlike clarify, losing it relaunches it instead of stopping the
+// service.
+type supervisedJob struct {
+	name string
+	// spec is the job specification file in the clarify install directory
+	spec string
+	// running is the minimum number of running allocations expected
+	running int
+	healthy bool
+}
+
+// parseJob parses a -job value of the form name=spec[,running=N]
+func parseJob(value string) (*supervisedJob, error) {
+	parts := strings.Split(value, ",")
+	kv := strings.SplitN(parts[0], "=", 2)
+	if len(kv) != 2 || len(kv[0]) == 0 || len(kv[1]) == 0 {
+		return nil, fmt.Errorf("invalid job %q; expected name=spec[,running=N]", value)
+	}
+	if kv[0] == "clarify" {
+		return nil, fmt.Errorf("invalid job %q; clarify is always supervised", value)
+	}
+	job := &supervisedJob{name: kv[0], spec: kv[1], healthy: true}
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[0] != "running" {
+			return nil, fmt.Errorf("invalid job option %q; expected running=N", opt)
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid job option %q; expected running=N", opt)
+		}
+		job.running = n
+	}
+	return job, nil
+}
+
+// launchJob submits the job's specification with the configured constraints
+// and meta injected
+func (p *program) launchJob(job *supervisedJob) error {
+	op := p.begin("submit_job")
+	spec, err := ioutil.ReadFile(filepath.Join(p.clarify, job.spec))
+	if err == nil {
+		spec, err = p.inject.apply(spec)
+	}
+	if err == nil {
+		err = p.checkDrivers(op.nomad, spec)
+	}
+	if err == nil {
+		err = p.newNomad(op.nomad).SubmitJob(spec)
+	}
+	op.end(err)
+	p.audit.Record("submit_job", p.initiator, err, job.spec)
+	if err != nil {
+		return errs.Wrap(errs.ErrJobSubmitFailed, err)
+	}
+	eventid.Info(op.logger, eventid.JobLaunched, "job submitted (name=%s;spec=%s)", job.name, job.spec)
+	p.publish("job_submitted", job.name)
+	return nil
+}
+
+// superviseJobs launches supervised jobs and clarify variants missing from
+// nomad and checks the others have their expected running allocations
+func (p *program) superviseJobs() {
+	p.superviseVariants()
+	for _, job := range p.jobs {
+		_, err := p.findJob(job.name)
+		if err == errNomadTimeout {
+			p.logger.Warning(err)
+			continue
+		} else if err != nil {
+			eventid.Warning(p.logger, eventid.JobLost, "job not found; launching it (name=%s;spec=%s)", job.name, job.spec)
+			p.publish("job_lost", job.name)
+			if err := p.launchJob(job); err != nil {
+				eventid.Error(p.logger, eventid.JobLaunchFailed, "error launching job (name=%s): %v", job.name, err)
+			}
+			continue
+		}
+		p.checkJobHealth(job)
+	}
+}
+
+// checkJobHealth alerts when a job runs fewer allocations than expected and
+// again once it recovers
+func (p *program) checkJobHealth(job *supervisedJob) {
+	if job.running == 0 {
+		return
+	}
+	allocs, err := nomad.JobAllocations(p.nomad, job.name)
+	if err != nil {
+		p.logger.Warningf("error retrieving job allocations (name=%s): %v", job.name, err)
+		return
+	}
+	running := 0
+	for _, a := range allocs {
+		if a.ClientStatus == "running" {
+			running++
+		}
+	}
+	healthy := running >= job.running
+	if healthy == job.healthy {
+		return
+	}
+	job.healthy = healthy
+	if healthy {
+		eventid.Info(p.logger, eventid.JobHealthy, "job healthy (name=%s;running=%d)", job.name, running)
+		p.publish("job_healthy", job.name)
+		return
+	}
+	msg := fmt.Sprintf("job %s has %d of %d expected running allocations", job.name, running, job.running)
+	eventid.Warning(p.logger, eventid.JobUnhealthy, "%s", msg)
+	p.publish("job_unhealthy", job.name)
+	if err := p.notifier.Notify("job_unhealthy", msg); err != nil {
+		p.logger.Warningf("error sending notification: %v", err)
+	}
+}
+
+// jobStatuses returns the status of the supervised jobs and the other
+// datacenters' clarify variants by name
+func (p *program) jobStatuses() map[string]string {
+	if len(p.jobs) == 0 && len(p.variants) == 0 {
+		return nil
+	}
+	statuses := p.variantStatuses()
+	for _, job := range p.jobs {
+		statuses[job.name] = "missing"
+		if j, err := p.findJob(job.name); err == nil {
+			statuses[job.name] = j.Status
+		}
+	}
+	return statuses
+}
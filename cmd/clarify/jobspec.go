@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// kvScheme prefixes a -launch value read from the consul kv store
+const kvScheme = "kv://"
+
+func (p *program) launchKey() string {
+	return kvLaunchKey(p.launchSpec())
+}
+
+// kvLaunchKey returns the consul key of a kv:// launch value, or an empty
+// key for other sources
+func kvLaunchKey(launch string) string {
+	if !strings.HasPrefix(launch, kvScheme) {
+		return ""
+	}
+	return strings.TrimPrefix(launch, kvScheme)
+}
+
+// jobSpec returns the clarify job specification with the configured
+// constraints and meta injected
+func (p *program) jobSpec() ([]byte, error) {
+	spec, err := p.readJobSpec()
+	if err != nil {
+		return nil, err
+	}
+	return p.prepareSpec(spec)
+}
+
+// stagedJobSpec returns the job specification like jobSpec, once its
+// artifacts are pre-staged for submission
+func (p *program) stagedJobSpec() ([]byte, error) {
+	spec, err := p.readJobSpec()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.prestageArtifacts(spec); err != nil {
+		return nil, err
+	}
+	return p.prepareSpec(spec)
+}
+
+// prepareSpec applies the job injection, points the artifacts at the mirror
+// and, with -job-datacenters, qualifies as this node's variant a job
+// specification about to be submitted
+func (p *program) prepareSpec(spec []byte) ([]byte, error) {
+	spec, err := p.inject.apply(spec)
+	if err != nil {
+		return nil, err
+	}
+	if spec, err = p.mirrorArtifacts(spec); err != nil {
+		return nil, err
+	}
+	v, err := p.localVariant()
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		return v.qualify(spec)
+	}
+	return spec, nil
+}
+
+// readJobSpec returns the clarify job specification from the consul kv
+// store, a remote url or the clarify install directory
+func (p *program) readJobSpec() ([]byte, error) {
+	launch := p.launchSpec()
+	if strings.HasPrefix(launch, "http://") || strings.HasPrefix(launch, "https://") {
+		return p.fetchJobSpec()
+	}
+	key := p.launchKey()
+	if len(key) == 0 {
+		return ioutil.ReadFile(filepath.Join(p.clarify, launch))
+	}
+	pair, err := p.consul.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, errors.New("job specification not found in consul (key=" + key + ")")
+	}
+	return pair.Value, nil
+}
+
+// Redeploy policies applied when the job specification differs from the
+// running job
+const (
+	redeployAuto   = "auto"
+	redeployNotify = "notify"
+	redeployManual = "manual"
+)
+
+func validRedeployPolicy(policy string) error {
+	switch policy {
+	case redeployAuto, redeployNotify, redeployManual:
+		return nil
+	}
+	return fmt.Errorf("invalid redeploy policy %q; expected %s, %s or %s", policy, redeployAuto, redeployNotify, redeployManual)
+}
+
+// watchJobSpec compares the job specification with the running clarify job
+// whenever it changes in the consul kv store, or every interval for file and
+// url sources
+func (p *program) watchJobSpec() {
+	key := p.launchKey()
+	if len(key) == 0 {
+		ticker := time.NewTicker(p.duration(&p.specInterval))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ticker.Reset(p.duration(&p.specInterval))
+				p.reconcileJobSpec()
+			case <-p.exit:
+				return
+			}
+		}
+	}
+	var index, modified uint64
+	for {
+		select {
+		case <-p.exit:
+			return
+		default:
+		}
+		pair, next, err := p.consul.Watch(key, index)
+		if err != nil {
+			p.logger.Warningf("error watching job specification: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		index = next
+		if pair == nil || pair.ModifyIndex == modified {
+			continue
+		}
+		if modified != 0 {
+			p.logger.Infof("job specification changed (key=%s)", key)
+			p.reconcileJobSpec()
+		}
+		modified = pair.ModifyIndex
+	}
+}
+
+// reconcileJobSpec plans the job specification against the running job and
+// applies the redeploy policy when they differ
+func (p *program) reconcileJobSpec() {
+	spec, err := p.jobSpec()
+	if err != nil {
+		p.logger.Warningf("error reading job specification: %v", err)
+		return
+	}
+	changed, err := nomad.PlanJob(p.nomad, spec)
+	if err != nil {
+		p.logger.Warningf("error planning job specification: %v", err)
+		return
+	}
+	if !changed {
+		return
+	}
+	policy := p.redeploy
+	if policy == redeployAuto && !p.features.Enabled(featureAutoRedeploy) {
+		p.logger.Infof("feature %s is off; notifying instead of redeploying", featureAutoRedeploy)
+		policy = redeployNotify
+	}
+	switch policy {
+	case redeployAuto:
+		// every node watches the same specification, so only the holder
+		// of the redeploy lock resubmits; the others plan no change after
+		key, err := p.redeployLock.TryAcquire()
+		if err != nil {
+			p.logger.Warningf("error acquiring redeploy lock: %v", err)
+			return
+		}
+		if len(key) == 0 {
+			p.logger.Info("job specification differs from running job; another node holds the redeploy lock")
+			return
+		}
+		defer p.releaseRedeployLock()
+		p.logger.Infof("job specification differs from running job; resubmitting clarify (lock=%s)", key)
+		if _, err := p.launchClarify(); err != nil {
+			p.logger.Error(err)
+			return
+		}
+		p.crash.Go(p.watchDeployment)
+	case redeployNotify:
+		p.logger.Warning("job specification differs from running job")
+		if err := p.notifier.Notify("job_spec_changed", "clarify job specification differs from the running job"); err != nil {
+			p.logger.Warningf("error sending notification: %v", err)
+		}
+	default:
+		p.logger.Info("job specification differs from running job; resubmit manually to apply")
+	}
+}
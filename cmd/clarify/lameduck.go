@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// lameDuck marks the node ineligible for new allocations while the running
+// ones finish on their own, without the forced migration of a drain.
+// Disabling it makes the node eligible again.
+func (p *program) lameDuck(enabled bool) (err error) {
+	op := p.begin("lame_duck")
+	defer func() {
+		op.end(err)
+	}()
+	node, err := p.hostID(p.hostname)
+	if err != nil {
+		return err
+	}
+	err = nomad.SetEligibility(op.nomad, node.ID, !enabled)
+	detail := node.Name + " on"
+	if !enabled {
+		detail = node.Name + " off"
+	}
+	p.audit.Record("lame_duck", p.initiator, err, detail)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		op.logger.Infof("lame-duck enabled; node ineligible for new allocations (name=%s;id=%s)", node.Name, node.ID)
+		p.publish("lame_duck", node.ID)
+	} else {
+		op.logger.Infof("lame-duck disabled; node eligible for new allocations (name=%s;id=%s)", node.Name, node.ID)
+		p.publish("lame_duck_off", node.ID)
+	}
+	return nil
+}
+
+// handleLameDuck enables lame-duck mode, or disables it with off=true
+func (p *program) handleLameDuck(w http.ResponseWriter, r *http.Request) {
+	off := false
+	if v := r.URL.Query().Get("off"); len(v) != 0 {
+		var err error
+		if off, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	p.handleAction("lame-duck", func() error {
+		return p.lameDuck(!off)
+	})(w, r)
+}
+
+// lameDuckQuery parses the lame-duck command's flags into the admin api query
+func lameDuckQuery(args []string) url.Values {
+	fs := flag.NewFlagSet("lame-duck", flag.ExitOnError)
+	off := fs.Bool("off", false, "Makes the node eligible for new allocations again.")
+	fs.Parse(args)
+	query := url.Values{}
+	if *off {
+		query.Set("off", "true")
+	}
+	return query
+}
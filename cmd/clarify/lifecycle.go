@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// lifecycleState is a stage of the program lifecycle
+type lifecycleState string
+
+const (
+	stateWaitingForInstall lifecycleState = "waiting_for_install"
+	stateWaitingForNomad   lifecycleState = "waiting_for_nomad"
+	stateLaunching         lifecycleState = "launching"
+	stateRunning           lifecycleState = "running"
+	stateDraining          lifecycleState = "draining"
+	stateStopped           lifecycleState = "stopped"
+)
+
+// transitions are the states each state may move to. Stopping the service
+// drains or stops from any state.
+var transitions = map[lifecycleState][]lifecycleState{
+	"":                     {stateWaitingForInstall},
+	stateWaitingForInstall: {stateWaitingForNomad, stateDraining, stateStopped},
+	stateWaitingForNomad:   {stateLaunching, stateRunning, stateDraining, stateStopped},
+	stateLaunching:         {stateRunning, stateDraining, stateStopped},
+	stateRunning:           {stateDraining, stateStopped},
+	stateDraining:          {stateStopped},
+	stateStopped:           {},
+}
+
+// lifecycle is the current state of the program
+type lifecycle struct {
+	mu    sync.Mutex
+	state lifecycleState
+	since time.Time
+}
+
+// transition moves the program to state to, logging and publishing why.
+// Moving to the current state does nothing. Returns an error, leaving the
+// state unchanged, if the lifecycle doesn't allow the move.
+func (p *program) transition(to lifecycleState, reason string) error {
+	p.lifecycle.mu.Lock()
+	from := p.lifecycle.state
+	if from == to {
+		p.lifecycle.mu.Unlock()
+		return nil
+	}
+	allowed := false
+	for _, s := range transitions[from] {
+		allowed = allowed || s == to
+	}
+	if !allowed {
+		p.lifecycle.mu.Unlock()
+		return fmt.Errorf("invalid state transition (from=%s;to=%s)", from, to)
+	}
+	p.lifecycle.state = to
+	p.lifecycle.since = time.Now().UTC()
+	p.lifecycle.mu.Unlock()
+	p.logger.Infof("state changed (from=%s;to=%s;reason=%s)", from, to, reason)
+	p.publish("state_"+string(to), reason)
+	p.signalReady(to)
+	return nil
+}
+
+// state returns the current state and when it was entered
+func (p *program) state() (lifecycleState, time.Time) {
+	p.lifecycle.mu.Lock()
+	defer p.lifecycle.mu.Unlock()
+	return p.lifecycle.state, p.lifecycle.since
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/eventid"
+)
+
+// Log levels of -log-level
+const (
+	levelInfo int32 = iota
+	levelWarning
+	levelError
+)
+
+func parseLogLevel(level string) (int32, error) {
+	switch level {
+	case "info":
+		return levelInfo, nil
+	case "warning":
+		return levelWarning, nil
+	case "error":
+		return levelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q; expected info, warning or error", level)
+}
+
+// levelLogger drops messages below the configured level, which may change
+// while the service runs
+type levelLogger struct {
+	service.Logger
+	level int32
+}
+
+func (l *levelLogger) setLevel(level int32) {
+	atomic.StoreInt32(&l.level, level)
+}
+
+func (l *levelLogger) enabled(level int32) bool {
+	return level >= atomic.LoadInt32(&l.level)
+}
+
+func (l *levelLogger) Warning(v ...interface{}) error {
+	if !l.enabled(levelWarning) {
+		return nil
+	}
+	return l.Logger.Warning(v...)
+}
+
+func (l *levelLogger) Info(v ...interface{}) error {
+	if !l.enabled(levelInfo) {
+		return nil
+	}
+	return l.Logger.Info(v...)
+}
+
+func (l *levelLogger) Warningf(format string, a ...interface{}) error {
+	if !l.enabled(levelWarning) {
+		return nil
+	}
+	return l.Logger.Warningf(format, a...)
+}
+
+func (l *levelLogger) Infof(format string, a ...interface{}) error {
+	if !l.enabled(levelInfo) {
+		return nil
+	}
+	return l.Logger.Infof(format, a...)
+}
+
+func (l *levelLogger) NError(eventID uint32, v ...interface{}) error {
+	return eventid.Error(l.Logger, eventID, "%s", fmt.Sprint(v...))
+}
+
+func (l *levelLogger) NWarning(eventID uint32, v ...interface{}) error {
+	if !l.enabled(levelWarning) {
+		return nil
+	}
+	return eventid.Warning(l.Logger, eventID, "%s", fmt.Sprint(v...))
+}
+
+func (l *levelLogger) NInfo(eventID uint32, v ...interface{}) error {
+	if !l.enabled(levelInfo) {
+		return nil
+	}
+	return eventid.Info(l.Logger, eventID, "%s", fmt.Sprint(v...))
+}
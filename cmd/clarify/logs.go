@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/gomad/client"
+)
+
+// logStreams follows the task logs of running clarify allocations on this
+// node, keyed by alloc/task/type so each log is only followed once
+type logStreams struct {
+	sync.Mutex
+	active map[string]bool
+}
+
+// streamLogs starts following the stdout and stderr of every task in the
+// running clarify allocations on the node
+func (p *program) streamLogs(host *client.Host) {
+	if p.logs == nil {
+		return
+	}
+	allocs, err := nomad.NodeAllocations(p.nomad, host.ID)
+	if err != nil {
+		p.logger.Warning("error retrieving node allocations")
+		return
+	}
+	jobID := p.clarifyJob()
+	for _, alloc := range allocs {
+		if alloc.JobID != jobID || alloc.ClientStatus != "running" {
+			continue
+		}
+		for task := range alloc.Tasks {
+			for _, logType := range []string{"stdout", "stderr"} {
+				id, task, logType := alloc.ID, task, logType
+				p.crash.Go(func() { p.followLog(id, task, logType) })
+			}
+		}
+	}
+}
+
+func (p *program) followLog(id string, task string, logType string) {
+	key := fmt.Sprintf("%s/%s/%s", id, task, logType)
+	p.logs.Lock()
+	if p.logs.active[key] {
+		p.logs.Unlock()
+		return
+	}
+	p.logs.active[key] = true
+	p.logs.Unlock()
+	defer func() {
+		p.logs.Lock()
+		delete(p.logs.active, key)
+		p.logs.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-p.exit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	stream, err := nomad.StreamLogs(ctx, p.nomad, id, task, logType)
+	if err != nil {
+		p.logger.Warningf("unable to stream %s logs (alloc=%s;task=%s)", logType, id, task)
+		return
+	}
+	defer stream.Close()
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		line := fmt.Sprintf("[%s:%s:%s] %s", task, id[:8], logType, scanner.Text())
+		if logType == "stderr" {
+			p.logger.Warning(line)
+		} else {
+			p.logger.Info(line)
+		}
+	}
+}
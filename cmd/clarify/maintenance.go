@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/gomad/client"
+)
+
+// maintenanceMeta is the nomad node metadata key set while in maintenance
+const maintenanceMeta = reservedMeta + "maintenance"
+
+func (p *program) maintenanceKey() string {
+	return strings.TrimSuffix(p.maintenance, "/") + "/" + p.hostname
+}
+
+// inMaintenance reports whether an operator put the node into maintenance,
+// either through the consul kv flag or the nomad node metadata
+func (p *program) inMaintenance(node *client.Host) bool {
+	if pair, err := p.consul.Get(p.maintenanceKey()); err != nil {
+		p.logger.Warning("error reading maintenance flag from consul")
+	} else if pair != nil {
+		return true
+	}
+	n, err := nomad.GetNode(p.nomad, node.ID)
+	if err != nil {
+		p.logger.Warning("error reading node metadata")
+		return false
+	}
+	return n.Meta[maintenanceMeta] == "true"
+}
+
+// enterMaintenance drains the node and flags it in maintenance, recording
+// source (manual or scheduled) in the consul flag
+func (p *program) enterMaintenance(source string) error {
+	node := p.node()
+	if err := p.drain(); err != nil {
+		return err
+	}
+	enabled := "true"
+	if err := nomad.SetMeta(p.nomad, node.ID, map[string]*string{maintenanceMeta: &enabled}); err != nil {
+		p.logger.Error("error setting maintenance node metadata")
+		return err
+	}
+	if err := p.consul.Put(p.maintenanceKey(), []byte(source+" "+time.Now().UTC().Format(time.RFC3339))); err != nil {
+		p.logger.Error("error setting maintenance flag in consul")
+		return err
+	}
+	p.logger.Infof("maintenance entered (name=%s;id=%s)", node.Name, node.ID)
+	return nil
+}
+
+// exitMaintenance undrains the node and clears its maintenance flags. The
+// drain is disabled first so a node still draining stays in maintenance.
+func (p *program) exitMaintenance() error {
+	node := p.node()
+	if err := p.disableDrain(node.ID); err != nil {
+		p.logger.Error("error disabling node drain; node stays in maintenance")
+		return err
+	}
+	if err := p.consul.Delete(p.maintenanceKey()); err != nil {
+		p.logger.Error("error removing maintenance flag from consul")
+		return err
+	}
+	if err := nomad.SetMeta(p.nomad, node.ID, map[string]*string{maintenanceMeta: nil}); err != nil {
+		p.logger.Error("error removing maintenance node metadata")
+		return err
+	}
+	p.releaseDrainLock()
+	p.logger.Infof("maintenance exited (name=%s;id=%s)", node.Name, node.ID)
+	return nil
+}
+
+// maintenance runs the maintenance subcommand. Exiting maintenance starts the
+// service again since it stops itself once the node is drained.
+func maintenance(p *program, s service.Service, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: maintenance enter|exit")
+	}
+	switch args[0] {
+	case "enter":
+		return p.enterMaintenance("manual")
+	case "exit":
+		if err := p.exitMaintenance(); err != nil {
+			return err
+		}
+		if err := s.Start(); err != nil {
+			p.logger.Warningf("unable to start service: %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown maintenance action %q; expected enter or exit", args[0])
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/schedule"
+)
+
+// maintenanceScheduled prefixes the consul maintenance flag of maintenance
+// entered by a window so only those are exited when the window closes
+const maintenanceScheduled = "scheduled"
+
+// watchMaintenanceWindows drains the node when a maintenance window opens and
+// undrains it once the window closes, checking every minute until the
+// program exits
+func (p *program) watchMaintenanceWindows() {
+	if len(p.windows) == 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		p.checkMaintenanceWindows(time.Now())
+		select {
+		case <-ticker.C:
+		case <-p.exit:
+			return
+		}
+	}
+}
+
+func (p *program) checkMaintenanceWindows(now time.Time) {
+	var open *schedule.Window
+	var end time.Time
+	for _, w := range p.windows {
+		if active, e := w.Active(now); active && e.After(end) {
+			open, end = w, e
+		}
+	}
+	pair, err := p.consul.Get(p.maintenanceKey())
+	if err != nil {
+		p.logger.Warningf("error reading maintenance flag: %v", err)
+		return
+	}
+	switch {
+	case open != nil && pair == nil:
+		p.logger.Infof("maintenance window open until %s (window=%s)", end.Format(time.RFC3339), open.Expr)
+		if err := p.enterMaintenance(maintenanceScheduled); err != nil {
+			// The drain slots may all be held; retry on the next check
+			p.logger.Warningf("unable to enter scheduled maintenance: %v", err)
+			return
+		}
+		p.publish("maintenance_window_open", open.Expr)
+		p.notifier.Notify("maintenance_window_open", fmt.Sprintf("node drained for maintenance until %s", end.Format(time.RFC3339)))
+	case open == nil && pair != nil && strings.HasPrefix(string(pair.Value), maintenanceScheduled):
+		p.logger.Info("maintenance window closed")
+		if err := p.exitMaintenance(); err != nil {
+			p.logger.Warningf("unable to exit scheduled maintenance: %v", err)
+			return
+		}
+		p.publish("maintenance_window_closed", "")
+		p.notifier.Notify("maintenance_window_closed", "node undrained after maintenance")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/metrics"
+)
+
+// publishMetrics sends the node and job state to the statsd collector every
+// interval until the program exits
+func (p *program) publishMetrics(interval time.Duration) {
+	if p.metrics == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		st := p.status()
+		p.metrics.Gauge("node.drain", metrics.Bool(st.Drain))
+		p.metrics.Gauge("node.registered", metrics.Bool(len(st.NodeID) != 0))
+		p.metrics.Gauge("job.running", metrics.Bool(st.JobStatus == "running"))
+		p.metrics.Gauge("quarantined", metrics.Bool(len(st.Quarantined) != 0))
+		select {
+		case <-ticker.C:
+		case <-p.exit:
+			p.metrics.Close()
+			return
+		}
+	}
+}
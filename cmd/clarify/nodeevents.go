@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// nodeEvents are the events recorded on the node with -node-events, besides
+// every state transition
+var nodeEvents = map[string]bool{
+	"job_submitted":    true,
+	"job_lost":         true,
+	"drained":          true,
+	"undrained":        true,
+	"lame_duck":        true,
+	"lame_duck_off":    true,
+	"alloc_restarted":  true,
+	"quarantined":      true,
+	"rolled_back":      true,
+	"upgrade_finished": true,
+	"upgrade_reverted": true,
+}
+
+// publishNodeEvents records the wrapper's state and its last notable event
+// in the node's dynamic metadata, shown by nomad node status -verbose, so
+// cluster operators see wrapper activity without the node's logs
+func (p *program) publishNodeEvents() {
+	if !p.nodeEvents {
+		return
+	}
+	events := p.events.subscribe()
+	defer p.events.unsubscribe(events)
+	for {
+		select {
+		case e := <-events:
+			if !nodeEvents[e.Event] && !strings.HasPrefix(e.Event, "state_") {
+				continue
+			}
+			if err := p.recordNodeEvent(e); err != nil {
+				p.logger.Warningf("unable to record node event (event=%s): %v", e.Event, err)
+			}
+		case <-p.exit:
+			return
+		}
+	}
+}
+
+// recordNodeEvent sets the last event metadata and, for a state transition,
+// the state metadata of this node
+func (p *program) recordNodeEvent(e *event) error {
+	node, err := p.hostID(p.hostname)
+	if err != nil {
+		return err
+	}
+	value := e.Time.Format(time.RFC3339) + " " + e.Event
+	if len(e.Detail) != 0 {
+		value += ": " + e.Detail
+	}
+	meta := map[string]*string{eventMeta: &value}
+	if strings.HasPrefix(e.Event, "state_") {
+		state := strings.TrimPrefix(e.Event, "state_")
+		meta[stateMeta] = &state
+	}
+	return nomad.SetMeta(p.nomad, node.ID, meta)
+}
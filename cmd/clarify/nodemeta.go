@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// Node metadata keys maintained by clarifysvc, which all start with
+// reservedMeta, as do drainOwnerMeta and maintenanceMeta
+const (
+	reservedMeta = "clarify."
+	// versionMeta is the clarifysvc version running on the node
+	versionMeta = reservedMeta + "version"
+	// publishedMeta lists the -publish-meta keys last published so keys
+	// removed from the config are removed from the node
+	publishedMeta = reservedMeta + "published-meta"
+	// stateMeta is the wrapper's lifecycle state, set with -node-events
+	stateMeta = reservedMeta + "state"
+	// eventMeta is the wrapper's last notable event, set with -node-events
+	eventMeta = reservedMeta + "last-event"
+)
+
+// parseNodeMeta parses -publish-meta values of comma separated key=value
+// pairs
+func parseNodeMeta(values []string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, value := range values {
+		for _, kv := range strings.Split(value, ",") {
+			kv = strings.TrimSpace(kv)
+			if len(kv) == 0 {
+				continue
+			}
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 || len(parts[0]) == 0 {
+				return nil, fmt.Errorf("invalid -publish-meta %q; expected key=value", kv)
+			}
+			if strings.HasPrefix(parts[0], reservedMeta) {
+				return nil, fmt.Errorf("invalid -publish-meta %q; %s* keys are maintained by clarifysvc", kv, reservedMeta)
+			}
+			meta[parts[0]] = parts[1]
+		}
+	}
+	return meta, nil
+}
+
+// publishNodeMeta sets the configured node metadata and the clarifysvc
+// version on the node so jobs can be constrained on them. Dynamic node
+// metadata needs nomad 1.5 or later.
+func (p *program) publishNodeMeta(op *operation, id string) error {
+	node, err := nomad.GetNode(op.nomad, id)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(p.nodeMeta))
+	meta := map[string]*string{versionMeta: &version}
+	for key, value := range p.nodeMeta {
+		value := value
+		meta[key] = &value
+		keys = append(keys, key)
+	}
+	for _, key := range strings.Split(node.Meta[publishedMeta], ",") {
+		if _, ok := meta[key]; !ok && len(key) != 0 {
+			meta[key] = nil
+		}
+	}
+	sort.Strings(keys)
+	published := strings.Join(keys, ",")
+	meta[publishedMeta] = &published
+	if err := nomad.SetMeta(op.nomad, id, meta); err != nil {
+		return err
+	}
+	op.logger.Infof("published node metadata (id=%s;keys=%s)", id, published)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/pgombola/clarify-svc/internal/chaos"
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/gomad/client"
+)
+
+// nomadAPI wraps the job, node and drain calls of the startup, poll and
+// drain flows so the chaos injector can fail them. Other calls go straight
+// to internal/nomad. Tests point program.nomad at the fakes of
+// internal/testutil, which serve both, and replace program.newNomad only to
+// inject failures.
+type nomadAPI interface {
+	FindJob(name string) (*client.Job, error)
+	Hosts() ([]client.Host, error)
+	// Drain drains node id according to spec, or disables drain when spec
+	// is nil. Returns the http status code.
+	Drain(id string, spec *nomad.DrainSpec) (int, error)
+	SubmitJob(spec []byte) error
+}
+
+// httpNomad calls the nomad http api of server
+type httpNomad struct {
+	server *nomad.Server
+}
+
+func newHTTPNomad(server *nomad.Server) nomadAPI {
+	return httpNomad{server: server}
+}
+
+func (n httpNomad) FindJob(name string) (*client.Job, error) {
+	return nomad.FindJob(n.server, name)
+}
+
+func (n httpNomad) Hosts() ([]client.Host, error) {
+	return nomad.Hosts(n.server)
+}
+
+func (n httpNomad) Drain(id string, spec *nomad.DrainSpec) (int, error) {
+	var err error
+	if spec == nil {
+		err = nomad.DisableDrain(n.server, id)
+	} else {
+		err = nomad.DrainNode(n.server, id, *spec)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return http.StatusOK, nil
+}
+
+func (n httpNomad) SubmitJob(spec []byte) error {
+	return nomad.SubmitJob(n.server, spec)
+}
+
+// chaosNomad fails and delays the calls of nomadAPI for chaos testing
+type chaosNomad struct {
+	nomadAPI
+	chaos *chaos.Injector
+}
+
+func (n chaosNomad) FindJob(name string) (*client.Job, error) {
+	if err := n.chaos.Fault("find_job"); err != nil {
+		return &client.Job{}, err
+	}
+	return n.nomadAPI.FindJob(name)
+}
+
+func (n chaosNomad) Hosts() ([]client.Host, error) {
+	if err := n.chaos.Fault("hosts"); err != nil {
+		return nil, err
+	}
+	return n.nomadAPI.Hosts()
+}
+
+func (n chaosNomad) Drain(id string, spec *nomad.DrainSpec) (int, error) {
+	if err := n.chaos.Fault("drain"); err != nil {
+		return 0, err
+	}
+	return n.nomadAPI.Drain(id, spec)
+}
+
+func (n chaosNomad) SubmitJob(spec []byte) error {
+	if err := n.chaos.Fault("submit_job"); err != nil {
+		return err
+	}
+	return n.nomadAPI.SubmitJob(spec)
+}
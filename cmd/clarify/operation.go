@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/consul"
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/clarify-svc/internal/opid"
+)
+
+// operation is one high-level action such as the startup sequence, a job
+// submission or a drain. Its id is appended to every log line and sent with
+// every nomad and consul api call made for it so the action can be followed
+// across nodes in centralized logging.
+type operation struct {
+	id     string
+	name   string
+	logger service.Logger
+	nomad  *nomad.Server
+	consul *consul.Client
+	// publish records the failure in the event history
+	publish func(name string, detail string)
+}
+
+// begin starts an operation named name
+func (p *program) begin(name string) *operation {
+	id := opid.New()
+	op := &operation{
+		id:      id,
+		name:    name,
+		logger:  opid.NewLogger(p.logger, id),
+		nomad:   nomad.WithOperation(p.nomad, id),
+		consul:  p.consul.WithOperation(id),
+		publish: p.publish,
+	}
+	op.logger.Infof("%s started", name)
+	return op
+}
+
+// end finishes the operation, logging whether it failed
+func (op *operation) end(err error) {
+	if err != nil {
+		op.logger.Warningf("%s failed: %v", op.name, err)
+		op.publish("operation_failed", fmt.Sprintf("%s: %v", op.name, err))
+		return
+	}
+	op.logger.Infof("%s finished", op.name)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// peer is a consul or nomad cluster member
+type peer struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Status  string `json:"status"`
+	Server  bool   `json:"server"`
+}
+
+// peerList is the membership printed by the peers command
+type peerList struct {
+	Consul []peer   `json:"consul"`
+	Nomad  []peer   `json:"nomad"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// peers prints the consul and nomad cluster membership
+func (p *program) peers(args []string) error {
+	fs := flag.NewFlagSet("peers", flag.ExitOnError)
+	fs.Parse(args)
+
+	list := &peerList{Consul: make([]peer, 0), Nomad: make([]peer, 0)}
+	if members, err := p.consul.Members(); err != nil {
+		list.Errors = append(list.Errors, fmt.Sprintf("consul: %v", err))
+	} else {
+		for _, m := range members {
+			list.Consul = append(list.Consul, peer{Name: m.Name, Address: m.Addr, Status: m.StatusName(), Server: m.Tags["role"] == "consul"})
+		}
+	}
+	if members, err := nomad.Members(p.nomad); err != nil {
+		list.Errors = append(list.Errors, fmt.Sprintf("nomad: %v", err))
+	} else {
+		for _, m := range members {
+			list.Nomad = append(list.Nomad, peer{Name: m.Name, Address: m.Addr, Status: m.Status, Server: true})
+		}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(list)
+}
+
+// forceLeave removes a dead node from the consul and nomad gossip pools it's
+// a member of. Live members are refused since they'd rejoin immediately.
+func (p *program) forceLeave(args []string) error {
+	fs := flag.NewFlagSet("force-leave", flag.ExitOnError)
+	prune := fs.Bool("prune", false, "Removes the node from the consul member list entirely.")
+	var node string
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		node, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+	if len(node) == 0 {
+		node = fs.Arg(0)
+	}
+	if len(node) == 0 {
+		return errors.New("usage: force-leave <node> [-prune]")
+	}
+
+	found := false
+	members, err := p.consul.Members()
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.Name != node {
+			continue
+		}
+		found = true
+		if m.StatusName() == "alive" {
+			return fmt.Errorf("consul member %s is alive; stop it instead", node)
+		}
+		if err := p.consul.ForceLeave(node, *prune); err != nil {
+			return err
+		}
+		fmt.Printf("consul: %s forced to leave\n", node)
+	}
+	servers, err := nomad.Members(p.nomad)
+	if err != nil {
+		return err
+	}
+	for _, m := range servers {
+		// Nomad server members are named <node>.<region>
+		if m.Name != node && !strings.HasPrefix(m.Name, node+".") {
+			continue
+		}
+		found = true
+		if m.Status == "alive" {
+			return fmt.Errorf("nomad server %s is alive; stop it instead", m.Name)
+		}
+		if err := nomad.ForceLeave(p.nomad, m.Name); err != nil {
+			return err
+		}
+		fmt.Printf("nomad: %s forced to leave\n", m.Name)
+	}
+	if !found {
+		return fmt.Errorf("%s isn't a consul or nomad member", node)
+	}
+	p.publish("force_leave", node)
+	return nil
+}
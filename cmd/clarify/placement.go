@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// explainPlacement summarizes, per task group, why the scheduler couldn't
+// place allocations
+func explainPlacement(failed map[string]*nomad.AllocMetric) []string {
+	groups := make([]string, 0, len(failed))
+	for group := range failed {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	explained := make([]string, 0, len(groups))
+	for _, group := range groups {
+		explained = append(explained, "group "+group+": "+failed[group].Explain())
+	}
+	return explained
+}
+
+// blockedPlacement explains the blocked evaluations of the clarify job,
+// which wait for resources or nodes satisfying its constraints
+func (p *program) blockedPlacement() ([]string, error) {
+	evals, err := nomad.JobEvaluations(p.nomad, p.clarifyJob())
+	if err != nil {
+		return nil, err
+	}
+	blocked := make(map[string]bool)
+	for _, eval := range evals {
+		if eval.Status == "blocked" {
+			blocked[eval.ID] = true
+		}
+	}
+	if len(blocked) == 0 {
+		return nil, nil
+	}
+	// The placement failures are recorded on the evaluation that created
+	// the blocked one
+	failed := make(map[string]*nomad.AllocMetric)
+	for _, eval := range evals {
+		if blocked[eval.BlockedEval] {
+			for group, metric := range eval.FailedTGAllocs {
+				failed[group] = metric
+			}
+		}
+	}
+	if len(failed) == 0 {
+		return []string{"evaluation blocked waiting for resources"}, nil
+	}
+	return explainPlacement(failed), nil
+}
+
+// watchPlacement alerts when the clarify job has blocked evaluations,
+// explaining which resources are exhausted or constraints unsatisfied,
+// instead of the job silently not running
+func (p *program) watchPlacement() {
+	if p.placementInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.placementInterval)
+	defer ticker.Stop()
+	last := ""
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.exit:
+			return
+		}
+		problems, err := p.blockedPlacement()
+		if err != nil {
+			p.logger.Warningf("error checking clarify evaluations: %v", err)
+			continue
+		}
+		p.mu.Lock()
+		p.placement = problems
+		p.mu.Unlock()
+		summary := strings.Join(problems, "; ")
+		if summary == last {
+			continue
+		}
+		if len(problems) == 0 {
+			p.logger.Info("clarify job placement unblocked")
+			p.publish("placement_ok", "")
+			p.notifier.Notify("placement_ok", "clarify job placement unblocked")
+		} else {
+			msg := "clarify job can't be placed: " + summary
+			p.logger.Warning(msg)
+			p.publish("placement_blocked", summary)
+			if err := p.notifier.Notify("placement_blocked", msg); err != nil {
+				p.logger.Warningf("error sending notification: %v", err)
+			}
+		}
+		last = summary
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// pollBackoff is the growth of the poll interval after each poll finding
+// nothing changed
+const pollBackoff = 1.5
+
+// pollSchedule spaces the polls of the clarify job and node. Polls are
+// jittered so nodes started together don't poll nomad in lockstep, and slow
+// down towards max while nothing changes, returning to the base interval as
+// soon as something does.
+type pollSchedule struct {
+	base     func() time.Duration
+	max      time.Duration
+	jitter   float64
+	interval time.Duration
+	last     string
+	rand     *rand.Rand
+}
+
+func newPollSchedule(base func() time.Duration, max time.Duration, jitter float64) *pollSchedule {
+	return &pollSchedule{base: base, max: max, jitter: jitter, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// first returns a random delay of up to one base interval before the first
+// poll
+func (s *pollSchedule) first() time.Duration {
+	return time.Duration(s.rand.Float64() * float64(s.base()))
+}
+
+// next returns the delay before the poll following one that observed state.
+// An empty state, such as after an error, is never stable so polling stays
+// quick until errors clear.
+func (s *pollSchedule) next(state string) time.Duration {
+	base := s.base()
+	if len(state) == 0 || state != s.last || s.interval < base {
+		s.interval = base
+	} else {
+		s.interval = time.Duration(float64(s.interval) * pollBackoff)
+	}
+	s.last = state
+	if s.interval > s.max {
+		s.interval = s.max
+	}
+	if s.interval < base {
+		// A max below the base interval disables backoff
+		s.interval = base
+	}
+	// Spread by up to +/- jitter of the interval
+	return time.Duration(float64(s.interval) * (1 + s.jitter*(2*s.rand.Float64()-1)))
+}
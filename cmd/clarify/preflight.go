@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/clarify-svc/internal/preflight"
+)
+
+// preflight verifies the node can run clarify and writes a json report to
+// stdout, exiting non-zero when a check fails
+func (p *program) preflight(args []string) {
+	fs := flag.NewFlagSet("preflight", flag.ExitOnError)
+	minDisk := fs.Uint64("min-disk-mb", 1024, "Free disk space required in the clarify install directory.")
+	fs.Parse(args)
+
+	r := preflight.NewReport(p.name)
+	_, err := p.hostID(p.hostname)
+	r.Add("nomad-node", err)
+	_, err = p.consul.Leader()
+	r.Add("consul", err)
+	r.Add("time", preflight.ClockSkewWith(nomad.HTTPClient(), nomad.URL(p.nomad, "/v1/status/leader"), 2*time.Second))
+	if err := p.verifyInstall(); err != nil {
+		r.Add("install", err)
+	} else {
+		r.Add("install", nil)
+		r.Add("disk", preflight.DiskFree(p.clarify, *minDisk<<20))
+	}
+	spec, err := p.jobSpec()
+	if err == nil && !json.Valid(spec) {
+		err = errors.New("job specification isn't valid json")
+	}
+	r.Add("job-spec", err)
+	if err == nil {
+		r.Add("drivers", p.checkDrivers(p.nomad, spec))
+	}
+	r.Write(os.Stdout)
+	if !r.OK {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+// quarantine records a launch of the clarify job and reports whether it was
+// relaunched too often and must wait for an operator to resume it
+func (p *program) quarantine(reason string) bool {
+	quarantined, err := p.crashes.Record(reason)
+	if err != nil {
+		p.logger.Warningf("unable to record launch: %v", err)
+		return false
+	}
+	if quarantined {
+		p.logger.Errorf("%s quarantined after repeated launches; run resume to launch clarify again", p.name)
+		p.publish("quarantined", reason)
+		if err := p.notifier.Notify("quarantined", reason); err != nil {
+			p.logger.Warningf("error sending notification: %v", err)
+		}
+	}
+	return quarantined
+}
+
+// resume lifts the quarantine and restarts the service
+func (p *program) resume() error {
+	if err := p.crashes.Resume(); err != nil {
+		return err
+	}
+	if err := p.svc.Restart(); err != nil {
+		p.logger.Warningf("unable to restart service: %v", err)
+	}
+	return nil
+}
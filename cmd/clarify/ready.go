@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/pgombola/clarify-svc/internal/ready"
+)
+
+// signalReady marks the readiness sentinel on entering the running state and
+// clears it on any other, so host tooling sees clarify up only while it's
+// running. Clearing on startup removes a sentinel left by a crash.
+func (p *program) signalReady(to lifecycleState) {
+	if len(p.readySentinel) == 0 {
+		return
+	}
+	if to == stateRunning {
+		if err := ready.Mark(p.readySentinel); err != nil {
+			p.logger.Warningf("unable to mark readiness sentinel (location=%s): %v", p.readySentinel, err)
+		}
+		return
+	}
+	if err := ready.Clear(p.readySentinel); err != nil {
+		p.logger.Warningf("unable to clear readiness sentinel (location=%s): %v", p.readySentinel, err)
+	}
+}
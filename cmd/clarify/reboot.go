@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/reboot"
+)
+
+// maintenanceReboot prefixes the consul maintenance flag of maintenance
+// entered for a pending reboot so it's exited once the node is back
+const maintenanceReboot = "reboot"
+
+// Reboot states published for the patch orchestrator
+const (
+	rebootReady    = "ready"
+	rebootReturned = "returned"
+)
+
+// rebootState is the json value of the node's reboot key. The orchestrator
+// reboots nodes in state ready; returned nodes are back in service.
+type rebootState struct {
+	State   string    `json:"state"`
+	Node    string    `json:"node"`
+	Reasons []string  `json:"reasons,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// pendingReboot returns why the operating system needs a reboot
+var pendingReboot = reboot.Pending
+
+func (p *program) rebootKey() string {
+	return strings.TrimSuffix(p.rebootPrefix, "/") + "/" + p.hostname
+}
+
+// watchReboot checks for a pending reboot every interval until the program
+// exits
+func (p *program) watchReboot() {
+	if len(p.rebootPrefix) == 0 || p.rebootInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.rebootInterval)
+	defer ticker.Stop()
+	for {
+		p.checkReboot()
+		select {
+		case <-ticker.C:
+		case <-p.exit:
+			return
+		}
+	}
+}
+
+// checkReboot drains the node and tells the orchestrator it's ready once a
+// reboot is pending, and undrains it when it comes back without one. Nodes
+// an operator put into maintenance are left alone.
+func (p *program) checkReboot() {
+	reasons, err := pendingReboot()
+	if err != nil {
+		p.logger.Warningf("unable to check for a pending reboot: %v", err)
+		return
+	}
+	pair, err := p.consul.Get(p.maintenanceKey())
+	if err != nil {
+		p.logger.Warningf("error reading maintenance flag: %v", err)
+		return
+	}
+	switch {
+	case len(reasons) != 0 && pair == nil:
+		p.logger.Infof("reboot pending; draining (reasons=%s)", strings.Join(reasons, ","))
+		if err := p.enterMaintenance(maintenanceReboot); err != nil {
+			// The drain slots may all be held; retry on the next check
+			p.logger.Warningf("unable to drain for reboot: %v", err)
+			return
+		}
+		p.readyForReboot(reasons)
+	case len(reasons) != 0 && pair != nil && strings.HasPrefix(string(pair.Value), maintenanceReboot):
+		// Publishing readiness may have failed after the drain
+		state, err := p.rebootState()
+		if err != nil {
+			p.logger.Warningf("error reading reboot state: %v", err)
+			return
+		}
+		if state == nil || state.State != rebootReady {
+			p.readyForReboot(reasons)
+		}
+	case len(reasons) == 0 && pair != nil && strings.HasPrefix(string(pair.Value), maintenanceReboot):
+		p.logger.Info("node returned from reboot")
+		if err := p.exitMaintenance(); err != nil {
+			p.logger.Warningf("unable to exit reboot maintenance: %v", err)
+			return
+		}
+		if err := p.setRebootState(rebootReturned, nil); err != nil {
+			p.logger.Warningf("error publishing reboot return: %v", err)
+		}
+		p.publish("reboot_returned", "")
+		p.notifier.Notify("reboot_returned", "node undrained after reboot")
+	}
+}
+
+// readyForReboot tells the orchestrator the drained node may reboot
+func (p *program) readyForReboot(reasons []string) {
+	if err := p.setRebootState(rebootReady, reasons); err != nil {
+		p.logger.Errorf("error publishing reboot readiness: %v", err)
+		return
+	}
+	p.publish("reboot_ready", strings.Join(reasons, ","))
+	p.notifier.Notify("reboot_ready", "node drained and ready to reboot")
+}
+
+// rebootState returns the state published for the orchestrator, or nil
+func (p *program) rebootState() (*rebootState, error) {
+	pair, err := p.consul.Get(p.rebootKey())
+	if err != nil || pair == nil {
+		return nil, err
+	}
+	state := &rebootState{}
+	if err := json.Unmarshal(pair.Value, state); err != nil {
+		return nil, fmt.Errorf("invalid reboot state (key=%s): %v", p.rebootKey(), err)
+	}
+	return state, nil
+}
+
+func (p *program) setRebootState(state string, reasons []string) error {
+	buf, err := json.Marshal(&rebootState{State: state, Node: p.hostname, Reasons: reasons, Time: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return p.consul.Put(p.rebootKey(), buf)
+}
+
+// rebootStatus runs the reboot-status subcommand, printing whether a reboot
+// is pending and the state published for the orchestrator
+func (p *program) rebootStatus() error {
+	reasons, err := pendingReboot()
+	if err != nil {
+		return err
+	}
+	status := struct {
+		Pending bool         `json:"pending"`
+		Reasons []string     `json:"reasons,omitempty"`
+		State   *rebootState `json:"state,omitempty"`
+	}{Pending: len(reasons) != 0, Reasons: reasons}
+	if len(p.rebootPrefix) != 0 {
+		if status.State, err = p.rebootState(); err != nil {
+			return err
+		}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(status)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// expectedServices returns the consul services the clarify job registers,
+// either configured with -consul-services or read from the job specification
+func (p *program) expectedServices() []string {
+	if len(p.services) != 0 {
+		return p.services
+	}
+	spec, err := p.jobSpec()
+	if err != nil {
+		return nil
+	}
+	var wrapped struct {
+		Job struct {
+			TaskGroups []struct {
+				Services []struct {
+					Name string `json:"Name"`
+				} `json:"Services"`
+				Tasks []struct {
+					Services []struct {
+						Name string `json:"Name"`
+					} `json:"Services"`
+				} `json:"Tasks"`
+			} `json:"TaskGroups"`
+		} `json:"Job"`
+	}
+	if json.Unmarshal(spec, &wrapped) != nil {
+		return nil
+	}
+	names := make([]string, 0)
+	for _, group := range wrapped.Job.TaskGroups {
+		for _, s := range group.Services {
+			names = append(names, s.Name)
+		}
+		for _, task := range group.Tasks {
+			for _, s := range task.Services {
+				names = append(names, s.Name)
+			}
+		}
+	}
+	return names
+}
+
+// registrationProblems returns what's missing or failing in consul for the
+// services clarify is expected to register on this node
+func (p *program) registrationProblems(expected []string) ([]string, error) {
+	registered, err := p.consul.NodeServices(p.hostname)
+	if err != nil {
+		return nil, err
+	}
+	checks, err := p.consul.NodeChecks(p.hostname)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(registered))
+	for _, name := range registered {
+		found[name] = true
+	}
+	problems := make([]string, 0)
+	for _, name := range expected {
+		if !found[name] {
+			problems = append(problems, fmt.Sprintf("service %s isn't registered", name))
+			continue
+		}
+		for _, c := range checks {
+			if c.ServiceName == name && c.Status == "critical" {
+				problems = append(problems, fmt.Sprintf("service %s check %q is critical", name, c.Name))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
+
+// runningLocally reports whether an allocation of the clarify job is
+// running on this node
+func (p *program) runningLocally() bool {
+	host, err := p.hostID(p.hostname)
+	if err != nil {
+		return false
+	}
+	allocs, err := nomad.NodeAllocations(p.nomad, host.ID)
+	if err != nil {
+		return false
+	}
+	jobID := p.clarifyJob()
+	for _, alloc := range allocs {
+		if alloc.JobID == jobID && alloc.ClientStatus == "running" {
+			return true
+		}
+	}
+	return false
+}
+
+// watchRegistration alerts when clarify runs on this node but its services
+// aren't registered or healthy in consul for longer than the grace period
+func (p *program) watchRegistration() {
+	if p.registrationInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.registrationInterval)
+	defer ticker.Stop()
+	var since time.Time
+	alerted := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.exit:
+			return
+		}
+		expected := p.expectedServices()
+		if len(expected) == 0 {
+			continue
+		}
+		if !p.runningLocally() {
+			since = time.Time{}
+			continue
+		}
+		problems, err := p.registrationProblems(expected)
+		if err != nil {
+			p.logger.Warningf("error checking consul registration: %v", err)
+			continue
+		}
+		p.mu.Lock()
+		p.registration = problems
+		p.mu.Unlock()
+		if len(problems) == 0 {
+			if alerted {
+				p.logger.Info("clarify services registered and healthy in consul")
+				p.publish("registration_ok", "")
+				p.notifier.Notify("registration_ok", "clarify services registered and healthy in consul")
+			}
+			since, alerted = time.Time{}, false
+			continue
+		}
+		if since.IsZero() {
+			since = time.Now()
+		}
+		if alerted || time.Since(since) < p.registrationGrace {
+			continue
+		}
+		msg := "clarify is running but " + strings.Join(problems, "; ")
+		p.logger.Warning(msg)
+		p.publish("registration_missing", strings.Join(problems, "; "))
+		if err := p.notifier.Notify("registration_missing", msg); err != nil {
+			p.logger.Warningf("error sending notification: %v", err)
+		}
+		alerted = true
+	}
+}
+
+// splitList splits a comma separated flag value, dropping empty entries
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			list = append(list, v)
+		}
+	}
+	return list
+}
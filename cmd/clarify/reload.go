@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/clarify-svc/internal/sealed"
+)
+
+// configFlags are the flags read from the -config file rather than the
+// command line. They're left out of the installed service's arguments so
+// the file stays authoritative and can be reloaded.
+var configFlags = make(map[string]bool)
+
+// commandLineFlags are the flags given on the command line, which neither
+// the config file nor the remote config override
+var commandLineFlags = make(map[string]bool)
+
+// reloadable are the -config keys applied on reload without a restart
+var reloadable = map[string]bool{
+	"launch":                   true,
+	"spec-interval":            true,
+	"heartbeat-interval":       true,
+	"poll-interval":            true,
+	"notify":                   true,
+	"log-level":                true,
+	"drain-deadline":           true,
+	"drain-force":              true,
+	"drain-ignore-system-jobs": true,
+	"drain-policy":             true,
+	"auto-undrain":             true,
+}
+
+// readConfig returns the flag values of the json config file, keyed by
+// flag name
+func readConfig(path string) (map[string]string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	values := make(map[string]string, len(raw))
+	for key, v := range raw {
+		if flag.Lookup(key) == nil {
+			return nil, fmt.Errorf("config file %s: unknown option %q", path, key)
+		}
+		if key == "config-key" {
+			return nil, fmt.Errorf("config file %s: config-key must be given on the command line", path)
+		}
+		values[key] = fmt.Sprint(v)
+		if s, ok := v.(string); ok && sealed.IsSealed(s) {
+			value, err := sealed.Open(s, configKey)
+			if err != nil {
+				return nil, fmt.Errorf("config file %s: %s: %v", path, key, err)
+			}
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// applyConfig sets the flags of the config file that weren't given on the
+// command line
+func applyConfig(path string) error {
+	if len(path) == 0 {
+		return nil
+	}
+	values, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	flag.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+	for key, value := range values {
+		if commandLineFlags[key] {
+			continue
+		}
+		if err := flag.Set(key, value); err != nil {
+			return fmt.Errorf("config file %s: %s: %v", path, key, err)
+		}
+		configFlags[key] = true
+	}
+	return nil
+}
+
+// watchReload reloads the config file on SIGHUP until the program exits
+func (p *program) watchReload() {
+	if len(p.configFile) == 0 {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-hup:
+			if err := p.reload(); err != nil {
+				p.logger.Errorf("error reloading config: %v", err)
+			}
+		case <-p.exit:
+			return
+		}
+	}
+}
+
+// reload applies the reloadable options of the config file. Options given
+// on the command line take precedence and other options need a restart.
+func (p *program) reload() error {
+	if len(p.configFile) == 0 {
+		return fmt.Errorf("no config file; start with -config to enable reload")
+	}
+	values, err := readConfig(p.configFile)
+	if err != nil {
+		return err
+	}
+	if err := p.applyReloadable(values, configFlags); err != nil {
+		return err
+	}
+	p.logger.Infof("reloaded config (file=%s)", p.configFile)
+	p.publish("reloaded", p.configFile)
+	return nil
+}
+
+// applyReloadable applies the values of the flags owned by source, warning
+// about changed flags that need a restart. Every value is validated before
+// any is applied, so an invalid value leaves the running config untouched.
+func (p *program) applyReloadable(values map[string]string, source map[string]bool) error {
+	durations := map[string]*time.Duration{
+		"spec-interval":      &p.specInterval,
+		"heartbeat-interval": &p.heartbeatInterval,
+		"poll-interval":      &p.pollInterval,
+		"drain-deadline":     &p.drainSpec.Deadline,
+	}
+	bools := map[string]*bool{
+		"drain-force":              &p.drainSpec.Force,
+		"drain-ignore-system-jobs": &p.drainSpec.IgnoreSystemJobs,
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var apply []func()
+	for key, value := range values {
+		if !source[key] {
+			continue
+		}
+		if !reloadable[key] {
+			if value != flag.Lookup(key).Value.String() {
+				p.logger.Warningf("%s changed; restart %s to apply it", key, p.name)
+			}
+			continue
+		}
+		key, value := key, value
+		switch key {
+		case "launch":
+			if (len(kvLaunchKey(p.launch)) == 0) != (len(kvLaunchKey(value)) == 0) {
+				p.logger.Warningf("switching between consul and file job specifications needs a restart")
+				continue
+			}
+			apply = append(apply, func() { p.launch = value })
+		case "notify":
+			apply = append(apply, func() { p.notifier.SetURL(value) })
+		case "log-level":
+			level, err := parseLogLevel(value)
+			if err != nil {
+				return err
+			}
+			apply = append(apply, func() { p.levels.setLevel(level) })
+		case "drain-policy":
+			if err := validDrainPolicy(value); err != nil {
+				return err
+			}
+			if len(p.windows) != 0 && value == drainPolicyAny {
+				return fmt.Errorf("drain-policy: %s can't be used with -maintenance-window", value)
+			}
+			apply = append(apply, func() { p.drainPolicy = value })
+		case "auto-undrain":
+			if err := validAutoUndrain(value); err != nil {
+				return err
+			}
+			apply = append(apply, func() { p.autoUndrain = value })
+		case "drain-force", "drain-ignore-system-jobs":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			apply = append(apply, func() { *bools[key] = b })
+		default:
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			if err := validDuration(key, d); err != nil {
+				return err
+			}
+			apply = append(apply, func() { *durations[key] = d })
+		}
+	}
+	for _, f := range apply {
+		f()
+	}
+	return nil
+}
+
+// validDuration rejects the non-positive intervals and deadlines, which
+// tickers panic on
+func validDuration(key string, d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%s: %v must be positive", key, d)
+	}
+	return nil
+}
+
+// drainOptions returns the reloadable drain specification
+func (p *program) drainOptions() nomad.DrainSpec {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.drainSpec
+}
+
+// stopPolicy returns the reloadable drain policy
+func (p *program) stopPolicy() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.drainPolicy
+}
+
+// undrainPolicy returns the reloadable auto undrain policy
+func (p *program) undrainPolicy() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.autoUndrain
+}
+
+// duration returns a reloadable interval
+func (p *program) duration(d *time.Duration) time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return *d
+}
+
+// launchSpec returns the reloadable job specification location
+func (p *program) launchSpec() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.launch
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/consul"
+)
+
+// remoteNodes is the path beneath the -config-kv prefix of per node
+// overrides, keyed <prefix>/nodes/<hostname>/<flag>
+const remoteNodes = "nodes/"
+
+// watchRemoteConfig applies the reloadable options stored beneath the
+// -config-kv prefix as they change, until the program exits. Options given on
+// the command line or in the -config file take precedence.
+func (p *program) watchRemoteConfig() {
+	if len(p.configKV) == 0 {
+		return
+	}
+	prefix := strings.TrimSuffix(p.configKV, "/") + "/"
+	var index uint64
+	applied := make(map[string]string)
+	for {
+		select {
+		case <-p.exit:
+			return
+		default:
+		}
+		pairs, next, err := p.consul.WatchPrefix(prefix, index)
+		if err != nil {
+			p.logger.Warningf("error watching remote config (prefix=%s): %v", prefix, err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-p.exit:
+				return
+			}
+			continue
+		}
+		index = next
+		p.applyRemoteFeatures(prefix, pairs)
+		values := p.remoteValues(prefix, pairs)
+		if sameValues(values, applied) {
+			continue
+		}
+		restore := withBaseline(values, applied)
+		if err := p.applyReloadable(restore, remoteFlags(restore)); err != nil {
+			p.logger.Errorf("error applying remote config (prefix=%s): %v", prefix, err)
+			continue
+		}
+		applied = values
+		p.logger.Infof("applied remote config (prefix=%s;keys=%s)", prefix, strings.Join(sortedKeys(restore), ","))
+		p.publish("reloaded", "consul:"+prefix)
+	}
+}
+
+// remoteValues returns the flag values of the pairs beneath prefix, the
+// overrides of this node replacing the fleet values. Only reloadable flags
+// are applied remotely; others are logged and ignored.
+func (p *program) remoteValues(prefix string, pairs []consul.KVPair) map[string]string {
+	values := make(map[string]string)
+	overrides := make(map[string]string)
+	node := remoteNodes + p.hostname + "/"
+	for _, pair := range pairs {
+		key := strings.TrimPrefix(pair.Key, prefix)
+		dst := values
+		if strings.HasPrefix(key, node) {
+			key, dst = strings.TrimPrefix(key, node), overrides
+		} else if strings.Contains(key, "/") {
+			continue
+		}
+		if len(key) == 0 {
+			continue
+		}
+		if flag.Lookup(key) == nil || !reloadable[key] {
+			p.logger.Warningf("ignoring remote config option %s; only reloadable options may be set remotely", key)
+			continue
+		}
+		dst[key] = strings.TrimSpace(string(pair.Value))
+	}
+	for key, value := range overrides {
+		values[key] = value
+	}
+	return values
+}
+
+// withBaseline returns values plus the local value of each key applied
+// before but deleted since, so removing a key reverts its option. Remote
+// keys only own flags left off the command line and the config file, whose
+// local value is the flag's default.
+func withBaseline(values map[string]string, applied map[string]string) map[string]string {
+	restore := make(map[string]string, len(values))
+	for key, value := range values {
+		restore[key] = value
+	}
+	for key := range applied {
+		if _, ok := values[key]; !ok {
+			restore[key] = flag.Lookup(key).Value.String()
+		}
+	}
+	return restore
+}
+
+// remoteFlags returns the keys of values not set on the command line or in
+// the config file
+func remoteFlags(values map[string]string) map[string]bool {
+	owned := make(map[string]bool)
+	for key := range values {
+		owned[key] = !commandLineFlags[key] && !configFlags[key]
+	}
+	return owned
+}
+
+func sameValues(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if v, ok := b[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var specClient = &http.Client{Timeout: 30 * time.Second}
+
+// remoteSpec configures fetching the job specification from a url
+type remoteSpec struct {
+	cache  string
+	sha256 string
+}
+
+func (p *program) specCache() string {
+	if len(p.remote.cache) != 0 {
+		return p.remote.cache
+	}
+	return filepath.Join(p.clarify, "launch_clarify.cache.json")
+}
+
+// fetchJobSpec downloads the job specification from the -launch url. The last
+// downloaded copy is kept next to the install and revalidated with its ETag;
+// it's used as a fallback when the url can't be reached.
+func (p *program) fetchJobSpec() ([]byte, error) {
+	cache := p.specCache()
+	etag, _ := ioutil.ReadFile(cache + ".etag")
+	spec, err := p.download(cache, strings.TrimSpace(string(etag)))
+	if err != nil {
+		p.logger.Warningf("error fetching job specification; using local copy: %v", err)
+		if spec, err = ioutil.ReadFile(cache); err != nil {
+			return nil, fmt.Errorf("job specification unavailable: %v", err)
+		}
+	}
+	if err := p.verifySpec(spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+func (p *program) download(cache string, etag string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, p.launchSpec(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(cache); err == nil && len(etag) != 0 {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := specClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return ioutil.ReadFile(cache)
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("http status: %v", resp.StatusCode)
+	}
+	spec, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.verifySpec(spec); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(cache, spec, 0644); err != nil {
+		p.logger.Warningf("unable to cache job specification: %v", err)
+	} else if err := ioutil.WriteFile(cache+".etag", []byte(resp.Header.Get("ETag")), 0644); err != nil {
+		p.logger.Warningf("unable to cache job specification etag: %v", err)
+	}
+	return spec, nil
+}
+
+// verifySpec checks the job specification against the pinned checksum
+func (p *program) verifySpec(spec []byte) error {
+	if len(p.remote.sha256) == 0 {
+		return nil
+	}
+	sum := sha256.Sum256(spec)
+	if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, p.remote.sha256) {
+		return fmt.Errorf("job specification checksum mismatch (expected=%s;actual=%s)", p.remote.sha256, actual)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// jobVersion is a version of the clarify job as listed by the versions
+// command
+type jobVersion struct {
+	Version   uint64    `json:"version"`
+	Stable    bool      `json:"stable"`
+	Current   bool      `json:"current,omitempty"`
+	Submitted time.Time `json:"submitted"`
+}
+
+// jobVersions returns the versions of the clarify job nomad keeps, most
+// recent first
+func (p *program) jobVersions() ([]jobVersion, error) {
+	versions, err := nomad.JobVersions(p.nomad, p.clarifyJob())
+	if err != nil {
+		return nil, err
+	}
+	list := make([]jobVersion, 0, len(versions))
+	for i, v := range versions {
+		list = append(list, jobVersion{
+			Version:   v.Version,
+			Stable:    v.Stable,
+			Current:   i == 0,
+			Submitted: time.Unix(0, v.SubmitTime).UTC(),
+		})
+	}
+	return list, nil
+}
+
+// rollback reverts the clarify job to version, or to the most recent stable
+// version before the current one when version is negative
+func (p *program) rollback(version int64) (err error) {
+	op := p.begin("rollback")
+	defer func() {
+		op.end(err)
+	}()
+	versions, err := nomad.JobVersions(op.nomad, p.clarifyJob())
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return errors.New("clarify job has no versions")
+	}
+	current := versions[0].Version
+	target := int64(-1)
+	for _, v := range versions[1:] {
+		if version < 0 && v.Stable {
+			target = int64(v.Version)
+			break
+		}
+		if version >= 0 && int64(v.Version) == version {
+			target = version
+			break
+		}
+	}
+	if target < 0 && version < 0 {
+		return fmt.Errorf("no stable version of clarify before version %d", current)
+	} else if target < 0 {
+		return fmt.Errorf("clarify has no version %d before the current version %d", version, current)
+	}
+	err = nomad.RevertJob(op.nomad, p.clarifyJob(), uint64(target))
+	detail := fmt.Sprintf("%d to %d", current, target)
+	p.audit.Record("rollback", p.initiator, err, detail)
+	if err != nil {
+		return err
+	}
+	op.logger.Infof("clarify job rolled back (from=%d;to=%d)", current, target)
+	p.publish("rolled_back", detail)
+	p.notifier.Notify("rolled_back", "clarify job rolled back from version "+detail)
+	return nil
+}
+
+// handleRollback rolls back to the version query parameter, or the last
+// stable version
+func (p *program) handleRollback(w http.ResponseWriter, r *http.Request) {
+	version := int64(-1)
+	if v := r.URL.Query().Get("version"); len(v) != 0 {
+		var err error
+		if version, err = strconv.ParseInt(v, 10, 64); err != nil || version < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid version %q", v))
+			return
+		}
+	}
+	p.handleAction("rollback", func() error {
+		return p.rollback(version)
+	})(w, r)
+}
+
+func (p *program) handleVersions(w http.ResponseWriter, r *http.Request) {
+	versions, err := p.jobVersions()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, versions)
+}
+
+// rollbackQuery parses the rollback command's flags into the admin api query
+func rollbackQuery(args []string) url.Values {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	version := fs.Int64("version", -1, "Version to revert to (defaults to the last stable version before the current one).")
+	fs.Parse(args)
+	query := url.Values{}
+	if *version >= 0 {
+		query.Set("version", strconv.FormatInt(*version, 10))
+	}
+	return query
+}
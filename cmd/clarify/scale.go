@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// scale sets the count of a task group of the clarify job and waits for the
+// resulting evaluation to complete
+func (p *program) scale(args []string) error {
+	fs := flag.NewFlagSet("scale", flag.ExitOnError)
+	group := fs.String("group", "", "Task group of the clarify job to scale.")
+	count := fs.Int("count", -1, "Number of instances of the task group.")
+	wait := fs.Duration("wait", 2*time.Minute, "How long to wait for the evaluation to complete.")
+	fs.Parse(args)
+	if len(*group) == 0 || *count < 0 {
+		return errors.New("scale requires -group and -count")
+	}
+
+	evalID, err := nomad.ScaleJob(p.nomad, p.clarifyJob(), *group, *count, "scaled by "+p.initiator)
+	if err != nil {
+		return err
+	}
+	p.publish("scaled", fmt.Sprintf("%s=%d", *group, *count))
+	fmt.Printf("scaling clarify group %s to %d (eval=%s)\n", *group, *count, evalID)
+	if len(evalID) == 0 {
+		return nil
+	}
+	return p.monitorEvaluation(evalID, *wait)
+}
+
+// monitorEvaluation polls the evaluation until it completes, failing when it
+// couldn't place every allocation
+func (p *program) monitorEvaluation(id string, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		eval, err := nomad.GetEvaluation(p.nomad, id)
+		if err != nil {
+			return err
+		}
+		switch eval.Status {
+		case "complete":
+			if len(eval.FailedTGAllocs) != 0 {
+				return fmt.Errorf("evaluation %s couldn't place every allocation (blocked=%s)", id, eval.BlockedEval)
+			}
+			fmt.Printf("evaluation %s complete\n", id)
+			return nil
+		case "failed", "canceled":
+			return fmt.Errorf("evaluation %s %s: %s", id, eval.Status, eval.StatusDesc)
+		}
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("timed out waiting for evaluation %s", id)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/sealed"
+)
+
+// configKey is the key file sealed -config values are opened with
+var configKey string
+
+// sealValue encrypts the value read from stdin, so it doesn't show in the
+// shell history or the audit log, and prints it for use in the -config file
+func sealValue(args []string) error {
+	fs := flag.NewFlagSet("seal", flag.ExitOnError)
+	keyFile := fs.String("key-file", configKey, "Key file the value is sealed with, generated when missing (defaults to -config-key; dpapi is used on windows when empty).")
+	fs.Parse(args)
+	buf, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	value := strings.TrimRight(string(buf), "\r\n")
+	if len(value) == 0 {
+		return errors.New("usage: echo <value> | seal [-key-file <file>]")
+	}
+	s, err := sealed.Seal([]byte(value), *keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to seal value: %v", err)
+	}
+	fmt.Println(s)
+	return nil
+}
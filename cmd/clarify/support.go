@@ -0,0 +1,178 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/buildinfo"
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/clarify-svc/internal/redact"
+)
+
+// maxLogBytes is how much of the end of each agent log is bundled
+const maxLogBytes = 4 << 20
+
+// bundle writes files into a tar.gz support bundle
+type bundle struct {
+	tw     *tar.Writer
+	prefix string
+	errors []string
+}
+
+// add writes content to the bundle as name
+func (b *bundle) add(name string, content []byte) {
+	hdr := &tar.Header{Name: b.prefix + name, Mode: 0600, Size: int64(len(content)), ModTime: time.Now()}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		b.fail(name, err)
+		return
+	}
+	b.tw.Write(content)
+}
+
+// addJSON writes v as indented json
+func (b *bundle) addJSON(name string, v interface{}, err error) {
+	if err != nil {
+		b.fail(name, err)
+		return
+	}
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b.fail(name, err)
+		return
+	}
+	b.add(name, buf)
+}
+
+// addFile writes the last limit bytes of the file at path, redacting
+// secrets when sanitize is set
+func (b *bundle) addFile(name string, path string, limit int64, sanitize bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		b.fail(name, err)
+		return
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && limit > 0 && fi.Size() > limit {
+		f.Seek(-limit, io.SeekEnd)
+	}
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		b.fail(name, err)
+		return
+	}
+	if sanitize {
+		buf = []byte(redact.String(string(buf)))
+	}
+	b.add(name, buf)
+}
+
+// addGlob adds every file matching pattern beneath dir
+func (b *bundle) addGlob(dir string, pattern string, limit int64) {
+	matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+	for _, m := range matches {
+		b.addFile(filepath.Join(filepath.Base(dir), filepath.Base(m)), m, limit, true)
+	}
+}
+
+func (b *bundle) fail(name string, err error) {
+	b.errors = append(b.errors, fmt.Sprintf("%s: %v", name, err))
+}
+
+// supportBundle collects logs, state, sanitized configs, cluster status and
+// versions into a tar.gz for support tickets
+func (p *program) supportBundle(args []string, wd string) error {
+	fs := flag.NewFlagSet("support-bundle", flag.ExitOnError)
+	out := fs.String("out", "", "Path of the bundle (defaults to <service-name>-support-<time>.tar.gz in the working directory).")
+	consulDir := fs.String("consul-dir", wd, "Directory of the consul wrapper.")
+	nomadDir := fs.String("nomad-dir", wd, "Directory of the nomad wrapper.")
+	fs.Parse(args)
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	if len(*out) == 0 {
+		*out = fmt.Sprintf("%s-support-%s.tar.gz", p.name, stamp)
+	}
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	b := &bundle{tw: tar.NewWriter(gz), prefix: fmt.Sprintf("%s-support-%s/", p.name, stamp)}
+
+	// Versions and status
+	st := p.status()
+	b.addJSON("versions.json", map[string]string{
+		"clarifysvc": version,
+		"go":         runtime.Version(),
+		"os":         runtime.GOOS + "/" + runtime.GOARCH,
+		"nomad":      st.NomadVersion,
+		"consul":     st.ConsulVersion,
+	}, nil)
+	b.addJSON("build.json", buildinfo.Get("clarifysvc"), nil)
+	b.addJSON("status.json", st, nil)
+	if len(st.NodeID) != 0 {
+		node, err := nomad.GetNode(p.nomad, st.NodeID)
+		b.addJSON("nomad/node.json", node, err)
+		allocs, err := nomad.NodeAllocations(p.nomad, st.NodeID)
+		b.addJSON("nomad/allocations.json", allocs, err)
+	}
+	job, err := p.findJob(p.clarifyJob())
+	b.addJSON("nomad/job.json", job, err)
+
+	// A fresh dump from the running service, if it's reachable
+	if body, err := ctlRequest(p.admin, "dump"); err != nil {
+		b.fail("dump", err)
+	} else {
+		var dump struct {
+			File string `json:"file"`
+		}
+		json.Unmarshal(body, &dump)
+		b.addFile("dump.txt", dump.File, 0, true)
+	}
+
+	// Wrapper state and logs
+	b.addFile("state/crashloop.json", p.crashes.Path, 0, false)
+	if p.audit != nil {
+		b.addFile("logs/audit.log", p.audit.Path, maxLogBytes, true)
+	}
+	if len(p.configFile) != 0 {
+		b.addFile("config/clarify.json", p.configFile, 0, true)
+	}
+	b.addGlob(wd, "*.log", maxLogBytes)
+
+	// Agent configs and logs
+	seen := map[string]bool{wd: true}
+	for _, dir := range []string{*consulDir, *nomadDir} {
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		b.addGlob(dir, "*.log", maxLogBytes)
+	}
+	for dir := range seen {
+		b.addGlob(dir, "*.json", 0)
+		b.addGlob(dir, "*.hcl", 0)
+	}
+
+	if len(b.errors) != 0 {
+		b.add("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n"))
+	}
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s\n", *out)
+	return nil
+}
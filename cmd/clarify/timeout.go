@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/pgombola/clarify-svc/internal/errs"
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/gomad/client"
+)
+
+// errNomadTimeout is returned when a nomad call doesn't complete within
+// -nomad-timeout
+var errNomadTimeout = fmt.Errorf("%w: timed out waiting for nomad", errs.ErrNomadUnavailable)
+
+// timeoutErr returns errNomadTimeout when the request was cancelled by the
+// http client's deadline, which internal/nomad sets to -nomad-timeout
+func timeoutErr(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errNomadTimeout
+	}
+	return err
+}
+
+func (p *program) findJob(name string) (*client.Job, error) {
+	job, err := p.newNomad(p.nomad).FindJob(name)
+	return job, timeoutErr(err)
+}
+
+// hostID returns the nomad node of hostname. Errors are marked
+// errs.ErrNodeNotFound when nomad answered without the node and
+// errs.ErrNomadUnavailable otherwise.
+func (p *program) hostID(hostname string) (*client.Host, error) {
+	hosts, err := p.newNomad(p.nomad).Hosts()
+	if err = timeoutErr(err); err != nil {
+		return &client.Host{}, errs.Wrap(errs.ErrNomadUnavailable, err)
+	}
+	for i := range hosts {
+		if hosts[i].Name == hostname {
+			p.journal.SetField("NODE_ID", hosts[i].ID)
+			return &hosts[i], nil
+		}
+	}
+	return &client.Host{}, fmt.Errorf("%w (hostname=%s)", errs.ErrNodeNotFound, hostname)
+}
+
+// drainNode drains the node with the provided id according to spec, or
+// disables drain when spec is nil
+func (p *program) drainNode(op *operation, id string, spec *nomad.DrainSpec) (int, error) {
+	status, err := p.newNomad(op.nomad).Drain(id, spec)
+	return status, timeoutErr(err)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/gomad/client"
+)
+
+// Job actions taken with -control uninstall
+const (
+	uninstallJobNone  = ""
+	uninstallJobStop  = "stop"
+	uninstallJobPurge = "purge"
+)
+
+func validUninstallJob(action string) error {
+	switch action {
+	case uninstallJobNone, uninstallJobStop, uninstallJobPurge:
+		return nil
+	}
+	return fmt.Errorf("invalid uninstall job action %q; expected %s or %s", action, uninstallJobStop, uninstallJobPurge)
+}
+
+// uninstall stops the clarify job, then drains this node, stops its nomad
+// agent and purges it from the cluster before the service is uninstalled
+func (p *program) uninstall(job string, removeNode bool, wait time.Duration, prefix string) error {
+	if job != uninstallJobNone {
+		err := nomad.StopJob(p.nomad, p.clarifyJob(), job == uninstallJobPurge)
+		p.audit.Record(job+"_job", p.initiator, err, p.clarifyJob())
+		if err != nil {
+			return fmt.Errorf("unable to %s clarify job: %v", job, err)
+		}
+		p.logger.Infof("%s clarify job", map[string]string{uninstallJobStop: "stopped", uninstallJobPurge: "purged"}[job])
+	}
+	if removeNode {
+		node, err := p.hostID(p.hostname)
+		if err != nil {
+			return fmt.Errorf("unable to find node: %v", err)
+		}
+		if !node.Drain {
+			if err := p.drain(); err != nil {
+				return err
+			}
+		}
+		if err := p.waitForAllocs(node.ID, wait); err != nil {
+			return err
+		}
+		server, err := p.purgeServer("")
+		if err != nil {
+			return err
+		}
+		if err := stopService(prefix + "-nomad"); err != nil {
+			return fmt.Errorf("unable to stop %s-nomad: %v", prefix, err)
+		}
+		if err := p.purgeNode(server, node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeServer returns the nomad server the node is purged through once its
+// own agent is stopped: the via address, or else an alive server other than
+// this node reached on the local agent's http port
+func (p *program) purgeServer(via string) (*nomad.Server, error) {
+	if len(via) != 0 {
+		scheme, address, port, err := parseAddress(via, p.nomad.Port)
+		if err != nil {
+			return nil, err
+		}
+		if scheme != nomad.Scheme {
+			return nil, fmt.Errorf("-purge-via %s must use the -nomad scheme, %s", via, nomad.Scheme)
+		}
+		return &nomad.Server{Address: address, Port: port}, nil
+	}
+	members, err := nomad.Members(p.nomad)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list nomad servers: %v", err)
+	}
+	for _, m := range members {
+		// Nomad server members are named <node>.<region>
+		if m.Status != "alive" || m.Name == p.hostname || strings.HasPrefix(m.Name, p.hostname+".") {
+			continue
+		}
+		return &nomad.Server{Address: m.Addr, Port: p.nomad.Port}, nil
+	}
+	return nil, errors.New("no other alive nomad server to purge the node through; use -purge-via")
+}
+
+// purgeNode removes the stopped node from the cluster so it no longer shows
+// in the node status of the servers
+func (p *program) purgeNode(server *nomad.Server, node *client.Host) error {
+	err := nomad.PurgeNode(server, node.ID)
+	p.audit.Record("purge_node", p.initiator, err, node.Name)
+	if err != nil {
+		return fmt.Errorf("unable to purge node: %v", err)
+	}
+	p.logger.Infof("purged node from the cluster (name=%s;id=%s;server=%s:%d)", node.Name, node.ID, server.Address, server.Port)
+	return nil
+}
+
+// waitForAllocs waits until no allocations are running on the node
+func (p *program) waitForAllocs(id string, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		allocs, err := nomad.NodeAllocations(p.nomad, id)
+		if err != nil {
+			return err
+		}
+		running := 0
+		for _, alloc := range allocs {
+			if alloc.ClientStatus == "running" || alloc.ClientStatus == "pending" {
+				running++
+			}
+		}
+		if running == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d allocations still running on the node after %v", running, wait)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
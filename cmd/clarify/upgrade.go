@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// upgrade rolls the clarify job to a new job specification one task group
+// at a time, waiting for each group's deployment to become healthy before
+// the next. Nomad rolls each group through the nodes according to its
+// update stanza. A failed step reverts the job to its version before the
+// upgrade.
+func (p *program) upgrade(args []string) (err error) {
+	fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	source := fs.String("spec", "", "Job specification to upgrade to, a file in the clarify directory or an http url (defaults to the -launch source, which the service's -redeploy policy keeps reconciling against).")
+	planOnly := fs.Bool("plan", false, "Prints the plan of the upgrade without applying it.")
+	order := fs.String("groups", "", "Comma separated order the task groups are upgraded in (defaults to their order in the job specification).")
+	timeout := fs.Duration("step-timeout", 10*time.Minute, "How long each group's deployment may take to become healthy.")
+	rollback := fs.Bool("rollback", true, "Reverts the job to its version before the upgrade when a step fails.")
+	fs.Parse(args)
+
+	op := p.begin("upgrade")
+	defer func() {
+		op.end(err)
+	}()
+	spec, err := p.upgradeSpec(*source)
+	if err != nil {
+		return err
+	}
+	plan, err := nomad.Plan(op.nomad, spec)
+	if err != nil {
+		return err
+	}
+	printPlan(plan)
+	if plan.Diff.Type == "None" {
+		fmt.Println("clarify job is already up to date")
+		return nil
+	}
+	if len(plan.FailedTGAllocs) != 0 {
+		return fmt.Errorf("nomad can't place every allocation of the new job (%s)", strings.Join(explainPlacement(plan.FailedTGAllocs), "; "))
+	}
+	if *planOnly {
+		return nil
+	}
+	unlock, err := p.lockRedeploys()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	groups, err := upgradeOrder(spec, splitList(*order))
+	if err != nil {
+		return err
+	}
+	current, version, err := nomad.GetJob(op.nomad, p.clarifyJob())
+	if err != nil {
+		return err
+	}
+	p.publish("upgrade_started", fmt.Sprintf("from version %d", version))
+	for i, group := range groups {
+		step, err := upgradeStep(current, spec, groups[:i+1])
+		if err == nil {
+			err = p.upgradeGroup(op, step, group, *timeout)
+		}
+		if err == nil {
+			fmt.Printf("group %s upgraded (%d/%d)\n", group, i+1, len(groups))
+			continue
+		}
+		err = fmt.Errorf("upgrading group %s: %v", group, err)
+		p.notifier.Notify("upgrade_failed", err.Error())
+		if !*rollback {
+			return err
+		}
+		fmt.Printf("reverting clarify to version %d\n", version)
+		if revertErr := nomad.RevertJob(op.nomad, p.clarifyJob(), version); revertErr != nil {
+			return fmt.Errorf("%v; reverting to version %d failed: %v", err, version, revertErr)
+		}
+		p.publish("upgrade_reverted", fmt.Sprintf("to version %d", version))
+		return fmt.Errorf("%v; reverted to version %d", err, version)
+	}
+	p.publish("upgrade_finished", strings.Join(groups, ","))
+	fmt.Println("clarify upgraded")
+	return nil
+}
+
+// lockRedeploys holds the redeploy lock for the upgrade, so no node under
+// -redeploy auto resubmits the -launch specification over a job that is
+// partway through its groups. The upgrade holds it as <hostname>/upgrade to
+// tell it apart from the service on the same node.
+func (p *program) lockRedeploys() (func(), error) {
+	lock := *p.redeployLock
+	lock.Holder += "/upgrade"
+	key, err := lock.TryAcquire()
+	if err != nil {
+		return nil, fmt.Errorf("acquiring redeploy lock: %v", err)
+	}
+	if len(key) == 0 {
+		return nil, errors.New("redeploy lock held; a node is resubmitting or upgrading clarify")
+	}
+	done := make(chan struct{})
+	go lock.Keep(done, p.lockRenewFailed)
+	return func() {
+		close(done)
+		if err := lock.Release(); err != nil {
+			p.logger.Warningf("error releasing redeploy lock: %v", err)
+		}
+	}, nil
+}
+
+// upgradeSpec reads the job specification to upgrade to
+func (p *program) upgradeSpec(source string) ([]byte, error) {
+	if len(source) == 0 {
+		return p.stagedJobSpec()
+	}
+	var spec []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		spec, err = fetchSpec(source)
+	} else {
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(p.clarify, source)
+		}
+		spec, err = ioutil.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := p.prestageArtifacts(spec); err != nil {
+		return nil, err
+	}
+	return p.prepareSpec(spec)
+}
+
+func fetchSpec(u string) ([]byte, error) {
+	resp, err := specClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status: %v", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func printPlan(plan *nomad.JobPlan) {
+	fmt.Printf("job: %s\n", plan.Diff.Type)
+	for _, group := range plan.Diff.TaskGroups {
+		updates := make([]string, 0, len(group.Updates))
+		for kind, n := range group.Updates {
+			if n != 0 {
+				updates = append(updates, fmt.Sprintf("%s=%d", kind, n))
+			}
+		}
+		sort.Strings(updates)
+		fmt.Printf("group %s: %s (%s)\n", group.Name, group.Type, strings.Join(updates, " "))
+	}
+	if len(plan.Warnings) != 0 {
+		fmt.Printf("warnings: %s\n", plan.Warnings)
+	}
+}
+
+// upgradeOrder returns the task groups of spec in the order they're
+// upgraded: the given ones first, then the others in spec order
+func upgradeOrder(spec []byte, first []string) ([]string, error) {
+	var wrapped struct {
+		Job struct {
+			TaskGroups []struct {
+				Name string `json:"Name"`
+			} `json:"TaskGroups"`
+		} `json:"Job"`
+	}
+	if err := json.Unmarshal(spec, &wrapped); err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, group := range wrapped.Job.TaskGroups {
+		known[group.Name] = true
+	}
+	order := make([]string, 0, len(known))
+	seen := make(map[string]bool)
+	for _, name := range first {
+		if !known[name] {
+			return nil, fmt.Errorf("-groups: the job specification has no group %s", name)
+		}
+		if !seen[name] {
+			order = append(order, name)
+			seen[name] = true
+		}
+	}
+	for _, group := range wrapped.Job.TaskGroups {
+		if !seen[group.Name] {
+			order = append(order, group.Name)
+			seen[group.Name] = true
+		}
+	}
+	return order, nil
+}
+
+// upgradeStep returns the job specification with the upgraded groups taken
+// from spec and the other groups still as registered in current
+func upgradeStep(current json.RawMessage, spec []byte, upgraded []string) ([]byte, error) {
+	var running map[string]interface{}
+	if err := json.Unmarshal(current, &running); err != nil {
+		return nil, err
+	}
+	var wrapped map[string]interface{}
+	if err := json.Unmarshal(spec, &wrapped); err != nil {
+		return nil, err
+	}
+	job, ok := wrapped["Job"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("job specification has no Job object")
+	}
+	previous := make(map[string]interface{})
+	runningGroups, _ := running["TaskGroups"].([]interface{})
+	for _, g := range runningGroups {
+		if group, ok := g.(map[string]interface{}); ok {
+			name, _ := group["Name"].(string)
+			previous[name] = group
+		}
+	}
+	upgrade := make(map[string]bool, len(upgraded))
+	for _, name := range upgraded {
+		upgrade[name] = true
+	}
+	groups, _ := job["TaskGroups"].([]interface{})
+	step := make([]interface{}, 0, len(groups))
+	for _, g := range groups {
+		group, _ := g.(map[string]interface{})
+		name, _ := group["Name"].(string)
+		if old, ok := previous[name]; ok && !upgrade[name] {
+			step = append(step, old)
+			continue
+		}
+		if !upgrade[name] {
+			// New groups are added at their step
+			continue
+		}
+		step = append(step, group)
+	}
+	job["TaskGroups"] = step
+	return json.Marshal(wrapped)
+}
+
+// upgradeGroup submits one step of the upgrade and waits for its deployment
+// to become healthy, promoting healthy canaries
+func (p *program) upgradeGroup(op *operation, step []byte, group string, timeout time.Duration) error {
+	changed, err := nomad.PlanJob(op.nomad, step)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fmt.Printf("group %s unchanged\n", group)
+		return nil
+	}
+	if err := p.checkDrivers(op.nomad, step); err != nil {
+		return err
+	}
+	fmt.Printf("upgrading group %s\n", group)
+	if err := nomad.SubmitJob(op.nomad, step); err != nil {
+		return err
+	}
+	_, version, err := nomad.GetJob(op.nomad, p.clarifyJob())
+	if err != nil {
+		return err
+	}
+	return p.awaitDeployment(op.nomad, version, group, timeout)
+}
+
+// awaitDeployment waits for the deployment of the job version to succeed.
+// Jobs without an update stanza have no deployment; the group's
+// allocations are checked instead.
+func (p *program) awaitDeployment(server *nomad.Server, version uint64, group string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	noDeployment := time.Now().Add(time.Minute)
+	for time.Now().Before(deadline) {
+		time.Sleep(5 * time.Second)
+		d, err := nomad.LatestDeployment(server, p.clarifyJob())
+		if err != nil || d.JobVersion != version {
+			if time.Now().After(noDeployment) {
+				return p.checkGroupRunning(server, group)
+			}
+			continue
+		}
+		switch d.Status {
+		case "successful":
+			return nil
+		case "failed", "cancelled":
+			return fmt.Errorf("deployment %s %s: %s", d.ID, d.Status, d.StatusDescription)
+		}
+		if d.CanariesHealthy() {
+			fmt.Printf("promoting canaries (deployment=%s)\n", d.ID)
+			if err := nomad.PromoteDeployment(server, d.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("deployment of version %d not healthy after %v", version, timeout)
+}
+
+// checkGroupRunning verifies every allocation of the group meant to run is
+// running
+func (p *program) checkGroupRunning(server *nomad.Server, group string) error {
+	allocs, err := nomad.JobAllocations(server, p.clarifyJob())
+	if err != nil {
+		return err
+	}
+	running := 0
+	for _, alloc := range allocs {
+		if alloc.TaskGroup != group || alloc.DesiredStatus != "run" {
+			continue
+		}
+		if alloc.ClientStatus != "running" {
+			return fmt.Errorf("allocation %s is %s", alloc.ID, alloc.ClientStatus)
+		}
+		running++
+	}
+	if running == 0 {
+		return fmt.Errorf("no running allocation of group %s", group)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/clarify-svc/internal/redact"
+	"github.com/pgombola/clarify-svc/internal/vault"
+)
+
+// vaultConfig describes which secrets are read from vault at startup
+type vaultConfig struct {
+	address      string
+	roleID       string
+	secretIDFile string
+	nomadToken   string
+	consulToken  string
+	tls          string
+	tlsDir       string
+}
+
+// loadSecrets authenticates with vault, applies the configured ACL tokens,
+// issues TLS material and keeps every lease renewed until the program exits
+func (p *program) loadSecrets(cfg *vaultConfig) error {
+	v := vault.NewClient(cfg.address, os.Getenv("VAULT_TOKEN"))
+	renewToken := true
+	if len(cfg.roleID) != 0 {
+		secretID, err := ioutil.ReadFile(cfg.secretIDFile)
+		if err != nil {
+			return fmt.Errorf("unable to read vault secret-id: %v", err)
+		}
+		login, err := v.LoginAppRole(cfg.roleID, strings.TrimSpace(string(secretID)))
+		if err != nil {
+			return err
+		}
+		renewToken = login.Auth.Renewable
+	} else if len(v.Token) == 0 {
+		return errors.New("vault requires VAULT_TOKEN or -vault-role-id")
+	}
+	redact.Add(v.Token)
+
+	leases := make([]*vault.Secret, 0)
+	if len(cfg.nomadToken) != 0 {
+		token, secret, err := v.ReadField(cfg.nomadToken)
+		if err != nil {
+			return err
+		}
+		nomad.Token = token
+		redact.Add(token)
+		leases = append(leases, secret)
+	}
+	if len(cfg.consulToken) != 0 {
+		token, secret, err := v.ReadField(cfg.consulToken)
+		if err != nil {
+			return err
+		}
+		p.consul.Token = token
+		redact.Add(token)
+		leases = append(leases, secret)
+	}
+	var expires time.Time
+	if len(cfg.tls) != 0 {
+		var err error
+		if expires, err = p.issueCert(v, cfg); err != nil {
+			return err
+		}
+	}
+	p.logger.Info("loaded secrets from vault")
+	p.crash.Go(func() { p.renewSecrets(v, cfg, renewToken, leases, expires) })
+	return nil
+}
+
+func (p *program) renewSecrets(v *vault.Client, cfg *vaultConfig, renewToken bool, leases []*vault.Secret, expires time.Time) {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if renewToken {
+				if _, err := v.RenewSelf(); err != nil {
+					p.logger.Warningf("error renewing vault token: %v", err)
+				}
+			}
+			for _, lease := range leases {
+				if !lease.Renewable || len(lease.LeaseID) == 0 {
+					continue
+				}
+				if _, err := v.RenewLease(lease.LeaseID); err != nil {
+					p.logger.Warningf("error renewing vault lease (id=%s): %v", lease.LeaseID, err)
+				}
+			}
+			if len(cfg.tls) != 0 && time.Until(expires) < time.Hour {
+				if e, err := p.issueCert(v, cfg); err != nil {
+					p.logger.Warningf("error reissuing tls certificate: %v", err)
+				} else {
+					expires = e
+				}
+			}
+		case <-p.exit:
+			return
+		}
+	}
+}
+
+// issueCert issues a certificate for this host from the vault pki path and
+// writes it to the tls directory
+// Returns when the certificate expires
+func (p *program) issueCert(v *vault.Client, cfg *vaultConfig) (time.Time, error) {
+	secret, err := v.Write(cfg.tls, map[string]string{"common_name": p.hostname})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := os.MkdirAll(cfg.tlsDir, 0700); err != nil {
+		return time.Time{}, err
+	}
+	files := map[string]string{"certificate": "cert.pem", "private_key": "key.pem", "issuing_ca": "ca.pem"}
+	for field, name := range files {
+		pem, err := secret.Field(field)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(cfg.tlsDir, name), []byte(pem), 0600); err != nil {
+			return time.Time{}, err
+		}
+	}
+	expiration, _ := secret.Data["expiration"].(float64)
+	p.logger.Infof("issued tls certificate (dir=%s)", cfg.tlsDir)
+	return time.Unix(int64(expiration), 0), nil
+}
@@ -0,0 +1,12 @@
+package main
+
+import "errors"
+
+// killAgent kills the agent as a crash would, for chaos testing
+func (p *consul) killAgent() error {
+	cmd := p.cmd
+	if cmd == nil || cmd.Process == nil {
+		return errors.New("consul isn't running")
+	}
+	return cmd.Process.Kill()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -10,32 +11,148 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/agentconfig"
+	"github.com/pgombola/clarify-svc/internal/audit"
+	"github.com/pgombola/clarify-svc/internal/backup"
+	"github.com/pgombola/clarify-svc/internal/buildinfo"
+	"github.com/pgombola/clarify-svc/internal/certs"
+	"github.com/pgombola/clarify-svc/internal/chaos"
+	"github.com/pgombola/clarify-svc/internal/crash"
+	"github.com/pgombola/clarify-svc/internal/crashloop"
+	"github.com/pgombola/clarify-svc/internal/dedup"
+	"github.com/pgombola/clarify-svc/internal/eventid"
+	"github.com/pgombola/clarify-svc/internal/journald"
+	"github.com/pgombola/clarify-svc/internal/metrics"
+	"github.com/pgombola/clarify-svc/internal/notify"
+	"github.com/pgombola/clarify-svc/internal/pidfile"
+	"github.com/pgombola/clarify-svc/internal/preflight"
+	"github.com/pgombola/clarify-svc/internal/procstat"
+	"github.com/pgombola/clarify-svc/internal/proxy"
+	"github.com/pgombola/clarify-svc/internal/redact"
+	"github.com/pgombola/clarify-svc/internal/runas"
+	"github.com/pgombola/clarify-svc/internal/scm"
 )
 
 type consul struct {
-	logger  service.Logger
-	verbose *bool
-	path    string
-	config  string
-	cmd     *exec.Cmd
-	exit    chan struct{}
+	name           string
+	logger         service.Logger
+	verbose        *bool
+	path           string
+	extraArgs      []string
+	config         string
+	runAs          string
+	encrypt        string
+	join           string
+	keyFile        string
+	certs          *certs.Rotator
+	watchCerts     sync.Once
+	snapshots      *backup.Schedule
+	startSnapshots sync.Once
+	raftInterval   time.Duration
+	startRaft      sync.Once
+	usageInterval  time.Duration
+	usageFile      string
+	startUsage     sync.Once
+	chaos          *chaos.Injector
+	startChaos     sync.Once
+	journal        *journald.Logger
+	quorum         string
+	restart        int32
+	readyTimeout   time.Duration
+	cmd            *exec.Cmd
+	audit          *audit.Log
+	crashes        *crashloop.Tracker
+	crash          *crash.Reporter
+	notifier       *notify.Notifier
+	metrics        *metrics.Statsd
+	exit           chan struct{}
 }
 
 func (p *consul) Start(s service.Service) error {
-	p.logger.Infof("Starting Clarify-Consul(exe=%s,config=%s)", p.path, p.config)
-	p.cmd = exec.Command(p.path, "agent", "-config-file", p.config)
+	defer p.crash.Recover()
+	if state, err := p.crashes.Load(); err == nil && state.Quarantined {
+		p.logger.Errorf("%s is quarantined (%s); run resume to start it again", p.name, state.Reason)
+		return nil
+	}
+	eventid.Info(p.logger, eventid.ServiceStarted, "Starting %s(exe=%s,config=%s)", p.name, p.path, p.config)
+	args := []string{"agent", "-config-file", p.config}
+	encrypt, err := p.encryptConfig()
+	if err != nil {
+		p.logger.Errorf("unable to configure gossip encryption: %v", err)
+		return err
+	}
+	if len(encrypt) != 0 {
+		args = append(args, "-config-file", encrypt)
+	}
+	tlsConfig, err := p.prepareTLS()
+	if err != nil {
+		p.logger.Errorf("unable to configure tls: %v", err)
+		return err
+	}
+	if len(tlsConfig) != 0 {
+		args = append(args, "-config-file", tlsConfig)
+	}
+	joins, err := p.joinArgs()
+	if err != nil {
+		p.logger.Errorf("unable to discover servers: %v", err)
+		return err
+	}
+	args = append(args, joins...)
+	args = append(args, p.extraArgs...)
+	p.cmd = exec.Command(p.path, args...)
 	if *p.verbose {
-		p.cmd.Stdout = os.Stdout
-		p.cmd.Stderr = os.Stderr
+		p.cmd.Stdout = redact.Writer(os.Stdout)
+		p.cmd.Stderr = redact.Writer(os.Stderr)
+	}
+	if err := preflight.PortsFree(configuredPorts(p.config)); err != nil {
+		p.logger.Error(err)
+		return err
+	}
+	if err := runas.Apply(p.cmd, p.runAs); err != nil {
+		p.logger.Error(err)
+		return err
+	}
+	p.audit.Record("start", "service-manager", nil, p.path)
+	p.metrics.Incr("agent.starts")
+	if err := p.cmd.Start(); err != nil {
+		p.logger.Errorf("unable to start consul: %v", err)
+		return err
+	}
+	done := wait(p.cmd)
+	if err := p.waitReady(done); err != nil {
+		eventid.Error(p.logger, eventid.StartFailed, "%v", err)
+		p.metrics.Incr("agent.ready_timeouts")
+		p.cmd.Process.Kill()
+		return err
 	}
-	go p.run()
+	p.watchSnapshots()
+	p.watchRaft()
+	p.startUsage.Do(func() {
+		p.crash.Go(p.watchUsage)
+	})
+	p.startChaos.Do(func() {
+		p.crash.Go(func() { p.chaos.Kill(p.exit, p.killAgent) })
+	})
+	p.crash.Go(func() { p.run(done) })
 	return nil
 }
 
 func (p *consul) Stop(s service.Service) error {
-	p.logger.Info("Stopping Clarify-Consul")
+	defer p.crash.Recover()
+	eventid.Info(p.logger, eventid.ServiceStopped, "Stopping %s", p.name)
+	if p.cmd == nil || p.cmd.Process == nil {
+		p.audit.Record("stop", "service-manager", nil, "")
+		close(p.exit)
+		return nil
+	}
+	p.prepareStop()
+	p.audit.Record("stop", "service-manager", nil, "")
 	close(p.exit)
 	// https://github.com/golang/go/issues/6720
 	if runtime.GOOS == "windows" {
@@ -51,25 +168,62 @@ func (p *consul) Stop(s service.Service) error {
 	return nil
 }
 
-func (p *consul) run() {
-	p.cmd.Start()
-	done := wait(p.cmd)
+func (p *consul) run(done chan error) {
 	select {
 	// The consul child process has exited
 	case err := <-done:
 		switch err.(type) {
 		case *exec.ExitError:
-			p.logger.Errorf("Consul process exited:\n%v", err)
-			os.Exit(1)
+			eventid.Error(p.logger, eventid.AgentCrashed, "Consul process exited:\n%v", err)
 		default:
-			p.logger.Info("Consul process exited gracefully.")
-			os.Exit(1)
+			eventid.Info(p.logger, eventid.AgentExited, "Consul process exited gracefully.")
+		}
+		p.metrics.Incr("agent.exits")
+		if atomic.CompareAndSwapInt32(&p.restart, 1, 0) {
+			if err := p.Start(nil); err != nil {
+				os.Exit(1)
+			}
+			return
 		}
+		if p.quarantine(fmt.Sprintf("consul exited: %v", err)) {
+			<-p.exit
+			return
+		}
+		os.Exit(1)
 	case <-p.exit:
 		return
 	}
 }
 
+// quarantine records the agent's exit and reports whether it restarted too
+// often and must wait for an operator to resume it
+func (p *consul) quarantine(reason string) bool {
+	quarantined, err := p.crashes.Record(reason)
+	if err != nil {
+		p.logger.Warningf("unable to record restart: %v", err)
+		return false
+	}
+	if quarantined {
+		eventid.Error(p.logger, eventid.AgentQuarantined, "%s quarantined after repeated restarts; run resume to start it again", p.name)
+		p.metrics.Incr("agent.quarantined")
+		p.notifier.Notify("quarantined", reason)
+	}
+	return quarantined
+}
+
+// crashState is the program state written to crash reports
+func (p *consul) crashState() interface{} {
+	state := map[string]interface{}{
+		"exe":     p.path,
+		"config":  p.config,
+		"restart": atomic.LoadInt32(&p.restart) == 1,
+	}
+	if p.cmd != nil && p.cmd.Process != nil {
+		state["agent_pid"] = p.cmd.Process.Pid
+	}
+	return state
+}
+
 func wait(cmd *exec.Cmd) chan error {
 	done := make(chan error, 1)
 	go func() {
@@ -78,10 +232,20 @@ func wait(cmd *exec.Cmd) chan error {
 	return done
 }
 
+// findFile returns the first file beneath dir whose name matches the
+// pattern name. The running wrapper is never matched so a missing agent
+// isn't replaced by the wrapper itself. Unreadable directories are skipped.
 func findFile(dir string, name string) (result string, err error) {
+	var self os.FileInfo
+	if exe, err := os.Executable(); err == nil {
+		self, _ = os.Stat(exe)
+	}
 	err = filepath.Walk(dir,
-		filepath.WalkFunc(func(fp string, fi os.FileInfo, _ error) error {
-			if fi.IsDir() {
+		filepath.WalkFunc(func(fp string, fi os.FileInfo, walkErr error) error {
+			if walkErr != nil || fi == nil {
+				return nil
+			}
+			if fi.IsDir() || (self != nil && os.SameFile(fi, self)) {
 				return nil
 			}
 			if matched, err := path.Match(name, fi.Name()); err != nil {
@@ -93,16 +257,98 @@ func findFile(dir string, name string) (result string, err error) {
 			return nil
 		}))
 	if err == io.EOF {
-		err = nil
+		return result, nil
+	} else if err != nil {
+		return "", err
 	}
-	return
+	return "", fmt.Errorf("no file matching %s in %s or its subdirectories", name, dir)
+}
+
+// secretFlags map the flags holding secrets, which aren't stored in the
+// service definition, to the file flags the service is installed with
+var secretFlags = map[string]string{
+	"snapshot-token": "snapshot-token-file",
+}
+
+// serviceArgs returns the flags given on the command line, minus -control, so
+// the installed service runs with the same configuration. A literal gossip
+// key is stored in the key file on install rather than in the arguments.
+func serviceArgs() []string {
+	args := make([]string, 0)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "encrypt" && f.Value.String() != generateKey {
+			return
+		}
+		if len(secretFlags[f.Name]) != 0 {
+			return
+		}
+		if f.Name != "control" {
+			args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		}
+	})
+	return args
 }
 
 func main() {
 	control := flag.String("control", "", fmt.Sprintf("Service control command [%q].", service.ControlAction))
+	exePath := flag.String("consul-exe", "", "Path of the consul executable, or its name to look up in PATH (defaults to the first consul* file beside the wrapper).")
+	flag.StringVar(exePath, "exe-path", "", "Same as -consul-exe.")
+	extraArgs := flag.String("extra-args", "", "Space separated flags also passed to the consul agent, e.g. \"-dev -node=edge-1\".")
 	cfg := flag.String("cfg", "config.json", "The name of the Consul configuration file.")
 	verbose := flag.Bool("v", false, "Logs verbose output from the Consul process to consul.")
+	prefix := flag.String("service-prefix", "clarify", "Prefix of the clarify service names, allowing several installs per host.")
+	name := flag.String("service-name", "", "Name of this service (defaults to <service-prefix>-consul).")
+	runAs := flag.String("run-as", "", "Runs consul as user[:group] (the service account on Windows, password from CLARIFY_RUN_AS_PASSWORD).")
+	crashMax := flag.Int("crash-max", 5, "Restarts within -crash-window before consul is quarantined.")
+	crashWindow := flag.Duration("crash-window", 10*time.Minute, "Window restarts are counted in.")
+	notifyURL := flag.String("notify", "", "Webhook URL that receives alerts.")
+	proxyURL := flag.String("proxy", "", "Proxy outbound http requests such as webhooks go through, http://[user:password@]host:port (defaults to HTTP_PROXY and HTTPS_PROXY).")
+	noProxy := flag.String("no-proxy", "", "Comma separated hosts, domains and cidrs reached without -proxy.")
+	statsdAddr := flag.String("statsd", "", "host:port of a statsd or DogStatsD collector metrics are sent to.")
+	statsdPrefix := flag.String("statsd-prefix", "clarify", "Prefix of the metric names.")
+	statsdTags := flag.String("statsd-tags", "", "Comma separated key:value DogStatsD tags added to every metric.")
+	auditLog := flag.String("audit", "", "Path of the append-only audit log of control actions.")
+	purgeData := flag.Bool("purge-data", false, "With -control uninstall, also deletes the agent's data directory.")
+	join := flag.String("join", "", "Discovery source of the servers to join (srv:<name>, an http url, a provider= cloud query or addresses).")
+	startTimeout := flag.Duration("start-timeout", 10*time.Minute, "How long the service may stay start pending, its TimeoutStartSec under systemd, before failing (0 waits forever).")
+	crashDir := flag.String("crash-dir", "", "Directory crash reports are written to when the service panics (defaults to crashes beside the executable).")
+	pidFile := flag.String("pid-file", "", "Pid file locked while the service runs so only one instance manages the agent (defaults to <name>.pid beside the executable).")
+	quorum := flag.String("quorum-policy", quorumRefuse, fmt.Sprintf("Whether -control stop and restart refuse to stop a server that would lose raft quorum or only warn [%s, %s].", quorumRefuse, quorumWarn))
+	force := flag.Bool("force", false, "With -control stop or restart, stops a server even when it would lose raft quorum.")
+	chaosSpec := chaos.Flag()
+	logDedup := flag.Duration("log-dedup-window", time.Minute, "Repeated warning and info messages are logged once per window (0 disables it).")
+	usageInterval := flag.Duration("usage-interval", 30*time.Second, "How often the agent's cpu, memory and open files are sampled (0 disables it).")
+	raftInterval := flag.Duration("raft-check-interval", time.Minute, "How often a server checks the cluster's raft health, alerting when it's one failure from losing quorum (0 disables it).")
+	readyTimeout := flag.Duration("ready-timeout", 2*time.Minute, "How long start waits for the agent to join the cluster and see a leader (0 disables the wait).")
+	snapshotCfg := &snapshotConfig{}
+	flag.StringVar(&snapshotCfg.dest, "snapshot-dest", "", "Directory or s3://bucket/prefix?endpoint=<url>&region=<region> periodic snapshots are stored in (empty disables them; enable on one server).")
+	flag.DurationVar(&snapshotCfg.interval, "snapshot-interval", time.Hour, "How often a snapshot is taken.")
+	flag.IntVar(&snapshotCfg.keep, "snapshot-keep", 24, "Number of snapshots kept (0 keeps all).")
+	flag.DurationVar(&snapshotCfg.maxAge, "snapshot-max-age", 0, "Deletes snapshots older than this (0 keeps them).")
+	flag.StringVar(&snapshotCfg.token, "snapshot-token", "", "ACL token with the management policy (defaults to CONSUL_HTTP_TOKEN).")
+	flag.StringVar(&snapshotCfg.tokenFile, "snapshot-token-file", "", "File containing the -snapshot-token.")
+	tlsCfg := &tlsConfig{}
+	flag.StringVar(&tlsCfg.caCert, "tls-ca-cert", "", "CA certificate used to sign the agent's TLS certificate.")
+	flag.StringVar(&tlsCfg.caKey, "tls-ca-key", "", "Private key of -tls-ca-cert.")
+	flag.StringVar(&tlsCfg.vault, "tls-vault", "", "Vault PKI issue path the agent's TLS certificate is issued from (VAULT_ADDR and VAULT_TOKEN).")
+	flag.StringVar(&tlsCfg.dir, "tls-dir", "tls", "Directory the agent's TLS certificate is written to.")
+	flag.StringVar(&tlsCfg.names, "tls-names", "", "Comma separated extra DNS names and IPs of the agent's TLS certificate.")
+	flag.DurationVar(&tlsCfg.ttl, "tls-ttl", 30*24*time.Hour, "Validity of certificates signed with -tls-ca-cert.")
+	flag.DurationVar(&tlsCfg.renew, "tls-renew", 72*time.Hour, "Reissues the TLS certificate once it's within this long of expiring.")
+	encrypt := flag.String("encrypt", os.Getenv("CONSUL_GOSSIP_KEY"), fmt.Sprintf("Base64 gossip encryption key, or %q to create one on first start.", generateKey))
 	flag.Parse()
+	if len(*name) == 0 {
+		*name = *prefix + "-consul"
+	}
+	if *quorum != quorumRefuse && *quorum != quorumWarn {
+		log.Fatalf("invalid -quorum-policy %q", *quorum)
+	}
+	proxyCfg, err := proxy.Parse(*proxyURL, *noProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	proxy.Install(proxyCfg)
+	redact.Add(proxyCfg.Password())
 
 	// Program
 	var prg *consul
@@ -111,13 +357,76 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		exe, _ := findFile(wd, "consul*")
+		if len(*pidFile) == 0 {
+			*pidFile = filepath.Join(wd, *name+".pid")
+		}
+		if len(*crashDir) == 0 {
+			*crashDir = filepath.Join(wd, "crashes")
+		}
+		// The agent is needed to run or install the service, not for
+		// subcommands or other control actions
+		needsAgent := flag.NArg() == 0 && (len(*control) == 0 || *control == "install")
+		exe := *exePath
+		if len(exe) == 0 {
+			exe, err = findFile(wd, "consul*")
+		} else if exe, err = exec.LookPath(exe); err != nil {
+			err = fmt.Errorf("-consul-exe: %v", err)
+		}
+		if err != nil && needsAgent {
+			log.Fatalf("unable to find the consul executable: %v; install consul beside %s or set -consul-exe", err, wd)
+		}
 		config, _ := findFile(wd, *cfg)
+		if len(config) == 0 && flag.NArg() == 0 && len(*control) == 0 {
+			// First start without a config; the agent would run with none
+			if config, err = agentconfig.Materialize(agentconfig.Consul, nil, wd, *cfg); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("no %s found; wrote the default config to %s", *cfg, config)
+		}
+		rotator, err := newRotator(tlsCfg, wd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		snapshots, err := newSnapshots(snapshotCfg, wd, *name, configuredPortMap(config)["http"])
+		if err != nil {
+			log.Fatal(err)
+		}
+		hostname, _ := os.Hostname()
+		sink, err := metrics.New(*statsdAddr, *statsdPrefix, metrics.Tags(*statsdTags, "host:"+hostname, "service:"+*name))
+		if err != nil {
+			log.Fatal(err)
+		}
 		prg = &consul{
-			path:    exe,
-			verbose: verbose,
-			config:  config,
-			exit:    make(chan struct{}, 1),
+			path:          exe,
+			extraArgs:     strings.Fields(*extraArgs),
+			verbose:       verbose,
+			config:        config,
+			audit:         audit.Open(*auditLog, *name),
+			name:          *name,
+			runAs:         *runAs,
+			certs:         rotator,
+			snapshots:     snapshots,
+			encrypt:       *encrypt,
+			join:          *join,
+			readyTimeout:  *readyTimeout,
+			raftInterval:  *raftInterval,
+			usageInterval: *usageInterval,
+			usageFile:     filepath.Join(wd, *name+".usage.json"),
+			quorum:        *quorum,
+			keyFile:       filepath.Join(wd, *name+".gossip.key"),
+			crashes: &crashloop.Tracker{
+				Path:   filepath.Join(wd, *name+".crashloop.json"),
+				Max:    *crashMax,
+				Window: *crashWindow,
+			},
+			crash: &crash.Reporter{
+				Dir:         *crashDir,
+				Name:        *name,
+				Fingerprint: crash.Fingerprint(os.Args[1:], config),
+			},
+			notifier: notify.New(*notifyURL, *name),
+			metrics:  sink,
+			exit:     make(chan struct{}, 1),
 		}
 	}
 
@@ -125,11 +434,12 @@ func main() {
 	var s service.Service
 	{
 		svcConfig := &service.Config{
-			Name:        "clarify-consul",
-			DisplayName: "clarify-consul",
-			Description: "clarify-consul service",
-			Arguments:   []string{"-cfg", *cfg},
+			Name:        *name,
+			DisplayName: *name,
+			Description: *name + " service",
+			Arguments:   serviceArgs(),
 		}
+		runas.Configure(svcConfig, *runAs, os.Getenv("CLARIFY_RUN_AS_PASSWORD"))
 		s, _ = service.New(prg, svcConfig)
 	}
 
@@ -141,17 +451,113 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
+		if flag.NArg() == 0 && len(*control) == 0 && !service.Interactive() && journald.Available() {
+			journal, err := journald.New(*name, map[string]string{"UNIT": *name + ".service"})
+			if err != nil {
+				log.Fatal(err)
+			}
+			prg.journal = journal
+			logger = journal
+		}
+		logger = dedup.New(redact.Logger(logger), *logDedup)
 		prg.logger = logger
+		prg.crash.Logger = logger
+		prg.crash.State = prg.crashState
+	}
+
+	// Chaos testing
+	if flag.NArg() == 0 && len(*control) == 0 {
+		injector, err := chaos.Parse(*chaosSpec, logger)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if injector != nil {
+			logger.Warningf("chaos mode enabled (spec=%s)", *chaosSpec)
+			prg.chaos = injector
+		}
 	}
 
-	// Run control command or start program
+	// Run subcommand, control command or start program
+	if flag.NArg() != 0 {
+		switch flag.Arg(0) {
+		case "preflight":
+			prg.runPreflight(flag.Args()[1:])
+		case "rotate-gossip-key":
+			prg.rotateGossipKey(flag.Args()[1:])
+		case "snapshot":
+			prg.snapshot(flag.Args()[1:])
+		case "version":
+			if err := buildinfo.Command("consulsvc", flag.Args()[1:], os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+		case "status":
+			state, err := prg.crashes.Load()
+			if err != nil {
+				log.Fatal(err)
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(struct {
+				*crashloop.State
+				Usage *procstat.Usage `json:"usage,omitempty"`
+			}{state, prg.usage()})
+		case "resume":
+			if err := prg.crashes.Resume(); err != nil {
+				log.Fatal(err)
+			}
+			prg.audit.Record("resume", audit.User(), nil, "")
+			if err := s.Restart(); err != nil {
+				log.Printf("unable to restart service: %v", err)
+			}
+		default:
+			log.Fatalf("unknown command %q", flag.Arg(0))
+		}
+		return
+	}
 	if len(*control) != 0 {
-		if err := service.Control(s, *control); err != nil {
+		if *control == "install" {
+			if err := scm.CheckSecrets(secretFlags); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *control == "install" && len(*encrypt) != 0 && *encrypt != generateKey {
+			if err := validKey(*encrypt); err != nil {
+				log.Fatal(err)
+			}
+			if err := prg.storeKey(*encrypt); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := prg.checkStop(*control, *force); err != nil {
+			prg.audit.Record(*control, audit.User(), err, "")
+			log.Fatal(err)
+		}
+		err := scm.Control(s, *control, *name, *startTimeout)
+		prg.audit.Record(*control, audit.User(), err, "")
+		if err != nil {
 			log.Fatal(err)
 		}
+		if *control == "uninstall" && *purgeData {
+			data := dataDir(prg.config)
+			if len(data) == 0 {
+				log.Fatal("consul config has no data_dir")
+			}
+			err := os.RemoveAll(data)
+			prg.audit.Record("purge_data", audit.User(), err, data)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
 		return
 	}
-	if err := s.Run(); err != nil {
+	pid, err := pidfile.Acquire(*pidFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer prg.crash.Recover()
+	err = scm.Run(s, prg, *name, *startTimeout)
+	pid.Release()
+	if err != nil {
 		logger.Error(err)
 	}
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/audit"
+	api "github.com/pgombola/clarify-svc/internal/consul"
+	"github.com/pgombola/clarify-svc/internal/redact"
+)
+
+// generateKey is the -encrypt value that creates a gossip key on first start
+const generateKey = "generate"
+
+// gossipKey returns a new random 32 byte gossip encryption key
+func gossipKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// validKey reports whether key decodes to a 16, 24 or 32 byte aes key
+func validKey(key string) error {
+	buf, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("gossip key isn't base64: %v", err)
+	}
+	switch len(buf) {
+	case 16, 24, 32:
+		return nil
+	}
+	return fmt.Errorf("gossip key must be 16, 24 or 32 bytes, got %d", len(buf))
+}
+
+// encryptKey returns the gossip key the agent starts with: the key stored
+// in the key file from a previous start or rotation, then -encrypt, then a
+// new key when -encrypt is "generate"
+func (p *consul) encryptKey() (string, error) {
+	if buf, err := ioutil.ReadFile(p.keyFile); err == nil {
+		return strings.TrimSpace(string(buf)), nil
+	}
+	key := p.encrypt
+	switch key {
+	case "":
+		return "", nil
+	case generateKey:
+		var err error
+		if key, err = gossipKey(); err != nil {
+			return "", err
+		}
+		p.logger.Info("generated gossip encryption key")
+	}
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return key, p.storeKey(key)
+}
+
+// storeKey persists key so restarts and rotations keep using it
+func (p *consul) storeKey(key string) error {
+	return ioutil.WriteFile(p.keyFile, []byte(key+"\n"), 0600)
+}
+
+// encryptConfig writes the generated agent config holding the gossip key and
+// returns its path, or an empty path when gossip encryption is disabled
+func (p *consul) encryptConfig() (string, error) {
+	key, err := p.encryptKey()
+	if err != nil || len(key) == 0 {
+		return "", err
+	}
+	redact.Add(key)
+	buf, err := json.Marshal(map[string]string{"encrypt": key})
+	if err != nil {
+		return "", err
+	}
+	path := strings.TrimSuffix(p.keyFile, ".key") + ".json"
+	return path, ioutil.WriteFile(path, buf, 0600)
+}
+
+// rotateGossipKey installs a new key across the keyring, makes it primary and
+// removes every other key
+func (p *consul) rotateGossipKey(args []string) {
+	flags := flag.NewFlagSet("rotate-gossip-key", flag.ExitOnError)
+	key := flags.String("key", "", "The new gossip key (generated when empty).")
+	token := flags.String("token", "", "ACL token with operator:write.")
+	flags.Parse(args)
+	if len(*key) == 0 {
+		var err error
+		if *key, err = gossipKey(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := validKey(*key); err != nil {
+		log.Fatal(err)
+	}
+	redact.Add(*key)
+	client := api.NewClient("127.0.0.1", configuredPortMap(p.config)["http"])
+	if err := client.SetToken(*token, ""); err != nil {
+		log.Fatal(err)
+	}
+	err := rotate(client, *key)
+	p.audit.Record("rotate-gossip-key", audit.User(), err, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := p.storeKey(*key); err != nil {
+		log.Fatalf("rotated the keyring but unable to store the key: %v", err)
+	}
+	fmt.Fprintln(os.Stdout, "gossip key rotated")
+}
+
+func rotate(client *api.Client, key string) error {
+	if err := client.InstallKey(key); err != nil {
+		return fmt.Errorf("unable to install key: %v", err)
+	}
+	if err := client.UseKey(key); err != nil {
+		return fmt.Errorf("unable to use key: %v", err)
+	}
+	keys, err := client.Keyring()
+	if err != nil {
+		return fmt.Errorf("unable to list keyring: %v", err)
+	}
+	for old := range keys {
+		if old == key {
+			continue
+		}
+		if err := client.RemoveKey(old); err != nil {
+			return fmt.Errorf("unable to remove old key: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/pgombola/clarify-svc/internal/discover"
+)
+
+// joinArgs resolves the -join discovery source into retry-join arguments
+func (p *consul) joinArgs() ([]string, error) {
+	if len(p.join) == 0 {
+		return nil, nil
+	}
+	addrs, err := discover.Resolve(p.join)
+	if err != nil {
+		return nil, err
+	}
+	p.logger.Infof("joining servers (servers=%v)", addrs)
+	args := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		args = append(args, "-retry-join="+addr)
+	}
+	return args, nil
+}
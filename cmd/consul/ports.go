@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+)
+
+// defaultPorts are the consul agent's listen ports unless overridden in the
+// "ports" block of its configuration
+var defaultPorts = map[string]int{
+	"dns":      8600,
+	"http":     8500,
+	"serf_lan": 8301,
+	"serf_wan": 8302,
+	"server":   8300,
+}
+
+// configuredPorts returns the tcp ports the agent listens on with config.
+// Ports disabled with a negative value are skipped.
+func configuredPorts(config string) []int {
+	ports := configuredPortMap(config)
+	result := make([]int, 0, len(ports))
+	for _, port := range ports {
+		if port > 0 {
+			result = append(result, port)
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+// configuredPortMap returns the agent's listen ports keyed by name
+func configuredPortMap(config string) map[string]int {
+	ports := make(map[string]int)
+	for name, port := range defaultPorts {
+		ports[name] = port
+	}
+	if buf, err := ioutil.ReadFile(config); err == nil {
+		var cfg struct {
+			Ports map[string]int `json:"ports"`
+		}
+		if json.Unmarshal(buf, &cfg) == nil {
+			for name, port := range cfg.Ports {
+				ports[name] = port
+			}
+		}
+	}
+	return ports
+}
+
+// dataDir returns the data_dir of the agent's config
+func dataDir(config string) string {
+	var cfg struct {
+		DataDir string `json:"data_dir"`
+	}
+	if buf, err := ioutil.ReadFile(config); err == nil {
+		json.Unmarshal(buf, &cfg)
+	}
+	return cfg.DataDir
+}
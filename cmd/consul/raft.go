@@ -0,0 +1,52 @@
+package main
+
+import (
+	api "github.com/pgombola/clarify-svc/internal/consul"
+	"github.com/pgombola/clarify-svc/internal/quorum"
+)
+
+// watchRaft monitors the server cluster's raft health when the agent is a
+// server, alerting when it's one failure away from losing quorum
+func (p *consul) watchRaft() {
+	if p.raftInterval <= 0 {
+		return
+	}
+	client := api.NewClient("127.0.0.1", configuredPortMap(p.config)["http"])
+	if err := client.SetToken("", ""); err != nil {
+		p.logger.Warningf("unable to read consul token; not monitoring raft: %v", err)
+		return
+	}
+	server, err := client.IsServer()
+	if err != nil {
+		p.logger.Warningf("unable to determine agent mode; not monitoring raft: %v", err)
+		return
+	}
+	if !server {
+		return
+	}
+	m := &quorum.Monitor{
+		Name:     "consul",
+		Interval: p.raftInterval,
+		Check: func() (*quorum.Health, error) {
+			h, err := client.Autopilot()
+			if err != nil {
+				return nil, err
+			}
+			health := &quorum.Health{Healthy: h.Healthy, FailureTolerance: h.FailureTolerance, Servers: len(h.Servers)}
+			for _, s := range h.Servers {
+				if s.Leader {
+					health.Leader = s.Name
+				}
+			}
+			return health, nil
+		},
+		Alert: func(state string, msg string) {
+			p.notifier.Notify("raft_"+state, msg)
+		},
+		Gauge:  p.metrics.Gauge,
+		Logger: p.logger,
+	}
+	p.startRaft.Do(func() {
+		p.crash.Go(func() { m.Run(p.exit) })
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	api "github.com/pgombola/clarify-svc/internal/consul"
+	"github.com/pgombola/clarify-svc/internal/eventid"
+)
+
+// waitReady blocks until the local agent joined the cluster and knows its
+// raft leader, which needs no acl token. Start only returns once this
+// succeeds, which is when scm.Run reports the service started to systemd or
+// the windows service control manager, so nomad doesn't start against a
+// consul that hasn't settled.
+// Returns an error if the agent exits or isn't ready within the timeout.
+func (p *consul) waitReady(done chan error) error {
+	if p.readyTimeout <= 0 {
+		return nil
+	}
+	client := api.NewClient("127.0.0.1", configuredPortMap(p.config)["http"])
+	deadline := time.After(p.readyTimeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		leader, err := client.Leader()
+		if err == nil {
+			eventid.Info(p.logger, eventid.AgentReady, "%s is ready (leader=%s)", p.name, leader)
+			return nil
+		}
+		select {
+		case err := <-done:
+			return fmt.Errorf("consul exited before it was ready: %v", err)
+		case <-deadline:
+			return fmt.Errorf("consul not ready after %v: %v", p.readyTimeout, err)
+		case <-p.exit:
+			return fmt.Errorf("stopped before consul was ready")
+		case <-ticker.C:
+		}
+	}
+}
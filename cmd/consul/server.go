@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	api "github.com/pgombola/clarify-svc/internal/consul"
+)
+
+// Quorum policies of -control stop and restart when stopping a server would
+// lose raft quorum
+const (
+	quorumRefuse = "refuse"
+	quorumWarn   = "warn"
+)
+
+// checkQuorum returns an error when the local agent is a server the raft
+// cluster would lose quorum without. A cluster of a single server has no
+// quorum to keep.
+func (p *consul) checkQuorum() error {
+	client := api.NewClient("127.0.0.1", configuredPortMap(p.config)["http"])
+	if err := client.SetToken("", ""); err != nil {
+		p.logger.Warningf("unable to read consul token: %v", err)
+		return nil
+	}
+	server, err := client.IsServer()
+	if err != nil {
+		p.logger.Warningf("unable to detect server mode: %v", err)
+		return nil
+	}
+	if !server {
+		return nil
+	}
+	health, err := client.Autopilot()
+	if err != nil {
+		p.logger.Warningf("unable to check raft health: %v", err)
+		return nil
+	}
+	if len(health.Servers) > 1 && health.FailureTolerance < 1 {
+		return fmt.Errorf("stopping %s would lose raft quorum (failure tolerance %d)", p.name, health.FailureTolerance)
+	}
+	return nil
+}
+
+// checkStop returns an error when the control action would stop a server
+// the raft cluster can't lose and -quorum-policy refuses it without force.
+// The service manager stops the process whatever Stop returns, so the policy
+// is enforced here rather than in Stop.
+func (p *consul) checkStop(action string, force bool) error {
+	if (action != "stop" && action != "restart") || p.quorum != quorumRefuse {
+		return nil
+	}
+	if force {
+		p.logger.Warning("skipping quorum check (-force)")
+		return nil
+	}
+	if err := p.checkQuorum(); err != nil {
+		return fmt.Errorf("%v; use -force to %s it anyway", err, action)
+	}
+	return nil
+}
+
+// prepareStop warns when the raft cluster loses quorum without this server
+func (p *consul) prepareStop() {
+	if err := p.checkQuorum(); err != nil {
+		p.logger.Error(err.Error())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/audit"
+	"github.com/pgombola/clarify-svc/internal/backup"
+	api "github.com/pgombola/clarify-svc/internal/consul"
+)
+
+// snapshotConfig holds the -snapshot-* flags
+type snapshotConfig struct {
+	dest     string
+	interval time.Duration
+	keep     int
+	maxAge   time.Duration
+	token    string
+	// tokenFile holds the token when it isn't given
+	tokenFile string
+}
+
+// newSnapshots returns the snapshot schedule of the agent's http api, or nil
+// when snapshots aren't enabled. The token needs the management policy.
+func newSnapshots(cfg *snapshotConfig, wd string, name string, port int) (*backup.Schedule, error) {
+	if len(cfg.dest) == 0 {
+		return nil, nil
+	}
+	if cfg.interval <= 0 {
+		return nil, fmt.Errorf("-snapshot-interval %v must be positive", cfg.interval)
+	}
+	dest := cfg.dest
+	if !strings.HasPrefix(dest, "s3://") && !filepath.IsAbs(dest) {
+		dest = filepath.Join(wd, dest)
+	}
+	store, err := backup.Open(dest)
+	if err != nil {
+		return nil, err
+	}
+	client := api.NewClient("127.0.0.1", port)
+	if err := client.SetToken(cfg.token, cfg.tokenFile); err != nil {
+		return nil, err
+	}
+	return &backup.Schedule{
+		Store:    store,
+		Prefix:   name,
+		Interval: cfg.interval,
+		Keep:     cfg.keep,
+		MaxAge:   cfg.maxAge,
+		Take:     client.Snapshot,
+	}, nil
+}
+
+// watchSnapshots takes snapshots on the schedule until the program exits
+func (p *consul) watchSnapshots() {
+	if p.snapshots == nil {
+		return
+	}
+	p.snapshots.Logger = p.logger
+	p.startSnapshots.Do(func() {
+		p.crash.Go(func() { p.snapshots.Run(p.exit, p.snapshotFailed) })
+	})
+}
+
+func (p *consul) snapshotFailed(err error) {
+	p.metrics.Incr("snapshot.failures")
+	p.notifier.Notify("snapshot_failed", fmt.Sprintf("consul snapshot failed: %v", err))
+}
+
+// snapshot runs the snapshot subcommand, taking a snapshot now
+func (p *consul) snapshot(args []string) {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	flags.Parse(args)
+	if p.snapshots == nil {
+		log.Fatal("snapshots need -snapshot-dest")
+	}
+	p.snapshots.Logger = p.logger
+	name, err := p.snapshots.Once()
+	p.audit.Record("snapshot", audit.User(), err, name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(name)
+}
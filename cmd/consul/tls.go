@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/certs"
+	"github.com/pgombola/clarify-svc/internal/redact"
+	"github.com/pgombola/clarify-svc/internal/vault"
+)
+
+// tlsConfig describes where the agent's TLS certificate is issued from
+type tlsConfig struct {
+	caCert string
+	caKey  string
+	vault  string
+	dir    string
+	names  string
+	ttl    time.Duration
+	renew  time.Duration
+}
+
+// newRotator returns the rotator for the configured certificate source, or
+// nil when TLS isn't managed by the wrapper
+func newRotator(cfg *tlsConfig, wd string) (*certs.Rotator, error) {
+	var issue certs.Issuer
+	switch {
+	case len(cfg.caCert) != 0 && len(cfg.vault) != 0:
+		return nil, errors.New("-tls-ca-cert and -tls-vault are mutually exclusive")
+	case len(cfg.caCert) != 0:
+		if len(cfg.caKey) == 0 {
+			return nil, errors.New("-tls-ca-cert requires -tls-ca-key")
+		}
+		issue = certs.FromCA(cfg.caCert, cfg.caKey, cfg.ttl)
+	case len(cfg.vault) != 0:
+		token := os.Getenv("VAULT_TOKEN")
+		if len(token) == 0 {
+			return nil, errors.New("-tls-vault requires VAULT_TOKEN")
+		}
+		redact.Add(token)
+		issue = certs.FromVault(vault.NewClient(os.Getenv("VAULT_ADDR"), token), cfg.vault)
+	default:
+		return nil, nil
+	}
+	dir := cfg.dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(wd, dir)
+	}
+	hostname, _ := os.Hostname()
+	names := []string{hostname, "localhost", "127.0.0.1", "server.dc1.consul"}
+	if len(cfg.names) != 0 {
+		names = append(names, strings.Split(cfg.names, ",")...)
+	}
+	return &certs.Rotator{Dir: dir, Names: names, Renew: cfg.renew, Issue: issue}, nil
+}
+
+// prepareTLS makes sure the agent has a valid certificate, writes the
+// generated agent config pointing at it and starts watching for expiry
+// Returns the path of the generated config or an empty path when TLS isn't
+// managed
+func (p *consul) prepareTLS() (string, error) {
+	if p.certs == nil {
+		return "", nil
+	}
+	p.certs.Logger = p.logger
+	if _, err := p.certs.Ensure(); err != nil {
+		if _, expiryErr := certs.Expiry(p.certs.Dir); expiryErr != nil {
+			return "", err
+		}
+		p.logger.Warningf("error reissuing tls certificate, starting with the current one: %v", err)
+	}
+	p.watchCerts.Do(func() {
+		p.crash.Go(func() { p.certs.Watch(time.Hour, p.exit, p.reload) })
+	})
+	return p.writeTLSConfig()
+}
+
+// reload makes the agent pick up a new certificate. Windows doesn't support
+// SIGHUP so the agent is restarted instead.
+func (p *consul) reload() {
+	if p.cmd == nil || p.cmd.Process == nil {
+		return
+	}
+	p.logger.Infof("reloading %s with the new tls certificate", p.name)
+	if runtime.GOOS == "windows" {
+		atomic.StoreInt32(&p.restart, 1)
+		if err := p.cmd.Process.Kill(); err != nil {
+			p.logger.Errorf("Error terminating consul:\n%v", err)
+		}
+		return
+	}
+	if err := p.cmd.Process.Signal(syscall.SIGHUP); err != nil {
+		p.logger.Errorf("Error reloading consul:\n%v", err)
+	}
+}
+
+// writeTLSConfig writes the agent config referencing the issued certificate
+func (p *consul) writeTLSConfig() (string, error) {
+	buf, err := json.Marshal(map[string]string{
+		"ca_file":   filepath.Join(p.certs.Dir, certs.CAFile),
+		"cert_file": filepath.Join(p.certs.Dir, certs.CertFile),
+		"key_file":  filepath.Join(p.certs.Dir, certs.KeyFile),
+	})
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(filepath.Dir(p.keyFile), p.name+".tls.json")
+	return path, ioutil.WriteFile(path, buf, 0600)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/procstat"
+)
+
+// watchUsage samples the consul process's cpu, memory and open files every
+// interval, sending them as metrics and writing them to the usage file read
+// by the status command
+func (p *consul) watchUsage() {
+	if p.usageInterval <= 0 {
+		return
+	}
+	sampler := &procstat.Sampler{}
+	ticker := time.NewTicker(p.usageInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cmd := p.cmd
+			if cmd == nil || cmd.Process == nil {
+				continue
+			}
+			u, err := sampler.Sample(cmd.Process.Pid)
+			if err != nil {
+				p.logger.Warningf("unable to sample consul resource usage: %v", err)
+				continue
+			}
+			p.metrics.Gauge("agent.cpu_percent", u.CPUPercent)
+			p.metrics.Gauge("agent.rss_bytes", float64(u.RSSBytes))
+			p.metrics.Gauge("agent.open_files", float64(u.OpenFiles))
+			if buf, err := json.Marshal(u); err == nil {
+				ioutil.WriteFile(p.usageFile, buf, 0644)
+			}
+		case <-p.exit:
+			return
+		}
+	}
+}
+
+// usage returns the last sampled resource usage
+func (p *consul) usage() *procstat.Usage {
+	buf, err := ioutil.ReadFile(p.usageFile)
+	if err != nil {
+		return nil
+	}
+	u := &procstat.Usage{}
+	if json.Unmarshal(buf, u) != nil {
+		return nil
+	}
+	return u
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/discover"
+)
+
+// joinArgs resolves the -join discovery source into retry-join arguments for
+// servers, or the -servers list for clients
+func (p *nomad) joinArgs() ([]string, error) {
+	if len(p.join) == 0 {
+		return nil, nil
+	}
+	addrs, err := discover.Resolve(p.join)
+	if err != nil {
+		return nil, err
+	}
+	p.logger.Infof("joining servers (servers=%v)", addrs)
+	if p.server == "true" || (p.server == "auto" && serverConfigured(p.config)) {
+		args := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			args = append(args, "-retry-join="+addr)
+		}
+		return args, nil
+	}
+	servers := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if discover.Cloud(addr) {
+			p.logger.Warningf("cloud auto-join is only supported by servers, ignoring %q", addr)
+			continue
+		}
+		servers = append(servers, addr)
+	}
+	if len(servers) == 0 {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("-servers=%s", strings.Join(servers, ","))}, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -11,33 +12,162 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/agentconfig"
+	"github.com/pgombola/clarify-svc/internal/audit"
+	"github.com/pgombola/clarify-svc/internal/backup"
+	"github.com/pgombola/clarify-svc/internal/buildinfo"
+	"github.com/pgombola/clarify-svc/internal/certs"
+	"github.com/pgombola/clarify-svc/internal/chaos"
+	"github.com/pgombola/clarify-svc/internal/crash"
+	"github.com/pgombola/clarify-svc/internal/crashloop"
+	"github.com/pgombola/clarify-svc/internal/dedup"
+	"github.com/pgombola/clarify-svc/internal/eventid"
+	"github.com/pgombola/clarify-svc/internal/journald"
+	"github.com/pgombola/clarify-svc/internal/metrics"
+	nomadapi "github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/clarify-svc/internal/notify"
+	"github.com/pgombola/clarify-svc/internal/pidfile"
+	"github.com/pgombola/clarify-svc/internal/preflight"
+	"github.com/pgombola/clarify-svc/internal/procstat"
+	"github.com/pgombola/clarify-svc/internal/proxy"
+	"github.com/pgombola/clarify-svc/internal/redact"
+	"github.com/pgombola/clarify-svc/internal/runas"
+	"github.com/pgombola/clarify-svc/internal/scm"
 )
 
 type nomad struct {
-	logger  service.Logger
-	verbose *bool
-	path    string
-	data    string
-	config  string
-	cmd     *exec.Cmd
-	exit    chan struct{}
+	name           string
+	logger         service.Logger
+	verbose        *bool
+	path           string
+	extraArgs      []string
+	datacenter     string
+	nodeClass      string
+	data           string
+	config         string
+	runAs          string
+	hostVolumes    []hostVolume
+	server         string
+	quorum         string
+	leave          bool
+	join           string
+	certs          *certs.Rotator
+	watchCerts     sync.Once
+	snapshots      *backup.Schedule
+	startSnapshots sync.Once
+	raftInterval   time.Duration
+	startRaft      sync.Once
+	usageInterval  time.Duration
+	usageFile      string
+	startUsage     sync.Once
+	chaos          *chaos.Injector
+	startChaos     sync.Once
+	journal        *journald.Logger
+	restart        int32
+	readyTimeout   time.Duration
+	cmd            *exec.Cmd
+	proxy          *proxy.Config
+	audit          *audit.Log
+	crashes        *crashloop.Tracker
+	crash          *crash.Reporter
+	notifier       *notify.Notifier
+	metrics        *metrics.Statsd
+	exit           chan struct{}
 }
 
 func (p *nomad) Start(s service.Service) error {
-	p.logger.Infof("Starting Clarify-Nomad(exe=%s,config=%s)", p.path, p.config)
-	p.cmd = exec.Command(p.path, "agent", fmt.Sprintf("-config=%s", p.config), fmt.Sprintf("-data-dir=%s", p.data))
+	defer p.crash.Recover()
+	if state, err := p.crashes.Load(); err == nil && state.Quarantined {
+		p.logger.Errorf("%s is quarantined (%s); run resume to start it again", p.name, state.Reason)
+		return nil
+	}
+	eventid.Info(p.logger, eventid.ServiceStarted, "Starting %s(exe=%s,config=%s)", p.name, p.path, p.config)
+	args := []string{"agent", fmt.Sprintf("-config=%s", p.config), fmt.Sprintf("-data-dir=%s", p.data)}
+	tlsConfig, err := p.prepareTLS()
+	if err != nil {
+		p.logger.Errorf("unable to configure tls: %v", err)
+		return err
+	}
+	if len(tlsConfig) != 0 {
+		args = append(args, fmt.Sprintf("-config=%s", tlsConfig))
+	}
+	volumeConfig, err := p.prepareHostVolumes()
+	if err != nil {
+		p.logger.Errorf("unable to provision host volumes: %v", err)
+		return err
+	}
+	if len(volumeConfig) != 0 {
+		args = append(args, fmt.Sprintf("-config=%s", volumeConfig))
+	}
+	joins, err := p.joinArgs()
+	if err != nil {
+		p.logger.Errorf("unable to discover servers: %v", err)
+		return err
+	}
+	args = append(args, joins...)
+	if len(p.datacenter) != 0 {
+		args = append(args, "-dc="+p.datacenter)
+	}
+	if len(p.nodeClass) != 0 {
+		args = append(args, "-node-class="+p.nodeClass)
+	}
+	args = append(args, p.extraArgs...)
+	p.cmd = exec.Command(p.path, args...)
+	if env := p.proxy.Env(); len(env) != 0 {
+		p.cmd.Env = append(os.Environ(), env...)
+	}
 	if *p.verbose {
-		p.cmd.Stdout = os.Stdout
-		p.cmd.Stderr = os.Stderr
+		p.cmd.Stdout = redact.Writer(os.Stdout)
+		p.cmd.Stderr = redact.Writer(os.Stderr)
+	}
+	if err := preflight.PortsFree(configuredPorts(p.config)); err != nil {
+		p.logger.Error(err)
+		return err
+	}
+	if err := runas.Apply(p.cmd, p.runAs); err != nil {
+		p.logger.Error(err)
+		return err
+	}
+	p.audit.Record("start", "service-manager", nil, p.path)
+	p.metrics.Incr("agent.starts")
+	if err := p.cmd.Start(); err != nil {
+		p.logger.Errorf("unable to start nomad: %v", err)
+		return err
 	}
-	go p.run()
+	done := wait(p.cmd)
+	if err := p.waitReady(done); err != nil {
+		eventid.Error(p.logger, eventid.StartFailed, "%v", err)
+		p.metrics.Incr("agent.ready_timeouts")
+		p.cmd.Process.Kill()
+		return err
+	}
+	p.watchSnapshots()
+	p.watchRaft()
+	p.startUsage.Do(func() {
+		p.crash.Go(p.watchUsage)
+	})
+	p.startChaos.Do(func() {
+		p.crash.Go(func() { p.chaos.Kill(p.exit, p.killAgent) })
+	})
+	p.crash.Go(func() { p.run(done) })
 	return nil
 }
 
 func (p *nomad) Stop(s service.Service) error {
-	p.logger.Info("Stopping Clarify-Nomad")
+	defer p.crash.Recover()
+	eventid.Info(p.logger, eventid.ServiceStopped, "Stopping %s", p.name)
+	if p.cmd == nil || p.cmd.Process == nil {
+		p.audit.Record("stop", "service-manager", nil, "")
+		close(p.exit)
+		return nil
+	}
+	p.prepareStop()
+	p.audit.Record("stop", "service-manager", nil, "")
 	close(p.exit)
 	// https://github.com/golang/go/issues/6720
 	if runtime.GOOS == "windows" {
@@ -53,25 +183,62 @@ func (p *nomad) Stop(s service.Service) error {
 	return nil
 }
 
-func (p *nomad) run() {
-	p.cmd.Start()
-	done := wait(p.cmd)
+func (p *nomad) run(done chan error) {
 	select {
 	// The consul child process has exited
 	case err := <-done:
 		switch err.(type) {
 		case *exec.ExitError:
-			p.logger.Errorf("Nomad process exited:\n%v", err)
-			os.Exit(1)
+			eventid.Error(p.logger, eventid.AgentCrashed, "Nomad process exited:\n%v", err)
 		default:
-			p.logger.Info("Nomad process exited gracefully.")
-			os.Exit(1)
+			eventid.Info(p.logger, eventid.AgentExited, "Nomad process exited gracefully.")
+		}
+		p.metrics.Incr("agent.exits")
+		if atomic.CompareAndSwapInt32(&p.restart, 1, 0) {
+			if err := p.Start(nil); err != nil {
+				os.Exit(1)
+			}
+			return
 		}
+		if p.quarantine(fmt.Sprintf("nomad exited: %v", err)) {
+			<-p.exit
+			return
+		}
+		os.Exit(1)
 	case <-p.exit:
 		return
 	}
 }
 
+// quarantine records the agent's exit and reports whether it restarted too
+// often and must wait for an operator to resume it
+func (p *nomad) quarantine(reason string) bool {
+	quarantined, err := p.crashes.Record(reason)
+	if err != nil {
+		p.logger.Warningf("unable to record restart: %v", err)
+		return false
+	}
+	if quarantined {
+		eventid.Error(p.logger, eventid.AgentQuarantined, "%s quarantined after repeated restarts; run resume to start it again", p.name)
+		p.metrics.Incr("agent.quarantined")
+		p.notifier.Notify("quarantined", reason)
+	}
+	return quarantined
+}
+
+// crashState is the program state written to crash reports
+func (p *nomad) crashState() interface{} {
+	state := map[string]interface{}{
+		"exe":     p.path,
+		"config":  p.config,
+		"restart": atomic.LoadInt32(&p.restart) == 1,
+	}
+	if p.cmd != nil && p.cmd.Process != nil {
+		state["agent_pid"] = p.cmd.Process.Pid
+	}
+	return state
+}
+
 func wait(cmd *exec.Cmd) chan error {
 	done := make(chan error, 1)
 	go func() {
@@ -80,10 +247,20 @@ func wait(cmd *exec.Cmd) chan error {
 	return done
 }
 
+// findFile returns the first file beneath dir whose name matches the
+// pattern name. The running wrapper is never matched so a missing agent
+// isn't replaced by the wrapper itself. Unreadable directories are skipped.
 func findFile(dir string, name string) (result string, err error) {
+	var self os.FileInfo
+	if exe, err := os.Executable(); err == nil {
+		self, _ = os.Stat(exe)
+	}
 	err = filepath.Walk(dir,
-		filepath.WalkFunc(func(fp string, fi os.FileInfo, _ error) error {
-			if fi.IsDir() {
+		filepath.WalkFunc(func(fp string, fi os.FileInfo, walkErr error) error {
+			if walkErr != nil || fi == nil {
+				return nil
+			}
+			if fi.IsDir() || (self != nil && os.SameFile(fi, self)) {
 				return nil
 			}
 			if matched, err := path.Match(name, fi.Name()); err != nil {
@@ -95,9 +272,11 @@ func findFile(dir string, name string) (result string, err error) {
 			return nil
 		}))
 	if err == io.EOF {
-		err = nil
+		return result, nil
+	} else if err != nil {
+		return "", err
 	}
-	return
+	return "", fmt.Errorf("no file matching %s in %s or its subdirectories", name, dir)
 }
 
 func cleanup(data string) {
@@ -121,11 +300,106 @@ func cleanup(data string) {
 	}
 }
 
+// secretFlags map the flags holding secrets, which aren't stored in the
+// service definition, to the file flags the service is installed with
+var secretFlags = map[string]string{
+	"snapshot-token": "snapshot-token-file",
+}
+
+// serviceArgs returns the flags given on the command line, minus -control, so
+// the installed service runs with the same configuration
+func serviceArgs() []string {
+	args := make([]string, 0)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "control" && len(secretFlags[f.Name]) == 0 {
+			args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		}
+	})
+	return args
+}
+
 func main() {
 	control := flag.String("control", "", fmt.Sprintf("Service control command [%q].", service.ControlAction))
+	exePath := flag.String("nomad-exe", "", "Path of the nomad executable, or its name to look up in PATH (defaults to the first nomad* file beside the wrapper).")
+	flag.StringVar(exePath, "exe-path", "", "Same as -nomad-exe.")
+	extraArgs := flag.String("extra-args", "", "Space separated flags also passed to the nomad agent, e.g. \"-dev -node=edge-1\".")
 	cfg := flag.String("cfg", "config.hcl", "The name of the Nomad configuration file.")
+	rawExec := flag.Bool("raw-exec", true, "Enables the raw_exec driver the clarify job runs with in a config written on first start.")
+	dockerPrivileged := flag.Bool("docker-privileged", false, "Allows docker tasks to run privileged containers in a config written on first start.")
+	hostVolumes := flag.String("host-volumes", "", "Comma separated name=path[:ro] host volumes created at start and declared in the client config.")
+	datacenter := flag.String("datacenter", "", "Datacenter the agent runs in, overriding the config's (dc1 in a config written on first start).")
+	nodeClass := flag.String("node-class", "", "Scheduling class of the nomad client, overriding the config's.")
+	disableDrivers := flag.String("disable-drivers", "", "Comma separated task drivers disabled in a config written on first start, e.g. java,qemu.")
 	verbose := flag.Bool("v", false, "Logs verbose output from the Nomad process.")
+	prefix := flag.String("service-prefix", "clarify", "Prefix of the clarify service names, allowing several installs per host.")
+	name := flag.String("service-name", "", "Name of this service (defaults to <service-prefix>-nomad).")
+	runAs := flag.String("run-as", "", "Runs nomad as user[:group] (the service account on Windows, password from CLARIFY_RUN_AS_PASSWORD).")
+	crashMax := flag.Int("crash-max", 5, "Restarts within -crash-window before nomad is quarantined.")
+	crashWindow := flag.Duration("crash-window", 10*time.Minute, "Window restarts are counted in.")
+	notifyURL := flag.String("notify", "", "Webhook URL that receives alerts.")
+	proxyURL := flag.String("proxy", "", "Proxy the agent's artifact downloads and outbound http requests such as webhooks go through, http://[user:password@]host:port (defaults to HTTP_PROXY and HTTPS_PROXY).")
+	noProxy := flag.String("no-proxy", "", "Comma separated hosts, domains and cidrs reached without -proxy.")
+	statsdAddr := flag.String("statsd", "", "host:port of a statsd or DogStatsD collector metrics are sent to.")
+	statsdPrefix := flag.String("statsd-prefix", "clarify", "Prefix of the metric names.")
+	statsdTags := flag.String("statsd-tags", "", "Comma separated key:value DogStatsD tags added to every metric.")
+	auditLog := flag.String("audit", "", "Path of the append-only audit log of control actions.")
+	purgeData := flag.Bool("purge-data", false, "With -control uninstall, also deletes the agent's data directory.")
+	skipDeps := flag.Bool("skip-dependency-check", false, "With -control install, registers the service without checking the consul service is installed.")
+	join := flag.String("join", "", "Discovery source of the servers to join (srv:<name>, an http url, a provider= cloud query or addresses).")
+	snapshotCfg := &snapshotConfig{}
+	flag.StringVar(&snapshotCfg.dest, "snapshot-dest", "", "Directory or s3://bucket/prefix?endpoint=<url>&region=<region> periodic server snapshots are stored in (empty disables them).")
+	flag.DurationVar(&snapshotCfg.interval, "snapshot-interval", time.Hour, "How often a snapshot is taken.")
+	flag.IntVar(&snapshotCfg.keep, "snapshot-keep", 24, "Number of snapshots kept (0 keeps all).")
+	flag.DurationVar(&snapshotCfg.maxAge, "snapshot-max-age", 0, "Deletes snapshots older than this (0 keeps them).")
+	flag.StringVar(&snapshotCfg.token, "snapshot-token", "", "ACL token with the management policy (defaults to NOMAD_TOKEN).")
+	flag.StringVar(&snapshotCfg.tokenFile, "snapshot-token-file", "", "File containing the -snapshot-token.")
+	tlsCfg := &tlsConfig{}
+	flag.StringVar(&tlsCfg.caCert, "tls-ca-cert", "", "CA certificate used to sign the agent's TLS certificate.")
+	flag.StringVar(&tlsCfg.caKey, "tls-ca-key", "", "Private key of -tls-ca-cert.")
+	flag.StringVar(&tlsCfg.vault, "tls-vault", "", "Vault PKI issue path the agent's TLS certificate is issued from (VAULT_ADDR and VAULT_TOKEN).")
+	flag.StringVar(&tlsCfg.dir, "tls-dir", "tls", "Directory the agent's TLS certificate is written to.")
+	flag.StringVar(&tlsCfg.names, "tls-names", "", "Comma separated extra DNS names and IPs of the agent's TLS certificate.")
+	flag.DurationVar(&tlsCfg.ttl, "tls-ttl", 30*24*time.Hour, "Validity of certificates signed with -tls-ca-cert.")
+	flag.DurationVar(&tlsCfg.renew, "tls-renew", 72*time.Hour, "Reissues the TLS certificate once it's within this long of expiring.")
+	server := flag.String("server", "auto", "Whether the agent runs in server mode [auto, true, false].")
+	quorum := flag.String("quorum-policy", quorumRefuse, fmt.Sprintf("Whether -control stop and restart refuse to stop a server that would lose raft quorum or only warn [%s, %s].", quorumRefuse, quorumWarn))
+	force := flag.Bool("force", false, "With -control stop or restart, stops a server even when it would lose raft quorum.")
+	leave := flag.Bool("leave", false, "Removes a server from the raft configuration before it stops.")
+	startTimeout := flag.Duration("start-timeout", 10*time.Minute, "How long the service may stay start pending, its TimeoutStartSec under systemd, before failing (0 waits forever).")
+	crashDir := flag.String("crash-dir", "", "Directory crash reports are written to when the service panics (defaults to crashes beside the executable).")
+	pidFile := flag.String("pid-file", "", "Pid file locked while the service runs so only one instance manages the agent (defaults to <name>.pid beside the executable).")
+	chaosSpec := chaos.Flag()
+	logDedup := flag.Duration("log-dedup-window", time.Minute, "Repeated warning and info messages are logged once per window (0 disables it).")
+	usageInterval := flag.Duration("usage-interval", 30*time.Second, "How often the agent's cpu, memory and open files are sampled (0 disables it).")
+	raftInterval := flag.Duration("raft-check-interval", time.Minute, "How often a server checks the cluster's raft health, alerting when it's one failure from losing quorum (0 disables it).")
+	readyTimeout := flag.Duration("ready-timeout", 2*time.Minute, "How long start waits for the agent's api and node to be ready (0 disables the wait).")
 	flag.Parse()
+	if len(*name) == 0 {
+		*name = *prefix + "-nomad"
+	}
+	if *server != "auto" && *server != "true" && *server != "false" {
+		log.Fatalf("invalid -server %q", *server)
+	}
+	if *quorum != quorumRefuse && *quorum != quorumWarn {
+		log.Fatalf("invalid -quorum-policy %q", *quorum)
+	}
+	proxyCfg, err := proxy.Parse(*proxyURL, *noProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	proxy.Install(proxyCfg)
+	nomadapi.SetProxy(proxyCfg.Func())
+	redact.Add(proxyCfg.Password())
+	if len(*datacenter) != 0 {
+		if err := agentconfig.ValidName("datacenter", *datacenter); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(*nodeClass) != 0 {
+		if err := agentconfig.ValidName("node class", *nodeClass); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Program
 	var prg *nomad
@@ -134,29 +408,113 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		exe, _ := findFile(wd, "nomad*")
+		if len(*pidFile) == 0 {
+			*pidFile = filepath.Join(wd, *name+".pid")
+		}
+		if len(*crashDir) == 0 {
+			*crashDir = filepath.Join(wd, "crashes")
+		}
+		// The agent is needed to run or install the service, not for
+		// subcommands or other control actions
+		needsAgent := flag.NArg() == 0 && (len(*control) == 0 || *control == "install")
+		exe := *exePath
+		if len(exe) == 0 {
+			exe, err = findFile(wd, "nomad*")
+		} else if exe, err = exec.LookPath(exe); err != nil {
+			err = fmt.Errorf("-nomad-exe: %v", err)
+		}
+		if err != nil && needsAgent {
+			log.Fatalf("unable to find the nomad executable: %v; install nomad beside %s or set -nomad-exe", err, wd)
+		}
 		config, _ := findFile(wd, *cfg)
+		if len(config) == 0 && flag.NArg() == 0 && len(*control) == 0 {
+			// First start without a config; the agent would run with none
+			values, err := agentconfig.Defaults(wd)
+			if err == nil {
+				err = values.SetDrivers(*rawExec, *dockerPrivileged, *disableDrivers)
+			}
+			if err == nil {
+				err = values.SetScheduling(*datacenter, *nodeClass)
+			}
+			if err == nil {
+				config, err = agentconfig.Materialize(agentconfig.Nomad, values, wd, *cfg)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("no %s found; wrote the default config to %s", *cfg, config)
+		}
+		rotator, err := newRotator(tlsCfg, wd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		volumes, err := parseHostVolumes(*hostVolumes, wd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hostname, _ := os.Hostname()
+		sink, err := metrics.New(*statsdAddr, *statsdPrefix, metrics.Tags(*statsdTags, "host:"+hostname, "service:"+*name))
+		if err != nil {
+			log.Fatal(err)
+		}
 		data := strings.Join([]string{wd, "data"}, string(os.PathSeparator))
-		cleanup(data)
+		if flag.NArg() == 0 {
+			cleanup(data)
+		}
 		prg = &nomad{
-			path:    exe,
-			verbose: verbose,
-			config:  config,
-			data:    data,
-			exit:    make(chan struct{}, 1),
+			path:          exe,
+			extraArgs:     strings.Fields(*extraArgs),
+			datacenter:    *datacenter,
+			nodeClass:     *nodeClass,
+			verbose:       verbose,
+			config:        config,
+			data:          data,
+			audit:         audit.Open(*auditLog, *name),
+			name:          *name,
+			runAs:         *runAs,
+			hostVolumes:   volumes,
+			certs:         rotator,
+			server:        *server,
+			quorum:        *quorum,
+			leave:         *leave,
+			readyTimeout:  *readyTimeout,
+			raftInterval:  *raftInterval,
+			usageInterval: *usageInterval,
+			usageFile:     filepath.Join(wd, *name+".usage.json"),
+			join:          *join,
+			proxy:         proxyCfg,
+			crashes: &crashloop.Tracker{
+				Path:   filepath.Join(wd, *name+".crashloop.json"),
+				Max:    *crashMax,
+				Window: *crashWindow,
+			},
+			crash: &crash.Reporter{
+				Dir:         *crashDir,
+				Name:        *name,
+				Fingerprint: crash.Fingerprint(os.Args[1:], config),
+			},
+			notifier: notify.New(*notifyURL, *name),
+			metrics:  sink,
+			exit:     make(chan struct{}, 1),
+		}
+		prg.snapshots, err = prg.newSnapshots(snapshotCfg, wd)
+		if err != nil {
+			log.Fatal(err)
 		}
 	}
 
 	// Service
 	var s service.Service
+	dependencies := []string{*prefix + "-consul"}
 	{
 		svcConfig := &service.Config{
-			Name:         "clarify-nomad",
-			DisplayName:  "clarify-nomad",
-			Description:  "clarify-nomad service",
-			Arguments:    []string{"-cfg", *cfg},
-			Dependencies: []string{"clarify-consul"},
+			Name:         *name,
+			DisplayName:  *name,
+			Description:  *name + " service",
+			Arguments:    serviceArgs(),
+			Dependencies: dependencies,
 		}
+		runas.Configure(svcConfig, *runAs, os.Getenv("CLARIFY_RUN_AS_PASSWORD"))
 		s, _ = service.New(prg, svcConfig)
 	}
 
@@ -168,17 +526,107 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
+		if flag.NArg() == 0 && len(*control) == 0 && !service.Interactive() && journald.Available() {
+			journal, err := journald.New(*name, map[string]string{"UNIT": *name + ".service"})
+			if err != nil {
+				log.Fatal(err)
+			}
+			prg.journal = journal
+			logger = journal
+		}
+		logger = dedup.New(redact.Logger(logger), *logDedup)
 		prg.logger = logger
+		prg.crash.Logger = logger
+		prg.crash.State = prg.crashState
+	}
+
+	// Chaos testing
+	if flag.NArg() == 0 && len(*control) == 0 {
+		injector, err := chaos.Parse(*chaosSpec, logger)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if injector != nil {
+			logger.Warningf("chaos mode enabled (spec=%s)", *chaosSpec)
+			nomadapi.Fault = injector.Fault
+			prg.chaos = injector
+		}
 	}
 
-	// Run control command or start program
+	// Run subcommand, control command or start program
+	if flag.NArg() != 0 {
+		switch flag.Arg(0) {
+		case "snapshot":
+			prg.snapshot(flag.Args()[1:])
+		case "restore":
+			prg.restore(flag.Args()[1:])
+		case "preflight":
+			prg.runPreflight(flag.Args()[1:])
+		case "version":
+			if err := buildinfo.Command("nomadsvc", flag.Args()[1:], os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+		case "status":
+			state, err := prg.crashes.Load()
+			if err != nil {
+				log.Fatal(err)
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(struct {
+				*crashloop.State
+				Usage *procstat.Usage `json:"usage,omitempty"`
+			}{state, prg.usage()})
+		case "resume":
+			if err := prg.crashes.Resume(); err != nil {
+				log.Fatal(err)
+			}
+			prg.audit.Record("resume", audit.User(), nil, "")
+			if err := s.Restart(); err != nil {
+				log.Printf("unable to restart service: %v", err)
+			}
+		default:
+			log.Fatalf("unknown command %q", flag.Arg(0))
+		}
+		return
+	}
 	if len(*control) != 0 {
-		if err := service.Control(s, *control); err != nil {
+		if *control == "install" {
+			if err := scm.CheckSecrets(secretFlags); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *control == "install" && !*skipDeps {
+			if err := scm.CheckDependencies(*name, dependencies); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := prg.checkStop(*control, *force); err != nil {
+			prg.audit.Record(*control, audit.User(), err, "")
 			log.Fatal(err)
 		}
+		err := scm.Control(s, *control, *name, *startTimeout)
+		prg.audit.Record(*control, audit.User(), err, "")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *control == "uninstall" && *purgeData {
+			err := os.RemoveAll(prg.data)
+			prg.audit.Record("purge_data", audit.User(), err, prg.data)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
 		return
 	}
-	if err := s.Run(); err != nil {
+	pid, err := pidfile.Acquire(*pidFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer prg.crash.Recover()
+	err = scm.Run(s, prg, *name, *startTimeout)
+	pid.Release()
+	if err != nil {
 		logger.Error(err)
 	}
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var (
+	// defaultPorts are the nomad agent's listen ports unless overridden in the
+	// ports block of its configuration
+	defaultPorts = map[string]int{
+		"http": 4646,
+		"rpc":  4647,
+		"serf": 4648,
+	}
+	portsBlock = regexp.MustCompile(`(?s)ports\s*\{([^}]*)\}`)
+	portValue  = regexp.MustCompile(`(http|rpc|serf)\s*=\s*(\d+)`)
+)
+
+// configuredPorts returns the tcp ports the agent listens on with the hcl
+// config
+func configuredPorts(config string) []int {
+	ports := configuredPortMap(config)
+	result := make([]int, 0, len(ports))
+	for _, port := range ports {
+		result = append(result, port)
+	}
+	sort.Ints(result)
+	return result
+}
+
+// configuredPortMap returns the agent's listen ports keyed by name
+func configuredPortMap(config string) map[string]int {
+	ports := make(map[string]int)
+	for name, port := range defaultPorts {
+		ports[name] = port
+	}
+	if buf, err := ioutil.ReadFile(config); err == nil {
+		if block := portsBlock.FindSubmatch(buf); block != nil {
+			for _, m := range portValue.FindAllSubmatch(block[1], -1) {
+				port, _ := strconv.Atoi(string(m[2]))
+				ports[string(m[1])] = port
+			}
+		}
+	}
+	return ports
+}
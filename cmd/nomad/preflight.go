@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/preflight"
+)
+
+// runPreflight verifies the host can run the nomad agent and writes a json
+// report to stdout, exiting non-zero when a check fails
+func (p *nomad) runPreflight(args []string) {
+	fs := flag.NewFlagSet("preflight", flag.ExitOnError)
+	timeURL := fs.String("time-url", "", "URL whose Date header the local clock is compared with.")
+	minDisk := fs.Uint64("min-disk-mb", 1024, "Free disk space required for the agent's data.")
+	fs.Parse(args)
+
+	r := preflight.NewReport(p.name)
+	for _, port := range configuredPorts(p.config) {
+		r.Add(fmt.Sprintf("port-%d", port), preflight.PortFree(port))
+	}
+	r.Add("disk", preflight.DiskFree(filepath.Dir(p.data), *minDisk<<20))
+	r.Add("open-files", preflight.OpenFiles(4096))
+	r.Add("binary", preflight.Runs(p.path, "version"))
+	r.Add("config", preflight.Runs(p.path, "config", "validate", p.config))
+	if len(*timeURL) != 0 {
+		r.Add("time", preflight.ClockSkew(*timeURL, 2*time.Second))
+	}
+	r.Write(os.Stdout)
+	if !r.OK {
+		os.Exit(1)
+	}
+}
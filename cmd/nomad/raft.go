@@ -0,0 +1,47 @@
+package main
+
+import (
+	nomadapi "github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/clarify-svc/internal/quorum"
+)
+
+// watchRaft monitors the server cluster's raft health when the agent is a
+// server, alerting when it's one failure away from losing quorum
+func (p *nomad) watchRaft() {
+	if p.raftInterval <= 0 {
+		return
+	}
+	server, err := p.isServer()
+	if err != nil {
+		p.logger.Warningf("unable to determine agent mode; not monitoring raft: %v", err)
+		return
+	}
+	if !server {
+		return
+	}
+	m := &quorum.Monitor{
+		Name:     "nomad",
+		Interval: p.raftInterval,
+		Check: func() (*quorum.Health, error) {
+			h, err := nomadapi.Autopilot(p.agentAddress())
+			if err != nil {
+				return nil, err
+			}
+			health := &quorum.Health{Healthy: h.Healthy, FailureTolerance: h.FailureTolerance, Servers: len(h.Servers)}
+			for _, s := range h.Servers {
+				if s.Leader {
+					health.Leader = s.Name
+				}
+			}
+			return health, nil
+		},
+		Alert: func(state string, msg string) {
+			p.notifier.Notify("raft_"+state, msg)
+		},
+		Gauge:  p.metrics.Gauge,
+		Logger: p.logger,
+	}
+	p.startRaft.Do(func() {
+		p.crash.Go(func() { m.Run(p.exit) })
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/eventid"
+	nomadapi "github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// waitReady blocks until the agent's http api answers and, for clients, the
+// node registered with the servers and is ready. Start only returns once this
+// succeeds, which is when scm.Run reports the service started to systemd or
+// the windows service control manager, so services depending on nomad start
+// against a working agent.
+// Returns an error if the agent exits or isn't ready within the timeout.
+func (p *nomad) waitReady(done chan error) error {
+	if p.readyTimeout <= 0 {
+		return nil
+	}
+	deadline := time.After(p.readyTimeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := fmt.Errorf("agent not checked")
+	for {
+		ready, err := p.ready()
+		if ready {
+			eventid.Info(p.logger, eventid.AgentReady, "%s is ready", p.name)
+			return nil
+		}
+		last = err
+		select {
+		case err := <-done:
+			return fmt.Errorf("nomad exited before it was ready: %v", err)
+		case <-deadline:
+			return fmt.Errorf("nomad not ready after %v: %v", p.readyTimeout, last)
+		case <-p.exit:
+			return fmt.Errorf("stopped before nomad was ready")
+		case <-ticker.C:
+		}
+	}
+}
+
+// ready reports whether the local agent is ready to be used
+func (p *nomad) ready() (bool, error) {
+	addr := p.agentAddress()
+	agent, err := nomadapi.AgentSelf(addr)
+	if err != nil {
+		return false, err
+	}
+	if err := nomadapi.AgentHealth(addr); err != nil {
+		return false, err
+	}
+	id := agent.Stats.Client["node_id"]
+	if len(id) == 0 {
+		// Server only agents have no node
+		return agent.Config.Server.Enabled, fmt.Errorf("client has no node id")
+	}
+	p.journal.SetField("NODE_ID", id)
+	node, err := nomadapi.GetNode(addr, id)
+	if err != nil {
+		return false, err
+	}
+	if node.Status != "ready" {
+		return false, fmt.Errorf("node status is %s", node.Status)
+	}
+	return true, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	nomadapi "github.com/pgombola/clarify-svc/internal/nomad"
+)
+
+// Quorum policies of -control stop and restart when stopping a server would
+// lose raft quorum
+const (
+	quorumRefuse = "refuse"
+	quorumWarn   = "warn"
+)
+
+// agentAddress returns the http api of the local agent
+func (p *nomad) agentAddress() *nomadapi.Server {
+	return &nomadapi.Server{Address: "127.0.0.1", Port: configuredPortMap(p.config)["http"]}
+}
+
+// isServer reports whether the agent runs in server mode, asking the agent
+// when the mode is left to auto detection
+func (p *nomad) isServer() (bool, error) {
+	switch p.server {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	agent, err := nomadapi.AgentSelf(p.agentAddress())
+	if err != nil {
+		return false, err
+	}
+	return agent.Config.Server.Enabled, nil
+}
+
+var serverBlock = regexp.MustCompile(`(?s)server\s*\{[^}]*enabled\s*=\s*true`)
+
+// serverConfigured reports whether the hcl config enables server mode
+func serverConfigured(config string) bool {
+	buf, err := ioutil.ReadFile(config)
+	return err == nil && serverBlock.Match(buf)
+}
+
+// checkQuorum returns an error when the raft cluster would lose quorum
+// without this server. A cluster of a single server has no quorum to keep.
+func (p *nomad) checkQuorum() error {
+	health, err := nomadapi.Autopilot(p.agentAddress())
+	if err != nil {
+		p.logger.Warningf("unable to check raft health: %v", err)
+		return nil
+	}
+	if len(health.Servers) > 1 && health.FailureTolerance < 1 {
+		return fmt.Errorf("stopping %s would lose raft quorum (failure tolerance %d)", p.name, health.FailureTolerance)
+	}
+	return nil
+}
+
+// checkStop returns an error when the control action would stop a server
+// the raft cluster can't lose and -quorum-policy refuses it without force.
+// The service manager stops the process whatever Stop returns, so the policy
+// is enforced here rather than in Stop.
+func (p *nomad) checkStop(action string, force bool) error {
+	if (action != "stop" && action != "restart") || p.quorum != quorumRefuse {
+		return nil
+	}
+	if force {
+		p.logger.Warning("skipping quorum check (-force)")
+		return nil
+	}
+	server, err := p.isServer()
+	if err != nil {
+		p.logger.Warningf("unable to detect server mode: %v", err)
+		return nil
+	}
+	if !server {
+		return nil
+	}
+	if err := p.checkQuorum(); err != nil {
+		return fmt.Errorf("%v; use -force to %s it anyway", err, action)
+	}
+	return nil
+}
+
+// prepareStop warns when the raft cluster loses quorum without this server
+// and optionally removes it from the raft configuration before it's stopped
+func (p *nomad) prepareStop() {
+	server, err := p.isServer()
+	if err != nil {
+		p.logger.Warningf("unable to detect server mode: %v", err)
+		return
+	}
+	if !server {
+		return
+	}
+	if err := p.checkQuorum(); err != nil {
+		p.logger.Error(err.Error())
+	}
+	if !p.leave {
+		return
+	}
+	addr := p.agentAddress()
+	agent, err := nomadapi.AgentSelf(addr)
+	if err != nil {
+		p.logger.Warningf("unable to leave the raft cluster: %v", err)
+		return
+	}
+	p.logger.Infof("leaving the raft cluster (name=%s)", agent.Member.Name)
+	if err := nomadapi.RemoveRaftPeer(addr, agent.Member.Tags["id"]); err != nil {
+		p.logger.Warningf("unable to leave the raft cluster: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/audit"
+	"github.com/pgombola/clarify-svc/internal/backup"
+	nomadapi "github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/clarify-svc/internal/redact"
+)
+
+// snapshotConfig holds the -snapshot-* flags
+type snapshotConfig struct {
+	dest     string
+	interval time.Duration
+	keep     int
+	maxAge   time.Duration
+	token    string
+	// tokenFile holds the token when it isn't given
+	tokenFile string
+}
+
+// newSnapshots returns the snapshot schedule of the agent's http api, or nil
+// when snapshots aren't enabled. The token needs the management policy.
+func (p *nomad) newSnapshots(cfg *snapshotConfig, wd string) (*backup.Schedule, error) {
+	if len(cfg.dest) == 0 {
+		return nil, nil
+	}
+	if cfg.interval <= 0 {
+		return nil, fmt.Errorf("-snapshot-interval %v must be positive", cfg.interval)
+	}
+	dest := cfg.dest
+	if !strings.HasPrefix(dest, "s3://") && !filepath.IsAbs(dest) {
+		dest = filepath.Join(wd, dest)
+	}
+	store, err := backup.Open(dest)
+	if err != nil {
+		return nil, err
+	}
+	token := cfg.token
+	if len(token) == 0 && len(cfg.tokenFile) != 0 {
+		buf, err := ioutil.ReadFile(cfg.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read snapshot token: %v", err)
+		}
+		token = strings.TrimSpace(string(buf))
+	}
+	if len(token) == 0 {
+		token = os.Getenv("NOMAD_TOKEN")
+	}
+	redact.Add(token)
+	nomadapi.Token = token
+	return &backup.Schedule{
+		Store:    store,
+		Prefix:   p.name,
+		Interval: cfg.interval,
+		Keep:     cfg.keep,
+		MaxAge:   cfg.maxAge,
+		Take: func() ([]byte, error) {
+			return nomadapi.Snapshot(p.agentAddress())
+		},
+	}, nil
+}
+
+// watchSnapshots takes snapshots on the schedule until the program exits.
+// Clients have no raft state so only servers take them.
+func (p *nomad) watchSnapshots() {
+	if p.snapshots == nil {
+		return
+	}
+	server, err := p.isServer()
+	if err != nil {
+		p.logger.Warningf("unable to determine agent mode; not taking snapshots: %v", err)
+		return
+	}
+	if !server {
+		return
+	}
+	p.snapshots.Logger = p.logger
+	p.startSnapshots.Do(func() {
+		p.crash.Go(func() { p.snapshots.Run(p.exit, p.snapshotFailed) })
+	})
+}
+
+func (p *nomad) snapshotFailed(err error) {
+	p.metrics.Incr("snapshot.failures")
+	p.notifier.Notify("snapshot_failed", fmt.Sprintf("nomad snapshot failed: %v", err))
+}
+
+// snapshot runs the snapshot subcommand, taking a snapshot now
+func (p *nomad) snapshot(args []string) {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	flags.Parse(args)
+	if p.snapshots == nil {
+		log.Fatal("snapshots need -snapshot-dest")
+	}
+	p.snapshots.Logger = p.logger
+	name, err := p.snapshots.Once()
+	p.audit.Record("snapshot", audit.User(), err, name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(name)
+}
+
+// restore runs the restore subcommand, replacing the cluster's raft state
+// with a stored snapshot, the latest one or a file
+func (p *nomad) restore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	file := flags.String("file", "", "Snapshot file to restore instead of one from -snapshot-dest.")
+	flags.Parse(args)
+	var data []byte
+	var source string
+	var err error
+	switch {
+	case len(*file) != 0:
+		source = *file
+		data, err = ioutil.ReadFile(*file)
+	case flags.NArg() != 1:
+		err = errors.New("usage: restore <snapshot>|latest or restore -file <path>")
+	case p.snapshots == nil:
+		err = errors.New("restoring a stored snapshot needs -snapshot-dest")
+	default:
+		source, data, err = p.storedSnapshot(flags.Arg(0))
+	}
+	if err == nil {
+		err = nomadapi.RestoreSnapshot(p.agentAddress(), data)
+	}
+	p.audit.Record("restore", audit.User(), err, source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("restored %s\n", source)
+}
+
+// storedSnapshot reads the snapshot called name from the store, or the newest
+// one when name is latest
+func (p *nomad) storedSnapshot(name string) (string, []byte, error) {
+	if name == "latest" {
+		names, err := backup.Snapshots(p.snapshots.Store, p.snapshots.Prefix)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(names) == 0 {
+			return "", nil, errors.New("no snapshots stored")
+		}
+		name = names[len(names)-1]
+	}
+	data, err := p.snapshots.Store.Get(name)
+	return name, data, err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pgombola/clarify-svc/internal/runas"
+)
+
+// hostVolume is a nomad client host volume managed by the wrapper
+type hostVolume struct {
+	name     string
+	path     string
+	readOnly bool
+}
+
+// parseHostVolumes parses the comma separated name=path[:ro] -host-volumes
+// list, resolving relative paths against wd
+func parseHostVolumes(list string, wd string) ([]hostVolume, error) {
+	volumes := make([]hostVolume, 0)
+	seen := make(map[string]bool)
+	for _, spec := range strings.Split(list, ",") {
+		spec = strings.TrimSpace(spec)
+		if len(spec) == 0 {
+			continue
+		}
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid -host-volumes %q; expected name=path[:ro]", spec)
+		}
+		v := hostVolume{name: parts[0], path: parts[1]}
+		if strings.HasSuffix(v.path, ":ro") {
+			v.path = strings.TrimSuffix(v.path, ":ro")
+			v.readOnly = true
+		}
+		if seen[v.name] {
+			return nil, fmt.Errorf("invalid -host-volumes; %s is declared twice", v.name)
+		}
+		seen[v.name] = true
+		if !filepath.IsAbs(v.path) {
+			v.path = filepath.Join(wd, v.path)
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes, nil
+}
+
+// prepareHostVolumes creates the host volume directories, owned by the
+// -run-as user, and writes the generated agent config declaring them.
+// Returns the path of the generated config or an empty path when there are
+// no host volumes.
+func (p *nomad) prepareHostVolumes() (string, error) {
+	if len(p.hostVolumes) == 0 {
+		return "", nil
+	}
+	var hcl strings.Builder
+	hcl.WriteString("client {\n")
+	for _, v := range p.hostVolumes {
+		if err := os.MkdirAll(v.path, 0755); err != nil {
+			return "", err
+		}
+		if err := runas.Chown(v.path, p.runAs); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&hcl, "  host_volume %q {\n    path      = %q\n    read_only = %t\n  }\n", v.name, v.path, v.readOnly)
+	}
+	hcl.WriteString("}\n")
+	path := filepath.Join(filepath.Dir(p.data), p.name+".volumes.hcl")
+	return path, ioutil.WriteFile(path, []byte(hcl.String()), 0644)
+}
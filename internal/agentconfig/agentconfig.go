@@ -0,0 +1,172 @@
+// Package agentconfig renders the consul and nomad agent configs from the
+// templates embedded in the binaries, used by init-config and to give the
+// wrappers a default config on first start.
+package agentconfig
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templates embed.FS
+
+// Agent config templates
+var (
+	Consul = parse("consul.json.tmpl")
+	Nomad  = parse("nomad.hcl.tmpl")
+)
+
+func parse(name string) *template.Template {
+	return template.Must(template.New(name).Funcs(template.FuncMap{"json": jsonList, "join": strings.Join}).ParseFS(templates, "templates/"+name))
+}
+
+// Values holds the node specific values rendered into the agent configs
+type Values struct {
+	Bind            string
+	Datacenter      string
+	RetryJoin       []string
+	ConsulData      string
+	NomadData       string
+	Server          bool
+	BootstrapExpect int
+	// RawExec enables the raw_exec driver, which nomad disables by default
+	// but the clarify job runs with
+	RawExec bool
+	// DockerPrivileged allows docker tasks to run privileged containers
+	DockerPrivileged bool
+	// DisabledDrivers are task drivers the nomad client won't fingerprint
+	DisabledDrivers []string
+	// NodeClass is the scheduling class of the nomad client
+	NodeClass string
+}
+
+// validName matches datacenter and node class names
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// ValidName returns an error unless name is a valid datacenter or node
+// class name
+func ValidName(kind string, name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid %s %q; use letters, digits, '.', '_' and '-'", kind, name)
+	}
+	return nil
+}
+
+// SetScheduling places the nomad client in datacenter, unless empty, and
+// node class
+func (v *Values) SetScheduling(datacenter string, nodeClass string) error {
+	if len(datacenter) != 0 {
+		if err := ValidName("datacenter", datacenter); err != nil {
+			return err
+		}
+		v.Datacenter = datacenter
+	}
+	if len(nodeClass) != 0 {
+		if err := ValidName("node class", nodeClass); err != nil {
+			return err
+		}
+	}
+	v.NodeClass = nodeClass
+	return nil
+}
+
+// SetDrivers configures the nomad client task drivers from the comma
+// separated list of drivers to disable
+func (v *Values) SetDrivers(rawExec bool, dockerPrivileged bool, disabled string) error {
+	v.RawExec = rawExec
+	v.DockerPrivileged = dockerPrivileged
+	v.DisabledDrivers = nil
+	for _, d := range strings.Split(disabled, ",") {
+		if d = strings.TrimSpace(d); len(d) == 0 {
+			continue
+		}
+		if d == "raw_exec" && rawExec {
+			return errors.New("raw_exec can't be both enabled and disabled")
+		}
+		v.DisabledDrivers = append(v.DisabledDrivers, d)
+	}
+	return nil
+}
+
+// Defaults returns the values of a single client node in datacenter dc1
+// bound to the first non-loopback address, keeping agent data beneath dir
+func Defaults(dir string) (*Values, error) {
+	bind, err := BindAddress()
+	if err != nil {
+		return nil, err
+	}
+	return &Values{
+		Bind:       bind,
+		Datacenter: "dc1",
+		ConsulData: filepath.ToSlash(filepath.Join(dir, "consul-data")),
+		NomadData:  filepath.ToSlash(filepath.Join(dir, "data")),
+		RawExec:    true,
+	}, nil
+}
+
+// Render writes tmpl rendered with v to path, refusing to replace an
+// existing file unless force
+func Render(tmpl *template.Template, v *Values, path string, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists; use -force to overwrite it", path)
+	} else if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(f, v); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// BindAddress returns the first non-loopback IPv4 address of the host
+func BindAddress() (string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			return ipnet.IP.String(), nil
+		}
+	}
+	return "", errors.New("no non-loopback address found; use -bind")
+}
+
+// Materialize writes the config rendered from tmpl with v, or the Defaults
+// of dir when nil, to dir/name, for agents started without a config.
+// Returns its path.
+func Materialize(tmpl *template.Template, v *Values, dir string, name string) (string, error) {
+	if strings.ContainsAny(name, "*?[") {
+		return "", fmt.Errorf("no config matches %s", name)
+	}
+	if v == nil {
+		var err error
+		if v, err = Defaults(dir); err != nil {
+			return "", err
+		}
+	}
+	path := filepath.Join(dir, name)
+	return path, Render(tmpl, v, path, false)
+}
+
+func jsonList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
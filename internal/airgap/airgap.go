@@ -0,0 +1,196 @@
+// Package airgap verifies and unpacks the signed archives (.tar, .tar.gz,
+// .tgz or .zip) installing the wrappers, agents, configs and job
+// specification on hosts without internet access. Bundles are signed with
+// ed25519 over the archive bytes, e.g.
+//
+//	openssl pkeyutl -sign -rawin -inkey key.pem -in bundle.tgz -out bundle.tgz.sig
+package airgap
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ReadKey reads an ed25519 public key from a PEM (PKIX) or base64 file
+func ReadKey(file string) (ed25519.PublicKey, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(buf); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %v", file, err)
+		}
+		if k, ok := key.(ed25519.PublicKey); ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("public key %s isn't an ed25519 key", file)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(buf)))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key %s; expected PEM or base64 ed25519 key", file)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// ReadSignature reads a raw or base64 ed25519 signature file
+func ReadSignature(file string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == ed25519.SignatureSize {
+		return buf, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(buf)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid signature %s; expected raw or base64 ed25519 signature", file)
+	}
+	return sig, nil
+}
+
+// Verify checks sig is key's signature of the archive at file
+func Verify(file string, sig []byte, key ed25519.PublicKey) error {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, buf, sig) {
+		return fmt.Errorf("bundle %s signature doesn't match the public key", file)
+	}
+	return nil
+}
+
+// Extract unpacks the archive at file. dest maps each entry's slash
+// separated name to the path it's written to; an empty path skips it.
+// Returns the paths written.
+func Extract(file string, dest func(name string) string) ([]string, error) {
+	switch {
+	case strings.HasSuffix(file, ".zip"):
+		return extractZip(file, dest)
+	case strings.HasSuffix(file, ".tar.gz"), strings.HasSuffix(file, ".tgz"):
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		return extractTar(gz, dest)
+	case strings.HasSuffix(file, ".tar"):
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return extractTar(f, dest)
+	}
+	return nil, fmt.Errorf("unsupported bundle %s; expected .tar, .tar.gz, .tgz or .zip", file)
+}
+
+func extractTar(r io.Reader, dest func(string) string) ([]string, error) {
+	var written []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return written, nil
+		} else if err != nil {
+			return written, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+		default:
+			return written, fmt.Errorf("bundle entry %s isn't a regular file", hdr.Name)
+		}
+		target, err := entryPath(hdr.Name, dest)
+		if err != nil {
+			return written, err
+		} else if len(target) == 0 {
+			continue
+		}
+		if err := writeFile(target, tr, os.FileMode(hdr.Mode).Perm()); err != nil {
+			return written, err
+		}
+		written = append(written, target)
+	}
+}
+
+func extractZip(file string, dest func(string) string) ([]string, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var written []string
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			return written, fmt.Errorf("bundle entry %s isn't a regular file", f.Name)
+		}
+		target, err := entryPath(f.Name, dest)
+		if err != nil {
+			return written, err
+		} else if len(target) == 0 {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return written, err
+		}
+		err = writeFile(target, rc, f.Mode().Perm())
+		rc.Close()
+		if err != nil {
+			return written, err
+		}
+		written = append(written, target)
+	}
+	return written, nil
+}
+
+// entryPath returns where the entry named name is written, refusing names
+// escaping the bundle
+func entryPath(name string, dest func(string) string) (string, error) {
+	clean := path.Clean(strings.TrimPrefix(strings.Replace(name, "\\", "/", -1), "./"))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || filepath.VolumeName(clean) != "" {
+		return "", fmt.Errorf("bundle entry %s is outside the bundle", name)
+	}
+	return dest(clean), nil
+}
+
+func writeFile(target string, r io.Reader, mode os.FileMode) error {
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,78 @@
+// Package audit appends a record of control actions and lifecycle decisions
+// to an append-only json lines file for compliance review.
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// Record is a single line of the audit log
+type Record struct {
+	Time      time.Time `json:"time"`
+	Service   string    `json:"service"`
+	Action    string    `json:"action"`
+	Initiator string    `json:"initiator"`
+	Outcome   string    `json:"outcome"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// Log appends records to the file at Path. A nil Log discards records.
+type Log struct {
+	Path    string
+	Service string
+	mu      sync.Mutex
+}
+
+// Open returns a Log appending to path, or nil when path is empty
+func Open(path string, service string) *Log {
+	if len(path) == 0 {
+		return nil
+	}
+	return &Log{Path: path, Service: service}
+}
+
+// Record appends action to the log. The outcome is "success" unless err is
+// set.
+func (l *Log) Record(action string, initiator string, err error, detail string) error {
+	if l == nil {
+		return nil
+	}
+	r := &Record{
+		Time:      time.Now().UTC(),
+		Service:   l.Service,
+		Action:    action,
+		Initiator: initiator,
+		Outcome:   "success",
+		Detail:    detail,
+	}
+	if err != nil {
+		r.Outcome = err.Error()
+	}
+	line, merr := json.Marshal(r)
+	if merr != nil {
+		return merr
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ferr := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if ferr != nil {
+		return ferr
+	}
+	defer f.Close()
+	_, werr := f.Write(append(line, '\n'))
+	return werr
+}
+
+// User returns the name of the user running the process, used as the
+// initiator of commands run from a shell
+func User() string {
+	u, err := user.Current()
+	if err != nil {
+		return "unknown"
+	}
+	return "user:" + u.Username
+}
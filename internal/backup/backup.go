@@ -0,0 +1,178 @@
+// Package backup takes periodic agent snapshots and keeps them in a
+// directory or an S3 compatible bucket, pruning them by count and age.
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// timeFormat is the timestamp in snapshot names, sorting chronologically
+const timeFormat = "20060102T150405Z"
+
+// Store keeps snapshots by name
+type Store interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	// List returns the names of the stored snapshots
+	List() ([]string, error)
+	Delete(name string) error
+}
+
+// Open returns the store at dest, an s3://bucket/prefix url or a directory.
+// S3 urls take the endpoint and region query parameters and credentials from
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func Open(dest string) (Store, error) {
+	if strings.HasPrefix(dest, "s3://") {
+		return newS3(dest)
+	}
+	if err := os.MkdirAll(dest, 0700); err != nil {
+		return nil, err
+	}
+	return Dir(dest), nil
+}
+
+// Dir stores snapshots as files in a directory
+type Dir string
+
+// Put writes the snapshot, renaming it in place once complete
+func (d Dir) Put(name string, data []byte) error {
+	path := filepath.Join(string(d), name)
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Get reads the snapshot
+func (d Dir) Get(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(d), name))
+}
+
+// List returns the snapshots in the directory
+func (d Dir) List() ([]string, error) {
+	files, err := ioutil.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		if !f.IsDir() && !strings.HasSuffix(f.Name(), ".tmp") {
+			names = append(names, f.Name())
+		}
+	}
+	return names, nil
+}
+
+// Delete removes the snapshot
+func (d Dir) Delete(name string) error {
+	return os.Remove(filepath.Join(string(d), name))
+}
+
+// Name returns the name of a snapshot taken at t
+func Name(prefix string, t time.Time) string {
+	return prefix + "-" + t.UTC().Format(timeFormat) + ".snap"
+}
+
+// Snapshots returns the names of the snapshots with prefix in store, oldest
+// first
+func Snapshots(store Store, prefix string) ([]string, error) {
+	names, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, ok := taken(prefix, name); ok {
+			matched = append(matched, name)
+		}
+	}
+	sort.Strings(matched)
+	return matched, nil
+}
+
+// taken parses the time a snapshot named by Name was taken
+func taken(prefix string, name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, prefix+"-") || !strings.HasSuffix(name, ".snap") {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(timeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix+"-"), ".snap"))
+	return t, err == nil
+}
+
+// Prune deletes the snapshots with prefix beyond the newest keep and those
+// older than maxAge. Zero disables either limit.
+func Prune(store Store, prefix string, keep int, maxAge time.Duration) ([]string, error) {
+	names, err := Snapshots(store, prefix)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make([]string, 0)
+	for i, name := range names {
+		t, _ := taken(prefix, name)
+		old := maxAge > 0 && time.Since(t) > maxAge
+		extra := keep > 0 && i < len(names)-keep
+		if !old && !extra {
+			continue
+		}
+		if err := store.Delete(name); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, name)
+	}
+	return deleted, nil
+}
+
+// Schedule takes a snapshot every Interval and applies the retention policy
+type Schedule struct {
+	Store    Store
+	Prefix   string
+	Interval time.Duration
+	Keep     int
+	MaxAge   time.Duration
+	Take     func() ([]byte, error)
+	Logger   service.Logger
+}
+
+// Once takes a snapshot and prunes old ones
+// Returns the name of the new snapshot
+func (s *Schedule) Once() (string, error) {
+	data, err := s.Take()
+	if err != nil {
+		return "", err
+	}
+	name := Name(s.Prefix, time.Now())
+	if err := s.Store.Put(name, data); err != nil {
+		return "", err
+	}
+	s.Logger.Infof("snapshot saved (name=%s;bytes=%d)", name, len(data))
+	deleted, err := Prune(s.Store, s.Prefix, s.Keep, s.MaxAge)
+	for _, d := range deleted {
+		s.Logger.Infof("snapshot pruned (name=%s)", d)
+	}
+	return name, err
+}
+
+// Run takes snapshots until exit is closed, calling failed after each
+// unsuccessful one
+func (s *Schedule) Run(exit <-chan struct{}, failed func(error)) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Once(); err != nil {
+				s.Logger.Warningf("error taking snapshot: %v", err)
+				failed(err)
+			}
+		case <-exit:
+			return
+		}
+	}
+}
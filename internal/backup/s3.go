@@ -0,0 +1,212 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 stores snapshots in a bucket of an S3 compatible endpoint, using path
+// style requests signed with AWS signature version 4
+type S3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	http      *http.Client
+}
+
+// newS3 parses s3://bucket/prefix?endpoint=https://host&region=us-east-1
+func newS3(dest string) (*S3, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("s3 destination %q has no bucket", dest)
+	}
+	s := &S3{
+		Endpoint:  strings.TrimSuffix(u.Query().Get("endpoint"), "/"),
+		Region:    u.Query().Get("region"),
+		Bucket:    u.Host,
+		Prefix:    strings.Trim(u.Path, "/"),
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		http:      &http.Client{Timeout: 5 * time.Minute},
+	}
+	if len(s.Region) == 0 {
+		s.Region = "us-east-1"
+	}
+	if len(s.Endpoint) == 0 {
+		s.Endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	}
+	if len(s.AccessKey) == 0 || len(s.SecretKey) == 0 {
+		return nil, errors.New("s3 destinations require AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+func (s *S3) key(name string) string {
+	if len(s.Prefix) == 0 {
+		return name
+	}
+	return s.Prefix + "/" + name
+}
+
+// Put uploads the snapshot
+func (s *S3) Put(name string, data []byte) error {
+	_, err := s.do(http.MethodPut, s.key(name), nil, data)
+	return err
+}
+
+// Get downloads the snapshot
+func (s *S3) Get(name string) ([]byte, error) {
+	return s.do(http.MethodGet, s.key(name), nil, nil)
+}
+
+// Delete removes the snapshot
+func (s *S3) Delete(name string) error {
+	_, err := s.do(http.MethodDelete, s.key(name), nil, nil)
+	return err
+}
+
+// List returns the snapshots under the prefix
+func (s *S3) List() ([]string, error) {
+	prefix := s.key("")
+	names := make([]string, 0)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if len(token) != 0 {
+			query.Set("continuation-token", token)
+		}
+		body, err := s.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			name := strings.TrimPrefix(c.Key, prefix)
+			if !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if !result.IsTruncated {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) do(method string, key string, query url.Values, body []byte) ([]byte, error) {
+	path := "/" + uriEncode(s.Bucket, false)
+	if len(key) != 0 {
+		path += "/" + uriEncode(key, true)
+	}
+	rawQuery := canonicalQuery(query)
+	u := s.Endpoint + path
+	if len(rawQuery) != 0 {
+		u += "?" + rawQuery
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, path, rawQuery, body, time.Now().UTC())
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("s3: %v %v returned %v: %v", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// sign adds the AWS signature version 4 authorization header to req
+func (s *S3) sign(req *http.Request, path string, rawQuery string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payload := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payload)
+	headers := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + payload + "\nx-amz-date:" + amzDate + "\n"
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{req.Method, path, rawQuery, headers, signed, payload}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKey, scope, signed, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, uriEncode(k, false)+"="+uriEncode(query.Get(k), false))
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent encodes everything but unreserved characters, and slashes
+// when keepSlash is set
+func uriEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,97 @@
+// Package buildinfo describes the build of the running binary so support can
+// confirm which wrapper build a node runs. Release builds set the version,
+// commit and date with
+//
+//	-ldflags "-X github.com/pgombola/clarify-svc/internal/buildinfo.Version=<semver>
+//	          -X github.com/pgombola/clarify-svc/internal/buildinfo.Commit=<sha>
+//	          -X github.com/pgombola/clarify-svc/internal/buildinfo.Date=<rfc3339>"
+package buildinfo
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Versions of the agent apis the wrappers are built against
+const (
+	NomadAPI  = "v1 (nomad >= 1.0.0)"
+	ConsulAPI = "v1 (consul >= 1.4.0)"
+)
+
+// Info is the build of the running binary
+type Info struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+	NomadAPI  string `json:"nomad_api"`
+	ConsulAPI string `json:"consul_api"`
+}
+
+// Get returns the build of the binary named name. The commit and date fall
+// back to the version control stamp of the go toolchain when not set.
+func Get(name string) Info {
+	info := Info{
+		Name:      name,
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		NomadAPI:  NomadAPI,
+		ConsulAPI: ConsulAPI,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && len(info.Commit) == 0:
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && len(info.Date) == 0:
+				info.Date = s.Value
+			}
+		}
+	}
+	if len(info.Commit) == 0 {
+		info.Commit = "unknown"
+	}
+	if len(info.Date) == 0 {
+		info.Date = "unknown"
+	}
+	return info
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("%s %s\n  commit:     %s\n  built:      %s\n  go:         %s (%s)\n  nomad api:  %s\n  consul api: %s\n",
+		i.Name, i.Version, i.Commit, i.Date, i.GoVersion, i.Platform, i.NomadAPI, i.ConsulAPI)
+}
+
+// Command runs the version subcommand of the binary named name, writing the
+// build to w
+// Usage: version [-json]
+func Command(name string, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the build as json.")
+	fs.Parse(args)
+	info := Get(name)
+	if *asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(info)
+	}
+	_, err := io.WriteString(w, info.String())
+	return err
+}
@@ -0,0 +1,206 @@
+// Package certs issues the TLS certificates consul and nomad agents use,
+// either signed by a local CA or issued from a vault pki mount, and reissues
+// them as they approach expiry.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/vault"
+)
+
+// Files written to the certificate directory
+const (
+	CertFile = "cert.pem"
+	KeyFile  = "key.pem"
+	CAFile   = "ca.pem"
+)
+
+// Bundle is a pem encoded certificate, its private key and the issuing CA
+type Bundle struct {
+	Cert []byte
+	Key  []byte
+	CA   []byte
+}
+
+// Issuer returns a new bundle valid for names
+type Issuer func(names []string) (*Bundle, error)
+
+// FromCA returns an Issuer signing certificates valid for ttl with the CA
+// certificate and key in the provided pem files
+func FromCA(certFile string, keyFile string, ttl time.Duration) Issuer {
+	return func(names []string) (*Bundle, error) {
+		ca, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("certs: unable to load CA: %v", err)
+		}
+		caCert, err := x509.ParseCertificate(ca.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("certs: unable to parse CA: %v", err)
+		}
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+		if err != nil {
+			return nil, err
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: serial,
+			Subject:      pkix.Name{CommonName: names[0]},
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(ttl),
+			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}
+		for _, name := range names {
+			if ip := net.ParseIP(name); ip != nil {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			} else {
+				tmpl.DNSNames = append(tmpl.DNSNames, name)
+			}
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, ca.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("certs: unable to sign certificate: %v", err)
+		}
+		keyDer, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &Bundle{
+			Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			Key:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+			CA:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+		}, nil
+	}
+}
+
+// FromVault returns an Issuer requesting certificates from the vault pki
+// issue path (e.g. pki/issue/agents)
+func FromVault(v *vault.Client, path string) Issuer {
+	return func(names []string) (*Bundle, error) {
+		dns := make([]string, 0)
+		ips := make([]string, 0)
+		for _, name := range names[1:] {
+			if net.ParseIP(name) != nil {
+				ips = append(ips, name)
+			} else {
+				dns = append(dns, name)
+			}
+		}
+		secret, err := v.Write(path, map[string]string{
+			"common_name": names[0],
+			"alt_names":   strings.Join(dns, ","),
+			"ip_sans":     strings.Join(ips, ","),
+		})
+		if err != nil {
+			return nil, err
+		}
+		b := &Bundle{}
+		for field, dst := range map[string]*[]byte{"certificate": &b.Cert, "private_key": &b.Key, "issuing_ca": &b.CA} {
+			v, err := secret.Field(field)
+			if err != nil {
+				return nil, err
+			}
+			*dst = []byte(v)
+		}
+		return b, nil
+	}
+}
+
+// Write stores the bundle in dir
+func (b *Bundle) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	files := map[string][]byte{CertFile: b.Cert, KeyFile: b.Key, CAFile: b.CA}
+	for name, buf := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), buf, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expiry returns when the certificate in dir expires
+func Expiry(dir string) (time.Time, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, CertFile))
+	if err != nil {
+		return time.Time{}, err
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return time.Time{}, errors.New("certs: certificate isn't pem encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+// Rotator keeps the certificate in Dir valid, reissuing it once it's within
+// Renew of expiring
+type Rotator struct {
+	Dir    string
+	Names  []string
+	Renew  time.Duration
+	Issue  Issuer
+	Logger service.Logger
+}
+
+// Ensure issues a certificate when none exists or the current one is due for
+// renewal
+// Returns whether a new certificate was written
+func (r *Rotator) Ensure() (bool, error) {
+	if expires, err := Expiry(r.Dir); err == nil && time.Until(expires) > r.Renew {
+		return false, nil
+	}
+	b, err := r.Issue(r.Names)
+	if err != nil {
+		return false, err
+	}
+	if err := b.Write(r.Dir); err != nil {
+		return false, err
+	}
+	r.Logger.Infof("issued tls certificate (dir=%s)", r.Dir)
+	return true, nil
+}
+
+// Watch checks the certificate every interval until exit is closed, calling
+// reload after a new certificate is written so the agent picks it up
+func (r *Rotator) Watch(interval time.Duration, exit <-chan struct{}, reload func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rotated, err := r.Ensure()
+			if err != nil {
+				r.Logger.Warningf("error reissuing tls certificate: %v", err)
+			} else if rotated {
+				reload()
+			}
+		case <-exit:
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package chaos injects failures into the clarify binaries so their recovery
+// logic can be exercised in staging: nomad api errors, slow responses and
+// kills of the supervised agent. The -chaos flag only exists in binaries
+// built with the chaos build tag.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// ErrInjected is returned by calls failed on purpose
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Injector randomly fails, delays and kills. A nil Injector injects nothing.
+type Injector struct {
+	// ErrorRate is the probability, from 0 to 1, of a call failing
+	ErrorRate float64
+	// MaxDelay bounds the random delay added to every call
+	MaxDelay time.Duration
+	// KillInterval is the mean time between kills of the supervised process
+	KillInterval time.Duration
+	Logger       service.Logger
+	mu           sync.Mutex
+	rand         *rand.Rand
+}
+
+// Parse returns the injector of spec, a comma separated list of
+// errors=<rate>, delay=<duration> and kill=<duration>. Returns nil for an
+// empty spec.
+func Parse(spec string, logger service.Logger) (*Injector, error) {
+	if len(spec) == 0 {
+		return nil, nil
+	}
+	i := &Injector{Logger: logger, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for _, opt := range strings.Split(spec, ",") {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid chaos option %q; expected key=value", opt)
+		}
+		var err error
+		switch kv[0] {
+		case "errors":
+			i.ErrorRate, err = strconv.ParseFloat(kv[1], 64)
+			if err == nil && (i.ErrorRate < 0 || i.ErrorRate > 1) {
+				err = errors.New("rate must be between 0 and 1")
+			}
+		case "delay":
+			i.MaxDelay, err = time.ParseDuration(kv[1])
+		case "kill":
+			i.KillInterval, err = time.ParseDuration(kv[1])
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid chaos option %q: %v", opt, err)
+		}
+	}
+	return i, nil
+}
+
+func (i *Injector) float() float64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rand.Float64()
+}
+
+// Fault delays the call named call and fails it at the error rate
+func (i *Injector) Fault(call string) error {
+	if i == nil {
+		return nil
+	}
+	if i.MaxDelay > 0 {
+		time.Sleep(time.Duration(i.float() * float64(i.MaxDelay)))
+	}
+	if i.ErrorRate > 0 && i.float() < i.ErrorRate {
+		i.Logger.Warningf("chaos: failing %s", call)
+		return fmt.Errorf("%w (call=%s)", ErrInjected, call)
+	}
+	return nil
+}
+
+// Kill calls kill at random intervals averaging the kill interval until exit
+// closes. Does nothing without a kill interval.
+func (i *Injector) Kill(exit <-chan struct{}, kill func() error) {
+	if i == nil || i.KillInterval <= 0 {
+		return
+	}
+	for {
+		// Uniform between half and one and a half intervals
+		wait := time.Duration((0.5 + i.float()) * float64(i.KillInterval))
+		select {
+		case <-time.After(wait):
+			i.Logger.Warning("chaos: killing supervised process")
+			if err := kill(); err != nil {
+				i.Logger.Warningf("chaos: kill failed: %v", err)
+			}
+		case <-exit:
+			return
+		}
+	}
+}
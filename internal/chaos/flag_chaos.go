@@ -0,0 +1,11 @@
+//go:build chaos
+// +build chaos
+
+package chaos
+
+import "flag"
+
+// Flag registers the -chaos flag
+func Flag() *string {
+	return flag.String("chaos", "", "Failures to inject for testing recovery: comma separated errors=<rate 0-1>, delay=<max duration> and kill=<mean interval>.")
+}
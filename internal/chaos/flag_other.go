@@ -0,0 +1,10 @@
+//go:build !chaos
+// +build !chaos
+
+package chaos
+
+// Flag returns an empty spec; the -chaos flag only exists in binaries built
+// with the chaos build tag
+func Flag() *string {
+	return new(string)
+}
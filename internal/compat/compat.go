@@ -0,0 +1,113 @@
+// Package compat holds the table of nomad and consul versions the wrappers
+// support so unsupported agents are caught at startup rather than through
+// subtle api mismatches.
+package compat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Levels of a rule
+const (
+	Unsupported = "unsupported"
+	Untested    = "untested"
+)
+
+// Range is the versions from Min up to but excluding Max. An empty bound is
+// open.
+type Range struct {
+	Min string
+	Max string
+}
+
+// contains reports whether version is in r; unknown versions never are
+func (r Range) contains(version string) bool {
+	v, ok := parse(version)
+	if !ok {
+		return false
+	}
+	if min, ok := parse(r.Min); ok && less(v, min) {
+		return false
+	}
+	if max, ok := parse(r.Max); ok && !less(v, max) {
+		return false
+	}
+	return true
+}
+
+// Rule flags agents whose versions fall in its ranges. A nil range matches
+// any version of that agent.
+type Rule struct {
+	Nomad  *Range
+	Consul *Range
+	Level  string
+	Reason string
+}
+
+// Table is the compatibility table of this build
+var Table = []Rule{
+	{Nomad: &Range{Max: "0.8.0"}, Level: Unsupported, Reason: "nomad before 0.8 has no drain spec api"},
+	{Nomad: &Range{Min: "0.8.0", Max: "1.0.0"}, Level: Untested, Reason: "nomad before 1.0 hasn't been tested"},
+	{Nomad: &Range{Min: "2.0.0"}, Level: Untested, Reason: "nomad 2 and newer haven't been tested"},
+	{Consul: &Range{Max: "0.9.1"}, Level: Unsupported, Reason: "consul before 0.9.1 has no autopilot health api"},
+	{Consul: &Range{Min: "0.9.1", Max: "1.4.0"}, Level: Untested, Reason: "consul before 1.4 uses the legacy acl system"},
+	{Consul: &Range{Min: "2.0.0"}, Level: Untested, Reason: "consul 2 and newer haven't been tested"},
+}
+
+// Finding is a rule matched by the detected agents
+type Finding struct {
+	Level  string
+	Reason string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s", f.Level, f.Reason)
+}
+
+// Check returns the rules of Table matched by the nomad and consul versions.
+// Rules about an agent whose version is unknown ("") don't match.
+func Check(nomad string, consul string) []Finding {
+	var findings []Finding
+	for _, rule := range Table {
+		if rule.Nomad != nil && !rule.Nomad.contains(nomad) {
+			continue
+		}
+		if rule.Consul != nil && !rule.Consul.contains(consul) {
+			continue
+		}
+		findings = append(findings, Finding{Level: rule.Level, Reason: rule.Reason})
+	}
+	return findings
+}
+
+// parse returns the major, minor and patch numbers of version, ignoring a
+// leading v and any prerelease or build suffix
+func parse(version string) ([3]int, bool) {
+	var v [3]int
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	if len(version) == 0 {
+		return v, false
+	}
+	for i, part := range strings.SplitN(version, ".", 3) {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+func less(a, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
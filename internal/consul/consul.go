@@ -0,0 +1,534 @@
+// Package consul is a minimal client for the parts of the Consul HTTP API
+// used by the clarify service wrappers.
+package consul
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/opid"
+)
+
+// Client is connection parameters to a consul agent
+type Client struct {
+	// Scheme is http or https
+	Scheme    string
+	Address   string
+	Port      int
+	Token     string
+	TokenFile string
+	Operation string
+	// DryRun, when set, is given the mutating requests instead of consul.
+	// They succeed without being sent; lock acquisitions report success.
+	DryRun func(call string, body []byte)
+	http   *http.Client
+	watch  *http.Client
+}
+
+// KVPair represents a json object in the consul kv store
+type KVPair struct {
+	Key         string `json:"Key"`
+	Value       []byte `json:"Value"`
+	Session     string `json:"Session"`
+	ModifyIndex uint64 `json:"ModifyIndex"`
+}
+
+// ErrNotFound is returned when consul responds with a 404
+var ErrNotFound = errors.New("consul: not found")
+
+// PermissionDenied is returned when the ACL token doesn't grant access to a
+// consul endpoint
+type PermissionDenied struct {
+	Method string
+	Path   string
+}
+
+func (e *PermissionDenied) Error() string {
+	return fmt.Sprintf("consul: permission denied for %v %v; check the ACL token (-consul-token, -consul-token-file or CONSUL_HTTP_TOKEN) has a policy granting access", e.Method, e.Path)
+}
+
+// NewClient returns a Client for the consul agent at address:port
+func NewClient(address string, port int) *Client {
+	return &Client{
+		Scheme:  "http",
+		Address: address,
+		Port:    port,
+		http:    &http.Client{Timeout: 10 * time.Second},
+		watch:   &http.Client{Timeout: 6 * time.Minute},
+	}
+}
+
+// SetTLS makes c use https, verifying the agent with config, the system
+// roots when nil
+func (c *Client) SetTLS(config *tls.Config) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	c.Scheme = "https"
+	c.http.Transport = transport
+	c.watch.Transport = transport
+}
+
+// WithOperation returns a copy of c whose requests carry the operation id
+// header
+func (c *Client) WithOperation(id string) *Client {
+	op := *c
+	op.Operation = id
+	return &op
+}
+
+// SetToken configures the ACL token sent with every request from, in order
+// of precedence, token, the contents of file, or the CONSUL_HTTP_TOKEN and
+// CONSUL_HTTP_TOKEN_FILE environment variables
+func (c *Client) SetToken(token string, file string) error {
+	if len(token) == 0 {
+		token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	if len(file) == 0 {
+		file = os.Getenv("CONSUL_HTTP_TOKEN_FILE")
+	}
+	if len(token) != 0 {
+		c.Token = token
+		return nil
+	}
+	if len(file) != 0 {
+		c.TokenFile = file
+		return c.readTokenFile()
+	}
+	return nil
+}
+
+func (c *Client) readTokenFile() error {
+	token, err := ioutil.ReadFile(c.TokenFile)
+	if err != nil {
+		return fmt.Errorf("consul: unable to read token file: %v", err)
+	}
+	c.Token = strings.TrimSpace(string(token))
+	return nil
+}
+
+// CreateSession creates a session that isn't bound to any health check, so
+// it outlives a restart of the agent that created it. With a ttl the session
+// expires unless it's renewed within ttl; without one it never expires. Keys
+// held by the session are deleted when it's destroyed or expires.
+func (c *Client) CreateSession(name string, ttl time.Duration) (string, error) {
+	body := map[string]interface{}{
+		"Name":      name,
+		"Behavior":  "delete",
+		"LockDelay": "0s",
+		"Checks":    []string{},
+	}
+	if ttl > 0 {
+		body["TTL"] = ttl.String()
+	}
+	var out struct {
+		ID string `json:"ID"`
+	}
+	if err := c.do(http.MethodPut, "/v1/session/create", body, &out); err != nil {
+		return "", err
+	}
+	return out.ID, nil
+}
+
+// RenewSession resets the ttl of the session. Returns ErrNotFound once the
+// session expired.
+func (c *Client) RenewSession(id string) error {
+	return c.do(http.MethodPut, "/v1/session/renew/"+id, nil, nil)
+}
+
+// DestroySession invalidates the session and releases any keys it holds
+func (c *Client) DestroySession(id string) error {
+	return c.do(http.MethodPut, "/v1/session/destroy/"+id, nil, nil)
+}
+
+// Acquire attempts to lock key with the provided session
+// Returns whether the lock was acquired
+func (c *Client) Acquire(key string, session string, value []byte) (bool, error) {
+	var ok bool
+	err := c.do(http.MethodPut, "/v1/kv/"+key+"?acquire="+session, value, &ok)
+	return ok, err
+}
+
+// Get returns the kv pair stored at key or nil if it doesn't exist
+func (c *Client) Get(key string) (*KVPair, error) {
+	pairs := make([]KVPair, 0)
+	if err := c.do(http.MethodGet, "/v1/kv/"+key, nil, &pairs); err == ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	return &pairs[0], nil
+}
+
+// Watch blocks until the kv pair stored at key changes from index, or five
+// minutes elapse, returning the current pair (nil if it doesn't exist) and the
+// index to pass to the next Watch
+func (c *Client) Watch(key string, index uint64) (*KVPair, uint64, error) {
+	pairs, next, err := c.block("/v1/kv/"+key, "", index)
+	if err != nil || len(pairs) == 0 {
+		return nil, next, err
+	}
+	return &pairs[0], next, nil
+}
+
+// WatchPrefix blocks until a kv pair beneath prefix changes from index, or
+// five minutes elapse, returning every pair beneath prefix and the index to
+// pass to the next WatchPrefix
+func (c *Client) WatchPrefix(prefix string, index uint64) ([]KVPair, uint64, error) {
+	return c.block("/v1/kv/"+prefix, "recurse&", index)
+}
+
+// block runs a blocking query of the kv pairs at path. A missing path has no
+// pairs.
+func (c *Client) block(path string, query string, index uint64) ([]KVPair, uint64, error) {
+	req, err := c.request(http.MethodGet, fmt.Sprintf("%s?%sindex=%d&wait=5m", path, query, index), nil)
+	if err != nil {
+		return nil, index, err
+	}
+	resp, err := c.watch.Do(req)
+	if err != nil {
+		return nil, index, err
+	}
+	defer resp.Body.Close()
+	next, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		next = index
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, next, nil
+	case http.StatusForbidden:
+		return nil, index, &PermissionDenied{Method: http.MethodGet, Path: path}
+	default:
+		return nil, index, fmt.Errorf("consul: GET %v returned %v", path, resp.StatusCode)
+	}
+	pairs := make([]KVPair, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return nil, next, err
+	}
+	return pairs, next, nil
+}
+
+// AgentVersion returns the version of the local consul agent
+func (c *Client) AgentVersion() (string, error) {
+	var self struct {
+		Config struct {
+			Version string `json:"Version"`
+		} `json:"Config"`
+	}
+	err := c.do(http.MethodGet, "/v1/agent/self", nil, &self)
+	return self.Config.Version, err
+}
+
+// Datacenter returns the datacenter of the local consul agent
+func (c *Client) Datacenter() (string, error) {
+	var self struct {
+		Config struct {
+			Datacenter string `json:"Datacenter"`
+		} `json:"Config"`
+	}
+	err := c.do(http.MethodGet, "/v1/agent/self", nil, &self)
+	return self.Config.Datacenter, err
+}
+
+// Member is a node in the LAN gossip pool
+type Member struct {
+	Name   string            `json:"Name"`
+	Addr   string            `json:"Addr"`
+	Status int               `json:"Status"`
+	Tags   map[string]string `json:"Tags"`
+}
+
+// Serf member statuses
+var memberStatuses = []string{"none", "alive", "leaving", "left", "failed"}
+
+// StatusName returns the member's status as a word
+func (m *Member) StatusName() string {
+	if m.Status < 0 || m.Status >= len(memberStatuses) {
+		return "unknown"
+	}
+	return memberStatuses[m.Status]
+}
+
+// Members returns the members of the LAN gossip pool
+func (c *Client) Members() ([]Member, error) {
+	members := make([]Member, 0)
+	err := c.do(http.MethodGet, "/v1/agent/members", nil, &members)
+	return members, err
+}
+
+// ForceLeave moves the failed node to the left state, removing it from the
+// member list entirely when prune is set
+func (c *Client) ForceLeave(node string, prune bool) error {
+	path := "/v1/agent/force-leave/" + url.PathEscape(node)
+	if prune {
+		path += "?prune"
+	}
+	return c.do(http.MethodPut, path, nil, nil)
+}
+
+// IsServer reports whether the local agent runs in server mode
+func (c *Client) IsServer() (bool, error) {
+	var self struct {
+		Config struct {
+			Server bool `json:"Server"`
+		} `json:"Config"`
+	}
+	err := c.do(http.MethodGet, "/v1/agent/self", nil, &self)
+	return self.Config.Server, err
+}
+
+// AutopilotHealth represents the raft health reported by autopilot
+type AutopilotHealth struct {
+	Healthy          bool `json:"Healthy"`
+	FailureTolerance int  `json:"FailureTolerance"`
+	Servers          []struct {
+		ID      string `json:"ID"`
+		Name    string `json:"Name"`
+		Address string `json:"Address"`
+		Healthy bool   `json:"Healthy"`
+		Leader  bool   `json:"Leader"`
+	} `json:"Servers"`
+}
+
+// Autopilot returns the raft health of the server cluster. Consul answers
+// 429 when the cluster is unhealthy, still describing its health.
+func (c *Client) Autopilot() (*AutopilotHealth, error) {
+	resp, err := c.send(http.MethodGet, "/v1/operator/autopilot/health", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusTooManyRequests:
+	case http.StatusForbidden:
+		return nil, &PermissionDenied{Method: http.MethodGet, Path: "/v1/operator/autopilot/health"}
+	default:
+		return nil, fmt.Errorf("consul: autopilot health returned %v", resp.StatusCode)
+	}
+	health := &AutopilotHealth{}
+	return health, json.NewDecoder(resp.Body).Decode(health)
+}
+
+// Leader returns the address of the cluster's raft leader
+func (c *Client) Leader() (string, error) {
+	var leader string
+	err := c.do(http.MethodGet, "/v1/status/leader", nil, &leader)
+	if err == nil && len(leader) == 0 {
+		err = errors.New("consul: no cluster leader")
+	}
+	return leader, err
+}
+
+// Put stores value at key
+func (c *Client) Put(key string, value []byte) error {
+	return c.do(http.MethodPut, "/v1/kv/"+key, value, nil)
+}
+
+// Delete removes key
+func (c *Client) Delete(key string) error {
+	return c.do(http.MethodDelete, "/v1/kv/"+key, nil, nil)
+}
+
+// List returns every kv pair beneath prefix
+func (c *Client) List(prefix string) ([]KVPair, error) {
+	pairs := make([]KVPair, 0)
+	err := c.do(http.MethodGet, "/v1/kv/"+prefix+"?recurse", nil, &pairs)
+	if err == ErrNotFound {
+		return pairs, nil
+	}
+	return pairs, err
+}
+
+// AgentServices returns the ids of the services registered with the local
+// agent
+func (c *Client) AgentServices() ([]string, error) {
+	services := make(map[string]interface{})
+	if err := c.do(http.MethodGet, "/v1/agent/services", nil, &services); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(services))
+	for id := range services {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// DeregisterService removes the service with the provided id from the local
+// agent
+func (c *Client) DeregisterService(id string) error {
+	return c.do(http.MethodPut, "/v1/agent/service/deregister/"+id, nil, nil)
+}
+
+// HealthCheck represents the status of a consul health check
+type HealthCheck struct {
+	Name        string `json:"Name"`
+	Status      string `json:"Status"`
+	ServiceName string `json:"ServiceName"`
+	Output      string `json:"Output"`
+}
+
+// NodeServices returns the names of the services registered in the catalog
+// on node
+func (c *Client) NodeServices(node string) ([]string, error) {
+	var out struct {
+		Services map[string]struct {
+			Service string `json:"Service"`
+		} `json:"Services"`
+	}
+	if err := c.do(http.MethodGet, "/v1/catalog/node/"+node, nil, &out); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(out.Services))
+	for _, s := range out.Services {
+		names = append(names, s.Service)
+	}
+	return names, nil
+}
+
+// NodeChecks returns the health checks registered on node
+func (c *Client) NodeChecks(node string) ([]HealthCheck, error) {
+	checks := make([]HealthCheck, 0)
+	err := c.do(http.MethodGet, "/v1/health/node/"+node, nil, &checks)
+	return checks, err
+}
+
+// Keyring lists the gossip encryption keys installed across the cluster and
+// how many members hold each
+func (c *Client) Keyring() (map[string]int, error) {
+	var rings []struct {
+		WAN  bool           `json:"WAN"`
+		Keys map[string]int `json:"Keys"`
+	}
+	if err := c.do(http.MethodGet, "/v1/operator/keyring", nil, &rings); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]int)
+	for _, ring := range rings {
+		if ring.WAN {
+			continue
+		}
+		for key, members := range ring.Keys {
+			keys[key] += members
+		}
+	}
+	return keys, nil
+}
+
+// InstallKey distributes a new gossip encryption key to the cluster
+func (c *Client) InstallKey(key string) error {
+	return c.do(http.MethodPost, "/v1/operator/keyring", map[string]string{"Key": key}, nil)
+}
+
+// UseKey makes key the primary gossip encryption key
+func (c *Client) UseKey(key string) error {
+	return c.do(http.MethodPut, "/v1/operator/keyring", map[string]string{"Key": key}, nil)
+}
+
+// RemoveKey removes a gossip encryption key from the cluster
+func (c *Client) RemoveKey(key string) error {
+	return c.do(http.MethodDelete, "/v1/operator/keyring", map[string]string{"Key": key}, nil)
+}
+
+// Snapshot returns a snapshot of the cluster's raft state
+func (c *Client) Snapshot() ([]byte, error) {
+	var snapshot []byte
+	err := c.do(http.MethodGet, "/v1/snapshot", nil, &snapshot)
+	return snapshot, err
+}
+
+func (c *Client) url(path string) string {
+	return fmt.Sprintf("%v://%v:%v%v", c.Scheme, c.Address, c.Port, path)
+}
+
+func (c *Client) do(method string, path string, body interface{}, target interface{}) error {
+	var payload []byte
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		payload = b
+	default:
+		buf, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		payload = buf
+	}
+	if c.DryRun != nil && method != http.MethodGet {
+		c.DryRun(method+" "+path, payload)
+		if ok, isBool := target.(*bool); isBool {
+			*ok = true
+		}
+		return nil
+	}
+	resp, err := c.send(method, path, payload)
+	if err == nil && resp.StatusCode == http.StatusForbidden && len(c.TokenFile) != 0 {
+		// The token may have been rotated on disk since it was read
+		resp.Body.Close()
+		if err = c.readTokenFile(); err == nil {
+			resp, err = c.send(method, path, payload)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusForbidden:
+		return &PermissionDenied{Method: method, Path: path}
+	default:
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("consul: %v %v returned %v: %v", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if target == nil {
+		return nil
+	}
+	if raw, ok := target.(*[]byte); ok {
+		*raw, err = ioutil.ReadAll(resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func (c *Client) send(method string, path string, payload []byte) (*http.Response, error) {
+	var r io.Reader
+	if payload != nil {
+		r = bytes.NewReader(payload)
+	}
+	req, err := c.request(method, path, r)
+	if err != nil {
+		return nil, err
+	}
+	return c.http.Do(req)
+}
+
+func (c *Client) request(method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.url(path), body)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Token) != 0 {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+	if len(c.Operation) != 0 {
+		req.Header.Set(opid.Header, c.Operation)
+	}
+	return req, nil
+}
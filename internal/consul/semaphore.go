@@ -0,0 +1,99 @@
+package consul
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Semaphore limits the number of concurrent holders of a kv prefix by
+// locking one of Slots keys beneath Prefix. Slots are held by sessions that
+// aren't bound to the agent's health, so a holder keeps its slot across a
+// reboot shorter than TTL. A session the holder stops renewing expires after
+// TTL, freeing the slot of a node that never came back.
+type Semaphore struct {
+	Client *Client
+	Prefix string
+	Slots  int
+	Holder string
+	// TTL is how long a slot is kept without Renew, between 10s and 24h.
+	// Slots taken without a TTL never expire.
+	TTL time.Duration
+}
+
+// TryAcquire attempts to lock a free slot
+// Returns the key of the held slot or an empty string if every slot is taken
+func (s *Semaphore) TryAcquire() (string, error) {
+	if held, err := s.Held(); err != nil {
+		return "", err
+	} else if held != nil {
+		return held.Key, nil
+	}
+	session, err := s.Client.CreateSession(s.Holder, s.TTL)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < s.Slots; i++ {
+		key := fmt.Sprintf("%s/slot-%d", strings.TrimSuffix(s.Prefix, "/"), i)
+		ok, err := s.Client.Acquire(key, session, []byte(s.Holder))
+		if err != nil {
+			s.Client.DestroySession(session)
+			return "", err
+		}
+		if ok {
+			return key, nil
+		}
+	}
+	return "", s.Client.DestroySession(session)
+}
+
+// Held returns the slot locked by Holder or nil when none is held
+func (s *Semaphore) Held() (*KVPair, error) {
+	pairs, err := s.Client.List(s.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range pairs {
+		if len(pair.Session) != 0 && string(pair.Value) == s.Holder {
+			return &pair, nil
+		}
+	}
+	return nil, nil
+}
+
+// Renew resets the TTL of the slot locked by Holder, if any
+func (s *Semaphore) Renew() error {
+	held, err := s.Held()
+	if err != nil || held == nil {
+		return err
+	}
+	return s.Client.RenewSession(held.Session)
+}
+
+// Keep renews the slot locked by Holder every half TTL until done is closed
+func (s *Semaphore) Keep(done <-chan struct{}, failed func(error)) {
+	if s.TTL <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.TTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Renew(); err != nil {
+				failed(err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Release frees the slot locked by Holder, if any
+func (s *Semaphore) Release() error {
+	held, err := s.Held()
+	if err != nil || held == nil {
+		return err
+	}
+	return s.Client.DestroySession(held.Session)
+}
@@ -0,0 +1,122 @@
+// Package crash recovers panics in the binaries' long running goroutines.
+// A recovered panic is written to a crash report (panic, stacks, build,
+// config fingerprint and program state) and the process exits with
+// errs.ExitPanic, instead of the panic going to a service manager that
+// discards stderr.
+package crash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/buildinfo"
+	"github.com/pgombola/clarify-svc/internal/errs"
+)
+
+// Reporter writes crash reports for the program Name
+type Reporter struct {
+	Dir         string
+	Name        string
+	Fingerprint string
+	// State returns the program state included in the report; may be nil
+	State  func() interface{}
+	Logger service.Logger
+}
+
+// Go runs f in a goroutine that reports panics
+func (r *Reporter) Go(f func()) {
+	go func() {
+		defer r.Recover()
+		f()
+	}()
+}
+
+// Recover reports a panic of the calling goroutine and exits. It must be
+// deferred directly.
+func (r *Reporter) Recover() {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if r == nil {
+		panic(v)
+	}
+	stack := debug.Stack()
+	path, err := r.write(v, stack)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "panic: %v\n\n%s\nunable to write crash report: %v\n", v, stack, err)
+	}
+	if r.Logger != nil {
+		if err != nil {
+			r.Logger.Errorf("%s panicked: %v (report=none;error=%v)", r.Name, v, err)
+		} else {
+			r.Logger.Errorf("%s panicked: %v (report=%s)", r.Name, v, path)
+		}
+	}
+	os.Exit(errs.ExitPanic)
+}
+
+func (r *Reporter) write(v interface{}, stack []byte) (string, error) {
+	if err := os.MkdirAll(r.Dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(r.Dir, fmt.Sprintf("%s-crash-%s.txt", r.Name, time.Now().UTC().Format("20060102T150405Z")))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	section := func(title string, v interface{}) {
+		fmt.Fprintf(f, "== %s ==\n", title)
+		buf, _ := json.MarshalIndent(v, "", "  ")
+		f.Write(buf)
+		fmt.Fprint(f, "\n\n")
+	}
+	fmt.Fprintf(f, "== panic ==\n%v\n\n", v)
+	fmt.Fprintf(f, "== stack ==\n%s\n", stack)
+	section("build", buildinfo.Get(r.Name))
+	fmt.Fprintf(f, "== config ==\nfingerprint=%s\n\n", r.Fingerprint)
+	section("state", r.state())
+	fmt.Fprintln(f, "== goroutines ==")
+	pprof.Lookup("goroutine").WriteTo(f, 2)
+	return path, nil
+}
+
+// state returns the program state, or why it couldn't be read; the state
+// may be what's broken
+func (r *Reporter) state() (state interface{}) {
+	if r.State == nil {
+		return nil
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			state = fmt.Sprintf("unavailable: %v", v)
+		}
+	}()
+	return r.State()
+}
+
+// Fingerprint returns a short digest of the command line args and the
+// contents of the config files, letting reports from nodes be grouped by
+// configuration without including secrets. Unreadable files are skipped.
+func Fingerprint(args []string, files ...string) string {
+	h := sha256.New()
+	h.Write([]byte(strings.Join(args, "\x00")))
+	for _, file := range files {
+		if buf, err := ioutil.ReadFile(file); err == nil {
+			h.Write(buf)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
@@ -0,0 +1,90 @@
+// Package crashloop detects services that keep restarting and quarantines
+// them until an operator resumes them. Restarts are recorded in a state file
+// so they're counted across restarts of the wrapper process itself.
+package crashloop
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// State is the content of the state file
+type State struct {
+	Restarts    []time.Time `json:"restarts"`
+	Quarantined bool        `json:"quarantined"`
+	Reason      string      `json:"reason,omitempty"`
+	Since       time.Time   `json:"since,omitempty"`
+}
+
+// Tracker quarantines once more than Max restarts are recorded within
+// Window
+type Tracker struct {
+	Path   string
+	Max    int
+	Window time.Duration
+	mu     sync.Mutex
+}
+
+// Load returns the current state
+func (t *Tracker) Load() (*State, error) {
+	s := &State{Restarts: make([]time.Time, 0)}
+	buf, err := ioutil.ReadFile(t.Path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return s, json.Unmarshal(buf, s)
+}
+
+// Record adds a restart caused by reason
+// Returns whether the service is now quarantined
+func (t *Tracker) Record(reason string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, err := t.Load()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC()
+	recent := make([]time.Time, 0, len(s.Restarts)+1)
+	for _, r := range s.Restarts {
+		if now.Sub(r) < t.Window {
+			recent = append(recent, r)
+		}
+	}
+	s.Restarts = append(recent, now)
+	if !s.Quarantined && len(s.Restarts) > t.Max {
+		s.Quarantined = true
+		s.Since = now
+		s.Reason = fmt.Sprintf("%d restarts within %v: %s", len(s.Restarts), t.Window, reason)
+	}
+	return s.Quarantined, t.save(s)
+}
+
+// Quarantine quarantines the service regardless of recorded restarts
+func (t *Tracker) Quarantine(reason string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now().UTC()
+	return t.save(&State{Restarts: make([]time.Time, 0), Quarantined: true, Reason: reason, Since: now})
+}
+
+// Resume lifts the quarantine and forgets recorded restarts
+func (t *Tracker) Resume() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.save(&State{Restarts: make([]time.Time, 0)})
+}
+
+func (t *Tracker) save(s *State) error {
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.Path, buf, 0644)
+}
@@ -0,0 +1,103 @@
+// Package dedup suppresses repeated log messages so polling loops don't flood
+// the log with the same warning every few seconds.
+package dedup
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/eventid"
+)
+
+// maxEntries bounds the messages remembered before old ones are swept
+const maxEntries = 1000
+
+type entry struct {
+	logged     time.Time
+	suppressed int
+}
+
+// Logger logs a warning or info message at most once per window, noting how
+// often it repeated when it's next logged. Errors are never suppressed.
+type Logger struct {
+	service.Logger
+	window time.Duration
+	mu     sync.Mutex
+	seen   map[string]*entry
+}
+
+// New wraps l, suppressing repeats within window
+func New(l service.Logger, window time.Duration) service.Logger {
+	if window <= 0 {
+		return l
+	}
+	return &Logger{Logger: l, window: window, seen: make(map[string]*entry)}
+}
+
+// allow reports whether msg is logged, returning it with the number of
+// suppressed repeats appended
+func (l *Logger) allow(level string, msg string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	key := level + msg
+	e, ok := l.seen[key]
+	if ok && now.Sub(e.logged) < l.window {
+		e.suppressed++
+		return "", false
+	}
+	if len(l.seen) >= maxEntries {
+		for k, old := range l.seen {
+			if now.Sub(old.logged) >= l.window {
+				delete(l.seen, k)
+			}
+		}
+	}
+	if ok && e.suppressed > 0 {
+		msg = fmt.Sprintf("%s (last message repeated %d times)", msg, e.suppressed)
+	}
+	l.seen[key] = &entry{logged: now}
+	return msg, true
+}
+
+func (l *Logger) Warning(v ...interface{}) error {
+	if msg, ok := l.allow("warning", fmt.Sprint(v...)); ok {
+		return l.Logger.Warning(msg)
+	}
+	return nil
+}
+
+func (l *Logger) Info(v ...interface{}) error {
+	if msg, ok := l.allow("info", fmt.Sprint(v...)); ok {
+		return l.Logger.Info(msg)
+	}
+	return nil
+}
+
+func (l *Logger) Warningf(format string, a ...interface{}) error {
+	return l.Warning(fmt.Sprintf(format, a...))
+}
+
+func (l *Logger) Infof(format string, a ...interface{}) error {
+	return l.Info(fmt.Sprintf(format, a...))
+}
+
+func (l *Logger) NError(eventID uint32, v ...interface{}) error {
+	return eventid.Error(l.Logger, eventID, "%s", fmt.Sprint(v...))
+}
+
+func (l *Logger) NWarning(eventID uint32, v ...interface{}) error {
+	if msg, ok := l.allow("warning", fmt.Sprint(v...)); ok {
+		return eventid.Warning(l.Logger, eventID, "%s", msg)
+	}
+	return nil
+}
+
+func (l *Logger) NInfo(eventID uint32, v ...interface{}) error {
+	if msg, ok := l.allow("info", fmt.Sprint(v...)); ok {
+		return eventid.Info(l.Logger, eventID, "%s", msg)
+	}
+	return nil
+}
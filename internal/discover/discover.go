@@ -0,0 +1,93 @@
+// Package discover resolves the servers an agent joins at start time from a
+// discovery source, so server addresses aren't baked into every node's
+// configuration.
+package discover
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Resolve returns the join addresses described by source:
+//   - srv:_consul._tcp.example.com looks up DNS SRV records
+//   - http(s)://host/path fetches a json array or newline separated list
+//   - provider=aws tag_key=... is a cloud auto-join query passed to the agent
+//   - anything else is a comma separated list of addresses
+func Resolve(source string) ([]string, error) {
+	switch {
+	case strings.HasPrefix(source, "srv:"):
+		return srv(strings.TrimPrefix(source, "srv:"))
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		return fetch(source)
+	case strings.HasPrefix(source, "provider="):
+		return []string{source}, nil
+	}
+	return split(strings.Split(source, ",")), nil
+}
+
+// Cloud reports whether addr is a cloud auto-join query
+func Cloud(addr string) bool {
+	return strings.HasPrefix(addr, "provider=")
+}
+
+func srv(name string) ([]string, error) {
+	_, records, err := net.LookupSRV("", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("discover: unable to look up %s: %v", name, err)
+	}
+	addrs := make([]string, 0, len(records))
+	for _, r := range records {
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))))
+	}
+	return nonEmpty(name, addrs)
+}
+
+func fetch(url string) ([]string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("discover: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discover: GET %s returned %v", url, resp.StatusCode)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	if json.Unmarshal(buf, &addrs) != nil {
+		scanner := bufio.NewScanner(bytes.NewReader(buf))
+		for scanner.Scan() {
+			addrs = append(addrs, scanner.Text())
+		}
+	}
+	return nonEmpty(url, split(addrs))
+}
+
+func split(values []string) []string {
+	addrs := make([]string, 0, len(values))
+	for _, v := range values {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			addrs = append(addrs, v)
+		}
+	}
+	return addrs
+}
+
+func nonEmpty(source string, addrs []string) ([]string, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("discover: %s returned no servers", source)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,94 @@
+// Package errs defines the failure causes shared by the clarify binaries and
+// the process exit codes they map to, so scripts driving the binaries can
+// branch on the cause instead of parsing log text.
+//
+// Exit codes:
+//
+//	0   success
+//	1   any other failure
+//	10  nomad unavailable (ErrNomadUnavailable)
+//	11  job submission failed (ErrJobSubmitFailed)
+//	12  node not found in nomad (ErrNodeNotFound)
+//	13  clarify install missing (ErrInstallMissing)
+//	14  panic, after writing a crash report
+//
+// On windows the service-specific exit code of a service failing to start
+// is the same code.
+package errs
+
+import (
+	"errors"
+	"log"
+	"os"
+)
+
+// Failure causes
+var (
+	ErrNomadUnavailable = errors.New("nomad unavailable")
+	ErrJobSubmitFailed  = errors.New("job submission failed")
+	ErrNodeNotFound     = errors.New("node not found")
+	ErrInstallMissing   = errors.New("clarify install missing")
+)
+
+// Exit codes
+const (
+	ExitOK               = 0
+	ExitFailure          = 1
+	ExitNomadUnavailable = 10
+	ExitJobSubmitFailed  = 11
+	ExitNodeNotFound     = 12
+	ExitInstallMissing   = 13
+	ExitPanic            = 14
+)
+
+var codes = []struct {
+	kind error
+	code int
+}{
+	{ErrNomadUnavailable, ExitNomadUnavailable},
+	{ErrJobSubmitFailed, ExitJobSubmitFailed},
+	{ErrNodeNotFound, ExitNodeNotFound},
+	{ErrInstallMissing, ExitInstallMissing},
+}
+
+// wrapped is an error marked with its cause; its message is unchanged
+type wrapped struct {
+	kind error
+	err  error
+}
+
+func (w *wrapped) Error() string {
+	return w.err.Error()
+}
+
+func (w *wrapped) Unwrap() []error {
+	return []error{w.kind, w.err}
+}
+
+// Wrap marks err as caused by kind so errors.Is and Code recognise it.
+// Returns nil if err is nil.
+func Wrap(kind error, err error) error {
+	if err == nil || errors.Is(err, kind) {
+		return err
+	}
+	return &wrapped{kind: kind, err: err}
+}
+
+// Code returns the exit code of err
+func Code(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	for _, c := range codes {
+		if errors.Is(err, c.kind) {
+			return c.code
+		}
+	}
+	return ExitFailure
+}
+
+// Fatal logs err and exits with its exit code
+func Fatal(err error) {
+	log.Print(err)
+	os.Exit(Code(err))
+}
@@ -0,0 +1,72 @@
+// Package eventid defines the stable event ids the wrappers log key events
+// with, so Windows Event Log monitoring rules can match on ids instead of
+// message text. The hundreds digit is the category.
+package eventid
+
+import (
+	"fmt"
+
+	"github.com/kardianos/service"
+)
+
+// Service lifecycle (1xx)
+const (
+	ServiceStarted uint32 = 100
+	ServiceStopped uint32 = 101
+	StartFailed    uint32 = 102
+)
+
+// Jobs (2xx)
+const (
+	JobLaunched     uint32 = 200
+	JobLost         uint32 = 201
+	JobUnhealthy    uint32 = 202
+	JobHealthy      uint32 = 203
+	JobLaunchFailed uint32 = 204
+)
+
+// Node drain (3xx)
+const (
+	DrainEnabled  uint32 = 300
+	DrainDisabled uint32 = 301
+	DrainFailed   uint32 = 302
+)
+
+// Agent child processes (4xx)
+const (
+	AgentReady       uint32 = 400
+	AgentExited      uint32 = 401
+	AgentCrashed     uint32 = 402
+	AgentQuarantined uint32 = 403
+)
+
+// Logger is a logger that records event ids, like service.WindowsLogger
+type Logger interface {
+	NError(eventID uint32, v ...interface{}) error
+	NWarning(eventID uint32, v ...interface{}) error
+	NInfo(eventID uint32, v ...interface{}) error
+}
+
+// Info logs msg with the event id when l supports ids
+func Info(l service.Logger, id uint32, format string, a ...interface{}) error {
+	if n, ok := l.(Logger); ok {
+		return n.NInfo(id, fmt.Sprintf(format, a...))
+	}
+	return l.Infof(format, a...)
+}
+
+// Warning logs msg with the event id when l supports ids
+func Warning(l service.Logger, id uint32, format string, a ...interface{}) error {
+	if n, ok := l.(Logger); ok {
+		return n.NWarning(id, fmt.Sprintf(format, a...))
+	}
+	return l.Warningf(format, a...)
+}
+
+// Error logs msg with the event id when l supports ids
+func Error(l service.Logger, id uint32, format string, a ...interface{}) error {
+	if n, ok := l.(Logger); ok {
+		return n.NError(id, fmt.Sprintf(format, a...))
+	}
+	return l.Errorf(format, a...)
+}
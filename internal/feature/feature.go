@@ -0,0 +1,169 @@
+// Package feature gates risky behaviors behind flags so they can be rolled
+// out gradually. Each flag has a default, which configuration sets and a
+// remote source (e.g. consul kv) overrides.
+package feature
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Flag is a feature that can be switched on or off
+type Flag struct {
+	Name        string
+	Description string
+	Default     bool
+}
+
+// Set holds the state of the known flags
+type Set struct {
+	mu     sync.RWMutex
+	flags  map[string]Flag
+	config map[string]bool
+	remote map[string]bool
+}
+
+// New returns a Set of flags
+func New(flags ...Flag) *Set {
+	s := &Set{
+		flags:  make(map[string]Flag, len(flags)),
+		config: make(map[string]bool),
+		remote: make(map[string]bool),
+	}
+	for _, f := range flags {
+		s.flags[f.Name] = f
+	}
+	return s
+}
+
+// Configure sets flags from values of comma separated name=on|off pairs
+func (s *Set) Configure(values []string) error {
+	config := make(map[string]bool)
+	for _, value := range values {
+		for _, kv := range strings.Split(value, ",") {
+			kv = strings.TrimSpace(kv)
+			if len(kv) == 0 {
+				continue
+			}
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid feature %q; expected name=on|off", kv)
+			}
+			enabled, err := s.parse(parts[0], parts[1])
+			if err != nil {
+				return err
+			}
+			config[parts[0]] = enabled
+		}
+	}
+	s.mu.Lock()
+	s.config = config
+	s.mu.Unlock()
+	return nil
+}
+
+// SetRemote replaces the remote overrides with values keyed by flag name.
+// Invalid values are left out and reported in the error; the valid ones are
+// applied regardless. Returns whether any flag changed state.
+func (s *Set) SetRemote(values map[string]string) (bool, error) {
+	remote := make(map[string]bool)
+	var invalid []string
+	for name, value := range values {
+		enabled, err := s.parse(name, value)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+			continue
+		}
+		remote[name] = enabled
+	}
+	before := s.String()
+	s.mu.Lock()
+	s.remote = remote
+	s.mu.Unlock()
+	changed := s.String() != before
+	if len(invalid) != 0 {
+		sort.Strings(invalid)
+		return changed, fmt.Errorf("%s", strings.Join(invalid, "; "))
+	}
+	return changed, nil
+}
+
+func (s *Set) parse(name string, value string) (bool, error) {
+	if _, ok := s.flags[name]; !ok {
+		return false, fmt.Errorf("unknown feature %q", name)
+	}
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q of feature %s; expected on or off", value, name)
+	}
+	return enabled, nil
+}
+
+// Enabled reports whether the flag named name is on. Unknown flags are off.
+func (s *Set) Enabled(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if enabled, ok := s.remote[name]; ok {
+		return enabled
+	}
+	if enabled, ok := s.config[name]; ok {
+		return enabled
+	}
+	return s.flags[name].Default
+}
+
+// States returns whether each flag is on, keyed by name
+func (s *Set) States() map[string]bool {
+	states := make(map[string]bool, len(s.flags))
+	for name := range s.flags {
+		states[name] = s.Enabled(name)
+	}
+	return states
+}
+
+// Usage describes the flags and their defaults
+func (s *Set) Usage() string {
+	names := make([]string, 0, len(s.flags))
+	for name := range s.flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		f := s.flags[name]
+		state := "off"
+		if f.Default {
+			state = "on"
+		}
+		lines = append(lines, fmt.Sprintf("%s (default %s): %s", name, state, f.Description))
+	}
+	return strings.Join(lines, "; ")
+}
+
+// String returns the state of every flag as sorted name=on|off pairs
+func (s *Set) String() string {
+	states := s.States()
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		state := "off"
+		if states[name] {
+			state = "on"
+		}
+		pairs = append(pairs, name+"="+state)
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,128 @@
+package fleet
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the admin api of the nodes. The token is only sent to admin
+// urls the controller trusts: those configured in Admins, or the url a node
+// advertises in its heartbeat when reached over TLS with a certificate valid
+// for the node's name.
+type Client struct {
+	// Token is the admin api bearer token
+	Token string
+	// Admins are the configured admin api urls by node
+	Admins map[string]string
+	HTTP   *http.Client
+	// TLS verifies advertised admin urls against the node name; nil
+	// without mutual TLS
+	TLS *tls.Config
+}
+
+// NewClient returns a client presenting the tls config, if any, to the
+// nodes' admin apis
+func NewClient(token string, tlsConfig *tls.Config, admins map[string]string) *Client {
+	return &Client{
+		Token:  token,
+		Admins: admins,
+		TLS:    tlsConfig,
+		HTTP: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+	}
+}
+
+// admin returns the admin api url of node and the http client to reach it
+// with
+func (c *Client) admin(node *Node) (string, *http.Client, error) {
+	if u, ok := c.Admins[node.Node]; ok {
+		return u, c.HTTP, nil
+	}
+	if len(node.Admin) == 0 {
+		return "", nil, fmt.Errorf("%s doesn't advertise its admin api; start it with a non-loopback -admin-listen", node.Node)
+	}
+	if c.TLS == nil || !strings.HasPrefix(node.Admin, "https://") {
+		return "", nil, fmt.Errorf("%s advertises %s, which can't be verified; configure its admin url or use mutual TLS", node.Node, node.Admin)
+	}
+	pinned := c.TLS.Clone()
+	pinned.ServerName = node.Node
+	return node.Admin, &http.Client{
+		Timeout:   c.HTTP.Timeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: pinned},
+	}, nil
+}
+
+// Status returns the node's current status
+func (c *Client) Status(node *Node) (*Heartbeat, error) {
+	hb := &Heartbeat{}
+	return hb, c.do(node, http.MethodGet, "/status", nil, hb)
+}
+
+// Drain drains the node, migrating allocations for at most deadline (the
+// node's -drain-deadline when 0)
+func (c *Client) Drain(node *Node, deadline time.Duration) error {
+	query := url.Values{}
+	if deadline > 0 {
+		query.Set("deadline", deadline.String())
+	}
+	return c.do(node, http.MethodPost, "/drain", query, nil)
+}
+
+// Undrain disables the node's drain, making it eligible again
+func (c *Client) Undrain(node *Node) error {
+	return c.do(node, http.MethodPost, "/undrain", nil, nil)
+}
+
+func (c *Client) do(node *Node, method string, path string, query url.Values, target interface{}) error {
+	admin, client, err := c.admin(node)
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(admin, "/") + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	if len(c.Token) != 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if client != c.HTTP {
+		defer client.CloseIdleConnections()
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && len(e.Error) != 0 {
+			return fmt.Errorf("%s: %s", node.Node, e.Error)
+		}
+		return fmt.Errorf("%s: http status: %v", node.Node, resp.StatusCode)
+	}
+	if target == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("%s: invalid response: %v", node.Node, err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Package fleet is shared by the clarify wrappers posting heartbeats and the
+// clarify-fleet controller aggregating them and coordinating the nodes
+// through their admin apis.
+package fleet
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Heartbeat is the node status a clarify wrapper periodically posts to the
+// fleet controller and serves on its admin api
+type Heartbeat struct {
+	Node          string            `json:"node"`
+	NodeID        string            `json:"node_id,omitempty"`
+	Service       string            `json:"service"`
+	Version       string            `json:"version"`
+	NomadVersion  string            `json:"nomad_version,omitempty"`
+	ConsulVersion string            `json:"consul_version,omitempty"`
+	State         string            `json:"state"`
+	StateSince    time.Time         `json:"state_since"`
+	JobStatus     string            `json:"job_status"`
+	Jobs          map[string]string `json:"jobs,omitempty"`
+	Drain         bool              `json:"drain"`
+	LameDuck      bool              `json:"lame_duck,omitempty"`
+	Quarantined   string            `json:"quarantined,omitempty"`
+	Registration  []string          `json:"registration,omitempty"`
+	// Placement explains why the clarify job's evaluations are blocked
+	Placement []string        `json:"placement,omitempty"`
+	Features  map[string]bool `json:"features,omitempty"`
+	// Admin is the url of the node's admin api when it listens beyond
+	// loopback
+	Admin string    `json:"admin,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Node is the last heartbeat received from a node
+type Node struct {
+	Heartbeat
+	LastSeen time.Time `json:"last_seen"`
+	// Stale is set once no heartbeat arrived within the registry's Stale
+	// duration
+	Stale bool `json:"stale"`
+}
+
+// Summary is the consolidated view of the fleet
+type Summary struct {
+	Nodes       int            `json:"nodes"`
+	Stale       int            `json:"stale"`
+	Draining    int            `json:"draining"`
+	LameDuck    int            `json:"lame_duck"`
+	Quarantined int            `json:"quarantined"`
+	States      map[string]int `json:"states"`
+	JobStatus   map[string]int `json:"job_status"`
+	Versions    map[string]int `json:"versions"`
+}
+
+// Registry keeps the last heartbeat of each node
+type Registry struct {
+	// Stale is how long a node may go without a heartbeat before it's
+	// reported stale
+	Stale time.Duration
+	mu    sync.RWMutex
+	nodes map[string]*Node
+}
+
+// Update records hb as the node's latest heartbeat
+func (r *Registry) Update(hb Heartbeat) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.nodes == nil {
+		r.nodes = make(map[string]*Node)
+	}
+	r.nodes[hb.Node] = &Node{Heartbeat: hb, LastSeen: time.Now().UTC()}
+}
+
+// Remove forgets the node, returning whether it was known
+func (r *Registry) Remove(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.nodes[name]
+	delete(r.nodes, name)
+	return ok
+}
+
+// Get returns a copy of the node or nil when it never sent a heartbeat
+func (r *Registry) Get(name string) *Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n, ok := r.nodes[name]
+	if !ok {
+		return nil
+	}
+	return r.copy(n)
+}
+
+// List returns copies of the nodes sorted by name
+func (r *Registry) List() []*Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodes := make([]*Node, 0, len(r.nodes))
+	for _, n := range r.nodes {
+		nodes = append(nodes, r.copy(n))
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Node < nodes[j].Node
+	})
+	return nodes
+}
+
+// Summary counts the nodes by state, job status and version
+func (r *Registry) Summary() *Summary {
+	s := &Summary{States: make(map[string]int), JobStatus: make(map[string]int), Versions: make(map[string]int)}
+	for _, n := range r.List() {
+		s.Nodes++
+		if n.Stale {
+			s.Stale++
+		}
+		if n.Drain {
+			s.Draining++
+		}
+		if n.LameDuck {
+			s.LameDuck++
+		}
+		if len(n.Quarantined) != 0 {
+			s.Quarantined++
+		}
+		s.States[n.State]++
+		s.JobStatus[n.JobStatus]++
+		s.Versions[n.Version]++
+	}
+	return s
+}
+
+func (r *Registry) copy(n *Node) *Node {
+	c := *n
+	c.Stale = r.Stale > 0 && time.Since(n.LastSeen) > r.Stale
+	return &c
+}
@@ -0,0 +1,123 @@
+// Package journald logs to the systemd journal over its native protocol so
+// messages carry structured fields journalctl can filter on.
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// socket is the journal's native protocol socket
+const socket = "/run/systemd/journal/socket"
+
+// Syslog priorities
+const (
+	priorityErr     = 3
+	priorityWarning = 4
+	priorityInfo    = 6
+)
+
+// Available reports whether the process runs under systemd with the journal
+// listening
+func Available() bool {
+	if runtime.GOOS != "linux" || len(os.Getenv("INVOCATION_ID")) == 0 {
+		return false
+	}
+	_, err := os.Stat(socket)
+	return err == nil
+}
+
+// Logger is a service.Logger writing to the journal
+type Logger struct {
+	identifier string
+	mu         sync.RWMutex
+	fields     map[string]string
+	conn       *net.UnixConn
+}
+
+// New returns a Logger tagging every message with identifier and fields.
+// Field names must be upper case, e.g. UNIT or NODE_ID.
+func New(identifier string, fields map[string]string) (*Logger, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	l := &Logger{identifier: identifier, fields: make(map[string]string), conn: conn}
+	for k, v := range fields {
+		l.fields[k] = v
+	}
+	return l, nil
+}
+
+// SetField adds or replaces a field sent with every following message
+func (l *Logger) SetField(name string, value string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fields[name] = value
+}
+
+func (l *Logger) send(priority int, msg string) error {
+	var b bytes.Buffer
+	field(&b, "PRIORITY", strconv.Itoa(priority))
+	field(&b, "SYSLOG_IDENTIFIER", l.identifier)
+	field(&b, "MESSAGE", msg)
+	l.mu.RLock()
+	names := make([]string, 0, len(l.fields))
+	for k := range l.fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		field(&b, k, l.fields[k])
+	}
+	l.mu.RUnlock()
+	_, err := l.conn.Write(b.Bytes())
+	return err
+}
+
+// field writes a field in the journal's export format, length prefixing
+// values spanning several lines
+func field(b *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+	b.WriteString(name + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+func (l *Logger) Error(v ...interface{}) error {
+	return l.send(priorityErr, fmt.Sprint(v...))
+}
+
+func (l *Logger) Warning(v ...interface{}) error {
+	return l.send(priorityWarning, fmt.Sprint(v...))
+}
+
+func (l *Logger) Info(v ...interface{}) error {
+	return l.send(priorityInfo, fmt.Sprint(v...))
+}
+
+func (l *Logger) Errorf(format string, a ...interface{}) error {
+	return l.send(priorityErr, fmt.Sprintf(format, a...))
+}
+
+func (l *Logger) Warningf(format string, a ...interface{}) error {
+	return l.send(priorityWarning, fmt.Sprintf(format, a...))
+}
+
+func (l *Logger) Infof(format string, a ...interface{}) error {
+	return l.send(priorityInfo, fmt.Sprintf(format, a...))
+}
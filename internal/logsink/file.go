@@ -0,0 +1,84 @@
+package logsink
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// file appends messages to a file, renaming it to path.1 (shifting older
+// backups up to path.N) once it grows past maxSize
+type file struct {
+	path    string
+	maxSize int64
+	backups int
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+}
+
+// OpenFile returns a logger writing to path, rotated at maxSize bytes and
+// keeping backups old files. A maxSize <= 0 never rotates.
+func OpenFile(path string, maxSize int64, backups int) (service.Logger, error) {
+	fl := &file{path: path, maxSize: maxSize, backups: backups}
+	if err := fl.open(); err != nil {
+		return nil, err
+	}
+	return logger{fl}, nil
+}
+
+func (fl *file) open() error {
+	f, err := os.OpenFile(fl.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fl.f = f
+	fl.size = info.Size()
+	return nil
+}
+
+// rotate shifts the backups and reopens an empty file
+func (fl *file) rotate() error {
+	fl.f.Close()
+	fl.f = nil
+	if fl.backups <= 0 {
+		os.Remove(fl.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", fl.path, fl.backups))
+		for i := fl.backups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", fl.path, i), fmt.Sprintf("%s.%d", fl.path, i+1))
+		}
+		if err := os.Rename(fl.path, fl.path+".1"); err != nil {
+			return err
+		}
+	}
+	return fl.open()
+}
+
+func (fl *file) write(severity string, msg string) error {
+	line := fmt.Sprintf("%s %s %s\n", time.Now().UTC().Format(time.RFC3339), severity, msg)
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if fl.f == nil {
+		// A failed rotation is retried on the next message
+		if err := fl.open(); err != nil {
+			return err
+		}
+	}
+	if fl.maxSize > 0 && fl.size > 0 && fl.size+int64(len(line)) > fl.maxSize {
+		if err := fl.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := fl.f.WriteString(line)
+	fl.size += int64(n)
+	return err
+}
@@ -0,0 +1,107 @@
+// Package logsink provides log destinations besides the platform service
+// logger: a size rotated file and a remote syslog endpoint. Multi sends each
+// message to several of them.
+package logsink
+
+import (
+	"fmt"
+
+	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/eventid"
+)
+
+// Severities of logged messages
+const (
+	severityError   = "ERROR"
+	severityWarning = "WARNING"
+	severityInfo    = "INFO"
+)
+
+// writer is the part shared by the file and remote loggers; the
+// service.Logger methods are derived from it
+type writer interface {
+	write(severity string, msg string) error
+}
+
+// logger adapts a writer to service.Logger
+type logger struct {
+	w writer
+}
+
+func (l logger) Error(v ...interface{}) error {
+	return l.w.write(severityError, fmt.Sprint(v...))
+}
+
+func (l logger) Warning(v ...interface{}) error {
+	return l.w.write(severityWarning, fmt.Sprint(v...))
+}
+
+func (l logger) Info(v ...interface{}) error {
+	return l.w.write(severityInfo, fmt.Sprint(v...))
+}
+
+func (l logger) Errorf(format string, a ...interface{}) error {
+	return l.w.write(severityError, fmt.Sprintf(format, a...))
+}
+
+func (l logger) Warningf(format string, a ...interface{}) error {
+	return l.w.write(severityWarning, fmt.Sprintf(format, a...))
+}
+
+func (l logger) Infof(format string, a ...interface{}) error {
+	return l.w.write(severityInfo, fmt.Sprintf(format, a...))
+}
+
+// Multi logs every message to each of loggers. Event ids are kept for the
+// loggers supporting them.
+type Multi []service.Logger
+
+// each calls fn for every logger, returning the first error
+func (m Multi) each(fn func(l service.Logger) error) error {
+	var first error
+	for _, l := range m {
+		if err := fn(l); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m Multi) Error(v ...interface{}) error {
+	return m.each(func(l service.Logger) error { return l.Error(v...) })
+}
+
+func (m Multi) Warning(v ...interface{}) error {
+	return m.each(func(l service.Logger) error { return l.Warning(v...) })
+}
+
+func (m Multi) Info(v ...interface{}) error {
+	return m.each(func(l service.Logger) error { return l.Info(v...) })
+}
+
+func (m Multi) Errorf(format string, a ...interface{}) error {
+	return m.Error(fmt.Sprintf(format, a...))
+}
+
+func (m Multi) Warningf(format string, a ...interface{}) error {
+	return m.Warning(fmt.Sprintf(format, a...))
+}
+
+func (m Multi) Infof(format string, a ...interface{}) error {
+	return m.Info(fmt.Sprintf(format, a...))
+}
+
+func (m Multi) NError(eventID uint32, v ...interface{}) error {
+	msg := fmt.Sprint(v...)
+	return m.each(func(l service.Logger) error { return eventid.Error(l, eventID, "%s", msg) })
+}
+
+func (m Multi) NWarning(eventID uint32, v ...interface{}) error {
+	msg := fmt.Sprint(v...)
+	return m.each(func(l service.Logger) error { return eventid.Warning(l, eventID, "%s", msg) })
+}
+
+func (m Multi) NInfo(eventID uint32, v ...interface{}) error {
+	msg := fmt.Sprint(v...)
+	return m.each(func(l service.Logger) error { return eventid.Info(l, eventID, "%s", msg) })
+}
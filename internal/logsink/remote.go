@@ -0,0 +1,78 @@
+package logsink
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// writeTimeout bounds how long a message may block on the remote endpoint
+const writeTimeout = 5 * time.Second
+
+// syslog severities (RFC 5424) of the daemon facility
+var priorities = map[string]int{
+	severityError:   3*8 + 3,
+	severityWarning: 3*8 + 4,
+	severityInfo:    3*8 + 6,
+}
+
+// remote sends RFC 5424 syslog messages over udp or newline framed tcp,
+// reconnecting after a failed write
+type remote struct {
+	network string
+	addr    string
+	tag     string
+	host    string
+	mu      sync.Mutex
+	conn    net.Conn
+}
+
+// Dial returns a logger sending to endpoint, given as udp://host:port or
+// tcp://host:port, with messages tagged as app. The connection is made on
+// the first message so an unreachable endpoint doesn't prevent startup.
+func Dial(endpoint string, app string) (service.Logger, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("invalid log endpoint %q; expected udp://host:port or tcp://host:port", endpoint)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return nil, fmt.Errorf("invalid log endpoint %q: %v", endpoint, err)
+	}
+	host, _ := os.Hostname()
+	if len(host) == 0 {
+		host = "-"
+	}
+	return logger{&remote{network: u.Scheme, addr: u.Host, tag: app, host: host}}, nil
+}
+
+func (r *remote) write(severity string, msg string) error {
+	line := fmt.Sprintf("<%d>1 %s %s %s %d - - %s", priorities[severity], time.Now().UTC().Format(time.RFC3339), r.host, r.tag, os.Getpid(), msg)
+	if r.network == "tcp" {
+		line = strings.Replace(line, "\n", " ", -1) + "\n"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		conn, err := net.DialTimeout(r.network, r.addr, writeTimeout)
+		if err != nil {
+			return err
+		}
+		r.conn = conn
+	}
+	r.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := r.conn.Write([]byte(line)); err != nil {
+		r.conn.Close()
+		r.conn = nil
+		return err
+	}
+	return nil
+}
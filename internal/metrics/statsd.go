@@ -0,0 +1,88 @@
+// Package metrics emits the wrapper's metrics to a statsd or DogStatsD
+// collector over udp.
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Statsd sends metrics to a collector. A nil Statsd drops every metric.
+type Statsd struct {
+	prefix string
+	tags   []string
+	conn   net.Conn
+}
+
+// New returns a Statsd sending to the collector at address (host:port).
+// Metric names are prefixed with prefix; tags (key:value) are appended in
+// the DogStatsD format to every metric when given.
+func New(address string, prefix string, tags []string) (*Statsd, error) {
+	if len(address) == 0 {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("metrics: %v", err)
+	}
+	if len(prefix) != 0 && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &Statsd{prefix: prefix, tags: tags, conn: conn}, nil
+}
+
+// Incr increments the counter name
+func (s *Statsd) Incr(name string, tags ...string) {
+	s.send(name, "1|c", tags)
+}
+
+// Gauge sets the gauge name to value
+func (s *Statsd) Gauge(name string, value float64, tags ...string) {
+	s.send(name, fmt.Sprintf("%g|g", value), tags)
+}
+
+// Timing records how long an operation took
+func (s *Statsd) Timing(name string, d time.Duration, tags ...string) {
+	s.send(name, fmt.Sprintf("%d|ms", d.Nanoseconds()/int64(time.Millisecond)), tags)
+}
+
+// Close closes the connection to the collector
+func (s *Statsd) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+func (s *Statsd) send(name string, value string, tags []string) {
+	if s == nil {
+		return
+	}
+	line := s.prefix + name + ":" + value
+	if all := append(append([]string{}, s.tags...), tags...); len(all) != 0 {
+		line += "|#" + strings.Join(all, ",")
+	}
+	// Metrics are best effort; a missing collector must not affect the service
+	s.conn.Write([]byte(line))
+}
+
+// Bool returns 1 for true and 0 for false, for use as a gauge value
+func Bool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Tags splits comma separated key:value tags, appending them to extra
+func Tags(csv string, extra ...string) []string {
+	tags := append([]string{}, extra...)
+	for _, tag := range strings.Split(csv, ",") {
+		if tag = strings.TrimSpace(tag); len(tag) != 0 {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,47 @@
+package nomad
+
+import (
+	"sync"
+	"time"
+)
+
+// historySize is how many recent responses are kept for diagnostics
+const historySize = 50
+
+// Response summarizes a recent request to nomad
+type Response struct {
+	Time     time.Time     `json:"time"`
+	Method   string        `json:"method"`
+	Path     string        `json:"path"`
+	Status   int           `json:"status,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+var history struct {
+	sync.Mutex
+	responses []Response
+}
+
+// Observe records the outcome of a request. Requests made through this
+// package are recorded automatically; callers record requests they make
+// with other clients.
+func Observe(method string, path string, status int, d time.Duration, err error) {
+	r := Response{Time: time.Now().UTC(), Method: method, Path: path, Status: status, Duration: d}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	history.Lock()
+	defer history.Unlock()
+	history.responses = append(history.responses, r)
+	if len(history.responses) > historySize {
+		history.responses = history.responses[len(history.responses)-historySize:]
+	}
+}
+
+// Recent returns the most recent responses, oldest first
+func Recent() []Response {
+	history.Lock()
+	defer history.Unlock()
+	return append([]Response{}, history.responses...)
+}
@@ -0,0 +1,725 @@
+// Package nomad calls the Nomad HTTP API for the clarify wrappers, sending
+// the ACL token with every request. It reuses the types of
+// github.com/pgombola/gomad/client, whose calls don't carry a token.
+package nomad
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/opid"
+	"github.com/pgombola/gomad/client"
+)
+
+// Node is a representation of a nomad client node including its metadata
+type Node struct {
+	ID     string            `json:"ID"`
+	Name   string            `json:"Name"`
+	Drain  bool              `json:"Drain"`
+	Status string            `json:"Status"`
+	Meta   map[string]string `json:"Meta"`
+	// SchedulingEligibility is eligible or ineligible for new allocations
+	SchedulingEligibility string `json:"SchedulingEligibility"`
+	// Drivers is the task driver fingerprint of the node, keyed by driver
+	// name
+	Drivers map[string]DriverInfo `json:"Drivers"`
+}
+
+// DriverInfo is the fingerprinted state of a task driver on a node
+type DriverInfo struct {
+	Detected          bool   `json:"Detected"`
+	Healthy           bool   `json:"Healthy"`
+	HealthDescription string `json:"HealthDescription"`
+}
+
+// Token is the ACL token sent with every request
+var Token string
+
+// Scheme is the scheme of the agents' http api, http or https
+var Scheme = "http"
+
+// DryRun, when set, is given the mutating requests instead of nomad. They
+// succeed without being sent. Reads, including the POST of a job plan, are
+// still sent.
+var DryRun func(call string, body []byte)
+
+// Fault, when set, is called before every request; an error it returns
+// fails the request. Used to inject failures for testing.
+var Fault func(call string) error
+
+// Server is the http api of a nomad agent, with the operation id and region
+// its requests carry
+type Server struct {
+	Address string
+	Port    int
+	// Operation is sent as the operation id header, see WithOperation
+	Operation string
+	// Region forwards the requests to another region, see WithRegion
+	Region string
+}
+
+// WithOperation returns a copy of nomad whose requests carry the operation id
+// header
+func WithOperation(nomad *Server, id string) *Server {
+	c := *nomad
+	c.Operation = id
+	return &c
+}
+
+// WithRegion returns a copy of nomad whose requests are forwarded to region
+func WithRegion(nomad *Server, region string) *Server {
+	c := *nomad
+	c.Region = region
+	return &c
+}
+
+// transport is shared by the requests and the log streams, which have no
+// timeout
+var transport = http.DefaultTransport.(*http.Transport).Clone()
+
+var httpClient = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+
+var streamClient = &http.Client{Transport: transport}
+
+// HTTPClient returns the client of the requests, configured for the agents'
+// scheme, tls and proxy, for calls outside this package
+func HTTPClient() *http.Client {
+	return httpClient
+}
+
+// URL returns the url of path on the nomad agent
+func URL(nomad *Server, path string) string {
+	return url(nomad) + path
+}
+
+// SetTimeout sets the timeout of each request
+func SetTimeout(timeout time.Duration) {
+	httpClient.Timeout = timeout
+}
+
+// SetTLS sets the tls config the https agents are verified with, the system
+// roots when nil
+func SetTLS(config *tls.Config) {
+	transport.TLSClientConfig = config
+}
+
+// SetProxy sets the proxy function of the requests, see proxy.Config.Func
+func SetProxy(proxy func(*http.Request) (*neturl.URL, error)) {
+	transport.Proxy = proxy
+}
+
+// Jobs returns the jobs of the cluster
+func Jobs(nomad *Server) ([]client.Job, error) {
+	jobs := make([]client.Job, 0)
+	err := do(nomad, http.MethodGet, "/v1/jobs", nil, &jobs)
+	return jobs, err
+}
+
+// FindJob returns the job with the provided name
+func FindJob(nomad *Server, name string) (*client.Job, error) {
+	jobs, err := Jobs(nomad)
+	if err != nil {
+		return &client.Job{}, err
+	}
+	for _, job := range jobs {
+		if job.Name == name {
+			return &job, nil
+		}
+	}
+	return &client.Job{}, errors.New("job not found")
+}
+
+// Hosts returns the client nodes of the cluster
+func Hosts(nomad *Server) ([]client.Host, error) {
+	hosts := make([]client.Host, 0)
+	err := do(nomad, http.MethodGet, "/v1/nodes", nil, &hosts)
+	return hosts, err
+}
+
+// GetNode returns the node with the provided id
+func GetNode(nomad *Server, id string) (*Node, error) {
+	node := &Node{}
+	err := do(nomad, http.MethodGet, "/v1/node/"+id, nil, node)
+	return node, err
+}
+
+// SetMeta merges meta into the dynamic metadata of the node with the
+// provided id. A nil value removes the key.
+func SetMeta(nomad *Server, id string, meta map[string]*string) error {
+	body := map[string]interface{}{
+		"NodeID": id,
+		"Meta":   meta,
+	}
+	return do(nomad, http.MethodPost, "/v1/client/metadata", body, nil)
+}
+
+// AgentVersion returns the version of the local nomad agent
+func AgentVersion(nomad *Server) (string, error) {
+	var self struct {
+		Config struct {
+			Version json.RawMessage `json:"Version"`
+		} `json:"config"`
+	}
+	if err := do(nomad, http.MethodGet, "/v1/agent/self", nil, &self); err != nil {
+		return "", err
+	}
+	// Older agents report a plain string, newer ones a version object
+	var v string
+	if json.Unmarshal(self.Config.Version, &v) == nil {
+		return v, nil
+	}
+	var obj struct {
+		Version    string `json:"Version"`
+		Prerelease string `json:"VersionPrerelease"`
+	}
+	if err := json.Unmarshal(self.Config.Version, &obj); err != nil {
+		return "", err
+	}
+	if len(obj.Prerelease) != 0 {
+		return obj.Version + "-" + obj.Prerelease, nil
+	}
+	return obj.Version, nil
+}
+
+// Agent describes the local nomad agent
+type Agent struct {
+	Config struct {
+		Datacenter string `json:"Datacenter"`
+		Region     string `json:"Region"`
+		Server     struct {
+			Enabled bool `json:"Enabled"`
+		} `json:"Server"`
+	} `json:"config"`
+	Member struct {
+		Name string            `json:"Name"`
+		Tags map[string]string `json:"Tags"`
+	} `json:"member"`
+	Stats struct {
+		Client map[string]string `json:"client"`
+	} `json:"stats"`
+}
+
+// AgentSelf returns the configuration and membership of the local agent
+func AgentSelf(nomad *Server) (*Agent, error) {
+	agent := &Agent{}
+	err := do(nomad, http.MethodGet, "/v1/agent/self", nil, agent)
+	return agent, err
+}
+
+// AgentHealth returns an error unless the local agent reports its client and
+// server as healthy
+func AgentHealth(nomad *Server) error {
+	return do(nomad, http.MethodGet, "/v1/agent/health", nil, nil)
+}
+
+// Member is a server in the gossip pool
+type Member struct {
+	Name   string            `json:"Name"`
+	Addr   string            `json:"Addr"`
+	Status string            `json:"Status"`
+	Tags   map[string]string `json:"Tags"`
+}
+
+// Members returns the servers in the gossip pool
+func Members(nomad *Server) ([]Member, error) {
+	var resp struct {
+		Members []Member `json:"Members"`
+	}
+	err := do(nomad, http.MethodGet, "/v1/agent/members", nil, &resp)
+	return resp.Members, err
+}
+
+// ForceLeave moves the failed server with the provided name to the left state
+func ForceLeave(nomad *Server, node string) error {
+	query := neturl.Values{"node": {node}}
+	return do(nomad, http.MethodPost, "/v1/agent/force-leave?"+query.Encode(), nil, nil)
+}
+
+// AutopilotHealth represents the raft health reported by autopilot
+type AutopilotHealth struct {
+	Healthy          bool `json:"Healthy"`
+	FailureTolerance int  `json:"FailureTolerance"`
+	Servers          []struct {
+		ID      string `json:"ID"`
+		Name    string `json:"Name"`
+		Healthy bool   `json:"Healthy"`
+		Leader  bool   `json:"Leader"`
+	} `json:"Servers"`
+}
+
+// Autopilot returns the raft health of the server cluster
+func Autopilot(nomad *Server) (*AutopilotHealth, error) {
+	health := &AutopilotHealth{}
+	err := do(nomad, http.MethodGet, "/v1/operator/autopilot/health", nil, health)
+	return health, err
+}
+
+// RemoveRaftPeer removes the server with the provided raft id from the raft
+// configuration
+func RemoveRaftPeer(nomad *Server, id string) error {
+	return do(nomad, http.MethodDelete, "/v1/operator/raft/peer?id="+id, nil, nil)
+}
+
+// SubmitJob registers the json job specification with nomad
+func SubmitJob(nomad *Server, spec []byte) error {
+	return do(nomad, http.MethodPost, "/v1/jobs", json.RawMessage(spec), nil)
+}
+
+// PlanJob runs a dry-run plan of the json job specification against the
+// registered job
+// Returns whether the plan differs from the running job
+func PlanJob(nomad *Server, spec []byte) (bool, error) {
+	plan, err := Plan(nomad, spec)
+	if err != nil {
+		return false, err
+	}
+	return plan.Diff.Type != "None", nil
+}
+
+// JobPlan is the result of planning a job specification
+type JobPlan struct {
+	Diff struct {
+		Type       string `json:"Type"`
+		TaskGroups []struct {
+			Name    string            `json:"Name"`
+			Type    string            `json:"Type"`
+			Updates map[string]uint64 `json:"Updates"`
+		} `json:"TaskGroups"`
+	} `json:"Diff"`
+	// FailedTGAllocs are the task groups that couldn't be placed
+	FailedTGAllocs map[string]*AllocMetric `json:"FailedTGAllocs"`
+	Warnings       string                  `json:"Warnings"`
+}
+
+// Plan runs a dry-run plan of the json job specification against the
+// registered job, returning the diff
+func Plan(nomad *Server, spec []byte) (*JobPlan, error) {
+	var wrapped struct {
+		Job json.RawMessage `json:"Job"`
+	}
+	if err := json.Unmarshal(spec, &wrapped); err != nil {
+		return nil, err
+	}
+	var job struct {
+		ID string `json:"ID"`
+	}
+	if err := json.Unmarshal(wrapped.Job, &job); err != nil {
+		return nil, err
+	}
+	body := map[string]interface{}{"Job": wrapped.Job, "Diff": true}
+	plan := &JobPlan{}
+	if err := do(nomad, http.MethodPost, "/v1/job/"+job.ID+"/plan", body, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// GetJob returns the json definition of the registered job with the
+// provided id and its version
+func GetJob(nomad *Server, id string) (json.RawMessage, uint64, error) {
+	var job json.RawMessage
+	if err := do(nomad, http.MethodGet, "/v1/job/"+id, nil, &job); err != nil {
+		return nil, 0, err
+	}
+	var version struct {
+		Version uint64 `json:"Version"`
+	}
+	if err := json.Unmarshal(job, &version); err != nil {
+		return nil, 0, err
+	}
+	return job, version.Version, nil
+}
+
+// JobStatus returns the status of the job with the provided id, or an empty
+// status when nomad has no such job
+func JobStatus(nomad *Server, id string) (string, error) {
+	var job struct {
+		Status string `json:"Status"`
+	}
+	path := "/v1/job/" + id
+	start := time.Now()
+	status, err := send(nomad, http.MethodGet, path, nil, &job)
+	Observe(http.MethodGet, path, status, time.Since(start), err)
+	if status == http.StatusNotFound {
+		return "", nil
+	}
+	return job.Status, err
+}
+
+// JobVersion is a registered version of a job. Nomad marks versions whose
+// deployment succeeded stable.
+type JobVersion struct {
+	Version    uint64 `json:"Version"`
+	Stable     bool   `json:"Stable"`
+	SubmitTime int64  `json:"SubmitTime"`
+}
+
+// JobVersions returns the versions of the job with the provided id, most
+// recent first
+func JobVersions(nomad *Server, id string) ([]JobVersion, error) {
+	var versions struct {
+		Versions []JobVersion `json:"Versions"`
+	}
+	err := do(nomad, http.MethodGet, "/v1/job/"+id+"/versions", nil, &versions)
+	return versions.Versions, err
+}
+
+// RevertJob reverts the job with the provided id to version
+func RevertJob(nomad *Server, id string, version uint64) error {
+	body := map[string]interface{}{"JobID": id, "JobVersion": version}
+	return do(nomad, http.MethodPost, "/v1/job/"+id+"/revert", body, nil)
+}
+
+// DeploymentGroup represents the state of a task group in a deployment
+type DeploymentGroup struct {
+	Promoted        bool `json:"Promoted"`
+	DesiredCanaries int  `json:"DesiredCanaries"`
+	HealthyAllocs   int  `json:"HealthyAllocs"`
+	UnhealthyAllocs int  `json:"UnhealthyAllocs"`
+}
+
+// Deployment is a representation of a nomad job deployment
+type Deployment struct {
+	ID                string                      `json:"ID"`
+	JobID             string                      `json:"JobID"`
+	JobVersion        uint64                      `json:"JobVersion"`
+	Status            string                      `json:"Status"`
+	StatusDescription string                      `json:"StatusDescription"`
+	TaskGroups        map[string]*DeploymentGroup `json:"TaskGroups"`
+}
+
+// CanariesHealthy reports whether the deployment placed canaries that are all
+// healthy and awaiting promotion
+func (d *Deployment) CanariesHealthy() bool {
+	canaries := false
+	for _, group := range d.TaskGroups {
+		if group.DesiredCanaries == 0 || group.Promoted {
+			continue
+		}
+		if group.HealthyAllocs < group.DesiredCanaries {
+			return false
+		}
+		canaries = true
+	}
+	return canaries
+}
+
+// LatestDeployment returns the most recent deployment of the job with the
+// provided id
+func LatestDeployment(nomad *Server, id string) (*Deployment, error) {
+	deployment := &Deployment{}
+	err := do(nomad, http.MethodGet, "/v1/job/"+id+"/deployment", nil, deployment)
+	return deployment, err
+}
+
+// PromoteDeployment promotes the canaries of every task group in the
+// deployment with the provided id
+func PromoteDeployment(nomad *Server, id string) error {
+	body := map[string]interface{}{"DeploymentID": id, "All": true}
+	return do(nomad, http.MethodPost, "/v1/deployment/promote/"+id, body, nil)
+}
+
+// NodeAllocations returns the allocations placed on the node with the
+// provided id
+func NodeAllocations(nomad *Server, id string) ([]client.Alloc, error) {
+	allocs := make([]client.Alloc, 0)
+	err := do(nomad, http.MethodGet, "/v1/node/"+id+"/allocations", nil, &allocs)
+	return allocs, err
+}
+
+// JobAllocations returns the allocations of the job with the provided id
+func JobAllocations(nomad *Server, id string) ([]client.Alloc, error) {
+	allocs := make([]client.Alloc, 0)
+	err := do(nomad, http.MethodGet, "/v1/job/"+id+"/allocations", nil, &allocs)
+	return allocs, err
+}
+
+// RestartAlloc restarts every task of the allocation with the provided id
+func RestartAlloc(nomad *Server, id string) error {
+	return do(nomad, http.MethodPost, "/v1/client/allocation/"+id+"/restart", struct{}{}, nil)
+}
+
+// Evaluation is the result of scheduling a job
+type Evaluation struct {
+	ID             string                  `json:"ID"`
+	Status         string                  `json:"Status"`
+	StatusDesc     string                  `json:"StatusDescription"`
+	BlockedEval    string                  `json:"BlockedEval"`
+	FailedTGAllocs map[string]*AllocMetric `json:"FailedTGAllocs"`
+}
+
+// AllocMetric records why the scheduler couldn't place a task group
+type AllocMetric struct {
+	NodesEvaluated     int            `json:"NodesEvaluated"`
+	NodesFiltered      int            `json:"NodesFiltered"`
+	NodesExhausted     int            `json:"NodesExhausted"`
+	ClassFiltered      map[string]int `json:"ClassFiltered"`
+	ConstraintFiltered map[string]int `json:"ConstraintFiltered"`
+	DimensionExhausted map[string]int `json:"DimensionExhausted"`
+	QuotaExhausted     []string       `json:"QuotaExhausted"`
+}
+
+// Explain summarizes why the task group couldn't be placed, e.g. "3 nodes
+// evaluated; 2 exhausted memory; 1 filtered by ${attr.kernel.name} = linux"
+func (m *AllocMetric) Explain() string {
+	if m.NodesEvaluated == 0 {
+		return "no eligible nodes in the job's datacenters"
+	}
+	reasons := []string{fmt.Sprintf("%d nodes evaluated", m.NodesEvaluated)}
+	for _, key := range sortedKeys(m.DimensionExhausted) {
+		reasons = append(reasons, fmt.Sprintf("%d exhausted %s", m.DimensionExhausted[key], key))
+	}
+	for _, key := range sortedKeys(m.ConstraintFiltered) {
+		reasons = append(reasons, fmt.Sprintf("%d filtered by %s", m.ConstraintFiltered[key], key))
+	}
+	for _, key := range sortedKeys(m.ClassFiltered) {
+		reasons = append(reasons, fmt.Sprintf("%d filtered by node class %s", m.ClassFiltered[key], key))
+	}
+	if len(m.QuotaExhausted) != 0 {
+		reasons = append(reasons, "quota exhausted: "+strings.Join(m.QuotaExhausted, ", "))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// JobEvaluations returns the evaluations of the job with the provided id
+func JobEvaluations(nomad *Server, id string) ([]Evaluation, error) {
+	evals := make([]Evaluation, 0)
+	err := do(nomad, http.MethodGet, "/v1/job/"+id+"/evaluations", nil, &evals)
+	return evals, err
+}
+
+// ScaleJob sets the count of the task group of the job with the provided id
+// Returns the id of the resulting evaluation
+func ScaleJob(nomad *Server, id string, group string, count int, message string) (string, error) {
+	body := map[string]interface{}{
+		"Count":   count,
+		"Target":  map[string]string{"Group": group},
+		"Message": message,
+	}
+	var resp struct {
+		EvalID string `json:"EvalID"`
+	}
+	err := do(nomad, http.MethodPost, "/v1/job/"+id+"/scale", body, &resp)
+	return resp.EvalID, err
+}
+
+// DispatchJob dispatches an instance of the parameterized job with the
+// provided id
+// Returns the ids of the dispatched job and its evaluation
+func DispatchJob(nomad *Server, id string, payload []byte, meta map[string]string) (string, string, error) {
+	body := map[string]interface{}{
+		"Payload": payload,
+		"Meta":    meta,
+	}
+	var resp struct {
+		DispatchedJobID string `json:"DispatchedJobID"`
+		EvalID          string `json:"EvalID"`
+	}
+	err := do(nomad, http.MethodPost, "/v1/job/"+id+"/dispatch", body, &resp)
+	return resp.DispatchedJobID, resp.EvalID, err
+}
+
+// GetEvaluation returns the evaluation with the provided id
+func GetEvaluation(nomad *Server, id string) (*Evaluation, error) {
+	eval := &Evaluation{}
+	err := do(nomad, http.MethodGet, "/v1/evaluation/"+id, nil, eval)
+	return eval, err
+}
+
+// DrainSpec configures how a node is drained
+type DrainSpec struct {
+	// Deadline after which remaining allocations are forced off the node
+	Deadline time.Duration
+	// IgnoreSystemJobs leaves system job allocations running
+	IgnoreSystemJobs bool
+	// Force stops all allocations immediately
+	Force bool
+}
+
+// DrainNode enables drain of the node with the provided id
+func DrainNode(nomad *Server, id string, spec DrainSpec) error {
+	deadline := spec.Deadline.Nanoseconds()
+	if spec.Force {
+		deadline = -1
+	}
+	body := map[string]interface{}{
+		"NodeID": id,
+		"DrainSpec": map[string]interface{}{
+			"Deadline":         deadline,
+			"IgnoreSystemJobs": spec.IgnoreSystemJobs,
+		},
+	}
+	return do(nomad, http.MethodPost, "/v1/node/"+id+"/drain", body, nil)
+}
+
+// DisableDrain disables the drain of the node with the provided id, leaving
+// it eligible
+func DisableDrain(nomad *Server, id string) error {
+	body := map[string]interface{}{"NodeID": id, "DrainSpec": nil, "MarkEligible": true}
+	return do(nomad, http.MethodPost, "/v1/node/"+id+"/drain", body, nil)
+}
+
+// SetEligibility toggles whether new allocations may be scheduled on the node
+// with the provided id
+func SetEligibility(nomad *Server, id string, eligible bool) error {
+	eligibility := "ineligible"
+	if eligible {
+		eligibility = "eligible"
+	}
+	body := map[string]string{"NodeID": id, "Eligibility": eligibility}
+	return do(nomad, http.MethodPost, "/v1/node/"+id+"/eligibility", body, nil)
+}
+
+// Snapshot returns a snapshot of the server cluster's raft state
+func Snapshot(nomad *Server) ([]byte, error) {
+	var snapshot []byte
+	err := do(nomad, http.MethodGet, "/v1/operator/snapshot", nil, &snapshot)
+	return snapshot, err
+}
+
+// RestoreSnapshot replaces the server cluster's raft state with snapshot
+func RestoreSnapshot(nomad *Server, snapshot []byte) error {
+	return do(nomad, http.MethodPut, "/v1/operator/snapshot", snapshot, nil)
+}
+
+// StopJob deregisters the job with the provided id, also removing it from
+// the job history when purge is set
+func StopJob(nomad *Server, id string, purge bool) error {
+	return do(nomad, http.MethodDelete, fmt.Sprintf("/v1/job/%s?purge=%t", id, purge), nil, nil)
+}
+
+// PurgeNode removes the node with the provided id from the cluster
+func PurgeNode(nomad *Server, id string) error {
+	return do(nomad, http.MethodPost, "/v1/node/"+id+"/purge", struct{}{}, nil)
+}
+
+// EvaluateJob forces a new evaluation of the job with the provided id
+func EvaluateJob(nomad *Server, id string) error {
+	return do(nomad, http.MethodPost, "/v1/job/"+id+"/evaluate", struct{}{}, nil)
+}
+
+// StreamLogs follows the stdout or stderr (logType) of a task in the
+// allocation with the provided id, starting at the end of the log. The stream
+// ends when ctx is cancelled or the task stops.
+func StreamLogs(ctx context.Context, nomad *Server, id string, task string, logType string) (io.ReadCloser, error) {
+	path := fmt.Sprintf("/v1/client/fs/logs/%s?task=%s&type=%s&follow=true&origin=end&offset=0&plain=true", id, task, logType)
+	req, err := newRequest(nomad, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := streamClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("nomad: GET %v returned %v", path, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func url(nomad *Server) string {
+	return fmt.Sprintf("%v://%v:%v", Scheme, nomad.Address, nomad.Port)
+}
+
+func newRequest(nomad *Server, method string, path string, body io.Reader) (*http.Request, error) {
+	if Fault != nil {
+		if err := Fault(method + " " + path); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, url(nomad)+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(Token) != 0 {
+		req.Header.Set("X-Nomad-Token", Token)
+	}
+	if len(nomad.Operation) != 0 {
+		req.Header.Set(opid.Header, nomad.Operation)
+	}
+	if len(nomad.Region) != 0 {
+		q := req.URL.Query()
+		q.Set("region", nomad.Region)
+		req.URL.RawQuery = q.Encode()
+	}
+	return req, nil
+}
+
+func do(nomad *Server, method string, path string, body interface{}, target interface{}) error {
+	start := time.Now()
+	status, err := send(nomad, method, path, body, target)
+	Observe(method, path, status, time.Since(start), err)
+	return err
+}
+
+// readOnly reports whether the request only reads state, which plans do
+// despite being POSTs
+func readOnly(method string, path string) bool {
+	if method == http.MethodGet {
+		return true
+	}
+	return method == http.MethodPost && strings.HasPrefix(path, "/v1/job/") && strings.HasSuffix(path, "/plan")
+}
+
+func send(nomad *Server, method string, path string, body interface{}, target interface{}) (int, error) {
+	var payload []byte
+	if raw, ok := body.([]byte); ok {
+		payload = raw
+	} else if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		payload = buf
+	}
+	if DryRun != nil && !readOnly(method, path) {
+		DryRun(method+" "+path, payload)
+		return http.StatusOK, nil
+	}
+	var r io.Reader
+	if payload != nil {
+		r = bytes.NewReader(payload)
+	}
+	req, err := newRequest(nomad, method, path, r)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("nomad: %v %v returned %v: %v", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if target == nil {
+		return resp.StatusCode, nil
+	}
+	if raw, ok := target.(*[]byte); ok {
+		*raw, err = ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(target)
+}
@@ -0,0 +1,66 @@
+// Package notify delivers alerts about notable wrapper events to an
+// operator-configured webhook.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event is the json body posted to the webhook
+type Event struct {
+	Node    string    `json:"node"`
+	Event   string    `json:"event"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Notifier posts events to URL. A Notifier without a URL drops every event.
+type Notifier struct {
+	URL  string
+	Node string
+	http *http.Client
+	mu   sync.RWMutex
+}
+
+// New returns a Notifier posting to url on behalf of node
+func New(url string, node string) *Notifier {
+	return &Notifier{URL: url, Node: node, http: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// SetURL changes the webhook events are posted to
+func (n *Notifier) SetURL(url string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.URL = url
+}
+
+// Notify posts an event to the webhook
+func (n *Notifier) Notify(event string, message string) error {
+	if n == nil {
+		return nil
+	}
+	n.mu.RLock()
+	url := n.URL
+	n.mu.RUnlock()
+	if len(url) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(&Event{Node: n.Node, Event: event, Message: message, Time: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	resp, err := n.http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("notify: webhook returned %v", resp.StatusCode)
+	}
+	return nil
+}
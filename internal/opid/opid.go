@@ -0,0 +1,73 @@
+// Package opid correlates the log lines and api calls of one high-level
+// action, such as the startup sequence or a drain, across nodes.
+package opid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/eventid"
+)
+
+// Header is the http header carrying the operation id of api calls
+const Header = "X-Clarify-Operation"
+
+// New returns a random operation id
+func New() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Logger appends the operation id to every message
+type Logger struct {
+	service.Logger
+	id string
+}
+
+// NewLogger wraps l, tagging messages with id
+func NewLogger(l service.Logger, id string) *Logger {
+	return &Logger{Logger: l, id: id}
+}
+
+func (l *Logger) tag(v []interface{}) string {
+	return fmt.Sprintf("%s (op=%s)", fmt.Sprint(v...), l.id)
+}
+
+func (l *Logger) Error(v ...interface{}) error {
+	return l.Logger.Error(l.tag(v))
+}
+
+func (l *Logger) Warning(v ...interface{}) error {
+	return l.Logger.Warning(l.tag(v))
+}
+
+func (l *Logger) Info(v ...interface{}) error {
+	return l.Logger.Info(l.tag(v))
+}
+
+func (l *Logger) Errorf(format string, a ...interface{}) error {
+	return l.Error(fmt.Sprintf(format, a...))
+}
+
+func (l *Logger) Warningf(format string, a ...interface{}) error {
+	return l.Warning(fmt.Sprintf(format, a...))
+}
+
+func (l *Logger) Infof(format string, a ...interface{}) error {
+	return l.Info(fmt.Sprintf(format, a...))
+}
+
+func (l *Logger) NError(eventID uint32, v ...interface{}) error {
+	return eventid.Error(l.Logger, eventID, "%s", l.tag(v))
+}
+
+func (l *Logger) NWarning(eventID uint32, v ...interface{}) error {
+	return eventid.Warning(l.Logger, eventID, "%s", l.tag(v))
+}
+
+func (l *Logger) NInfo(eventID uint32, v ...interface{}) error {
+	return eventid.Info(l.Logger, eventID, "%s", l.tag(v))
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package pidfile
+
+import (
+	"os"
+	"syscall"
+)
+
+func lock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
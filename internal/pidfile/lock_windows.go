@@ -0,0 +1,32 @@
+package pidfile
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32   = syscall.NewLazyDLL("kernel32.dll")
+	lockFileEx = kernel32.NewProc("LockFileEx")
+)
+
+// LockFileEx flags
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+)
+
+// lockOffset is where the locked byte lies; windows locks are mandatory, so
+// locking past the pid leaves it readable by other instances
+const lockOffset = 0x7fffffff
+
+func lock(f *os.File) error {
+	ol := new(syscall.Overlapped)
+	ol.Offset = lockOffset
+	r, _, err := lockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Package pidfile writes the process id to a file held under an exclusive
+// lock, so a second copy of a wrapper (e.g. run from a console while the
+// service is running) can't manage the same drain state and agents.
+package pidfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// File is a held pid file
+type File struct {
+	path string
+	f    *os.File
+}
+
+// Acquire locks path and writes the process id to it. Returns an error
+// naming the running instance when another process holds the lock. The
+// lock is released when the process exits, even if Release isn't called.
+func Acquire(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lock(f); err != nil {
+		f.Close()
+		if pid := running(path); len(pid) != 0 {
+			return nil, fmt.Errorf("another instance is running (pid=%s;pidfile=%s)", pid, path)
+		}
+		return nil, fmt.Errorf("another instance is running (pidfile=%s): %v", path, err)
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &File{path: path, f: f}, nil
+}
+
+// running returns the pid written by the instance holding path
+func running(path string) string {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(buf))
+}
+
+// Release removes the pid file and drops the lock
+func (p *File) Release() error {
+	if p == nil {
+		return nil
+	}
+	os.Remove(p.path)
+	return p.f.Close()
+}
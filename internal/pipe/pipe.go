@@ -0,0 +1,3 @@
+// Package pipe listens on and dials windows named pipes, which only the local
+// system, administrators and the pipe's owner may open.
+package pipe
@@ -0,0 +1,225 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32             = windows.NewLazySystemDLL("kernel32.dll")
+	advapi32             = windows.NewLazySystemDLL("advapi32.dll")
+	procCreateNamedPipe  = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = kernel32.NewProc("ConnectNamedPipe")
+	procWaitNamedPipe    = kernel32.NewProc("WaitNamedPipeW")
+	procConvertSDDL      = advapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+)
+
+const (
+	pipeAccessDuplex        = 0x3
+	fileFlagFirstInstance   = 0x80000
+	pipeRejectRemoteClients = 0x8
+	pipeUnlimitedInstances  = 255
+	bufferSize              = 4096
+	sddlRevision            = 1
+
+	errPipeBusy      = syscall.Errno(231)
+	errPipeConnected = syscall.Errno(535)
+)
+
+// sddl grants the local system, administrators and the pipe's owner full
+// access and nobody else any
+const sddl = "D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GA;;;OW)"
+
+var errClosed = errors.New("pipe listener closed")
+
+// Addr is the path of a named pipe
+type Addr string
+
+func (a Addr) Network() string { return "pipe" }
+func (a Addr) String() string  { return string(a) }
+
+// Listen listens on the named pipe at path, such as \\.\pipe\<name>. It
+// fails when another process already serves the pipe.
+func Listen(path string) (net.Listener, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	descriptor, err := windows.UTF16PtrFromString(sddl)
+	if err != nil {
+		return nil, err
+	}
+	var sd uintptr
+	if r, _, err := procConvertSDDL.Call(uintptr(unsafe.Pointer(descriptor)), sddlRevision, uintptr(unsafe.Pointer(&sd)), 0); r == 0 {
+		return nil, fmt.Errorf("unable to build the security descriptor of %s: %v", path, err)
+	}
+	l := &listener{
+		path: path,
+		name: name,
+		sa:   &windows.SecurityAttributes{SecurityDescriptor: sd},
+	}
+	l.sa.Length = uint32(unsafe.Sizeof(*l.sa))
+	// The first instance is created up front so a second listener fails
+	// here rather than sharing the pipe
+	if l.next, err = l.create(fileFlagFirstInstance); err != nil {
+		windows.LocalFree(windows.Handle(sd))
+		return nil, err
+	}
+	return l, nil
+}
+
+type listener struct {
+	path string
+	name *uint16
+	sa   *windows.SecurityAttributes
+
+	mu      sync.Mutex
+	next    windows.Handle
+	pending windows.Handle
+	ov      *windows.Overlapped
+	closed  bool
+}
+
+// create creates a pipe instance for the next client
+func (l *listener) create(flags uintptr) (windows.Handle, error) {
+	h, _, err := procCreateNamedPipe.Call(
+		uintptr(unsafe.Pointer(l.name)),
+		pipeAccessDuplex|windows.FILE_FLAG_OVERLAPPED|flags,
+		pipeRejectRemoteClients,
+		pipeUnlimitedInstances,
+		bufferSize,
+		bufferSize,
+		0,
+		uintptr(unsafe.Pointer(l.sa)),
+	)
+	if windows.Handle(h) == windows.InvalidHandle {
+		return 0, fmt.Errorf("unable to create pipe %s: %v", l.path, err)
+	}
+	return windows.Handle(h), nil
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, errClosed
+	}
+	h := l.next
+	l.next = 0
+	if h == 0 {
+		var err error
+		if h, err = l.create(0); err != nil {
+			l.mu.Unlock()
+			return nil, err
+		}
+	}
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		l.mu.Unlock()
+		windows.CloseHandle(h)
+		return nil, err
+	}
+	l.pending, l.ov = h, &windows.Overlapped{HEvent: event}
+	ov := l.ov
+	l.mu.Unlock()
+
+	err = connect(h, ov)
+	windows.CloseHandle(event)
+	l.mu.Lock()
+	closed := l.closed
+	l.pending, l.ov = 0, nil
+	l.mu.Unlock()
+	if closed {
+		err = errClosed
+	}
+	if err != nil {
+		windows.CloseHandle(h)
+		return nil, err
+	}
+	return newConn(h, l.path), nil
+}
+
+// connect waits for a client to open the pipe instance h
+func connect(h windows.Handle, ov *windows.Overlapped) error {
+	r, _, err := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(ov)))
+	if r != 0 || err == errPipeConnected {
+		return nil
+	}
+	if err != windows.ERROR_IO_PENDING {
+		return err
+	}
+	if _, err := windows.WaitForSingleObject(ov.HEvent, windows.INFINITE); err != nil {
+		return err
+	}
+	// Internal holds the NTSTATUS of the completed connect
+	if ov.Internal != 0 {
+		return fmt.Errorf("connect failed with status %#x", ov.Internal)
+	}
+	return nil
+}
+
+// Close stops listening, cancelling a pending Accept
+func (l *listener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.ov != nil {
+		windows.CancelIoEx(l.pending, l.ov)
+	}
+	if l.next != 0 {
+		windows.CloseHandle(l.next)
+		l.next = 0
+	}
+	windows.LocalFree(windows.Handle(l.sa.SecurityDescriptor))
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return Addr(l.path)
+}
+
+// Dial connects to the named pipe at path, waiting up to timeout for an
+// instance while all are busy
+func Dial(path string, timeout time.Duration) (net.Conn, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		h, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING, windows.FILE_FLAG_OVERLAPPED, 0)
+		if err == nil {
+			return newConn(h, path), nil
+		}
+		if err != errPipeBusy || time.Now().After(deadline) {
+			return nil, err
+		}
+		procWaitNamedPipe.Call(uintptr(unsafe.Pointer(name)), 250)
+	}
+}
+
+// conn is a connected pipe instance. Its handle is overlapped, so os.File
+// reads and writes it through the runtime's i/o completion port and both may
+// block at once.
+type conn struct {
+	*os.File
+	addr Addr
+}
+
+func newConn(h windows.Handle, path string) *conn {
+	return &conn{File: os.NewFile(uintptr(h), path), addr: Addr(path)}
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.addr }
+func (c *conn) RemoteAddr() net.Addr { return c.addr }
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package preflight
+
+import "syscall"
+
+func freeBytes(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+func openFileLimit() (uint64, bool, error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, false, err
+	}
+	return uint64(rl.Cur), true, nil
+}
@@ -0,0 +1,47 @@
+package preflight
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// portOwner finds the process listening on the tcp port by matching the
+// socket inode from /proc/net/tcp against each process' file descriptors
+func portOwner(port int) string {
+	inodes := make(map[string]bool)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		buf, err := ioutil.ReadFile(table)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(buf), "\n")[1:] {
+			fields := strings.Fields(line)
+			// local_address is hex ip:port and state 0A is LISTEN
+			if len(fields) < 10 || fields[3] != "0A" {
+				continue
+			}
+			local := strings.Split(fields[1], ":")
+			if p, err := strconv.ParseInt(local[len(local)-1], 16, 32); err == nil && int(p) == port {
+				inodes["socket:["+fields[9]+"]"] = true
+			}
+		}
+	}
+	if len(inodes) == 0 {
+		return ""
+	}
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		link, err := os.Readlink(fd)
+		if err != nil || !inodes[link] {
+			continue
+		}
+		pid := strings.Split(fd, string(filepath.Separator))[2]
+		comm, _ := ioutil.ReadFile(filepath.Join("/proc", pid, "comm"))
+		return fmt.Sprintf("%s (pid %s)", strings.TrimSpace(string(comm)), pid)
+	}
+	return ""
+}
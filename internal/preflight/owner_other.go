@@ -0,0 +1,9 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package preflight
+
+// portOwner isn't supported on this platform
+func portOwner(port int) string {
+	return ""
+}
@@ -0,0 +1,32 @@
+package preflight
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// portOwner finds the process listening on the tcp port from netstat and
+// tasklist output
+func portOwner(port int) string {
+	out, err := exec.Command("netstat", "-ano", "-p", "TCP").Output()
+	if err != nil {
+		return ""
+	}
+	suffix := ":" + strconv.Itoa(port)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 5 || fields[3] != "LISTENING" || !strings.HasSuffix(fields[1], suffix) {
+			continue
+		}
+		pid := fields[4]
+		task, err := exec.Command("tasklist", "/FO", "CSV", "/NH", "/FI", "PID eq "+pid).Output()
+		if err != nil {
+			return "pid " + pid
+		}
+		name := strings.Trim(strings.Split(string(task), ",")[0], "\"\r\n ")
+		return fmt.Sprintf("%s (pid %s)", name, pid)
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+// Package preflight verifies a host is ready to run the clarify services
+// before the node joins the cluster.
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Check is the outcome of a single preflight check
+type Check struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is the machine-readable result of a preflight run
+type Report struct {
+	Service string    `json:"service"`
+	Host    string    `json:"host"`
+	Time    time.Time `json:"time"`
+	OK      bool      `json:"ok"`
+	Checks  []Check   `json:"checks"`
+}
+
+// NewReport returns an empty, passing report for service
+func NewReport(service string) *Report {
+	host, _ := os.Hostname()
+	return &Report{Service: service, Host: host, Time: time.Now().UTC(), OK: true, Checks: make([]Check, 0)}
+}
+
+// Add records the outcome of the check called name
+func (r *Report) Add(name string, err error) {
+	c := Check{Name: name, OK: err == nil}
+	if err != nil {
+		c.Detail = err.Error()
+		r.OK = false
+	}
+	r.Checks = append(r.Checks, c)
+}
+
+// Write writes the report to w as indented json
+func (r *Report) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// PortFree verifies nothing is listening on the tcp port, naming the
+// process holding it when it can be found
+func PortFree(port int) error {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		if owner := portOwner(port); len(owner) != 0 {
+			return fmt.Errorf("port %d is in use by %s", port, owner)
+		}
+		return fmt.Errorf("port %d is in use: %v", port, err)
+	}
+	return l.Close()
+}
+
+// PortsFree verifies none of the tcp ports are in use
+func PortsFree(ports []int) error {
+	for _, port := range ports {
+		if err := PortFree(port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiskFree verifies the volume holding path has at least min bytes free
+func DiskFree(path string, min uint64) error {
+	free, err := freeBytes(path)
+	if err != nil {
+		return err
+	}
+	if free < min {
+		return fmt.Errorf("%s has %d MB free; %d MB required", path, free>>20, min>>20)
+	}
+	return nil
+}
+
+// OpenFiles verifies the open file limit is at least min. It always passes
+// on platforms without a limit.
+func OpenFiles(min uint64) error {
+	limit, ok, err := openFileLimit()
+	if err != nil || !ok {
+		return err
+	}
+	if limit < min {
+		return fmt.Errorf("open file limit is %d; %d required", limit, min)
+	}
+	return nil
+}
+
+// Runs verifies the executable at path runs successfully with args
+func Runs(path string, args ...string) error {
+	if len(path) == 0 {
+		return fmt.Errorf("executable not found")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v: %s", path, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ClockSkew verifies the local clock is within max of the Date reported by
+// the http server at url
+func ClockSkew(url string, max time.Duration) error {
+	return ClockSkewWith(&http.Client{Timeout: 10 * time.Second}, url, max)
+}
+
+// ClockSkewWith is ClockSkew through c, for servers needing a tls config
+func ClockSkewWith(c *http.Client, url string, max time.Duration) error {
+	resp, err := c.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("%s returned no usable Date header", url)
+	}
+	skew := time.Since(remote)
+	if skew < 0 {
+		skew = -skew
+	}
+	// The Date header only has a resolution of one second
+	if skew > max+time.Second {
+		return fmt.Errorf("clock differs from %s by %v", url, skew)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+// Package procstat samples the cpu, memory and open file usage of the agent
+// child processes.
+package procstat
+
+import "time"
+
+// Usage is the resource usage of a process
+type Usage struct {
+	PID        int       `json:"pid"`
+	CPUSeconds float64   `json:"cpu_seconds"`
+	CPUPercent float64   `json:"cpu_percent"`
+	RSSBytes   uint64    `json:"rss_bytes"`
+	OpenFiles  int       `json:"open_files"`
+	Time       time.Time `json:"time"`
+}
+
+// Sampler computes cpu utilisation between successive samples of a process
+type Sampler struct {
+	last *Usage
+}
+
+// Sample returns the current usage of the process with the provided pid
+func (s *Sampler) Sample(pid int) (*Usage, error) {
+	u, err := sample(pid)
+	if err != nil {
+		return nil, err
+	}
+	u.PID = pid
+	u.Time = time.Now().UTC()
+	if s.last != nil && s.last.PID == pid {
+		if elapsed := u.Time.Sub(s.last.Time).Seconds(); elapsed > 0 {
+			u.CPUPercent = 100 * (u.CPUSeconds - s.last.CPUSeconds) / elapsed
+		}
+	}
+	s.last = u
+	return u, nil
+}
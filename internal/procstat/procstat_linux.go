@@ -0,0 +1,48 @@
+package procstat
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is USER_HZ, the unit of cpu times in /proc
+const clockTicks = 100
+
+func sample(pid int) (*Usage, error) {
+	proc := fmt.Sprintf("/proc/%d", pid)
+	stat, err := ioutil.ReadFile(proc + "/stat")
+	if err != nil {
+		return nil, err
+	}
+	// The command name may contain spaces so fields are counted after it
+	s := string(stat)
+	fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+	if len(fields) < 13 {
+		return nil, fmt.Errorf("unexpected %s/stat format", proc)
+	}
+	utime, _ := strconv.ParseFloat(fields[11], 64)
+	stime, _ := strconv.ParseFloat(fields[12], 64)
+	u := &Usage{CPUSeconds: (utime + stime) / clockTicks}
+
+	f, err := os.Open(proc + "/status")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.Fields(scanner.Text())
+		if len(line) >= 2 && line[0] == "VmRSS:" {
+			kb, _ := strconv.ParseUint(line[1], 10, 64)
+			u.RSSBytes = kb << 10
+		}
+	}
+	if fds, err := ioutil.ReadDir(proc + "/fd"); err == nil {
+		u.OpenFiles = len(fds)
+	}
+	return u, nil
+}
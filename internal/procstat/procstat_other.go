@@ -0,0 +1,10 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package procstat
+
+import "errors"
+
+func sample(pid int) (*Usage, error) {
+	return nil, errors.New("process usage isn't supported on this platform")
+}
@@ -0,0 +1,61 @@
+package procstat
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32              = syscall.NewLazyDLL("kernel32.dll")
+	getProcessMemoryInfo  = kernel32.NewProc("K32GetProcessMemoryInfo")
+	getProcessHandleCount = kernel32.NewProc("GetProcessHandleCount")
+)
+
+// Process access rights
+const (
+	processQueryLimitedInfo = 0x1000
+	processVMRead           = 0x0010
+)
+
+// processMemoryCounters is PROCESS_MEMORY_COUNTERS
+type processMemoryCounters struct {
+	cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// sample reports open handles as open files
+func sample(pid int) (*Usage, error) {
+	h, err := syscall.OpenProcess(processQueryLimitedInfo|processVMRead, false, uint32(pid))
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.CloseHandle(h)
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err != nil {
+		return nil, err
+	}
+	// Filetimes are in 100ns units
+	ticks := func(t syscall.Filetime) float64 {
+		return float64(uint64(t.HighDateTime)<<32 | uint64(t.LowDateTime))
+	}
+	u := &Usage{CPUSeconds: (ticks(kernel) + ticks(user)) / 1e7}
+	var mem processMemoryCounters
+	mem.cb = uint32(unsafe.Sizeof(mem))
+	if r, _, err := getProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&mem)), uintptr(mem.cb)); r == 0 {
+		return nil, err
+	}
+	u.RSSBytes = uint64(mem.WorkingSetSize)
+	var handles uint32
+	if r, _, _ := getProcessHandleCount.Call(uintptr(h), uintptr(unsafe.Pointer(&handles))); r != 0 {
+		u.OpenFiles = int(handles)
+	}
+	return u, nil
+}
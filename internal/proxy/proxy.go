@@ -0,0 +1,124 @@
+// Package proxy routes the wrappers' outbound http requests, such as nomad
+// api calls, artifact downloads and webhooks, through an explicitly
+// configured proxy. Without one HTTP_PROXY, HTTPS_PROXY and NO_PROXY apply
+// as they do for net/http.
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Config is an explicitly configured proxy
+type Config struct {
+	// URL of the proxy; user info authenticates with it
+	URL *url.URL
+	// NoProxy are the hosts, domain suffixes, ips and cidrs reached
+	// directly. Loopback addresses are always reached directly.
+	NoProxy []string
+}
+
+// Parse returns the proxy at rawURL, http://[user:password@]host:port,
+// bypassed for the comma separated noProxy hosts. It returns nil when
+// rawURL is empty.
+func Parse(rawURL string, noProxy string) (*Config, error) {
+	if len(rawURL) == 0 {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid proxy %q; expected http://[user:password@]host:port", rawURL)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q; scheme must be http, https or socks5", rawURL)
+	}
+	c := &Config{URL: u}
+	for _, host := range strings.Split(noProxy, ",") {
+		if host = strings.TrimSpace(host); len(host) != 0 {
+			c.NoProxy = append(c.NoProxy, strings.ToLower(host))
+		}
+	}
+	return c, nil
+}
+
+// Func returns the proxy function of an http.Transport, which is the
+// environment's when c is nil
+func (c *Config) Func() func(*http.Request) (*url.URL, error) {
+	if c == nil {
+		return http.ProxyFromEnvironment
+	}
+	return func(r *http.Request) (*url.URL, error) {
+		if c.bypass(r.URL.Hostname()) {
+			return nil, nil
+		}
+		return c.URL, nil
+	}
+}
+
+// Install makes the default transport, which the http clients without their
+// own transport use, proxy through c. Clients with their own transport, like
+// the nomad API's, take c.Func themselves
+func Install(c *Config) {
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.Proxy = c.Func()
+	}
+}
+
+// Env returns the proxy environment variables of child processes, which is
+// nothing when c is nil so they inherit the environment's
+func (c *Config) Env() []string {
+	if c == nil {
+		return nil
+	}
+	noProxy := strings.Join(append([]string{"localhost", "127.0.0.1", "::1"}, c.NoProxy...), ",")
+	var env []string
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		value := c.URL.String()
+		if name == "NO_PROXY" {
+			value = noProxy
+		}
+		env = append(env, name+"="+value, strings.ToLower(name)+"="+value)
+	}
+	return env
+}
+
+// bypass reports whether host is reached directly
+func (c *Config) bypass(host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return true
+	}
+	for _, entry := range c.NoProxy {
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		// example.com and .example.com match the domain and its subdomains
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Password returns the password authenticating with the proxy, for
+// redaction
+func (c *Config) Password() string {
+	if c == nil || c.URL.User == nil {
+		return ""
+	}
+	password, _ := c.URL.User.Password()
+	return password
+}
@@ -0,0 +1,86 @@
+// Package quorum monitors the raft health of a consul or nomad server
+// cluster, alerting when it's one failure away from losing quorum.
+package quorum
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// Health is the raft health reported by autopilot
+type Health struct {
+	Healthy          bool
+	FailureTolerance int
+	Servers          int
+	Leader           string
+}
+
+// States of the cluster reported to Alert
+const (
+	StateOK       = "ok"
+	StateAtRisk   = "at_risk"
+	StateNoLeader = "no_leader"
+	StateUnknown  = "unknown"
+)
+
+// Monitor checks the cluster's health every Interval
+type Monitor struct {
+	Name     string
+	Interval time.Duration
+	Check    func() (*Health, error)
+	// Alert is called when the state changes, including back to ok
+	Alert func(state string, msg string)
+	// Gauge records the failure tolerance and server count
+	Gauge  func(name string, value float64, tags ...string)
+	Logger service.Logger
+	state  string
+}
+
+// Run checks the cluster until exit is closed
+func (m *Monitor) Run(exit <-chan struct{}) {
+	m.state = StateOK
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.check()
+		case <-exit:
+			return
+		}
+	}
+}
+
+func (m *Monitor) check() {
+	state, msg := m.evaluate()
+	if state == m.state {
+		return
+	}
+	m.state = state
+	if state == StateOK {
+		m.Logger.Infof("%s raft healthy again (%s)", m.Name, msg)
+	} else {
+		m.Logger.Warningf("%s raft %s (%s)", m.Name, state, msg)
+	}
+	m.Alert(state, fmt.Sprintf("%s raft %s: %s", m.Name, state, msg))
+}
+
+// evaluate returns the cluster's state and a description of it
+func (m *Monitor) evaluate() (string, string) {
+	h, err := m.Check()
+	if err != nil {
+		return StateUnknown, err.Error()
+	}
+	m.Gauge("raft.failure_tolerance", float64(h.FailureTolerance))
+	m.Gauge("raft.servers", float64(h.Servers))
+	msg := fmt.Sprintf("servers=%d;failure_tolerance=%d;healthy=%t", h.Servers, h.FailureTolerance, h.Healthy)
+	switch {
+	case len(h.Leader) == 0:
+		return StateNoLeader, msg
+	case h.FailureTolerance < 1:
+		return StateAtRisk, msg
+	}
+	return StateOK, msg
+}
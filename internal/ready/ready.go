@@ -0,0 +1,34 @@
+// Package ready maintains a sentinel other host tooling (backup agents,
+// patching tools) checks to know a service is up: a file on unix and a
+// registry key under HKLM on windows.
+package ready
+
+import (
+	"os"
+	"time"
+)
+
+// Disabled is the sentinel location turning the sentinel off
+const Disabled = "none"
+
+// Sentinel is the readiness of the process
+type Sentinel struct {
+	Pid   int       `json:"pid"`
+	Since time.Time `json:"since"`
+}
+
+// Mark writes the sentinel at location
+func Mark(location string) error {
+	return mark(location, Sentinel{Pid: os.Getpid(), Since: time.Now().UTC()})
+}
+
+// Clear removes the sentinel at location. Clearing an absent sentinel
+// succeeds.
+func Clear(location string) error {
+	return clear(location)
+}
+
+// Default returns the sentinel location of the service name
+func Default(name string) string {
+	return defaultLocation(name)
+}
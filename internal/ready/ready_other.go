@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package ready
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func defaultLocation(name string) string {
+	return filepath.Join("/var/run", name+".ready")
+}
+
+// mark writes the sentinel to a temporary file renamed into place so
+// readers never see it partially written
+func mark(path string, s Sentinel) error {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(buf, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func clear(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
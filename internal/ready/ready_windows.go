@@ -0,0 +1,46 @@
+package ready
+
+import (
+	"time"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// Values of the sentinel key; Ready is a DWORD set to 1
+var values = []string{"Ready", "Pid", "Since"}
+
+func defaultLocation(name string) string {
+	return `SOFTWARE\` + name
+}
+
+func mark(path string, s Sentinel) error {
+	k, _, err := registry.CreateKey(registry.LOCAL_MACHINE, path, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+	if err := k.SetDWordValue("Pid", uint32(s.Pid)); err != nil {
+		return err
+	}
+	if err := k.SetStringValue("Since", s.Since.Format(time.RFC3339)); err != nil {
+		return err
+	}
+	// Ready goes last so tools never see it without the other values
+	return k.SetDWordValue("Ready", 1)
+}
+
+func clear(path string) error {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.SET_VALUE)
+	if err == registry.ErrNotExist {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer k.Close()
+	for _, name := range values {
+		if err := k.DeleteValue(name); err != nil && err != registry.ErrNotExist {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+// Package reboot detects operating system reboots pending after updates so
+// the node can be drained before a patch orchestrator restarts it.
+package reboot
+
+// Pending returns why the operating system needs a reboot, or nothing when
+// no reboot is pending
+func Pending() ([]string, error) {
+	return pending()
+}
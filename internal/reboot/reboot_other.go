@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package reboot
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// requiredFile is created by update-notifier and unattended-upgrades, with
+// the packages needing the reboot listed in requiredFile.pkgs
+const requiredFile = "/var/run/reboot-required"
+
+func pending() ([]string, error) {
+	if _, err := os.Stat(requiredFile); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	reasons := []string{requiredFile}
+	if pkgs, err := ioutil.ReadFile(requiredFile + ".pkgs"); err == nil {
+		for _, pkg := range strings.Fields(string(pkgs)) {
+			reasons = append(reasons, "package "+pkg)
+		}
+	}
+	return reasons, nil
+}
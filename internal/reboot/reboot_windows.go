@@ -0,0 +1,38 @@
+package reboot
+
+import (
+	"golang.org/x/sys/windows/registry"
+)
+
+// pendingKeys exist while component servicing or windows update wait for a
+// reboot
+var pendingKeys = []string{
+	`SOFTWARE\Microsoft\Windows\CurrentVersion\Component Based Servicing\RebootPending`,
+	`SOFTWARE\Microsoft\Windows\CurrentVersion\WindowsUpdate\Auto Update\RebootRequired`,
+}
+
+// sessionManager holds the file renames applied on the next boot
+const sessionManager = `SYSTEM\CurrentControlSet\Control\Session Manager`
+
+func pending() ([]string, error) {
+	var reasons []string
+	for _, path := range pendingKeys {
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+		if err == registry.ErrNotExist {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		k.Close()
+		reasons = append(reasons, `HKLM\`+path)
+	}
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, sessionManager, registry.QUERY_VALUE)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+	if renames, _, err := k.GetStringsValue("PendingFileRenameOperations"); err == nil && len(renames) != 0 {
+		reasons = append(reasons, `HKLM\`+sessionManager+`\PendingFileRenameOperations`)
+	}
+	return reasons, nil
+}
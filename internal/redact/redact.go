@@ -0,0 +1,126 @@
+// Package redact masks secrets such as ACL tokens, gossip keys and TLS key
+// paths in log output before it's written anywhere.
+package redact
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/eventid"
+)
+
+// Mask replaces redacted values
+const Mask = "[REDACTED]"
+
+var (
+	// Values of well-known secret settings in json, hcl and command lines
+	settings = regexp.MustCompile(`(?i)((?:acl_|master_|agent_)?token|encrypt|secret_id|password|key_file|private_key)("?\s*[:=]\s*"?)([^"\s,}]+)`)
+	// Vault service, batch and recovery tokens
+	vaultTokens = regexp.MustCompile(`\bhv[sbr]\.[A-Za-z0-9_-]{20,}`)
+
+	mu     sync.RWMutex
+	values = make([]string, 0)
+)
+
+// Add registers secret values, such as tokens read at startup, that are
+// masked wherever they appear
+func Add(secrets ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, s := range secrets {
+		if len(s) != 0 {
+			values = append(values, s)
+		}
+	}
+}
+
+// String masks every secret in s
+func String(s string) string {
+	mu.RLock()
+	for _, v := range values {
+		s = strings.Replace(s, v, Mask, -1)
+	}
+	mu.RUnlock()
+	s = settings.ReplaceAllString(s, "${1}${2}"+Mask)
+	return vaultTokens.ReplaceAllString(s, Mask)
+}
+
+type logger struct {
+	service.Logger
+}
+
+// Logger wraps l so every message is redacted before it's logged
+func Logger(l service.Logger) service.Logger {
+	return &logger{l}
+}
+
+func (l *logger) Error(v ...interface{}) error {
+	return l.Logger.Error(String(fmt.Sprint(v...)))
+}
+
+func (l *logger) Warning(v ...interface{}) error {
+	return l.Logger.Warning(String(fmt.Sprint(v...)))
+}
+
+func (l *logger) Info(v ...interface{}) error {
+	return l.Logger.Info(String(fmt.Sprint(v...)))
+}
+
+func (l *logger) Errorf(format string, a ...interface{}) error {
+	return l.Logger.Error(String(fmt.Sprintf(format, a...)))
+}
+
+func (l *logger) Warningf(format string, a ...interface{}) error {
+	return l.Logger.Warning(String(fmt.Sprintf(format, a...)))
+}
+
+func (l *logger) Infof(format string, a ...interface{}) error {
+	return l.Logger.Info(String(fmt.Sprintf(format, a...)))
+}
+
+func (l *logger) NError(eventID uint32, v ...interface{}) error {
+	return eventid.Error(l.Logger, eventID, "%s", String(fmt.Sprint(v...)))
+}
+
+func (l *logger) NWarning(eventID uint32, v ...interface{}) error {
+	return eventid.Warning(l.Logger, eventID, "%s", String(fmt.Sprint(v...)))
+}
+
+func (l *logger) NInfo(eventID uint32, v ...interface{}) error {
+	return eventid.Info(l.Logger, eventID, "%s", String(fmt.Sprint(v...)))
+}
+
+type writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+// Writer returns a writer that redacts each complete line before passing it
+// to w, such as the output of a child process
+func Writer(w io.Writer) io.Writer {
+	return &writer{w: w}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := String(string(w.buf[:i+1]))
+		w.buf = w.buf[i+1:]
+		if _, err := io.WriteString(w.w, line); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,145 @@
+// Package rpc serves gRPC services without the grpc-go runtime, which isn't
+// among the vendored dependencies. A call is an HTTP/2 POST to
+// /<service>/<method> carrying length-prefixed protobuf messages, answered
+// with the response messages and a grpc-status trailer. Messages are encoded
+// with Encoder and decoded with Decode against the service's .proto.
+package rpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Status codes of failed calls
+const (
+	OK              = 0
+	InvalidArgument = 3
+	Unimplemented   = 12
+	Internal        = 13
+	Unauthenticated = 16
+)
+
+// maxMessage bounds the size of a request message
+const maxMessage = 4 << 20
+
+// Error fails a call with a status code
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf returns an Error with code and the formatted message
+func Errorf(code int, format string, a ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// Method handles a call given its request message, sending its response
+// messages with send: one for a unary method, any number for a server
+// streaming one. Errors other than an Error fail the call as Internal.
+type Method func(r *http.Request, req []byte, send func(msg []byte) error) error
+
+// Service routes the calls of the gRPC service Name, such as pkg.Service, to
+// its methods
+type Service struct {
+	Name    string
+	Methods map[string]Method
+}
+
+// Prefix is the path the service's calls share, to mount it on a mux
+func (s *Service) Prefix() string {
+	return "/" + s.Name + "/"
+}
+
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC calls are HTTP/2 POSTs of application/grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	err := s.call(w, r)
+	code, msg := OK, ""
+	if e, ok := err.(*Error); ok {
+		code, msg = e.Code, e.Message
+	} else if err != nil {
+		code, msg = Internal, err.Error()
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if len(msg) != 0 {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeMessage(msg))
+	}
+}
+
+func (s *Service) call(w http.ResponseWriter, r *http.Request) error {
+	method, ok := s.Methods[strings.TrimPrefix(r.URL.Path, s.Prefix())]
+	if !ok {
+		return Errorf(Unimplemented, "unknown method %s", r.URL.Path)
+	}
+	req, err := readMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	flusher, _ := w.(http.Flusher)
+	return method(r, req, func(msg []byte) error {
+		frame := make([]byte, 5, 5+len(msg))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+		if _, err := w.Write(append(frame, msg...)); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
+
+// readMessage reads the request message, the only one unary and server
+// streaming calls send
+func readMessage(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, Errorf(InvalidArgument, "unable to read the request message: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, Errorf(Unimplemented, "compressed messages aren't supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxMessage {
+		return nil, Errorf(InvalidArgument, "request message of %d bytes exceeds %d", size, maxMessage)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, Errorf(InvalidArgument, "unable to read the request message: %v", err)
+	}
+	return msg, nil
+}
+
+// encodeMessage percent-encodes the grpc-message trailer
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// NewServer returns an http server answering HTTP/1 requests and, for gRPC
+// calls, HTTP/2 with prior knowledge on the same unencrypted listener
+func NewServer(h http.Handler) *http.Server {
+	protocols := &http.Protocols{}
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Server{Handler: h, Protocols: protocols}
+}
@@ -0,0 +1,176 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Wire types of protobuf fields
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// Encoder builds a protobuf message field by field. Zero values are omitted,
+// as proto3 does.
+type Encoder struct {
+	buf []byte
+}
+
+func (e *Encoder) key(field int, wire int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wire))
+}
+
+// Bytes appends a bytes or embedded message field
+func (e *Encoder) Bytes(field int, b []byte) {
+	e.key(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// String appends a string field
+func (e *Encoder) String(field int, s string) {
+	if len(s) != 0 {
+		e.Bytes(field, []byte(s))
+	}
+}
+
+// Bool appends a bool field
+func (e *Encoder) Bool(field int, b bool) {
+	if b {
+		e.key(field, wireVarint)
+		e.buf = append(e.buf, 1)
+	}
+}
+
+// Int64 appends an int64 field
+func (e *Encoder) Int64(field int, v int64) {
+	if v != 0 {
+		e.key(field, wireVarint)
+		e.buf = binary.AppendUvarint(e.buf, uint64(v))
+	}
+}
+
+// Map appends a map<string, string> field, its entries sorted by key so the
+// message is deterministic
+func (e *Encoder) Map(field int, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		entry := &Encoder{}
+		entry.String(1, k)
+		entry.String(2, m[k])
+		e.Bytes(field, entry.Message())
+	}
+}
+
+// Time appends a google.protobuf.Timestamp field
+func (e *Encoder) Time(field int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	ts := &Encoder{}
+	ts.Int64(1, t.Unix())
+	ts.Int64(2, int64(t.Nanosecond()))
+	e.Bytes(field, ts.Message())
+}
+
+// Message returns the encoded message
+func (e *Encoder) Message() []byte {
+	return e.buf
+}
+
+// Field is a field of a decoded message
+type Field struct {
+	Number int
+	Wire   int
+	// Varint is the value of a varint field
+	Varint uint64
+	// Bytes is the value of a bytes, string or embedded message field
+	Bytes []byte
+}
+
+// Bool returns the value of a bool field
+func (f Field) Bool() bool {
+	return f.Wire == wireVarint && f.Varint != 0
+}
+
+// String returns the value of a string field
+func (f Field) String() string {
+	if f.Wire != wireBytes {
+		return ""
+	}
+	return string(f.Bytes)
+}
+
+// Duration returns the value of a google.protobuf.Duration field
+func (f Field) Duration() (time.Duration, error) {
+	if f.Wire != wireBytes {
+		return 0, fmt.Errorf("field %d isn't a google.protobuf.Duration", f.Number)
+	}
+	var d time.Duration
+	err := Decode(f.Bytes, func(g Field) error {
+		switch g.Number {
+		case 1:
+			d += time.Duration(int64(g.Varint)) * time.Second
+		case 2:
+			d += time.Duration(int32(g.Varint))
+		}
+		return nil
+	})
+	return d, err
+}
+
+// Decode calls fn with each field of msg in order
+func Decode(msg []byte, fn func(Field) error) error {
+	for len(msg) != 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errTruncated
+		}
+		msg = msg[n:]
+		f := Field{Number: int(key >> 3), Wire: int(key & 7)}
+		if f.Number == 0 {
+			return errors.New("protobuf field number 0")
+		}
+		switch f.Wire {
+		case wireVarint:
+			if f.Varint, n = binary.Uvarint(msg); n <= 0 {
+				return errTruncated
+			}
+			msg = msg[n:]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if f.Wire == wireFixed32 {
+				size = 4
+			}
+			if len(msg) < size {
+				return errTruncated
+			}
+			msg = msg[size:]
+		case wireBytes:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < size {
+				return errTruncated
+			}
+			f.Bytes = msg[n : n+int(size)]
+			msg = msg[n+int(size):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", f.Wire)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+// Package runas runs the consul and nomad child processes as a dedicated
+// user instead of the wrapper's elevated account.
+package runas
+
+import "strings"
+
+// split parses a user[:group] specification
+func split(spec string) (string, string) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,74 @@
+//go:build !windows
+// +build !windows
+
+package runas
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/kardianos/service"
+)
+
+// Apply sets the credential cmd runs with to the user[:group] spec. The
+// user's primary group is used when no group is given.
+func Apply(cmd *exec.Cmd, spec string) error {
+	if len(spec) == 0 {
+		return nil
+	}
+	uid, gid, err := lookup(spec)
+	if err != nil {
+		return err
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uid, Gid: gid},
+	}
+	return nil
+}
+
+// Chown gives the user[:group] spec ownership of path so the child process
+// can write to it
+func Chown(path string, spec string) error {
+	if len(spec) == 0 {
+		return nil
+	}
+	uid, gid, err := lookup(spec)
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, int(uid), int(gid))
+}
+
+// lookup returns the uid and gid of the user[:group] spec
+func lookup(spec string) (uint32, uint32, error) {
+	name, group := split(spec)
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to find run-as user: %v", err)
+	}
+	gid := u.Gid
+	if len(group) != 0 {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unable to find run-as group: %v", err)
+		}
+		gid = g.Gid
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	g, err := strconv.ParseUint(gid, 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(uid), uint32(g), nil
+}
+
+// Configure is a no-op on unix where the wrapper keeps running as root and
+// only the child process drops privileges
+func Configure(cfg *service.Config, spec string, password string) {}
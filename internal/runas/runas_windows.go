@@ -0,0 +1,33 @@
+package runas
+
+import (
+	"os/exec"
+
+	"github.com/kardianos/service"
+)
+
+// Apply is a no-op on windows where child processes inherit the service
+// account configured at install
+func Apply(cmd *exec.Cmd, spec string) error {
+	return nil
+}
+
+// Chown is a no-op on windows where the service itself runs as the spec
+// user, which owns the directories it creates
+func Chown(path string, spec string) error {
+	return nil
+}
+
+// Configure installs the service to run as the spec user so the agent it
+// launches doesn't run as LocalSystem
+func Configure(cfg *service.Config, spec string, password string) {
+	if len(spec) == 0 {
+		return
+	}
+	name, _ := split(spec)
+	cfg.UserName = name
+	if cfg.Option == nil {
+		cfg.Option = service.KeyValue{}
+	}
+	cfg.Option["Password"] = password
+}
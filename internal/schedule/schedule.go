@@ -0,0 +1,137 @@
+// Package schedule parses cron expressions describing recurring windows,
+// such as when a node may be taken out of service for maintenance.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed five field cron expression: minute, hour, day of month,
+// month and day of week (0 or 7 is sunday). Fields accept *, lists, ranges
+// and steps, e.g. "0 2 * * 6,0" or "*/15 1-4 * * *".
+type Cron struct {
+	fields [5]map[int]bool
+	// Cron only restricts one of the day fields when the other is *
+	anyDom, anyDow bool
+}
+
+var bounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+var dayNames = strings.NewReplacer(
+	"sun", "0", "mon", "1", "tue", "2", "wed", "3", "thu", "4", "fri", "5", "sat", "6")
+
+// Parse parses a cron expression
+func Parse(expr string) (*Cron, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("schedule: %q must have 5 fields", expr)
+	}
+	c := &Cron{anyDom: parts[2] == "*", anyDow: parts[4] == "*"}
+	for i, part := range parts {
+		if i == 4 {
+			part = dayNames.Replace(strings.ToLower(part))
+		}
+		values, err := parseField(part, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("schedule: %q: %v", expr, err)
+		}
+		c.fields[i] = values
+	}
+	if c.fields[4][7] {
+		c.fields[4][0] = true
+	}
+	return c, nil
+}
+
+func parseField(field string, min int, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i != -1 {
+			s, err := strconv.Atoi(item[i+1:])
+			if err != nil || s < 1 {
+				return nil, fmt.Errorf("invalid step in %q", item)
+			}
+			step = s
+			item = item[:i]
+		}
+		lo, hi := min, max
+		if item != "*" {
+			r := strings.SplitN(item, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(r[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", item)
+			}
+			hi = lo
+			if len(r) == 2 {
+				if hi, err = strconv.Atoi(r[1]); err != nil {
+					return nil, fmt.Errorf("invalid range %q", item)
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q out of range %d-%d", item, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// Matches reports whether the minute of t matches the expression
+func (c *Cron) Matches(t time.Time) bool {
+	if !c.fields[0][t.Minute()] || !c.fields[1][t.Hour()] || !c.fields[3][int(t.Month())] {
+		return false
+	}
+	dom, dow := c.fields[2][t.Day()], c.fields[4][int(t.Weekday())]
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+// Window is a recurring period starting whenever Start matches and lasting
+// Duration
+type Window struct {
+	Start    *Cron
+	Duration time.Duration
+	Expr     string
+}
+
+// ParseWindow parses "<cron expression> <duration>", e.g. "0 2 * * sun 3h"
+func ParseWindow(value string) (*Window, error) {
+	parts := strings.Fields(value)
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("schedule: window %q must be a cron expression followed by a duration", value)
+	}
+	d, err := time.ParseDuration(parts[5])
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("schedule: window %q has an invalid duration", value)
+	}
+	c, err := Parse(strings.Join(parts[:5], " "))
+	if err != nil {
+		return nil, err
+	}
+	return &Window{Start: c, Duration: d, Expr: value}, nil
+}
+
+// Active reports whether t falls within an occurrence of the window
+// Returns when that occurrence ends
+func (w *Window) Active(t time.Time) (bool, time.Time) {
+	t = t.Truncate(time.Minute)
+	for start := t; t.Sub(start) < w.Duration; start = start.Add(-time.Minute) {
+		if w.Start.Matches(start) {
+			return true, start.Add(w.Duration)
+		}
+	}
+	return false, time.Time{}
+}
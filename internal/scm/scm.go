@@ -0,0 +1,62 @@
+// Package scm runs a service under the service manager, reporting start
+// progress to the Windows service control manager while Start blocks and
+// readiness to systemd once it returns.
+package scm
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// progressInterval is how often start progress is reported
+const progressInterval = 5 * time.Second
+
+// CheckSecrets returns an error naming the first flag of secrets given on
+// the command line. Service arguments are stored in plaintext in the unit
+// file or registry, so secrets maps each secret flag to the file flag it's
+// installed with instead.
+func CheckSecrets(secrets map[string]string) error {
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if file, ok := secrets[f.Name]; ok && err == nil && len(f.Value.String()) != 0 {
+			err = fmt.Errorf("-%s would be stored in plaintext in the service definition; install with -%s instead", f.Name, file)
+		}
+	})
+	return err
+}
+
+// CheckDependencies returns an error naming the first of the services deps
+// that isn't installed, so name isn't registered depending on a service the
+// service manager can't start
+func CheckDependencies(name string, deps []string) error {
+	for _, dep := range deps {
+		installed, err := Installed(dep)
+		if err != nil {
+			return fmt.Errorf("unable to check the %s service is installed: %v", dep, err)
+		}
+		if !installed {
+			return fmt.Errorf("%s depends on the %s service, which isn't installed; install %s first with its wrapper's -control install, or install them all with clarifysvc install-all", name, dep, dep)
+		}
+	}
+	return nil
+}
+
+// Control runs the service control action. Installing under systemd also
+// makes the unit Type=notify with a start timeout of timeout (0 waits
+// forever), so units ordered after name only start once Run reports it
+// ready.
+func Control(s service.Service, action string, name string, timeout time.Duration) error {
+	if err := service.Control(s, action); err != nil {
+		return err
+	}
+	switch action {
+	case "install":
+		return installNotify(name, timeout)
+	case "uninstall":
+		return removeNotify(name)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+//go:build !windows
+// +build !windows
+
+package scm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// Run runs i until SIGTERM or an interrupt, which also stops a Start still
+// blocking so it gives up rather than the process being killed mid-start.
+// Under systemd, which passes NOTIFY_SOCKET to Type=notify units, readiness
+// is reported once i.Start returns. Outside of windows the service manager
+// doesn't track start progress so the timeout is ignored; systemd applies the
+// unit's TimeoutStartSec instead. Other unix service managers consider the
+// service started as soon as it runs.
+func Run(s service.Service, i service.Interface, name string, timeout time.Duration) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sig)
+	started := make(chan error, 1)
+	go func() {
+		started <- i.Start(s)
+	}()
+	select {
+	case err := <-started:
+		if err != nil {
+			return err
+		}
+	case <-sig:
+		notify("STOPPING=1")
+		err := i.Stop(s)
+		<-started
+		return err
+	}
+	if err := notify("READY=1"); err != nil {
+		i.Stop(s)
+		return fmt.Errorf("unable to notify systemd the service is ready: %v", err)
+	}
+	<-sig
+	notify("STOPPING=1")
+	return i.Stop(s)
+}
+
+// notify sends state to the systemd notification socket, if any
+func notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if len(socket) == 0 {
+		return nil
+	}
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// dropIn is the systemd unit drop-in making the service Type=notify
+const dropIn = "/etc/systemd/system/%s.service.d/notify.conf"
+
+func installNotify(name string, timeout time.Duration) error {
+	if service.Platform() != "linux-systemd" {
+		return nil
+	}
+	start := "infinity"
+	if timeout > 0 {
+		start = fmt.Sprint(int(timeout.Seconds()))
+	}
+	path := fmt.Sprintf(dropIn, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	unit := "[Service]\nType=notify\nNotifyAccess=main\nTimeoutStartSec=" + start + "\n"
+	if err := ioutil.WriteFile(path, []byte(unit), 0644); err != nil {
+		return err
+	}
+	return exec.Command("systemctl", "daemon-reload").Run()
+}
+
+func removeNotify(name string) error {
+	if service.Platform() != "linux-systemd" {
+		return nil
+	}
+	if err := os.RemoveAll(filepath.Dir(fmt.Sprintf(dropIn, name))); err != nil {
+		return err
+	}
+	return exec.Command("systemctl", "daemon-reload").Run()
+}
+
+// configPaths are where the service manager configs written on install live,
+// by service manager
+var configPaths = []string{
+	"/etc/systemd/system/%s.service",
+	"/etc/init.d/%s",
+	"/etc/init/%s.conf",
+	"/Library/LaunchDaemons/%s.plist",
+}
+
+// Installed reports whether the service manager has a service named name
+func Installed(name string) (bool, error) {
+	for _, path := range configPaths {
+		_, err := os.Stat(fmt.Sprintf(path, name))
+		if err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
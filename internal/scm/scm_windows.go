@@ -0,0 +1,137 @@
+package scm
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/pgombola/clarify-svc/internal/errs"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+)
+
+// Run runs s, reporting start-pending checkpoints to the service control
+// manager while i.Start blocks and failing the start once it takes longer
+// than timeout (0 waits forever). A stop while start pending calls i.Stop so
+// the pending Start gives up. Interactive sessions run s directly.
+func Run(s service.Service, i service.Interface, name string, timeout time.Duration) error {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil {
+		return err
+	}
+	if interactive {
+		return s.Run()
+	}
+	h := &handler{s: s, i: i, timeout: timeout}
+	if err := svc.Run(name, h); err != nil {
+		return err
+	}
+	return h.err
+}
+
+type handler struct {
+	s       service.Service
+	i       service.Interface
+	timeout time.Duration
+	err     error
+}
+
+func (h *handler) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
+	hint := uint32(2 * progressInterval / time.Millisecond)
+	changes <- svc.Status{State: svc.StartPending, Accepts: cmdsAccepted, WaitHint: hint}
+
+	started := make(chan error, 1)
+	go func() {
+		started <- h.i.Start(h.s)
+	}()
+	var deadline <-chan time.Time
+	if h.timeout > 0 {
+		deadline = time.After(h.timeout)
+	}
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	checkpoint := uint32(0)
+start:
+	for {
+		select {
+		case err := <-started:
+			if err != nil {
+				h.err = err
+				return true, uint32(errs.Code(err))
+			}
+			break start
+		case <-ticker.C:
+			checkpoint++
+			changes <- svc.Status{State: svc.StartPending, Accepts: cmdsAccepted, CheckPoint: checkpoint, WaitHint: hint}
+		case <-deadline:
+			h.err = fmt.Errorf("service didn't start within %v", h.timeout)
+			return true, 3
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- svc.Status{State: svc.StartPending, Accepts: cmdsAccepted, CheckPoint: checkpoint, WaitHint: hint}
+			case svc.Stop, svc.Shutdown:
+				// Stop makes the pending Start give up
+				changes <- svc.Status{State: svc.StopPending, WaitHint: hint}
+				err := h.i.Stop(h.s)
+				<-started
+				if err != nil {
+					h.err = err
+					return true, 2
+				}
+				return false, 0
+			}
+		}
+	}
+
+	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+	for c := range r {
+		switch c.Cmd {
+		case svc.Interrogate:
+			changes <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			changes <- svc.Status{State: svc.StopPending}
+			if err := h.i.Stop(h.s); err != nil {
+				h.err = err
+				return true, 2
+			}
+			return false, 0
+		}
+	}
+	h.err = errors.New("service control channel closed")
+	return true, 2
+}
+
+// errServiceDoesNotExist is ERROR_SERVICE_DOES_NOT_EXIST
+const errServiceDoesNotExist = syscall.Errno(1060)
+
+// Installed reports whether the service control manager has a service named
+// name
+func Installed(name string) (bool, error) {
+	m, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		return false, err
+	}
+	defer windows.CloseServiceHandle(m)
+	h, err := windows.OpenService(m, syscall.StringToUTF16Ptr(name), windows.SERVICE_QUERY_STATUS)
+	if err == errServiceDoesNotExist {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	windows.CloseServiceHandle(h)
+	return true, nil
+}
+
+// installNotify is a no-op; the service control manager tracks start
+// progress through Run
+func installNotify(name string, timeout time.Duration) error {
+	return nil
+}
+
+func removeNotify(name string) error {
+	return nil
+}
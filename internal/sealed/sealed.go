@@ -0,0 +1,128 @@
+// Package sealed encrypts config values with a key bound to the host so
+// tokens and keys don't sit in service config files in plaintext. Values
+// are sealed with DPAPI (machine scope) on windows or with AES-GCM under a
+// key file mixed with the host's machine id.
+package sealed
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix marks a sealed value
+const Prefix = "enc:"
+
+// Sealing schemes, recorded after the prefix
+const (
+	schemeKeyFile = "key"
+	schemeDPAPI   = "dpapi"
+)
+
+// keySize is the length of a generated key file
+const keySize = 32
+
+// IsSealed reports whether value was sealed
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// Seal encrypts plaintext with the key file, which is generated when
+// missing, or with DPAPI when keyFile is empty
+func Seal(plaintext []byte, keyFile string) (string, error) {
+	if len(keyFile) == 0 {
+		data, err := protect(plaintext)
+		if err != nil {
+			return "", err
+		}
+		return Prefix + schemeDPAPI + ":" + base64.StdEncoding.EncodeToString(data), nil
+	}
+	gcm, err := newGCM(keyFile, true)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := gcm.Seal(nonce, nonce, plaintext, nil)
+	return Prefix + schemeKeyFile + ":" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Open decrypts a sealed value. Values sealed with a key file need the same
+// key file on the same host.
+func Open(value string, keyFile string) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(value, Prefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("malformed sealed value")
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed sealed value: %v", err)
+	}
+	switch parts[0] {
+	case schemeDPAPI:
+		plaintext, err := unprotect(data)
+		return string(plaintext), err
+	case schemeKeyFile:
+		if len(keyFile) == 0 {
+			return "", errors.New("value is sealed with a key file but none is configured")
+		}
+		gcm, err := newGCM(keyFile, false)
+		if err != nil {
+			return "", err
+		}
+		if len(data) < gcm.NonceSize() {
+			return "", errors.New("malformed sealed value")
+		}
+		plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+		if err != nil {
+			return "", errors.New("unable to decrypt sealed value; it was sealed with another key or on another host")
+		}
+		return string(plaintext), nil
+	}
+	return "", fmt.Errorf("unknown sealing scheme %q", parts[0])
+}
+
+// newGCM returns the cipher of the key file, generating the file when
+// create is set and it doesn't exist
+func newGCM(keyFile string, create bool) (cipher.AEAD, error) {
+	key, err := ioutil.ReadFile(keyFile)
+	if os.IsNotExist(err) && create {
+		key = make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if len(key) < keySize {
+		return nil, fmt.Errorf("key file %s is shorter than %d bytes", keyFile, keySize)
+	}
+	// Mixing in the machine id keeps a copied key file and config from
+	// decrypting on another host
+	id, err := machineID()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read machine id: %v", err)
+	}
+	sum := sha256.Sum256(append(key, id...))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,76 @@
+package sealed
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+var (
+	crypt32            = syscall.NewLazyDLL("crypt32.dll")
+	cryptProtectData   = crypt32.NewProc("CryptProtectData")
+	cryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	localFree          = kernel32.NewProc("LocalFree")
+)
+
+// CryptProtectData flags. Machine scope lets the service account and
+// administrators running the command line decrypt the same values.
+const (
+	cryptProtectUIForbidden  = 0x1
+	cryptProtectLocalMachine = 0x4
+)
+
+// dataBlob is DATA_BLOB
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+func newBlob(b []byte) *dataBlob {
+	if len(b) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{size: uint32(len(b)), data: &b[0]}
+}
+
+// free copies the blob allocated by crypt32 and releases it
+func (b *dataBlob) free() []byte {
+	out := make([]byte, b.size)
+	if b.size != 0 {
+		copy(out, (*[1 << 30]byte)(unsafe.Pointer(b.data))[:b.size:b.size])
+	}
+	localFree.Call(uintptr(unsafe.Pointer(b.data)))
+	return out
+}
+
+func protect(plaintext []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := cryptProtectData.Call(uintptr(unsafe.Pointer(newBlob(plaintext))), 0, 0, 0, 0,
+		cryptProtectUIForbidden|cryptProtectLocalMachine, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	return out.free(), nil
+}
+
+func unprotect(data []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := cryptUnprotectData.Call(uintptr(unsafe.Pointer(newBlob(data))), 0, 0, 0, 0,
+		cryptProtectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	return out.free(), nil
+}
+
+func machineID() ([]byte, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Cryptography`, registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+	id, _, err := k.GetStringValue("MachineGuid")
+	return []byte(id), err
+}
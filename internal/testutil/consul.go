@@ -0,0 +1,174 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/consul"
+)
+
+// Consul is a fake consul agent serving the kv store, sessions and the raft
+// leader from memory. Like sessions created by the wrappers, destroying a
+// session or letting its ttl pass without renewing it deletes the keys it
+// holds.
+type Consul struct {
+	recorder
+	Server     *httptest.Server
+	Version    string
+	Datacenter string
+	Leader     string
+	kv         map[string]*consul.KVPair
+	index      uint64
+	sessions   map[string]*session
+}
+
+// session is a fake session, expiring at expires unless renewed
+type session struct {
+	ttl     time.Duration
+	expires time.Time
+}
+
+// NewConsul starts a fake consul agent; Close stops it
+func NewConsul() *Consul {
+	c := &Consul{Version: "1.16.0", Datacenter: "dc1", Leader: "127.0.0.1:8300", kv: make(map[string]*consul.KVPair), sessions: make(map[string]*session)}
+	c.Server = httptest.NewServer(http.HandlerFunc(c.serve))
+	return c
+}
+
+// Close stops the fake
+func (c *Consul) Close() {
+	c.Server.Close()
+}
+
+// Client returns a client of the fake
+func (c *Consul) Client() *consul.Client {
+	host, port := hostPort(c.Server)
+	return consul.NewClient(host, port)
+}
+
+// Put stores value at key
+func (c *Consul) Put(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(key, value, "")
+}
+
+// Get returns the value stored at key or nil if it doesn't exist
+func (c *Consul) Get(key string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pair, ok := c.kv[key]; ok {
+		return pair.Value
+	}
+	return nil
+}
+
+func (c *Consul) put(key string, value []byte, session string) {
+	c.index++
+	c.kv[key] = &consul.KVPair{Key: key, Value: value, Session: session, ModifyIndex: c.index}
+}
+
+func (c *Consul) serve(w http.ResponseWriter, r *http.Request) {
+	if !c.record(w, r) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(time.Now())
+	path := r.URL.Path
+	switch {
+	case path == "/v1/agent/self":
+		writeJSON(w, map[string]interface{}{"Config": map[string]string{"Version": c.Version, "Datacenter": c.Datacenter}})
+	case path == "/v1/status/leader":
+		writeJSON(w, c.Leader)
+	case path == "/v1/session/create":
+		var body struct {
+			TTL string `json:"TTL"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		ttl, _ := time.ParseDuration(body.TTL)
+		c.index++
+		id := fmt.Sprintf("session-%d", c.index)
+		c.sessions[id] = &session{ttl: ttl, expires: time.Now().Add(ttl)}
+		writeJSON(w, map[string]string{"ID": id})
+	case strings.HasPrefix(path, "/v1/session/renew/"):
+		s, ok := c.sessions[strings.TrimPrefix(path, "/v1/session/renew/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		s.expires = time.Now().Add(s.ttl)
+		writeJSON(w, []interface{}{})
+	case strings.HasPrefix(path, "/v1/session/destroy/"):
+		c.destroy(strings.TrimPrefix(path, "/v1/session/destroy/"))
+		writeJSON(w, true)
+	case strings.HasPrefix(path, "/v1/kv/"):
+		c.serveKV(w, r, strings.TrimPrefix(path, "/v1/kv/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// expire destroys the sessions with a ttl that weren't renewed by now
+func (c *Consul) expire(now time.Time) {
+	for id, s := range c.sessions {
+		if s.ttl > 0 && now.After(s.expires) {
+			c.destroy(id)
+		}
+	}
+}
+
+// destroy deletes the session and the keys it holds
+func (c *Consul) destroy(id string) {
+	delete(c.sessions, id)
+	for key, pair := range c.kv {
+		if pair.Session == id {
+			delete(c.kv, key)
+		}
+	}
+}
+
+func (c *Consul) serveKV(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		pairs := make([]consul.KVPair, 0)
+		for k, pair := range c.kv {
+			if k == key || (query["recurse"] != nil && strings.HasPrefix(k, key)) {
+				pairs = append(pairs, *pair)
+			}
+		}
+		if len(pairs) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+		w.Header().Set("X-Consul-Index", fmt.Sprint(c.index))
+		writeJSON(w, pairs)
+	case http.MethodPut:
+		value, _ := ioutil.ReadAll(r.Body)
+		if session := query.Get("acquire"); len(session) != 0 {
+			held, ok := c.kv[key]
+			if c.sessions[session] == nil || (ok && len(held.Session) != 0 && held.Session != session) {
+				writeJSON(w, false)
+				return
+			}
+			c.put(key, value, session)
+			writeJSON(w, true)
+			return
+		}
+		c.put(key, value, "")
+		writeJSON(w, true)
+	case http.MethodDelete:
+		delete(c.kv, key)
+		writeJSON(w, true)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,258 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/pgombola/clarify-svc/internal/nomad"
+	"github.com/pgombola/gomad/client"
+)
+
+// Nomad is a fake nomad agent serving nodes, their metadata and allocations,
+// jobs, job plans and node drains from memory. Submitted jobs are reported
+// running and plan as edited until the same job is submitted.
+type Nomad struct {
+	recorder
+	Server  *httptest.Server
+	Version string
+	nodes   []nomad.Node
+	jobs    map[string]string
+	specs   map[string]string
+	allocs  map[string][]client.Alloc
+	drains  map[string]*nomad.DrainSpec
+}
+
+// NewNomad starts a fake nomad agent; Close stops it
+func NewNomad() *Nomad {
+	n := &Nomad{Version: "1.6.0", jobs: make(map[string]string), specs: make(map[string]string), allocs: make(map[string][]client.Alloc), drains: make(map[string]*nomad.DrainSpec)}
+	n.Server = httptest.NewServer(http.HandlerFunc(n.serve))
+	return n
+}
+
+// Close stops the fake
+func (n *Nomad) Close() {
+	n.Server.Close()
+}
+
+// Client returns the connection parameters of the fake
+func (n *Nomad) Client() *nomad.Server {
+	host, port := hostPort(n.Server)
+	return &nomad.Server{Address: host, Port: port}
+}
+
+// AddNode registers a ready client node
+func (n *Nomad) AddNode(id string, name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nodes = append(n.nodes, nomad.Node{ID: id, Name: name, Status: "ready", Meta: map[string]string{}})
+}
+
+// SetAllocs sets the allocations of the node with the provided id
+func (n *Nomad) SetAllocs(id string, allocs ...client.Alloc) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.allocs[id] = allocs
+}
+
+// SetJob sets the status of job, adding it if needed. An empty status
+// removes the job.
+func (n *Nomad) SetJob(name string, status string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(status) == 0 {
+		delete(n.jobs, name)
+		delete(n.specs, name)
+		return
+	}
+	n.jobs[name] = status
+}
+
+// Job returns the status of job or "" if it doesn't exist
+func (n *Nomad) Job(name string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.jobs[name]
+}
+
+// Drain returns the drain spec of node id, or nil if it isn't draining
+func (n *Nomad) Drain(id string) *nomad.DrainSpec {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.drains[id]
+}
+
+func (n *Nomad) node(id string) *nomad.Node {
+	for i := range n.nodes {
+		if n.nodes[i].ID == id {
+			return &n.nodes[i]
+		}
+	}
+	return nil
+}
+
+func (n *Nomad) serve(w http.ResponseWriter, r *http.Request) {
+	if !n.record(w, r) {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	path := r.URL.Path
+	switch {
+	case path == "/v1/agent/self":
+		writeJSON(w, map[string]interface{}{"config": map[string]interface{}{"Version": n.Version}})
+	case path == "/v1/agent/health":
+		writeJSON(w, map[string]interface{}{})
+	case path == "/v1/nodes":
+		hosts := make([]client.Host, 0, len(n.nodes))
+		for _, node := range n.nodes {
+			hosts = append(hosts, client.Host{ID: node.ID, Name: node.Name, Drain: node.Drain})
+		}
+		writeJSON(w, hosts)
+	case path == "/v1/client/metadata":
+		n.serveMeta(w, r)
+	case strings.HasPrefix(path, "/v1/node/") && strings.HasSuffix(path, "/allocations"):
+		allocs := n.allocs[strings.TrimSuffix(strings.TrimPrefix(path, "/v1/node/"), "/allocations")]
+		if allocs == nil {
+			allocs = []client.Alloc{}
+		}
+		writeJSON(w, allocs)
+	case strings.HasPrefix(path, "/v1/client/allocation/") && strings.HasSuffix(path, "/restart"):
+		writeJSON(w, struct{}{})
+	case strings.HasPrefix(path, "/v1/job/") && strings.HasSuffix(path, "/evaluate"):
+		writeJSON(w, map[string]string{"EvalID": "eval-" + strings.TrimSuffix(strings.TrimPrefix(path, "/v1/job/"), "/evaluate")})
+	case strings.HasPrefix(path, "/v1/node/") && strings.HasSuffix(path, "/drain"):
+		n.serveDrain(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/v1/node/"), "/drain"))
+	case strings.HasPrefix(path, "/v1/node/"):
+		node := n.node(strings.TrimPrefix(path, "/v1/node/"))
+		if node == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, node)
+	case path == "/v1/jobs" && r.Method == http.MethodPost:
+		name, job, ok := decodeJob(w, r)
+		if !ok {
+			return
+		}
+		n.jobs[name] = "running"
+		n.specs[name] = job
+		writeJSON(w, map[string]string{"EvalID": "eval-" + name})
+	case strings.HasPrefix(path, "/v1/job/") && strings.HasSuffix(path, "/plan"):
+		name, job, ok := decodeJob(w, r)
+		if !ok {
+			return
+		}
+		plan := &nomad.JobPlan{}
+		plan.Diff.Type = "Edited"
+		if n.specs[name] == job {
+			plan.Diff.Type = "None"
+		}
+		writeJSON(w, plan)
+	case path == "/v1/jobs":
+		jobs := make([]client.Job, 0, len(n.jobs))
+		for name, status := range n.jobs {
+			jobs = append(jobs, client.Job{Name: name, Status: status})
+		}
+		writeJSON(w, jobs)
+	case strings.HasPrefix(path, "/v1/job/"):
+		name := strings.TrimPrefix(path, "/v1/job/")
+		status, ok := n.jobs[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, client.Job{Name: name, Status: status})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// decodeJob returns the name and json definition of the job in a submit or
+// plan request, answering bad request when it has none
+func decodeJob(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	var spec struct {
+		Job json.RawMessage `json:"Job"`
+	}
+	var job struct {
+		ID   string `json:"ID"`
+		Name string `json:"Name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil || json.Unmarshal(spec.Job, &job) != nil || len(job.ID)+len(job.Name) == 0 {
+		http.Error(w, "invalid job", http.StatusBadRequest)
+		return "", "", false
+	}
+	name := job.Name
+	if len(name) == 0 {
+		name = job.ID
+	}
+	return name, string(spec.Job), true
+}
+
+// serveMeta merges the dynamic metadata of a node, removing null keys
+func (n *Nomad) serveMeta(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		NodeID string             `json:"NodeID"`
+		Meta   map[string]*string `json:"Meta"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	node := n.node(body.NodeID)
+	if node == nil {
+		http.NotFound(w, r)
+		return
+	}
+	for key, value := range body.Meta {
+		if value == nil {
+			delete(node.Meta, key)
+			continue
+		}
+		node.Meta[key] = *value
+	}
+	writeJSON(w, map[string]interface{}{})
+}
+
+// serveDrain handles both the legacy ?enable= form and the drain spec body
+func (n *Nomad) serveDrain(w http.ResponseWriter, r *http.Request, id string) {
+	node := n.node(id)
+	if node == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if enable := r.URL.Query().Get("enable"); len(enable) != 0 {
+		node.Drain = enable == "true"
+		if node.Drain {
+			n.drains[id] = &nomad.DrainSpec{}
+		} else {
+			delete(n.drains, id)
+		}
+		writeJSON(w, map[string]interface{}{})
+		return
+	}
+	var body struct {
+		DrainSpec *struct {
+			Deadline         int64 `json:"Deadline"`
+			IgnoreSystemJobs bool  `json:"IgnoreSystemJobs"`
+		} `json:"DrainSpec"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.DrainSpec == nil {
+		node.Drain = false
+		delete(n.drains, id)
+	} else {
+		spec := &nomad.DrainSpec{IgnoreSystemJobs: body.DrainSpec.IgnoreSystemJobs, Force: body.DrainSpec.Deadline < 0}
+		if body.DrainSpec.Deadline > 0 {
+			spec.Deadline = time.Duration(body.DrainSpec.Deadline)
+		}
+		node.Drain = true
+		n.drains[id] = spec
+	}
+	writeJSON(w, map[string]interface{}{})
+}
@@ -0,0 +1,68 @@
+// Package testutil provides in-memory fakes of the nomad and consul http apis
+// the clarify binaries call, served by httptest, so the startup, poll and
+// drain flows can be exercised without running the agents.
+package testutil
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"github.com/pgombola/clarify-svc/internal/opid"
+)
+
+// Request is a call received by a fake
+type Request struct {
+	Method    string
+	Path      string
+	Operation string
+}
+
+// recorder records the requests received by a fake and lets tests make it
+// unavailable
+type recorder struct {
+	mu       sync.Mutex
+	requests []Request
+	down     bool
+}
+
+// SetDown makes every request fail with 503 while down is true
+func (r *recorder) SetDown(down bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.down = down
+}
+
+// Requests returns the requests received so far
+func (r *recorder) Requests() []Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Request(nil), r.requests...)
+}
+
+// record logs req, returning false when the fake is down
+func (r *recorder) record(w http.ResponseWriter, req *http.Request) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, Request{Method: req.Method, Path: req.URL.Path, Operation: req.Header.Get(opid.Header)})
+	if r.down {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// hostPort returns the address and port of server
+func hostPort(server *httptest.Server) (string, int) {
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,189 @@
+// Package trace records spans of the wrapper's control-loop operations and
+// exports them to an OpenTelemetry collector with the OTLP/HTTP json
+// protocol.
+package trace
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer batches finished spans and exports them to Endpoint. A nil Tracer
+// records nothing.
+type Tracer struct {
+	Endpoint string
+	Service  string
+	Host     string
+	http     *http.Client
+	mu       sync.Mutex
+	pending  []*Span
+}
+
+// Span is a timed operation
+type Span struct {
+	tracer  *Tracer
+	traceID string
+	spanID  string
+	parent  string
+	name    string
+	start   time.Time
+	end     time.Time
+	attrs   map[string]string
+	err     error
+}
+
+// New returns a Tracer exporting to the OTLP/HTTP endpoint (e.g.
+// http://localhost:4318), or nil when endpoint is empty
+func New(endpoint string, service string, host string) *Tracer {
+	if len(endpoint) == 0 {
+		return nil
+	}
+	return &Tracer{
+		Endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		Service:  service,
+		Host:     host,
+		http:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Start begins a root span
+func (t *Tracer) Start(name string) *Span {
+	if t == nil {
+		return nil
+	}
+	return &Span{tracer: t, traceID: randomID(16), spanID: randomID(8), name: name, start: time.Now(), attrs: make(map[string]string)}
+}
+
+// Child begins a span beneath s
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return &Span{tracer: s.tracer, traceID: s.traceID, spanID: randomID(8), parent: s.spanID, name: name, start: time.Now(), attrs: make(map[string]string)}
+}
+
+// Set records an attribute of the span
+func (s *Span) Set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = fmt.Sprint(value)
+}
+
+// End finishes the span, marking it failed when err isn't nil
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	s.tracer.mu.Lock()
+	s.tracer.pending = append(s.tracer.pending, s)
+	s.tracer.mu.Unlock()
+}
+
+// Run exports finished spans every interval until exit is closed
+func (t *Tracer) Run(interval time.Duration, exit <-chan struct{}) {
+	if t == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.Flush()
+		case <-exit:
+			t.Flush()
+			return
+		}
+	}
+}
+
+// Flush exports the finished spans. Spans that fail to export are dropped
+// so an unreachable collector doesn't grow memory without bound.
+func (t *Tracer) Flush() error {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(t.export(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := t.http.Post(t.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("trace: POST %s returned %v", t.Endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+type keyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func attributes(attrs map[string]string) []keyValue {
+	kvs := make([]keyValue, 0, len(attrs))
+	for k, v := range attrs {
+		kv := keyValue{Key: k}
+		kv.Value.StringValue = v
+		kvs = append(kvs, kv)
+	}
+	return kvs
+}
+
+// export returns the OTLP ExportTraceServiceRequest of spans
+func (t *Tracer) export(spans []*Span) map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		status := map[string]interface{}{"code": 1}
+		if s.err != nil {
+			status = map[string]interface{}{"code": 2, "message": s.err.Error()}
+		}
+		out = append(out, map[string]interface{}{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"parentSpanId":      s.parent,
+			"name":              s.name,
+			"kind":              1,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        attributes(s.attrs),
+			"status":            status,
+		})
+	}
+	resource := map[string]string{"service.name": t.Service, "host.name": t.Host}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": attributes(resource)},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "clarify-svc"},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+func randomID(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
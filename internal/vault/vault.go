@@ -0,0 +1,145 @@
+// Package vault is a minimal client for the Vault HTTP API used to fetch the
+// secrets the clarify wrappers need at startup.
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client is connection parameters to a vault server
+type Client struct {
+	Address string
+	Token   string
+	http    *http.Client
+}
+
+// Auth represents the auth block of a vault login response
+type Auth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// Secret represents a vault secret response
+type Secret struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *Auth                  `json:"auth"`
+}
+
+// NewClient returns a Client for the vault server at address
+// (e.g. https://vault:8200) authenticating with token
+func NewClient(address string, token string) *Client {
+	return &Client{
+		Address: strings.TrimSuffix(address, "/"),
+		Token:   token,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// LoginAppRole authenticates with the approle auth method and uses the
+// returned token for subsequent requests
+func (c *Client) LoginAppRole(roleID string, secretID string) (*Secret, error) {
+	body := map[string]string{"role_id": roleID, "secret_id": secretID}
+	secret, err := c.Write("auth/approle/login", body)
+	if err != nil {
+		return nil, err
+	}
+	if secret.Auth == nil {
+		return nil, fmt.Errorf("vault: approle login returned no token")
+	}
+	c.Token = secret.Auth.ClientToken
+	return secret, nil
+}
+
+// Read returns the secret at path
+func (c *Client) Read(path string) (*Secret, error) {
+	secret := &Secret{}
+	return secret, c.do(http.MethodGet, "/v1/"+path, nil, secret)
+}
+
+// Write writes body to path, returning the response secret
+func (c *Client) Write(path string, body interface{}) (*Secret, error) {
+	secret := &Secret{}
+	return secret, c.do(http.MethodPost, "/v1/"+path, body, secret)
+}
+
+// RenewSelf extends the lease of the client's token
+func (c *Client) RenewSelf() (*Secret, error) {
+	return c.Write("auth/token/renew-self", struct{}{})
+}
+
+// RenewLease extends the lease with the provided id
+func (c *Client) RenewLease(id string) (*Secret, error) {
+	secret := &Secret{}
+	return secret, c.do(http.MethodPut, "/v1/sys/leases/renew", map[string]string{"lease_id": id}, secret)
+}
+
+// Field returns a string field of the secret's data. Secrets read from a
+// version 2 kv engine nest their fields beneath "data".
+func (s *Secret) Field(name string) (string, error) {
+	data := s.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	v, ok := data[name].(string)
+	if !ok {
+		return "", fmt.Errorf("vault: secret has no field %q", name)
+	}
+	return v, nil
+}
+
+// ReadField reads a "path#field" reference, returning the field's value and
+// the secret it was read from
+func (c *Client) ReadField(ref string) (string, *Secret, error) {
+	parts := strings.SplitN(ref, "#", 2)
+	if len(parts) != 2 {
+		return "", nil, fmt.Errorf("vault: %q must be of the form path#field", ref)
+	}
+	secret, err := c.Read(parts[0])
+	if err != nil {
+		return "", nil, err
+	}
+	v, err := secret.Field(parts[1])
+	return v, secret, err
+}
+
+func (c *Client) do(method string, path string, body interface{}, target interface{}) error {
+	var r io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, c.Address+path, r)
+	if err != nil {
+		return err
+	}
+	if len(c.Token) != 0 {
+		req.Header.Set("X-Vault-Token", c.Token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("vault: %v %v returned %v: %v", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}